@@ -0,0 +1,239 @@
+package webdav
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// Usage is what one request moved and stored, passed to Server.Accounting
+// when it is done. BytesIn and BytesOut count what was actually read from
+// the request body and written to the response, so an aborted transfer
+// reports the part that got through. Stored is the change in stored bytes
+// the request made: the size written by PUT or COPY less the size of the
+// file it replaced, or minus the size of a file DELETE removed.
+type Usage struct {
+	Principal string
+	Op        string // the request method
+	Path      string
+	Status    int
+	BytesIn   int64
+	BytesOut  int64
+	Stored    int64
+}
+
+// principal returns who made r, by Server.Principal or else the basic auth
+// user name
+func (s *Server) principal(r *http.Request) string {
+	if s.Principal != nil {
+		return s.Principal(r)
+	}
+	user, _, _ := r.BasicAuth()
+	return user
+}
+
+// usageKey is the context key of the *Usage a request is accounted in
+type usageKey struct{}
+
+// stored records a change of delta stored bytes by r, for Accounting
+func (s *Server) stored(r *http.Request, delta int64) {
+	if u, ok := r.Context().Value(usageKey{}).(*Usage); ok {
+		u.Stored += delta
+	}
+}
+
+// withUsage returns r with u in its context, for handlers to record the
+// stored bytes in
+func withUsage(r *http.Request, u *Usage) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), usageKey{}, u))
+}
+
+// UserUsage is the running total of a principal in a Ledger
+type UserUsage struct {
+	Requests int64 `json:"requests"`
+	BytesIn  int64 `json:"bytes_in"`
+	BytesOut int64 `json:"bytes_out"`
+	Stored   int64 `json:"stored"`
+}
+
+// A LedgerStore persists the totals of a Ledger
+type LedgerStore interface {
+	// Load returns the saved totals, called once by NewLedger
+	Load() (map[string]UserUsage, error)
+	// Save replaces the saved totals, called by Flush
+	Save(map[string]UserUsage) error
+}
+
+// Ledger keeps per-principal totals of traffic and storage. Its Record
+// method is meant for Server.Accounting:
+//
+//	ledger, err := webdav.NewLedger(webdav.LedgerFile("usage.json"))
+//	server.Accounting = ledger.Record
+//
+// Storage is charged to the principal whose request changed it, so a user
+// overwriting another's file takes over its size. Totals are only written
+// to the store by Flush, which embedders call periodically and on shutdown.
+type Ledger struct {
+	store LedgerStore
+
+	mu    sync.Mutex
+	users map[string]UserUsage
+	dirty bool
+}
+
+// NewLedger returns a Ledger starting from the totals in store
+func NewLedger(store LedgerStore) (*Ledger, error) {
+	users, err := store.Load()
+	if err != nil {
+		return nil, err
+	}
+	if users == nil {
+		users = map[string]UserUsage{}
+	}
+	return &Ledger{store: store, users: users}, nil
+}
+
+// Record adds a request to the totals of its principal
+func (l *Ledger) Record(u Usage) {
+	l.mu.Lock()
+	t := l.users[u.Principal]
+	t.Requests++
+	t.BytesIn += u.BytesIn
+	t.BytesOut += u.BytesOut
+	t.Stored += u.Stored
+	l.users[u.Principal] = t
+	l.dirty = true
+	l.mu.Unlock()
+}
+
+// User returns the totals of a principal
+func (l *Ledger) User(principal string) UserUsage {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.users[principal]
+}
+
+// Principals returns the principals with totals, sorted
+func (l *Ledger) Principals() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	names := make([]string, 0, len(l.users))
+	for name := range l.users {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Snapshot returns a copy of the totals of every principal
+func (l *Ledger) Snapshot() map[string]UserUsage {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.snapshot()
+}
+
+func (l *Ledger) snapshot() map[string]UserUsage {
+	m := make(map[string]UserUsage, len(l.users))
+	for k, v := range l.users {
+		m[k] = v
+	}
+	return m
+}
+
+// Flush saves the totals to the store if they changed since the last Flush
+func (l *Ledger) Flush() error {
+	l.mu.Lock()
+	if !l.dirty {
+		l.mu.Unlock()
+		return nil
+	}
+	m := l.snapshot()
+	l.dirty = false
+	l.mu.Unlock()
+
+	if err := l.store.Save(m); err != nil {
+		l.mu.Lock()
+		l.dirty = true
+		l.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// MemLedgerStore is a LedgerStore in memory, its zero value is empty
+type MemLedgerStore struct {
+	mu    sync.Mutex
+	users map[string]UserUsage
+}
+
+// Load returns a copy of the totals of the last Save, for NewLedger
+func (m *MemLedgerStore) Load() (map[string]UserUsage, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	users := make(map[string]UserUsage, len(m.users))
+	for k, v := range m.users {
+		users[k] = v
+	}
+	return users, nil
+}
+
+// Save keeps a copy of the totals a Flush passes in memory
+func (m *MemLedgerStore) Save(users map[string]UserUsage) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.users = make(map[string]UserUsage, len(users))
+	for k, v := range users {
+		m.users[k] = v
+	}
+	return nil
+}
+
+// LedgerFile is a LedgerStore in a JSON file, replaced atomically on Save.
+// A missing file is an empty ledger.
+type LedgerFile string
+
+// Load reads the totals from the file for NewLedger, none if it is missing
+func (f LedgerFile) Load() (map[string]UserUsage, error) {
+	b, err := os.ReadFile(string(f))
+	if os.IsNotExist(err) {
+		return map[string]UserUsage{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var users map[string]UserUsage
+	if err := json.Unmarshal(b, &users); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// Save writes the totals a Flush passes to a temporary file, synced and
+// renamed over the file
+func (f LedgerFile) Save(users map[string]UserUsage) error {
+	b, err := json.MarshalIndent(users, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(string(f)), TempPrefix+"ledger")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), string(f))
+}
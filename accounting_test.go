@@ -0,0 +1,167 @@
+package webdav
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// rawRequest starts a request of user on a connection of its own, with a
+// Content-Length of size and sent bytes of body, for transfers cut short
+func rawRequest(t *testing.T, addr, method, name, user string, size, sent int) net.Conn {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth := base64.StdEncoding.EncodeToString([]byte(user + ":pw"))
+	fmt.Fprintf(conn, "%s %s HTTP/1.1\r\nHost: x\r\nAuthorization: Basic %s\r\nContent-Length: %d\r\n\r\n", method, name, auth, size)
+	conn.Write([]byte(strings.Repeat("x", sent)))
+	return conn
+}
+
+// TestLedgerInterleavedUsers has two users upload, download, replace and
+// delete files at the same time, one of them aborting an upload and a
+// download halfway, and checks each is charged exactly what moved
+func TestLedgerInterleavedUsers(t *testing.T) {
+	root := t.TempDir()
+	file := LedgerFile(filepath.Join(t.TempDir(), "usage.json"))
+	ledger, err := NewLedger(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mu sync.Mutex
+	var aborted []Usage
+	s := &Server{Fs: Dir(root), TrimPrefix: "/", Accounting: func(u Usage) {
+		ledger.Record(u)
+		if u.Path == "/bob/cut" || u.Path == "/bob/big" && u.Op == "GET" {
+			mu.Lock()
+			aborted = append(aborted, u)
+			mu.Unlock()
+		}
+	}}
+	ts := newTestServer(t, s)
+	os.Mkdir(filepath.Join(root, "bob"), 0o755)
+	const bigSize = 64 << 20
+	os.WriteFile(filepath.Join(root, "bob", "big"), make([]byte, bigSize), 0o644)
+
+	do := func(user, method, name, body string) int64 {
+		req, _ := http.NewRequest(method, ts.URL+name, strings.NewReader(body))
+		req.SetBasicAuth(user, "pw")
+		resp, err := ts.Client().Do(req)
+		if err != nil {
+			t.Error(err)
+			return 0
+		}
+		defer resp.Body.Close()
+		n, _ := io.Copy(io.Discard, resp.Body)
+		if resp.StatusCode >= 300 {
+			t.Errorf("%s %s %s: %d", user, method, name, resp.StatusCode)
+		}
+		return n
+	}
+
+	// each user writes files of its own size, replaces them with ones
+	// twice as long, reads them and deletes every other one
+	want := map[string]UserUsage{}
+	var wantMu sync.Mutex
+	var wg sync.WaitGroup
+	for _, user := range []string{"alice", "bob"} {
+		size := map[string]int{"alice": 100, "bob": 1000}[user]
+		wg.Add(1)
+		go func(user string, size int) {
+			defer wg.Done()
+			var u UserUsage
+			for i := 0; i < 20; i++ {
+				name := fmt.Sprintf("/%s-%d", user, i)
+				do(user, "PUT", name, strings.Repeat("a", size))
+				do(user, "PUT", name, strings.Repeat("b", 2*size))
+				u.BytesOut += do(user, "GET", name, "")
+				u.Requests += 3
+				u.BytesIn += int64(3 * size)
+				u.Stored += int64(2 * size)
+				if i%2 == 0 {
+					do(user, "DELETE", name, "")
+					u.Requests++
+					u.Stored -= int64(2 * size)
+				}
+			}
+			wantMu.Lock()
+			want[user] = u
+			wantMu.Unlock()
+		}(user, size)
+	}
+
+	// meanwhile bob sends a third of an upload and reads 1 MiB of a
+	// download before hanging up
+	addr := ts.Listener.Addr().String()
+	up := rawRequest(t, addr, "PUT", "/bob/cut", "bob", 30000, 10000)
+	down := rawRequest(t, addr, "GET", "/bob/big", "bob", 0, 0)
+	if _, err := io.CopyN(io.Discard, bufio.NewReader(down), 1<<20); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	up.Close()
+	down.Close()
+	wg.Wait()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		mu.Lock()
+		n := len(aborted)
+		mu.Unlock()
+		if n == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("aborted transfers accounted: %+v", aborted)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	for _, u := range aborted {
+		switch u.Op {
+		case "PUT":
+			if u.BytesIn != 10000 || u.Stored != 0 {
+				t.Errorf("aborted PUT accounted %+v, want 10000 bytes in, nothing stored", u)
+			}
+		case "GET":
+			if u.BytesOut < 1<<20 || u.BytesOut >= bigSize {
+				t.Errorf("aborted GET accounted %d bytes out, want the part that was sent", u.BytesOut)
+			}
+		}
+		b := want["bob"]
+		b.Requests++
+		b.BytesIn += u.BytesIn
+		b.BytesOut += u.BytesOut
+		want["bob"] = b
+	}
+
+	got := ledger.Snapshot()
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ledger %+v\nwant %+v", got, want)
+	}
+	if p := ledger.Principals(); strings.Join(p, ",") != "alice,bob" {
+		t.Errorf("principals %q", p)
+	}
+
+	if err := ledger.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	again, err := NewLedger(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(again.Snapshot(), got) {
+		t.Errorf("reloaded ledger %+v\nwant %+v", again.Snapshot(), got)
+	}
+}
@@ -0,0 +1,150 @@
+package webdav
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/golang/glog"
+)
+
+// BatchNamespace is the XML namespace of batch delete bodies
+const BatchNamespace = "urn:x-webdav:batch"
+
+// maxBatchBody is the largest batch delete body read, and maxBatchTokens
+// the most XML tokens decoded from it
+const (
+	maxBatchBody   = 1 << 20
+	maxBatchTokens = 100000
+)
+
+// batchDelete is the body of a batch delete, listing the members of a
+// collection to remove, each with an optional entity tag they must still
+// have. As XML:
+//
+//	<B:delete xmlns:B="urn:x-webdav:batch" xmlns:D="DAV:">
+//	  <B:member><D:href>/dir/a.txt</D:href><D:getetag>"…"</D:getetag></B:member>
+//	</B:delete>
+//
+// and as JSON {"members": [{"href": "/dir/a.txt", "etag": "\"…\""}]}.
+type batchDelete struct {
+	XMLName xml.Name      `xml:"urn:x-webdav:batch delete" json:"-"`
+	Members []batchMember `xml:"urn:x-webdav:batch member" json:"members"`
+}
+
+type batchMember struct {
+	Href string `xml:"DAV: href" json:"href"`
+	ETag string `xml:"DAV: getetag" json:"etag,omitempty"`
+}
+
+// hasBody reports whether r comes with a body
+func hasBody(r *http.Request) bool {
+	return r.ContentLength != 0
+}
+
+// doBatchDelete answers a POST, or a DELETE with a body, to a collection
+// for Server.BatchDelete. Every member is deleted as a DELETE of it alone
+// would be, with the entity tag given for it as If-Match and the If header
+// of the request, and gets its own status in the 207 answer. A member
+// outside the collection, or refused by BatchAuthorize, gets 403, as does
+// a collection member.
+func (s *Server) doBatchDelete(w http.ResponseWriter, r *http.Request) {
+	if s.ReadOnly || s.DeletesDisabled {
+		glog.Infoln("DAV:", "batch delete attempted, deletes are disabled", r.URL)
+		w.WriteHeader(StatusForbidden)
+		return
+	}
+	dir := s.url2path(r.URL)
+	fi, err := s.stat(dir)
+	if err != nil {
+		http.Error(w, r.RequestURI, StatusNotFound)
+		return
+	}
+	if !fi.IsDir() {
+		s.methodNotAllowed(w, fi)
+		return
+	}
+
+	var req batchDelete
+	body := io.LimitReader(r.Body, maxBatchBody)
+	if t, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); t == "application/json" {
+		err = json.NewDecoder(body).Decode(&req)
+	} else {
+		err = newXMLDecoder(body, maxBatchTokens).Decode(&req)
+	}
+	if err != nil {
+		glog.Infoln("DAV:", "batch delete bad body", r.URL, "error", err)
+		http.Error(w, "bad batch delete body", StatusBadRequest)
+		return
+	}
+
+	prefix := path.Clean("/" + dir)
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="utf-8"?>` + "\n" + `<D:multistatus xmlns:D="DAV:">`)
+	for _, m := range req.Members {
+		status := StatusForbidden
+		name, ok := s.batchMember(r, m.Href)
+		if ok && inCollection(prefix, name) && (s.BatchAuthorize == nil || s.BatchAuthorize(r, path.Clean("/"+name))) {
+			h := http.Header{}
+			if m.ETag != "" {
+				h.Set("If-Match", m.ETag)
+			}
+			if v := r.Header.Get("If"); v != "" {
+				h.Set("If", v)
+			}
+			status = s.deleteResource(r, name, h)
+		}
+		glog.Infoln("DAV:", "batch delete", m.Href, "status", status)
+
+		b.WriteString(`<D:response><D:href>`)
+		xml.EscapeText(&b, []byte(m.Href))
+		b.WriteString(`</D:href><D:status>HTTP/1.1 ` + statusLine(status) + `</D:status></D:response>`)
+	}
+	b.WriteString(`</D:multistatus>`)
+
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(StatusMulti)
+	io.WriteString(w, b.String())
+}
+
+// batchMember returns the name of the member href of the batch delete r,
+// false if it doesn't name a resource of this Server
+func (s *Server) batchMember(r *http.Request, href string) (string, bool) {
+	u, err := url.Parse(href)
+	if err != nil || u.Path == "" {
+		return "", false
+	}
+	if u.Host != "" && u.Host != r.Host {
+		return "", false
+	}
+	u = r.URL.ResolveReference(u)
+	var ok bool
+	if u.Path, ok = s.requestPath(u); !ok {
+		return "", false
+	}
+	if !strings.HasPrefix(u.Path, s.TrimPrefix) {
+		return "", false
+	}
+	return s.url2path(u), true
+}
+
+// inCollection reports whether name lies below the cleaned collection
+// path prefix
+func inCollection(prefix, name string) bool {
+	name = path.Clean("/" + name)
+	if prefix == "/" {
+		return name != "/"
+	}
+	return strings.HasPrefix(name, prefix+"/")
+}
+
+// statusLine returns the code and reason phrase of status
+func statusLine(status int) string {
+	return strconv.Itoa(status) + " " + http.StatusText(status)
+}
@@ -0,0 +1,116 @@
+package webdav
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// TestBatchDeleteMixed sends one batch whose members succeed and fail for
+// every reason a DELETE of them alone would, and some of a batch's own
+func TestBatchDeleteMixed(t *testing.T) {
+	m := NewMemFS()
+	mkdirAll(m, "/dir/sub")
+	mkdirAll(m, "/outside")
+	for _, name := range []string{"/dir/a", "/dir/b", "/dir/stale", "/dir/private", "/dir/sub/c", "/outside/d"} {
+		writeMem(t, m, name, []byte(name))
+	}
+	s := &Server{Fs: m, BatchDelete: true, BatchAuthorize: func(r *http.Request, name string) bool {
+		return name != "/dir/private"
+	}}
+	ts := newTestServer(t, s)
+	resp, _ := request(t, ts, "HEAD", "/dir/b", "")
+	etag := resp.Header.Get("ETag")
+
+	resp, body := request(t, ts, "POST", "/dir/", `{"members": [
+		{"href": "/dir/a"},
+		{"href": "/dir/b", "etag": `+strconv.Quote(etag)+`},
+		{"href": "/dir/stale", "etag": "\"stale\""},
+		{"href": "/dir/sub"},
+		{"href": "/dir/missing"},
+		{"href": "/dir/private"},
+		{"href": "/outside/d"},
+		{"href": "sub/c"}]}`, "Content-Type", "application/json")
+	wantStatus(t, resp, StatusMulti)
+	for href, status := range map[string]string{
+		"/dir/a":       "204",
+		"/dir/b":       "204",
+		"/dir/stale":   "412",
+		"/dir/sub":     "403",
+		"/dir/missing": "404",
+		"/dir/private": "403",
+		"/outside/d":   "403",
+		"sub/c":        "204",
+	} {
+		if want := "<D:href>" + href + "</D:href><D:status>HTTP/1.1 " + status + " "; !strings.Contains(body, want) {
+			t.Errorf("multistatus lacks %s", want)
+		}
+	}
+	for name, exists := range map[string]bool{
+		"/dir/a": false, "/dir/b": false, "/dir/sub/c": false,
+		"/dir/stale": true, "/dir/sub": true, "/dir/private": true, "/outside/d": true,
+	} {
+		if _, err := s.stat(name); (err == nil) != exists {
+			t.Errorf("%s: exists %v after the batch, want %v", name, err == nil, exists)
+		}
+	}
+
+	// Client.RemoveAll reports exactly the members that failed
+	c, err := NewClient(ts.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	writeMem(t, m, "/dir/e", []byte("e"))
+	err = c.RemoveAll(context.Background(), []string{"/dir/e", "/dir/sub", "/dir/private"})
+	var me *MultiError
+	if !errors.As(err, &me) {
+		t.Fatalf("RemoveAll: %v, want a *MultiError", err)
+	}
+	var failed []string
+	for _, e := range me.Errors {
+		if e.Code != StatusForbidden {
+			t.Errorf("RemoveAll: %v", e)
+		}
+		failed = append(failed, e.URL[strings.LastIndex(e.URL, "/"):])
+	}
+	slices.Sort(failed)
+	if !slices.Equal(failed, []string{"/private", "/sub"}) {
+		t.Errorf("RemoveAll failed for %q", failed)
+	}
+	if _, err := s.stat("/dir/e"); err == nil {
+		t.Error("RemoveAll left /dir/e")
+	}
+}
+
+// TestBatchDeleteIf checks the If header of a batch applies to each member
+// as it would to a DELETE of it alone, lock tokens holding only under
+// FakeLocks
+func TestBatchDeleteIf(t *testing.T) {
+	for _, fake := range []bool{false, true} {
+		m := NewMemFS()
+		mkdirAll(m, "/dir")
+		writeMem(t, m, "/dir/a", []byte("a"))
+		writeMem(t, m, "/dir/b", []byte("b"))
+		ts := newTestServer(t, &Server{Fs: m, BatchDelete: true, FakeLocks: fake})
+
+		batch := `{"members": [{"href": "/dir/a"}, {"href": "/dir/b"}]}`
+		resp, body := request(t, ts, "POST", "/dir", batch, "Content-Type", "application/json", "If", "(")
+		wantStatus(t, resp, StatusMulti)
+		if n := strings.Count(body, "HTTP/1.1 400 "); n != 2 {
+			t.Errorf("malformed If: %d members with 400 in %s", n, body)
+		}
+
+		resp, body = request(t, ts, "POST", "/dir", batch, "Content-Type", "application/json",
+			"If", "<"+ts.URL+"/dir> (<urn:uuid:4f2a0b7e-1c3d-4e5f-8a9b-0c1d2e3f4a5b>)")
+		wantStatus(t, resp, StatusMulti)
+		want := map[bool]string{false: "412", true: "204"}[fake]
+		if n := strings.Count(body, "HTTP/1.1 "+want+" "); n != 2 {
+			t.Errorf("FakeLocks %v: %d members with %s in %s", fake, n, want, body)
+		}
+	}
+}
@@ -0,0 +1,233 @@
+package webdav
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// benchBackends are the FileSystems the handler benchmarks run over. Dir
+// lives on tmpfs where /dev/shm exists, so the disk doesn't dominate.
+var benchBackends = map[string]func(b *testing.B) FileSystem{
+	"memfs": func(b *testing.B) FileSystem { return NewMemFS() },
+	"dir": func(b *testing.B) FileSystem {
+		if fi, err := os.Stat("/dev/shm"); err == nil && fi.IsDir() {
+			dir, err := os.MkdirTemp("/dev/shm", "webdav-bench-")
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.Cleanup(func() { os.RemoveAll(dir) })
+			return Dir(dir)
+		}
+		return Dir(b.TempDir())
+	},
+}
+
+// latencies collects the durations of requests and reports their
+// percentiles as metrics of b
+type latencies struct {
+	mu sync.Mutex
+	d  []time.Duration
+}
+
+func (l *latencies) add(d time.Duration) {
+	l.mu.Lock()
+	l.d = append(l.d, d)
+	l.mu.Unlock()
+}
+
+func (l *latencies) report(b *testing.B) {
+	if len(l.d) == 0 {
+		return
+	}
+	sort.Slice(l.d, func(i, j int) bool { return l.d[i] < l.d[j] })
+	for _, p := range []int{50, 90, 99} {
+		b.ReportMetric(float64(l.d[(len(l.d)-1)*p/100].Microseconds()), fmt.Sprintf("p%d-µs", p))
+	}
+}
+
+// benchRequest sends a request and reads the answer, failing b unless it
+// has one of statuses. It doesn't stop b, so RunParallel bodies can call it.
+func benchRequest(b *testing.B, c *http.Client, req *http.Request, l *latencies, statuses ...int) int64 {
+	start := time.Now()
+	resp, err := c.Do(req)
+	if err != nil {
+		b.Error(err)
+		return 0
+	}
+	n, err := io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		b.Error(err)
+	}
+	l.add(time.Since(start))
+	for _, status := range statuses {
+		if resp.StatusCode == status {
+			return n
+		}
+	}
+	b.Errorf("%s %s: %d, want %v", req.Method, req.URL.Path, resp.StatusCode, statuses)
+	return n
+}
+
+// benchServer serves fsys over HTTP for the benchmark, writing files of the
+// given sizes to fsys first
+func benchServer(b *testing.B, fsys FileSystem, files map[string]int) (*httptest.Server, *http.Client) {
+	for name, size := range files {
+		f, err := fsys.Create(name)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if _, err := f.Write(bytes.Repeat([]byte("x"), size)); err != nil {
+			b.Fatal(err)
+		}
+		f.Close()
+	}
+	ts := httptest.NewServer(&Server{Fs: fsys, TrimPrefix: "/", Listings: true})
+	b.Cleanup(ts.Close)
+	c := ts.Client()
+	c.Transport.(*http.Transport).MaxIdleConnsPerHost = 64
+	return ts, c
+}
+
+func benchGet(b *testing.B, size int, rng string) {
+	for name, mk := range benchBackends {
+		b.Run(name, func(b *testing.B) {
+			ts, c := benchServer(b, mk(b), map[string]int{"/f": size})
+			status, n := StatusOK, int64(size)
+			if rng != "" {
+				status, n = StatusPartialContent, 4096
+			}
+			var l latencies
+			b.SetBytes(n)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				req, _ := http.NewRequest("GET", ts.URL+"/f", nil)
+				if rng != "" {
+					req.Header.Set("Range", rng)
+				}
+				benchRequest(b, c, req, &l, status)
+			}
+			l.report(b)
+		})
+	}
+}
+
+func BenchmarkGetSmall(b *testing.B) {
+	b.Run("whole", func(b *testing.B) { benchGet(b, 4<<10, "") })
+	b.Run("range", func(b *testing.B) { benchGet(b, 4<<10, "bytes=0-4095") })
+}
+
+func BenchmarkGetLarge(b *testing.B) {
+	b.Run("whole", func(b *testing.B) { benchGet(b, 64<<20, "") })
+	b.Run("range", func(b *testing.B) { benchGet(b, 64<<20, "bytes=33554432-33558527") })
+}
+
+func BenchmarkPutStream(b *testing.B) {
+	for _, size := range []int{4 << 10, 1 << 20, 32 << 20} {
+		data := bytes.Repeat([]byte("0123456789abcdef"), size/16)
+		for name, mk := range benchBackends {
+			b.Run(fmt.Sprintf("%dKiB/%s", size>>10, name), func(b *testing.B) {
+				ts, c := benchServer(b, mk(b), nil)
+				var l latencies
+				b.SetBytes(int64(size))
+				b.ReportAllocs()
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					// a body of unknown size is streamed chunked
+					req, _ := http.NewRequest("PUT", ts.URL+"/f", struct{ io.Reader }{bytes.NewReader(data)})
+					status := StatusNoContent
+					if i == 0 {
+						status = StatusCreated
+					}
+					benchRequest(b, c, req, &l, status)
+				}
+				l.report(b)
+			})
+		}
+	}
+}
+
+// BenchmarkListDepth1 lists collections of 100 and 10k members. The
+// Server answers no PROPFIND, its JSON listing is the Depth 1 read it
+// has.
+func BenchmarkListDepth1(b *testing.B) {
+	for _, n := range []int{100, 10000} {
+		for name, mk := range benchBackends {
+			b.Run(fmt.Sprintf("%d/%s", n, name), func(b *testing.B) {
+				fsys := mk(b)
+				if err := fsys.Mkdir("/d"); err != nil {
+					b.Fatal(err)
+				}
+				files := make(map[string]int, n)
+				for i := 0; i < n; i++ {
+					files[fmt.Sprintf("/d/f%05d", i)] = 0
+				}
+				ts, c := benchServer(b, fsys, files)
+				var l latencies
+				b.ReportAllocs()
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					req, _ := http.NewRequest("GET", ts.URL+"/d", nil)
+					req.Header.Set("Accept", "application/json")
+					b.SetBytes(benchRequest(b, c, req, &l, StatusOK))
+				}
+				l.report(b)
+			})
+		}
+	}
+}
+
+// BenchmarkConcurrentMixed sends GETs, PUTs, HEADs and COPYs of small
+// files from many goroutines
+func BenchmarkConcurrentMixed(b *testing.B) {
+	for name, mk := range benchBackends {
+		b.Run(name, func(b *testing.B) {
+			files := make(map[string]int)
+			for i := 0; i < 32; i++ {
+				files[fmt.Sprintf("/f%02d", i)] = 16 << 10
+			}
+			ts, c := benchServer(b, mk(b), files)
+			data := strings.Repeat("x", 16<<10)
+			var l latencies
+			var seq atomic.Int64
+			b.ReportAllocs()
+			b.SetParallelism(4)
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					i := seq.Add(1)
+					name := fmt.Sprintf("/f%02d", i%32)
+					var req *http.Request
+					statuses := []int{StatusOK}
+					switch i % 10 {
+					case 0, 1:
+						req, _ = http.NewRequest("PUT", ts.URL+name, strings.NewReader(data))
+						statuses = []int{StatusNoContent}
+					case 2:
+						req, _ = http.NewRequest("HEAD", ts.URL+name, nil)
+					case 3:
+						// created the first time, replaced after
+						req, _ = http.NewRequest("COPY", ts.URL+name, nil)
+						req.Header.Set("Destination", fmt.Sprintf("%s/copy%02d", ts.URL, i%32))
+						statuses = []int{StatusCreated, StatusNoContent}
+					default:
+						req, _ = http.NewRequest("GET", ts.URL+name, nil)
+					}
+					benchRequest(b, c, req, &l, statuses...)
+				}
+			})
+			l.report(b)
+		})
+	}
+}
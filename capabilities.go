@@ -0,0 +1,147 @@
+package webdav
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// capabilitiesName is the name of the capabilities document at the root
+const capabilitiesName = ".capabilities"
+
+// Capabilities reports what a Server supports. It is derived from the
+// options of the Server and the optional interfaces of its FileSystem each
+// time it is asked for, the same checks the handlers make, so it can't
+// disagree with what the Server does.
+type Capabilities struct {
+	// compliance classes for the DAV header, none unless FakeLocks as
+	// PROPFIND is only answered for the trash
+	DAV []string `json:"dav"`
+
+	// every method some resource allows
+	Methods []string `json:"methods"`
+
+	ReadOnly       bool  `json:"read_only"`
+	Deletes        bool  `json:"deletes"`
+	Listings       bool  `json:"listings"`
+	ZipDownloads   bool  `json:"zip_downloads"`
+	ChangeFeed     bool  `json:"change_feed"`
+	SyncCollection bool  `json:"sync_collection"`
+	FakeLocks      bool  `json:"fake_locks"`
+	BatchDelete    bool  `json:"batch_delete"`
+	Trash          bool  `json:"trash"` // /.trash lists, restores and purges
+	MaxUploadSize  int64 `json:"max_upload_size,omitempty"`
+
+	// from the FileSystem
+	Checksums       []string `json:"checksums,omitempty"` // X-Checksum-* algorithms
+	StrongETags     bool     `json:"strong_etags"`        // ETags of the content, not of mtime and size
+	SetMTime        bool     `json:"set_mtime"`           // X-OC-MTime is honored
+	ExternalChanges bool     `json:"external_changes"`    // changes not made through the Server are seen
+	AtomicUploads   bool     `json:"atomic_uploads"`      // a failed PUT leaves the previous file
+	ExclusiveCreate bool     `json:"exclusive_create"`    // If-None-Match: * is atomic
+	ServerSideCopy  bool     `json:"server_side_copy"`
+	UnixProperties  bool     `json:"unix_properties"` // owner, group and unix-mode in REPORT
+}
+
+// Capabilities returns what the Server supports, see Capabilities
+func (s *Server) Capabilities() Capabilities {
+	c := Capabilities{
+		DAV:            s.davClasses(),
+		ReadOnly:       s.ReadOnly,
+		Deletes:        !s.ReadOnly && !s.DeletesDisabled,
+		Listings:       s.Listings,
+		ZipDownloads:   s.ZipDownloads,
+		ChangeFeed:     s.ChangeFeed,
+		SyncCollection: s.SyncCollection,
+		FakeLocks:      s.FakeLocks,
+		BatchDelete:    s.BatchDelete && !s.ReadOnly && !s.DeletesDisabled,
+		MaxUploadSize:  s.MaxUploadSize,
+		Trash:          s.ServeTrash && supports[Trasher](s.Fs),
+
+		StrongETags:     supports[ETagger](s.Fs),
+		SetMTime:        s.OCMTime && supports[Chtimer](s.Fs),
+		ExternalChanges: supports[Watcher](s.Fs),
+		AtomicUploads:   supports[TempFiler](s.Fs) || supports[Renamer](s.Fs),
+		ExclusiveCreate: supports[OpenFiler](s.Fs),
+		ServerSideCopy:  supports[Copier](s.Fs),
+		UnixProperties:  s.UnixProperties && s.SyncCollection && supports[OwnerFS](s.Fs),
+	}
+
+	// PUT and GET only ever send SHA-256, and PUT does for any ChecksumFS
+	if supports[ChecksumFS](s.Fs) {
+		c.Checksums = append(c.Checksums, "SHA-256")
+	}
+
+	seen := map[string]bool{}
+	for _, fi := range []os.FileInfo{nil, &memFileInfo{mode: os.ModeDir}, &memFileInfo{}} {
+		for _, m := range strings.Split(s.allow(fi), ", ") {
+			if !seen[m] {
+				seen[m] = true
+				c.Methods = append(c.Methods, m)
+			}
+		}
+	}
+	if c.Trash {
+		for _, m := range strings.Split(s.allowTrash("-"), ", ") {
+			if !seen[m] {
+				seen[m] = true
+				c.Methods = append(c.Methods, m)
+			}
+		}
+	}
+	return c
+}
+
+// davClasses returns the compliance classes of the DAV header
+func (s *Server) davClasses() []string {
+	if s.FakeLocks {
+		return []string{"1", "2"}
+	}
+	return []string{}
+}
+
+// supports reports whether fsys implements the optional interface T. A
+// wrapper like LockedFS implements them all, returning ErrNotImplemented
+// where its inner FileSystem doesn't, so it is looked through, unless it
+// provides T by itself as TrashFS does Trasher.
+func supports[T any](fsys FileSystem) bool {
+	for {
+		if _, ok := fsys.(T); !ok {
+			return false
+		}
+		if p, ok := fsys.(interface{ provides(any) bool }); ok && p.provides((*T)(nil)) {
+			return true
+		}
+		u, ok := fsys.(interface{ Unwrap() FileSystem })
+		if !ok {
+			return true
+		}
+		fsys = u.Unwrap()
+	}
+}
+
+// capability returns fsys as the optional interface T if supports says it
+// has it, for handlers that degrade without it
+func capability[T any](fsys FileSystem) (T, bool) {
+	t, ok := fsys.(T)
+	if !ok || !supports[T](fsys) {
+		var zero T
+		return zero, false
+	}
+	return t, true
+}
+
+// serveCapabilities answers GET of /.capabilities with the Capabilities
+// as JSON, for Server.ServeCapabilities
+func (s *Server) serveCapabilities(w http.ResponseWriter, r *http.Request) {
+	b, err := json.MarshalIndent(s.Capabilities(), "", "  ")
+	if err != nil {
+		w.WriteHeader(StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	http.ServeContent(w, r, "", time.Time{}, strings.NewReader(string(b)+"\n"))
+}
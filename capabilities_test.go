@@ -0,0 +1,168 @@
+package webdav
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// TestCapabilitiesFollowOptions flips each option of a Server and checks
+// the report changes with it, and only where it should
+func TestCapabilitiesFollowOptions(t *testing.T) {
+	fsys := NewMemFS()
+	before := (&Server{Fs: fsys}).Capabilities()
+	if before.ReadOnly || !before.Deletes || before.Listings || before.FakeLocks || len(before.DAV) != 0 {
+		t.Fatalf("defaults %+v", before)
+	}
+	has := func(c Capabilities, method string) bool { return slices.Contains(c.Methods, method) }
+
+	for _, tc := range []struct {
+		name string
+		set  func(*Server)
+		want func(Capabilities) bool
+	}{
+		{"ReadOnly", func(s *Server) { s.ReadOnly = true }, func(c Capabilities) bool {
+			return c.ReadOnly && !c.Deletes && !has(c, "PUT") && !has(c, "DELETE") && !has(c, "COPY")
+		}},
+		{"DeletesDisabled", func(s *Server) { s.DeletesDisabled = true }, func(c Capabilities) bool {
+			return !c.Deletes && !has(c, "DELETE") && has(c, "PUT")
+		}},
+		{"Listings", func(s *Server) { s.Listings = true }, func(c Capabilities) bool { return c.Listings }},
+		{"ZipDownloads", func(s *Server) { s.ZipDownloads = true }, func(c Capabilities) bool { return c.ZipDownloads }},
+		{"ChangeFeed", func(s *Server) { s.ChangeFeed = true }, func(c Capabilities) bool { return c.ChangeFeed }},
+		{"SyncCollection", func(s *Server) { s.SyncCollection = true }, func(c Capabilities) bool {
+			return c.SyncCollection && has(c, "REPORT")
+		}},
+		{"FakeLocks", func(s *Server) { s.FakeLocks = true }, func(c Capabilities) bool {
+			return c.FakeLocks && reflect.DeepEqual(c.DAV, []string{"1", "2"}) && has(c, "LOCK") && has(c, "UNLOCK")
+		}},
+		{"BatchDelete", func(s *Server) { s.BatchDelete = true }, func(c Capabilities) bool {
+			return c.BatchDelete && has(c, "POST")
+		}},
+		{"MaxUploadSize", func(s *Server) { s.MaxUploadSize = 1 << 20 }, func(c Capabilities) bool {
+			return c.MaxUploadSize == 1<<20
+		}},
+		{"OCMTime", func(s *Server) { s.OCMTime = true }, func(c Capabilities) bool { return c.SetMTime }},
+	} {
+		if tc.want(before) {
+			t.Errorf("%s: the report of the defaults already matches", tc.name)
+		}
+		s := &Server{Fs: fsys}
+		tc.set(s)
+		if after := s.Capabilities(); !tc.want(after) {
+			t.Errorf("%s set: report %+v", tc.name, after)
+		}
+	}
+
+	// options that depend on others
+	for name, s := range map[string]*Server{
+		"BatchDelete with ReadOnly":        {Fs: fsys, BatchDelete: true, ReadOnly: true},
+		"BatchDelete with DeletesDisabled": {Fs: fsys, BatchDelete: true, DeletesDisabled: true},
+	} {
+		if c := s.Capabilities(); c.BatchDelete || slices.Contains(c.Methods, "POST") {
+			t.Errorf("%s: report %+v", name, c)
+		}
+	}
+	if c := (&Server{Fs: struct{ FileSystem }{fsys}, OCMTime: true}).Capabilities(); c.SetMTime {
+		t.Error("OCMTime without a Chtimer reported")
+	}
+}
+
+// TestCapabilitiesFollowFileSystem checks the report against the optional
+// interfaces of the FileSystem, looking through wrappers
+func TestCapabilitiesFollowFileSystem(t *testing.T) {
+	plain := struct{ FileSystem }{Dir(t.TempDir())}
+	for _, tc := range []struct {
+		name string
+		fsys FileSystem
+		want bool
+	}{
+		{"dir", Dir(t.TempDir()), true},
+		{"memfs", NewMemFS(), true},
+		{"lockedfs over dir", NewLockedFS(Dir(t.TempDir())), true},
+		{"plain", plain, false},
+		{"lockedfs over plain", NewLockedFS(plain), false},
+		{"readahead over plain", NewReadAheadFS(plain, 0, 0), false},
+	} {
+		c := (&Server{Fs: tc.fsys}).Capabilities()
+		if c.AtomicUploads != tc.want || c.ExclusiveCreate != tc.want || c.ServerSideCopy != tc.want {
+			t.Errorf("%s: atomic uploads %v, exclusive create %v, server-side copy %v, want %v",
+				tc.name, c.AtomicUploads, c.ExclusiveCreate, c.ServerSideCopy, tc.want)
+		}
+		if !tc.want && (c.StrongETags || len(c.Checksums) > 0 || c.ExternalChanges) {
+			t.Errorf("%s: report %+v", tc.name, c)
+		}
+	}
+	if c := (&Server{Fs: Dir(t.TempDir())}).Capabilities(); !slices.Equal(c.Checksums, []string{"SHA-256"}) {
+		t.Errorf("dir checksums %q", c.Checksums)
+	}
+
+	// checksums are reported without computing one
+	counting := &countingChecksumFS{FileSystem: Dir(t.TempDir())}
+	if c := (&Server{Fs: NewLockedFS(counting)}).Capabilities(); !slices.Equal(c.Checksums, []string{"SHA-256"}) || counting.calls > 0 {
+		t.Errorf("checksums %q after %d calls of Checksum", c.Checksums, counting.calls)
+	}
+
+	// every GET carries an ETag, strong ones come from an ETagger
+	if c := (&Server{Fs: NewMemFS()}).Capabilities(); c.StrongETags {
+		t.Error("memfs reports strong ETags")
+	}
+	dedup, err := NewDedupFS(Dir(t.TempDir()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := (&Server{Fs: dedup}).Capabilities(); !c.StrongETags {
+		t.Error("dedupfs reports no strong ETags")
+	}
+}
+
+// countingChecksumFS counts the calls of Checksum
+type countingChecksumFS struct {
+	FileSystem
+	calls int
+}
+
+func (c *countingChecksumFS) Checksum(name, algo string) (string, error) {
+	c.calls++
+	return "", ErrNotImplemented
+}
+
+// TestServeCapabilities fetches /.capabilities, which agrees with the
+// OPTIONS headers and sits behind the same authentication as the tree
+func TestServeCapabilities(t *testing.T) {
+	s := &Server{Fs: NewMemFS(), TrimPrefix: "/", ServeCapabilities: true, FakeLocks: true, SyncCollection: true}
+	defer s.Close()
+	ts := httptest.NewServer(requireAuth(s, "alice", "secret"))
+	defer ts.Close()
+	alice := conformanceClient{ts, "alice", "secret"}
+
+	if x := (conformanceClient{ts: ts}).do(t, "GET", "/"+capabilitiesName, ""); x.resp.StatusCode != StatusUnauthorized {
+		t.Errorf("anonymous GET: %d", x.resp.StatusCode)
+	}
+	x := alice.do(t, "GET", "/"+capabilitiesName, "")
+	x.want(t, StatusOK)
+	var got Capabilities
+	if err := json.Unmarshal([]byte(x.body), &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, s.Capabilities()) {
+		t.Errorf("served %+v\nwant %+v", got, s.Capabilities())
+	}
+
+	opts := alice.do(t, "OPTIONS", "/", "")
+	if dav := opts.resp.Header.Get("DAV"); dav != strings.Join(got.DAV, ", ") {
+		t.Errorf("DAV header %q, report %q", dav, got.DAV)
+	}
+	for _, m := range strings.Split(opts.resp.Header.Get("Allow"), ", ") {
+		if !slices.Contains(got.Methods, m) {
+			t.Errorf("OPTIONS allows %s, the report doesn't list it", m)
+		}
+	}
+
+	// without the option it is an ordinary missing file
+	s.ServeCapabilities = false
+	alice.do(t, "GET", "/"+capabilitiesName, "").want(t, StatusNotFound)
+}
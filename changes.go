@@ -0,0 +1,264 @@
+package webdav
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// changesName is the last element of the change feed URL of a directory
+const changesName = ".changes"
+
+// feedSize is the number of changes the feed keeps, a client further
+// behind has to resync
+const feedSize = 1024
+
+// feedKeepAlive is how often an idle event stream sends a comment, so
+// proxies don't time it out
+const feedKeepAlive = 30 * time.Second
+
+// change is an entry of the change feed
+type change struct {
+	seq    uint64    // its place in the feed
+	Cursor string    `json:"cursor"`
+	Op     string    `json:"op"`
+	Path   string    `json:"path"`
+	ETag   string    `json:"etag,omitempty"`
+	Time   time.Time `json:"time"`
+}
+
+// feed records the last feedSize changes of a Server from Server.Watch.
+// Readers keep nothing but a cursor, so a slow one costs no memory and
+// learns it fell behind when its cursor has left the ring. A watch that
+// loses events, the feed's own included when it falls behind, sends an
+// OpResync in their place, which ends every cursor before it: readers are
+// reset rather than handed a list with changes missing.
+type feed struct {
+	once sync.Once
+	err  error // from Server.Watch, the feed is unavailable
+
+	// tells this feed's cursors from those of a previous process, see
+	// cursor
+	epoch string
+
+	mu     sync.Mutex
+	stop   func()
+	closed bool
+	next   uint64 // cursor of the next change, cursors start at 1
+	floor  uint64 // cursors before it missed lost events
+	ring   [feedSize]change
+	wake   chan struct{} // closed and replaced on every change
+}
+
+// start begins recording, the first request for the feed calls it
+func (f *feed) start(s *Server) error {
+	f.once.Do(func() {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		if f.closed {
+			f.err = errServerClosed
+			return
+		}
+		f.next = 1
+		f.epoch = strconv.FormatInt(time.Now().UnixNano(), 36)
+		f.wake = make(chan struct{})
+		ch, stop, err := s.Watch("/")
+		if err != nil {
+			glog.Infoln("DAV:", "change feed unavailable, error", err)
+			f.err = err
+			return
+		}
+		f.stop = stop
+
+		go func() {
+			for ev := range ch {
+				if strings.HasPrefix(path.Base(ev.Path), TempPrefix) {
+					// the upload shows up when it is renamed into place
+					continue
+				}
+				c := change{Op: ev.Op.String(), Path: ev.Path, Time: ev.Time}
+				if ev.Op != OpRemove && ev.Op != OpRename {
+					if fi, err := s.stat(ev.Path); err == nil && !fi.IsDir() {
+						c.ETag = s.etag(ev.Path, fi)
+					}
+				}
+				f.add(c)
+			}
+		}()
+	})
+	return f.err
+}
+
+// cursor returns the cursor of the change n as clients see it, qualified
+// by the epoch so one of a previous process is never taken for one of this
+func (f *feed) cursor(n uint64) string {
+	return f.epoch + "-" + strconv.FormatUint(n, 10)
+}
+
+// parseCursor returns the change a cursor of this feed names, false for
+// a cursor of another epoch or not a cursor at all
+func (f *feed) parseCursor(cursor string) (uint64, bool) {
+	epoch, n, ok := strings.Cut(cursor, "-")
+	if !ok || epoch != f.epoch {
+		return 0, false
+	}
+	c, err := strconv.ParseUint(n, 10, 64)
+	return c, err == nil
+}
+
+// add records c, a resync also ends every cursor before it
+func (f *feed) add(c change) {
+	f.mu.Lock()
+	c.seq, c.Cursor = f.next, f.cursor(f.next)
+	if c.Op == OpResync.String() {
+		f.floor = c.seq
+	}
+	f.ring[f.next%feedSize] = c
+	f.next++
+	close(f.wake)
+	f.wake = make(chan struct{})
+	f.mu.Unlock()
+}
+
+// since returns the changes after cursor below prefix, the cursor to ask
+// for next and a channel closed by the next change. ok is false when
+// cursor is no longer, or not yet, in the ring, or precedes a resync.
+func (f *feed) since(cursor uint64, prefix string) (changes []change, next uint64, wake <-chan struct{}, ok bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	last := f.next - 1
+	if cursor > last || last-cursor > feedSize || cursor < f.floor {
+		return nil, last, f.wake, false
+	}
+	for c := cursor + 1; c <= last; c++ {
+		if ch := f.ring[c%feedSize]; inPrefix(ch.Path, prefix) {
+			changes = append(changes, ch)
+		}
+	}
+	return changes, last, f.wake, true
+}
+
+// close stops recording, a feed that hasn't started yet never will
+func (f *feed) close() {
+	f.mu.Lock()
+	stop := f.stop
+	f.closed = true
+	f.mu.Unlock()
+	if stop != nil {
+		stop()
+	}
+}
+
+// serveChanges answers GET of <dir>/.changes with the changes below dir,
+// for Server.ChangeFeed. With Accept: text/event-stream it is a stream of
+// server-sent events named "change", their id being the cursor, which
+// continues from Last-Event-ID or ?since. Otherwise it is a JSON object of
+// the changes after ?since and the cursor to ask for next; without since
+// it only reports the current cursor. Cursors name the process that gave
+// them out. One that fell out of the feed, or one of a previous process,
+// gets 410 Gone, or a "reset" event that ends the stream: the client has
+// to list the directory again and continue from the cursor it carries.
+func (s *Server) serveChanges(w http.ResponseWriter, r *http.Request, dir string) {
+	if err := s.feed.start(s); err != nil {
+		http.Error(w, "change feed unavailable", StatusNotImplemented)
+		return
+	}
+	prefix := path.Clean("/" + dir)
+
+	cursor := r.URL.Query().Get("since")
+	if id := r.Header.Get("Last-Event-ID"); id != "" {
+		cursor = id
+	}
+
+	if strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+		s.streamChanges(w, r, prefix, cursor)
+		return
+	}
+
+	_, next, _, _ := s.feed.since(0, prefix)
+	body := struct {
+		Cursor  string   `json:"cursor"`
+		Changes []change `json:"changes"`
+		Reset   bool     `json:"reset,omitempty"`
+	}{Cursor: s.feed.cursor(next), Changes: []change{}}
+	status := StatusOK
+
+	if cursor != "" {
+		n, ok := s.feed.parseCursor(cursor)
+		var changes []change
+		if ok {
+			changes, next, _, ok = s.feed.since(n, prefix)
+		}
+		body.Cursor = s.feed.cursor(next)
+		if ok {
+			body.Changes = append(body.Changes, changes...)
+		} else {
+			body.Reset = true
+			status = http.StatusGone
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+func (s *Server) streamChanges(w http.ResponseWriter, r *http.Request, prefix, cursor string) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", StatusInternalServerError)
+		return
+	}
+
+	_, n, _, _ := s.feed.since(0, prefix)
+	valid := true
+	if cursor != "" {
+		n, valid = s.feed.parseCursor(cursor)
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(feedKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		changes, next, wake, ok := s.feed.since(n, prefix)
+		if !ok || !valid {
+			fmt.Fprintf(w, "event: reset\ndata: {\"cursor\":%q}\n\n", s.feed.cursor(next))
+			flusher.Flush()
+			return
+		}
+		for _, c := range changes {
+			data, _ := json.Marshal(c)
+			fmt.Fprintf(w, "id: %s\nevent: change\ndata: %s\n\n", c.Cursor, data)
+		}
+		if len(changes) > 0 {
+			flusher.Flush()
+		}
+		n = next
+
+		select {
+		case <-wake:
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		case <-s.done():
+			return
+		}
+	}
+}
@@ -0,0 +1,265 @@
+package webdav
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFeedResyncEndsCursors(t *testing.T) {
+	f := &feed{next: 1, wake: make(chan struct{})}
+	f.add(change{Op: OpCreate.String(), Path: "/a"})
+	f.add(change{Op: OpResync.String(), Path: "/"})
+	f.add(change{Op: OpWrite.String(), Path: "/b"})
+
+	if _, _, _, ok := f.since(1, "/"); ok {
+		t.Error("cursor from before the resync is still valid")
+	}
+	changes, next, _, ok := f.since(2, "/")
+	if !ok || next != 3 || len(changes) != 1 || changes[0].Path != "/b" {
+		t.Errorf("since(2) = %v, %d, %v", changes, next, ok)
+	}
+}
+
+// sseEvent is an event of a text/event-stream
+type sseEvent struct {
+	id, event, data string
+}
+
+// readEvents sends the events of an event stream to a channel, closed at
+// its end
+func readEvents(r io.Reader) <-chan sseEvent {
+	events := make(chan sseEvent, 100)
+	go func() {
+		defer close(events)
+		sc := bufio.NewScanner(r)
+		var ev sseEvent
+		for sc.Scan() {
+			field, value, _ := strings.Cut(sc.Text(), ": ")
+			switch field {
+			case "id":
+				ev.id = value
+			case "event":
+				ev.event = value
+			case "data":
+				ev.data = value
+			case "":
+				if ev.event != "" {
+					events <- ev
+				}
+				ev = sseEvent{}
+			}
+		}
+	}()
+	return events
+}
+
+// TestChangeStreamOrder holds the event stream of a directory open while
+// PUT and DELETE change it, over a FileSystem that watches itself and over
+// one whose changes only the Server's requests report
+func TestChangeStreamOrder(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		fsys FileSystem
+		want string
+	}{
+		// the rename into place of the replaced /dir/b creates it again
+		{"memfs", NewMemFS(), "create /dir/a,create /dir/b,remove /dir/a,create /dir/b,remove /dir/b"},
+		{"server", struct{ FileSystem }{NewMemFS()}, "create /dir/a,create /dir/b,remove /dir/a,write /dir/b,remove /dir/b"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fsys := tc.fsys
+			fsys.Mkdir("/dir")
+			s := &Server{Fs: fsys, TrimPrefix: "/", ChangeFeed: true}
+			defer s.Close()
+			ts := httptest.NewServer(requireAuth(s, "alice", "secret"))
+			defer ts.Close()
+
+			req, _ := http.NewRequest("GET", ts.URL+"/dir/.changes", nil)
+			req.Header.Set("Accept", "text/event-stream")
+			if resp, err := ts.Client().Do(req); err != nil || resp.StatusCode != StatusUnauthorized {
+				t.Fatalf("anonymous stream: %v %v", resp, err)
+			}
+			req.SetBasicAuth("alice", "secret")
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			resp, err := ts.Client().Do(req.WithContext(ctx))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			wantStatus(t, resp, StatusOK)
+			events := readEvents(resp.Body)
+
+			c := conformanceClient{ts, "alice", "secret"}
+			c.do(t, "PUT", "/dir/a", "1").want(t, StatusCreated)
+			c.do(t, "PUT", "/other", "x").want(t, StatusCreated)
+			c.do(t, "PUT", "/dir/b", "2").want(t, StatusCreated)
+			c.do(t, "DELETE", "/dir/a", "").want(t, StatusNoContent)
+			c.do(t, "PUT", "/dir/b", "3").want(t, StatusNoContent)
+			c.do(t, "DELETE", "/dir/b", "").want(t, StatusNoContent)
+
+			var got []string
+			var last uint64
+			for len(got) < 5 {
+				var ev sseEvent
+				select {
+				case ev = <-events:
+				case <-ctx.Done():
+					t.Fatalf("events %v, then none", got)
+				}
+				var ch change
+				if err := json.Unmarshal([]byte(ev.data), &ch); err != nil || ev.event != "change" {
+					t.Fatalf("event %+v: %v", ev, err)
+				}
+				if id, ok := s.feed.parseCursor(ev.id); !ok || id <= last || ev.id != ch.Cursor {
+					t.Errorf("event id %s after %d, cursor %s", ev.id, last, ch.Cursor)
+				} else {
+					last = id
+				}
+				got = append(got, ch.Op+" "+ch.Path)
+			}
+			// the PUT of /other came before the last of them
+			if s := strings.Join(got, ","); s != tc.want {
+				t.Errorf("events %s\nwant %s", s, tc.want)
+			}
+		})
+	}
+}
+
+// TestChangesCursorEpoch checks a cursor of a previous process, whose
+// feed numbered its changes from 1 as well, resets the client rather than
+// skipping the changes between
+func TestChangesCursorEpoch(t *testing.T) {
+	changes := func(ts *httptest.Server, since string) (int, string, bool) {
+		t.Helper()
+		resp, body := request(t, ts, "GET", "/.changes?since="+since, "")
+		var got struct {
+			Cursor string
+			Reset  bool
+		}
+		if err := json.Unmarshal([]byte(body), &got); err != nil {
+			t.Fatalf("%s: %v", body, err)
+		}
+		return resp.StatusCode, got.Cursor, got.Reset
+	}
+
+	old := &Server{Fs: NewMemFS(), TrimPrefix: "/", ChangeFeed: true}
+	ts := newTestServer(t, old)
+	resp, _ := request(t, ts, "PUT", "/a", "1")
+	wantStatus(t, resp, StatusCreated)
+	_, cursor, _ := changes(ts, "")
+	ts.Close()
+	old.Close()
+
+	s := &Server{Fs: NewMemFS(), TrimPrefix: "/", ChangeFeed: true}
+	defer s.Close()
+	ts = newTestServer(t, s)
+	_, current, _ := changes(ts, "")
+	for _, name := range []string{"/b", "/c", "/d"} {
+		resp, _ := request(t, ts, "PUT", name, "1")
+		wantStatus(t, resp, StatusCreated)
+	}
+	if _, ok := s.feed.parseCursor(cursor); ok {
+		t.Fatalf("cursor %s of the previous process parses", cursor)
+	}
+	for _, since := range []string{cursor, "1", "x"} {
+		if status, next, reset := changes(ts, since); status != http.StatusGone || !reset || next == since {
+			t.Errorf("since=%s: %d %s reset %v, want 410 and a reset", since, status, next, reset)
+		}
+	}
+	if status, _, reset := changes(ts, current); status != StatusOK || reset {
+		t.Errorf("since=%s: %d reset %v", current, status, reset)
+	}
+
+	req, _ := http.NewRequest("GET", ts.URL+"/.changes", nil)
+	req.Header.Set("Accept", "text/event-stream")
+	req.Header.Set("Last-Event-ID", cursor)
+	resp, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ev, ok := <-readEvents(resp.Body); !ok || ev.event != "reset" || strings.Contains(ev.data, cursor) {
+		t.Errorf("stream from %s: %+v", cursor, ev)
+	}
+}
+
+// TestChangesConcurrentPuts stores files in parallel faster than the feed
+// may keep up with: every one of them is reported after the cursor taken
+// before, or the cursor is reset, never a list with changes missing
+func TestChangesConcurrentPuts(t *testing.T) {
+	for name, fsys := range map[string]FileSystem{
+		"memfs":  NewMemFS(),
+		"server": struct{ FileSystem }{NewMemFS()},
+	} {
+		t.Run(name, func(t *testing.T) {
+			s := &Server{Fs: fsys, TrimPrefix: "/", ChangeFeed: true}
+			defer s.Close()
+			ts := newTestServer(t, s)
+			changes := func(since string) (int, []change, bool) {
+				t.Helper()
+				resp, body := request(t, ts, "GET", "/.changes?since="+since, "")
+				var got struct {
+					Cursor  string
+					Changes []change
+					Reset   bool
+				}
+				if err := json.Unmarshal([]byte(body), &got); err != nil {
+					t.Fatalf("%s: %v", body, err)
+				}
+				return resp.StatusCode, got.Changes, got.Reset
+			}
+			resp, body := request(t, ts, "GET", "/.changes", "")
+			wantStatus(t, resp, http.StatusOK)
+			var start struct{ Cursor string }
+			json.Unmarshal([]byte(body), &start)
+
+			const n = 400
+			var wg sync.WaitGroup
+			sem := make(chan struct{}, 50)
+			for i := 0; i < n; i++ {
+				wg.Add(1)
+				sem <- struct{}{}
+				go func(i int) {
+					defer wg.Done()
+					defer func() { <-sem }()
+					req, _ := http.NewRequest("PUT", fmt.Sprintf("%s/f%03d", ts.URL, i), strings.NewReader("x"))
+					if resp, err := ts.Client().Do(req); err == nil {
+						resp.Body.Close()
+					}
+				}(i)
+			}
+			wg.Wait()
+
+			// the feed is fed asynchronously, so ask until it has caught up
+			seen := map[string]bool{}
+			for deadline := time.Now().Add(10 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+				status, got, reset := changes(start.Cursor)
+				if status == http.StatusGone && reset {
+					return
+				}
+				if status != http.StatusOK || reset {
+					t.Fatalf("since the start: %d reset %v", status, reset)
+				}
+				for _, c := range got {
+					seen[c.Path] = true
+				}
+				if len(seen) == n {
+					return
+				}
+				if time.Now().After(deadline) {
+					t.Fatalf("%d of %d files reported, and no reset", len(seen), n)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,35 @@
+//go:build linux
+
+package webdav
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// checksumAttr is the extended attribute caching the SHA-256 of a file
+const checksumAttr = "user.webdav.sha256"
+
+// cachedChecksum returns the checksum cached for the file p, if it was
+// taken at the size and modification time of fi
+func cachedChecksum(p string, fi os.FileInfo) (string, bool) {
+	buf := make([]byte, 128)
+	n, err := syscall.Getxattr(p, checksumAttr, buf)
+	if err != nil {
+		return "", false
+	}
+	f := strings.Fields(string(buf[:n]))
+	if len(f) != 3 || f[0] != strconv.FormatInt(fi.Size(), 10) || f[1] != strconv.FormatInt(fi.ModTime().UnixNano(), 10) {
+		return "", false
+	}
+	return f[2], true
+}
+
+// cacheChecksum stores sum for the file p as of fi, where the filesystem
+// supports user extended attributes
+func cacheChecksum(p string, fi os.FileInfo, sum string) {
+	v := strconv.FormatInt(fi.Size(), 10) + " " + strconv.FormatInt(fi.ModTime().UnixNano(), 10) + " " + sum
+	syscall.Setxattr(p, checksumAttr, []byte(v), 0)
+}
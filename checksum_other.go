@@ -0,0 +1,12 @@
+//go:build !linux
+
+package webdav
+
+import "os"
+
+// checksums are not cached on this platform
+func cachedChecksum(p string, fi os.FileInfo) (string, bool) {
+	return "", false
+}
+
+func cacheChecksum(p string, fi os.FileInfo, sum string) {}
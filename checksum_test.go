@@ -0,0 +1,80 @@
+package webdav
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func sha256Hex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// TestChecksumChanges changes a file behind the Server's back between
+// requests. GET and HEAD send the checksum only while the cached one is
+// still that of the file, and never hash it themselves.
+func TestChecksumChanges(t *testing.T) {
+	root := t.TempDir()
+	d := Dir(root)
+	ts := newTestServer(t, &Server{Fs: NewLockedFS(d)})
+	name := filepath.Join(root, "f")
+	header := func(method string) string {
+		t.Helper()
+		resp, _ := request(t, ts, method, "/f", "")
+		wantStatus(t, resp, StatusOK)
+		return resp.Header.Get("X-Checksum-SHA256")
+	}
+
+	resp, _ := request(t, ts, "PUT", "/f", "version 1")
+	wantStatus(t, resp, StatusCreated)
+	if got := resp.Header.Get("X-Checksum-SHA256"); got != sha256Hex("version 1") {
+		t.Fatalf("PUT answered checksum %q", got)
+	}
+	if _, ok := d.CachedChecksum("/f", "SHA-256"); !ok {
+		t.Skip("checksums aren't cached here: no extended attributes")
+	}
+	for _, method := range []string{"GET", "HEAD"} {
+		if got := header(method); got != sha256Hex("version 1") {
+			t.Errorf("%s after PUT: checksum %q", method, got)
+		}
+	}
+
+	for _, tc := range []struct {
+		what    string
+		content string
+		mtime   time.Time // zero to leave it as written
+	}{
+		{"another size", "version two", time.Time{}},
+		{"the same size", "version 3!!", time.Time{}},
+		// as a sync tool that keeps modification times writes it
+		{"the same size and a set time", "version 4!!", time.Now().Add(time.Hour)},
+	} {
+		if err := os.WriteFile(name, []byte(tc.content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if !tc.mtime.IsZero() {
+			os.Chtimes(name, tc.mtime, tc.mtime)
+		}
+		for _, method := range []string{"GET", "HEAD"} {
+			if got := header(method); got != "" {
+				t.Errorf("%s after a change to %s: stale checksum %q", method, tc.what, got)
+			}
+		}
+		if sum, err := d.Checksum("/f", "SHA-256"); err != nil || sum != sha256Hex(tc.content) {
+			t.Errorf("Checksum after a change to %s = %q, %v", tc.what, sum, err)
+		}
+		if got := header("GET"); got != sha256Hex(tc.content) {
+			t.Errorf("GET after Checksum of %s: checksum %q", tc.what, got)
+		}
+	}
+
+	// a cached checksum isn't taken for a file of another size
+	d.CacheChecksum("/f", "SHA-256", sha256Hex("wrong"), 5)
+	if got := header("GET"); got != sha256Hex("version 4!!") {
+		t.Errorf("GET after caching for another size: checksum %q", got)
+	}
+}
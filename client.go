@@ -0,0 +1,494 @@
+package webdav
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// errors returned by the Client, compare with errors.Is
+var (
+	ErrNotFound            = errors.New("not found")
+	ErrForbidden           = errors.New("forbidden")
+	ErrLocked              = errors.New("locked")
+	ErrInsufficientStorage = errors.New("insufficient storage")
+	ErrPreconditionFailed  = errors.New("precondition failed")
+	ErrTooLarge            = errors.New("request entity too large")
+)
+
+var statusErrors = map[int]error{
+	StatusNotFound:            ErrNotFound,
+	StatusForbidden:           ErrForbidden,
+	StatusLocked:              ErrLocked,
+	StatusInsufficientStorage: ErrInsufficientStorage,
+	StatusPreconditionFailed:  ErrPreconditionFailed,
+	StatusRequestTooLarge:     ErrTooLarge,
+}
+
+// A StatusError is returned by the Client when the server answers with an
+// unexpected status. It matches the sentinel error of its code, e.g.
+// errors.Is(err, ErrNotFound) for a 404.
+type StatusError struct {
+	Method string
+	URL    string
+	Code   int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("webdav: %s %s: %d %s", e.Method, e.URL, e.Code, StatusText(e.Code))
+}
+
+// Is reports whether target is the sentinel error for e.Code
+func (e *StatusError) Is(target error) bool {
+	return statusErrors[e.Code] == target
+}
+
+// A MultiError is returned when the server answers a request on a
+// collection with 207 Multi-Status, listing the members that failed
+type MultiError struct {
+	Errors []*StatusError
+}
+
+func (e *MultiError) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Unwrap lets errors.Is and errors.As look at every member error
+func (e *MultiError) Unwrap() []error {
+	errs := make([]error, len(e.Errors))
+	for i, err := range e.Errors {
+		errs[i] = err
+	}
+	return errs
+}
+
+// A RequestOption adjusts a request made by the Client
+type RequestOption func(req *http.Request)
+
+// Depth sets the Depth header, e.g. Depth("0") copies a collection without
+// its members
+func Depth(depth string) RequestOption {
+	return func(req *http.Request) {
+		req.Header.Set("Depth", depth)
+	}
+}
+
+// Client talks to a WebDAV server. Names passed to its methods are slash
+// separated paths relative to the base URL.
+type Client struct {
+	base *url.URL
+	hc   *http.Client
+	auth authenticator // nil without credentials
+
+	// canceled by Close, aborting the requests in flight
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu    sync.Mutex
+	locks map[string]*Lock // held locks by token
+
+	progress         Progress
+	progressBytes    int64
+	progressInterval time.Duration
+
+	redirects map[string]bool // methods that follow redirects
+}
+
+// ErrClientClosed is returned by the methods of a closed Client
+var ErrClientClosed = errors.New("webdav: client closed")
+
+// ErrNotSupported is returned when the server lacks the properties a
+// method needs
+var ErrNotSupported = errors.New("webdav: not supported by the server")
+
+// A ClientOption configures a Client in NewClient
+type ClientOption func(cfg *clientConfig)
+
+type clientConfig struct {
+	// used instead of the default transport
+	transport http.RoundTripper
+
+	// adjust the *http.Client NewClient builds, they can't be combined
+	// with a caller supplied one
+	custom []func(hc *http.Client) error
+
+	auth authenticator
+	err  error
+
+	progress         Progress
+	progressBytes    int64
+	progressInterval time.Duration
+
+	redirects []string // nil for defaultRedirects
+}
+
+// NewClient returns a Client for the server at baseURL, sending requests
+// with hc. If hc is nil the Client gets its own transport, configured by
+// opts, which Close shuts down. The Client follows redirects itself, see
+// FollowRedirects, the CheckRedirect of hc is not used.
+func NewClient(baseURL string, hc *http.Client, opts ...ClientOption) (*Client, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("webdav: unsupported URL scheme %q", u.Scheme)
+	}
+	if !strings.HasSuffix(u.Path, "/") {
+		u.Path += "/"
+	}
+	u.RawPath = ""
+	u.RawQuery, u.Fragment = "", ""
+
+	var cfg clientConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if cfg.err != nil {
+		return nil, cfg.err
+	}
+	if hc != nil && (cfg.transport != nil || len(cfg.custom) > 0) {
+		return nil, errors.New("webdav: transport options need the client's own transport, not an *http.Client")
+	}
+	if hc == nil {
+		if hc, err = newHTTPClient(&cfg); err != nil {
+			return nil, err
+		}
+	}
+	own := *hc
+	own.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	if cfg.redirects == nil {
+		cfg.redirects = defaultRedirects
+	}
+
+	c := &Client{
+		base:             u,
+		hc:               &own,
+		auth:             cfg.auth,
+		redirects:        make(map[string]bool),
+		progress:         cfg.progress,
+		progressBytes:    cfg.progressBytes,
+		progressInterval: cfg.progressInterval,
+	}
+	for _, method := range cfg.redirects {
+		c.redirects[method] = true
+	}
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+	return c, nil
+}
+
+// HTTPClient returns the *http.Client the Client sends its requests with,
+// a copy of the one passed to NewClient that doesn't follow redirects
+func (c *Client) HTTPClient() *http.Client {
+	return c.hc
+}
+
+// Close aborts the requests in flight and closes the idle connections.
+// Further requests fail with ErrClientClosed.
+func (c *Client) Close() error {
+	c.cancel()
+	c.hc.CloseIdleConnections()
+	return nil
+}
+
+// url returns the escaped URL of name, with a trailing slash for
+// collections
+func (c *Client) url(name string, collection bool) string {
+	u := *c.base
+	u.Path = path.Join(c.base.Path, name)
+	if collection && !strings.HasSuffix(u.Path, "/") {
+		u.Path += "/"
+	}
+	return u.String()
+}
+
+func (c *Client) newRequest(ctx context.Context, method, name string, collection bool, body io.Reader) (*http.Request, error) {
+	if c.ctx.Err() != nil {
+		return nil, ErrClientClosed
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.url(name, collection), body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "rbastic-webdav")
+	return req, nil
+}
+
+// do sends req and returns the response if its status is one of ok,
+// otherwise the body is discarded and a *StatusError returned. The request
+// is aborted when its context is done or the Client is closed, including
+// while the caller reads the body.
+func (c *Client) do(req *http.Request, ok ...int) (*http.Response, error) {
+	parent := req.Context()
+	ctx, cancel := context.WithCancel(parent)
+	stop := context.AfterFunc(c.ctx, cancel)
+	done := func() {
+		stop()
+		cancel()
+	}
+
+	req = req.WithContext(ctx)
+	resp, err := c.send(req)
+	for hops := 0; err == nil && hops <= maxRedirects; hops++ {
+		next := c.redirect(req, resp)
+		if next == nil {
+			break
+		}
+		if hops == maxRedirects {
+			err = fmt.Errorf("webdav: %s %s: stopped after %d redirects", req.Method, req.URL, maxRedirects)
+		} else {
+			req = next
+			resp, err = c.send(req)
+		}
+	}
+	if err != nil {
+		done()
+		return nil, c.ctxErr(parent, err)
+	}
+
+	for _, code := range ok {
+		if resp.StatusCode == code {
+			resp.Body = &requestBody{ReadCloser: resp.Body, c: c, ctx: parent, done: done}
+			return resp, nil
+		}
+	}
+
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+	done()
+	return nil, &StatusError{Method: req.Method, URL: req.URL.String(), Code: resp.StatusCode}
+}
+
+// ctxErr replaces the error of a request aborted by ctx or by Close
+func (c *Client) ctxErr(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if c.ctx.Err() != nil {
+		return ErrClientClosed
+	}
+	return err
+}
+
+// requestBody releases the request context of a response when closed
+type requestBody struct {
+	io.ReadCloser
+	c    *Client
+	ctx  context.Context
+	done func()
+}
+
+func (b *requestBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err != nil && err != io.EOF {
+		err = b.c.ctxErr(b.ctx, err)
+	}
+	return n, err
+}
+
+func (b *requestBody) Close() error {
+	defer b.done()
+	return b.ReadCloser.Close()
+}
+
+// Open starts a GET of name, the caller must close the returned body
+func (c *Client) Open(ctx context.Context, name string) (io.ReadCloser, error) {
+	req, err := c.newRequest(ctx, "GET", name, false, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.do(req, StatusOK)
+	if err != nil {
+		return nil, err
+	}
+	if t := c.track(ctx, name, resp.ContentLength); t != nil {
+		return &progressBody{ReadCloser: resp.Body, t: t, closeFinishes: true}, nil
+	}
+	return resp.Body, nil
+}
+
+// ReadFile returns the content of name
+func (c *Client) ReadFile(ctx context.Context, name string) ([]byte, error) {
+	body, err := c.Open(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	return io.ReadAll(body)
+}
+
+// Put uploads the content read from r to name. The body is streamed, with
+// chunked transfer encoding unless its size is known from r or given with
+// ContentLength. A body that can't be sent twice, or a large one, is only
+// sent once the server agreed with 100 Continue, so a rejected upload does
+// not consume it.
+func (c *Client) Put(ctx context.Context, name string, r io.Reader, opts ...RequestOption) error {
+	req, err := c.newRequest(ctx, "PUT", name, false, r)
+	if err != nil {
+		return err
+	}
+	c.addLockTokens(req, name)
+	for _, opt := range opts {
+		opt(req)
+	}
+	hasBody := req.Body != nil && req.Body != http.NoBody
+	if hasBody && (req.GetBody == nil || req.ContentLength > expectContinueSize) {
+		req.Header.Set("Expect", "100-continue")
+	}
+
+	total := req.ContentLength
+	if hasBody && total == 0 {
+		total = -1
+	}
+	if t := c.track(ctx, name, total); t != nil {
+		defer t.finish()
+		if hasBody {
+			req.Body = &progressBody{ReadCloser: req.Body, t: t}
+			if getBody := req.GetBody; getBody != nil {
+				req.GetBody = func() (io.ReadCloser, error) {
+					body, err := getBody()
+					if err != nil {
+						return nil, err
+					}
+					t.reset()
+					return &progressBody{ReadCloser: body, t: t}, nil
+				}
+			}
+		}
+	}
+
+	resp, err := c.do(req, StatusOK, StatusCreated, StatusNoContent)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// WriteFile uploads data to name
+func (c *Client) WriteFile(ctx context.Context, name string, data []byte, opts ...RequestOption) error {
+	return c.Put(ctx, name, bytes.NewReader(data), opts...)
+}
+
+// Delete removes name. A 207 answer for a collection is returned as a
+// *MultiError.
+func (c *Client) Delete(ctx context.Context, name string, opts ...RequestOption) error {
+	req, err := c.newRequest(ctx, "DELETE", name, false, nil)
+	if err != nil {
+		return err
+	}
+	c.addLockTokens(req, name)
+	for _, opt := range opts {
+		opt(req)
+	}
+
+	resp, err := c.do(req, StatusOK, StatusNoContent, StatusAccepted, StatusMulti)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != StatusMulti {
+		return nil
+	}
+	return multiError("DELETE", resp.Body)
+}
+
+// Mkcol creates the collection name, its parent must exist
+func (c *Client) Mkcol(ctx context.Context, name string) error {
+	req, err := c.newRequest(ctx, "MKCOL", name, true, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.do(req, StatusCreated)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Copy copies src to dst, replacing an existing dst only if overwrite is
+// set. A 207 answer for a collection is returned as a *MultiError.
+func (c *Client) Copy(ctx context.Context, src, dst string, overwrite bool, opts ...RequestOption) error {
+	return c.copyMove(ctx, "COPY", src, dst, overwrite, opts)
+}
+
+// Move moves src to dst, replacing an existing dst only if overwrite is
+// set. A 207 answer for a collection is returned as a *MultiError.
+func (c *Client) Move(ctx context.Context, src, dst string, overwrite bool, opts ...RequestOption) error {
+	return c.copyMove(ctx, "MOVE", src, dst, overwrite, opts)
+}
+
+func (c *Client) copyMove(ctx context.Context, method, src, dst string, overwrite bool, opts []RequestOption) error {
+	req, err := c.newRequest(ctx, method, src, false, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Destination", c.url(dst, false))
+	if method == "MOVE" {
+		c.addLockTokens(req, src, dst)
+	} else {
+		c.addLockTokens(req, dst)
+	}
+	if overwrite {
+		req.Header.Set("Overwrite", "T")
+	} else {
+		req.Header.Set("Overwrite", "F")
+	}
+	for _, opt := range opts {
+		opt(req)
+	}
+
+	resp, err := c.do(req, StatusCreated, StatusNoContent, StatusMulti)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != StatusMulti {
+		return nil
+	}
+	return multiError(method, resp.Body)
+}
+
+// multiError collects the failed responses of a 207 answer
+func multiError(method string, body io.Reader) error {
+	ms, err := parseMultistatus(body)
+	if err != nil {
+		return err
+	}
+
+	merr := &MultiError{}
+	for _, r := range ms.Responses {
+		code := parseStatus(r.Status)
+		if code >= 200 && code <= 299 {
+			continue
+		}
+		for _, href := range r.Hrefs {
+			merr.Errors = append(merr.Errors, &StatusError{Method: method, URL: href, Code: code})
+		}
+	}
+
+	if len(merr.Errors) == 0 {
+		return nil
+	}
+	return merr
+}
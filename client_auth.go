@@ -0,0 +1,277 @@
+package webdav
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// authenticator adds credentials to the requests of a Client
+type authenticator interface {
+	// authorize is called before every request to the base host
+	authorize(req *http.Request) error
+
+	// challenge is called with a 401 answer and reports whether the
+	// request should be sent again
+	challenge(resp *http.Response) bool
+
+	// needsChallenge reports whether a 401 is expected before credentials
+	// can be sent, so a body that can't be replayed isn't wasted on it
+	needsChallenge() bool
+}
+
+// BasicAuth sends user and password with every request to the server
+func BasicAuth(user, password string) ClientOption {
+	return func(cfg *clientConfig) {
+		cfg.auth = &basicAuth{user: user, password: password}
+	}
+}
+
+// DigestAuth answers the server's Digest challenges with user and password
+func DigestAuth(user, password string) ClientOption {
+	return func(cfg *clientConfig) {
+		cfg.auth = &digestAuth{user: user, password: password}
+	}
+}
+
+// AuthFunc calls fn on every request to the server, e.g. to set a bearer
+// token or sign the request
+func AuthFunc(fn func(req *http.Request) error) ClientOption {
+	return func(cfg *clientConfig) {
+		cfg.auth = authFunc(fn)
+	}
+}
+
+type basicAuth struct {
+	user, password string
+}
+
+func (a *basicAuth) authorize(req *http.Request) error {
+	req.SetBasicAuth(a.user, a.password)
+	return nil
+}
+
+func (a *basicAuth) challenge(resp *http.Response) bool { return false }
+func (a *basicAuth) needsChallenge() bool               { return false }
+
+type authFunc func(req *http.Request) error
+
+func (fn authFunc) authorize(req *http.Request) error  { return fn(req) }
+func (fn authFunc) challenge(resp *http.Response) bool { return false }
+func (fn authFunc) needsChallenge() bool               { return false }
+
+// send sends req with credentials, answering one authentication challenge
+func (c *Client) send(req *http.Request) (*http.Response, error) {
+	if c.auth == nil || req.URL.Host != c.base.Host || req.URL.Scheme != c.base.Scheme {
+		return c.hc.Do(req)
+	}
+
+	replayable := req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+	if !replayable && c.auth.needsChallenge() {
+		c.probe(req)
+	}
+
+	// authorize a copy, req stays as the caller made it for a redirect
+	sent := req.Clone(req.Context())
+	if err := c.auth.authorize(sent); err != nil {
+		return nil, err
+	}
+	resp, err := c.hc.Do(sent)
+	if err != nil || resp.StatusCode != StatusUnauthorized || !replayable || !c.auth.challenge(resp) {
+		return resp, err
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+
+	retry := req.Clone(req.Context())
+	if req.GetBody != nil {
+		if retry.Body, err = req.GetBody(); err != nil {
+			return nil, err
+		}
+	}
+	if err := c.auth.authorize(retry); err != nil {
+		return nil, err
+	}
+	return c.hc.Do(retry)
+}
+
+// probe fetches a challenge with a bodyless request to the URL of req
+func (c *Client) probe(req *http.Request) {
+	p, err := http.NewRequestWithContext(req.Context(), "OPTIONS", req.URL.String(), nil)
+	if err != nil {
+		return
+	}
+	p.Header.Set("User-Agent", req.Header.Get("User-Agent"))
+
+	resp, err := c.hc.Do(p)
+	if err != nil {
+		return
+	}
+	if resp.StatusCode == StatusUnauthorized {
+		c.auth.challenge(resp)
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+}
+
+// digestAuth implements Digest access authentication, RFC 7616. The nonce
+// of the last challenge is reused with an increasing count until the
+// server rejects it.
+type digestAuth struct {
+	user, password string
+
+	mu     sync.Mutex
+	params map[string]string // of the last challenge, nil before the first
+	nc     int
+}
+
+func (a *digestAuth) needsChallenge() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.params == nil
+}
+
+func (a *digestAuth) challenge(resp *http.Response) bool {
+	var params map[string]string
+	for _, h := range resp.Header.Values("Www-Authenticate") {
+		if scheme, rest, _ := strings.Cut(strings.TrimSpace(h), " "); strings.EqualFold(scheme, "Digest") {
+			params = parseAuthParams(rest)
+			break
+		}
+	}
+	if params == nil || params["nonce"] == "" || digestHash(params["algorithm"]) == nil {
+		return false
+	}
+	if qop, ok := params["qop"]; ok && !hasToken(qop, "auth") {
+		return false
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	// the same nonce again without stale=true means the credentials are wrong
+	if a.params != nil && a.params["nonce"] == params["nonce"] && !strings.EqualFold(params["stale"], "true") {
+		return false
+	}
+	a.params = params
+	a.nc = 0
+	return true
+}
+
+func (a *digestAuth) authorize(req *http.Request) error {
+	a.mu.Lock()
+	params := a.params
+	a.nc++
+	nc := fmt.Sprintf("%08x", a.nc)
+	a.mu.Unlock()
+
+	if params == nil {
+		return nil
+	}
+
+	algorithm := params["algorithm"]
+	newHash := digestHash(algorithm)
+	h := func(s string) string {
+		d := newHash()
+		io.WriteString(d, s)
+		return hex.EncodeToString(d.Sum(nil))
+	}
+
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return err
+	}
+	cnonce := hex.EncodeToString(b[:])
+
+	realm, nonce, uri := params["realm"], params["nonce"], req.URL.RequestURI()
+	ha1 := h(a.user + ":" + realm + ":" + a.password)
+	if strings.HasSuffix(strings.ToLower(algorithm), "-sess") {
+		ha1 = h(ha1 + ":" + nonce + ":" + cnonce)
+	}
+	ha2 := h(req.Method + ":" + uri)
+
+	var response string
+	_, withQop := params["qop"]
+	if withQop {
+		response = h(ha1 + ":" + nonce + ":" + nc + ":" + cnonce + ":auth:" + ha2)
+	} else {
+		response = h(ha1 + ":" + nonce + ":" + ha2)
+	}
+
+	v := fmt.Sprintf(`Digest username=%q, realm=%q, nonce=%q, uri=%q, response=%q`,
+		a.user, realm, nonce, uri, response)
+	if algorithm != "" {
+		v += ", algorithm=" + algorithm
+	}
+	if opaque, ok := params["opaque"]; ok {
+		v += fmt.Sprintf(", opaque=%q", opaque)
+	}
+	if withQop {
+		v += fmt.Sprintf(`, qop=auth, nc=%s, cnonce=%q`, nc, cnonce)
+	}
+	req.Header.Set("Authorization", v)
+	return nil
+}
+
+// digestHash returns the hash of a Digest algorithm, nil if unsupported
+func digestHash(algorithm string) func() hash.Hash {
+	switch strings.ToUpper(strings.TrimSuffix(strings.ToLower(algorithm), "-sess")) {
+	case "", "MD5":
+		return md5.New
+	case "SHA-256":
+		return sha256.New
+	}
+	return nil
+}
+
+// parseAuthParams parses the comma separated name=value pairs of a
+// challenge, values may be quoted strings containing commas
+func parseAuthParams(s string) map[string]string {
+	params := make(map[string]string)
+	for {
+		s = strings.TrimLeft(s, " \t,")
+		eq := strings.IndexByte(s, '=')
+		if eq < 0 {
+			return params
+		}
+		name := strings.ToLower(strings.TrimSpace(s[:eq]))
+		s = strings.TrimLeft(s[eq+1:], " \t")
+
+		var value string
+		if strings.HasPrefix(s, `"`) {
+			var b strings.Builder
+			i := 1
+			for ; i < len(s) && s[i] != '"'; i++ {
+				if s[i] == '\\' && i+1 < len(s) {
+					i++
+				}
+				b.WriteByte(s[i])
+			}
+			value, s = b.String(), s[min(i+1, len(s)):]
+		} else {
+			end := strings.IndexByte(s, ',')
+			if end < 0 {
+				end = len(s)
+			}
+			value, s = strings.TrimSpace(s[:end]), s[end:]
+		}
+		params[name] = value
+	}
+}
+
+// hasToken reports whether the comma separated list contains token
+func hasToken(list, token string) bool {
+	for _, t := range strings.Split(list, ",") {
+		if strings.EqualFold(strings.TrimSpace(t), token) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,287 @@
+package webdav
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// digestResponse computes the response of an Authorization header the way
+// a server checks it, RFC 7616 section 3.4.1
+func digestResponse(newHash func() hash.Hash, user, password, method string, p map[string]string) string {
+	h := func(s string) string {
+		d := newHash()
+		io.WriteString(d, s)
+		return hex.EncodeToString(d.Sum(nil))
+	}
+	ha1 := h(user + ":" + p["realm"] + ":" + password)
+	if strings.HasSuffix(strings.ToLower(p["algorithm"]), "-sess") {
+		ha1 = h(ha1 + ":" + p["nonce"] + ":" + p["cnonce"])
+	}
+	ha2 := h(method + ":" + p["uri"])
+	if p["qop"] == "" {
+		return h(ha1 + ":" + p["nonce"] + ":" + ha2)
+	}
+	return h(ha1 + ":" + p["nonce"] + ":" + p["nc"] + ":" + p["cnonce"] + ":" + p["qop"] + ":" + ha2)
+}
+
+func TestDigestResponseReference(t *testing.T) {
+	// RFC 2617 section 3.5
+	p := map[string]string{
+		"realm": "testrealm@host.com", "nonce": "dcd98b7102dd2f0e8b11d0f600bfb0c093",
+		"uri": "/dir/index.html", "qop": "auth", "nc": "00000001", "cnonce": "0a4f113b",
+	}
+	if got := digestResponse(md5.New, "Mufasa", "Circle Of Life", "GET", p); got != "6629fae49393a05397450978507c4ef1" {
+		t.Fatalf("reference digest = %s", got)
+	}
+}
+
+// recorded WWW-Authenticate headers
+var digestChallenges = map[string]string{
+	"apache":     `Digest realm="webdav", nonce="Q8Y4HhEXBgA=3fbc9b1d1e06e8dd8ba1ba33cf4fad6f1f20a2b7", algorithm=MD5, qop="auth"`,
+	"nginx":      `Digest algorithm="MD5", qop="auth", realm="webdav", nonce="5f8c3a6b1e7d2c4a09f1b2e3", opaque="f1e2d3c4b5a6"`,
+	"rfc2617":    `Digest realm="testrealm@host.com", qop="auth,auth-int", nonce="dcd98b7102dd2f0e8b11d0f600bfb0c093", opaque="5ccc069c403ebaf9f0171e9517f40e41"`,
+	"rfc7616":    `Digest realm="http-auth@example.org", qop="auth, auth-int", algorithm=SHA-256, nonce="7ypf/xlj9XXwfDPEoM4URrv/xwf94BcCAzFZH4GiTo0v", opaque="FQhe/qaU925kfnzjCev0ciny7QMkPqMAFRtzCUYo5tdS"`,
+	"md5-sess":   `Digest realm="sess", nonce="abc", algorithm=MD5-sess, qop="auth"`,
+	"no-qop":     `Digest realm="old", nonce="0123456789"`,
+	"escaped":    `Digest realm="a \"quoted\", realm", nonce="n,1", qop=auth`,
+	"lower-case": `digest REALM="webdav", Nonce="xyz", QOP="auth"`,
+}
+
+func TestDigestAuthorizeRecordedChallenges(t *testing.T) {
+	for name, challenge := range digestChallenges {
+		t.Run(name, func(t *testing.T) {
+			a := &digestAuth{user: "Mufasa", password: "Circle Of Life"}
+			resp := &http.Response{StatusCode: StatusUnauthorized, Header: http.Header{"Www-Authenticate": {challenge}}}
+			if !a.challenge(resp) {
+				t.Fatal("challenge not accepted")
+			}
+			_, rest, _ := strings.Cut(challenge, " ")
+			want := parseAuthParams(rest)
+
+			for i := 1; i <= 2; i++ {
+				req, _ := http.NewRequest("PROPFIND", "http://host/dir/a%20b?x=1", nil)
+				if err := a.authorize(req); err != nil {
+					t.Fatal(err)
+				}
+				scheme, rest, _ := strings.Cut(req.Header.Get("Authorization"), " ")
+				got := parseAuthParams(rest)
+				if scheme != "Digest" || got["username"] != "Mufasa" || got["realm"] != want["realm"] ||
+					got["nonce"] != want["nonce"] || got["uri"] != "/dir/a%20b?x=1" || got["opaque"] != want["opaque"] {
+					t.Fatalf("Authorization: %s", req.Header.Get("Authorization"))
+				}
+				if _, ok := want["qop"]; ok {
+					if got["qop"] != "auth" || got["nc"] != fmt.Sprintf("%08x", i) || got["cnonce"] == "" {
+						t.Errorf("qop %q nc %q cnonce %q", got["qop"], got["nc"], got["cnonce"])
+					}
+				} else if got["qop"] != "" || got["nc"] != "" {
+					t.Errorf("qop sent without one offered: %s", req.Header.Get("Authorization"))
+				}
+				newHash := md5.New
+				if want["algorithm"] == "SHA-256" {
+					newHash = sha256.New
+				}
+				if r := digestResponse(newHash, "Mufasa", "Circle Of Life", "PROPFIND", got); got["response"] != r {
+					t.Errorf("response %s, want %s", got["response"], r)
+				}
+			}
+		})
+	}
+}
+
+func TestDigestRefusesUnsupportedChallenges(t *testing.T) {
+	for _, challenge := range []string{
+		`Digest realm="x", nonce="1", algorithm=SHA-512-256`,
+		`Digest realm="x", nonce="1", qop="auth-int"`,
+		`Digest realm="x"`,
+		`Basic realm="x"`,
+	} {
+		a := &digestAuth{user: "u", password: "p"}
+		resp := &http.Response{Header: http.Header{"Www-Authenticate": {challenge}}}
+		if a.challenge(resp) {
+			t.Errorf("accepted %s", challenge)
+		}
+	}
+}
+
+// digestServer checks Digest credentials for user "u" password "p", with a
+// new nonce whenever stale is set
+type digestServer struct {
+	mu        sync.Mutex
+	nonce     int
+	stale     bool
+	seen      map[string]bool // nonce:nc, replays are refused
+	requests  []string
+	challenge int
+}
+
+func (s *digestServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = append(s.requests, r.Method)
+	io.Copy(io.Discard, r.Body)
+
+	scheme, rest, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+	p := parseAuthParams(rest)
+	nonce := fmt.Sprintf("nonce-%d", s.nonce)
+	ok := scheme == "Digest" && p["nonce"] == nonce && p["uri"] == r.URL.RequestURI() &&
+		p["response"] == digestResponse(md5.New, "u", "p", r.Method, p) && !s.seen[nonce+":"+p["nc"]]
+	if ok && !s.stale {
+		s.seen[nonce+":"+p["nc"]] = true
+		w.WriteHeader(StatusCreated)
+		return
+	}
+	stale := ""
+	if ok && s.stale {
+		s.nonce++
+		s.stale = false
+		stale = ", stale=true"
+	}
+	s.challenge++
+	w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Digest realm="dav", nonce="nonce-%d", qop="auth"%s`, s.nonce, stale))
+	w.WriteHeader(StatusUnauthorized)
+}
+
+func TestDigestAuthRoundTrips(t *testing.T) {
+	ds := &digestServer{seen: make(map[string]bool)}
+	ts := httptest.NewServer(ds)
+	defer ts.Close()
+	ctx := context.Background()
+
+	c, err := NewClient(ts.URL, nil, DigestAuth("u", "p"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	// a body that can't be replayed waits for a challenge fetched first
+	if err := c.Put(ctx, "a", io.MultiReader(strings.NewReader("once"))); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(ds.requests, " "); got != "OPTIONS PUT" {
+		t.Errorf("requests %q, want the OPTIONS probe and one PUT", got)
+	}
+	// the nonce is reused with the next count
+	for i := 0; i < 3; i++ {
+		if err := c.WriteFile(ctx, "b", []byte("again")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if ds.challenge != 1 {
+		t.Errorf("%d challenges, want 1", ds.challenge)
+	}
+	// a stale nonce is replaced without failing the request
+	ds.stale = true
+	if err := c.WriteFile(ctx, "c", []byte("stale")); err != nil {
+		t.Fatalf("after a stale nonce: %v", err)
+	}
+
+	wrong, err := NewClient(ts.URL, nil, DigestAuth("u", "wrong"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wrong.Close()
+	var serr *StatusError
+	if err := wrong.WriteFile(ctx, "d", []byte("x")); !errors.As(err, &serr) || serr.Code != StatusUnauthorized {
+		t.Errorf("wrong password = %v, want 401", err)
+	}
+}
+
+func TestClientAuthStaysOnHost(t *testing.T) {
+	var other http.Header
+	elsewhere := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		other = r.Header.Clone()
+		io.WriteString(w, "elsewhere")
+	}))
+	defer elsewhere.Close()
+	var got []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = append(got, r.Header.Get("Authorization"))
+		http.Redirect(w, r, elsewhere.URL+"/moved", http.StatusFound)
+	}))
+	defer ts.Close()
+
+	for name, opt := range map[string]ClientOption{
+		"basic": BasicAuth("user", "secret"),
+		"func": AuthFunc(func(req *http.Request) error {
+			req.Header.Set("Authorization", "Bearer token")
+			return nil
+		}),
+	} {
+		got, other = nil, nil
+		c, err := NewClient(ts.URL, nil, opt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := c.ReadFile(context.Background(), "f")
+		c.Close()
+		if err != nil || string(b) != "elsewhere" {
+			t.Fatalf("%s: ReadFile = %q, %v", name, b, err)
+		}
+		if len(got) != 1 || got[0] == "" {
+			t.Errorf("%s: credentials sent to the server: %q", name, got)
+		}
+		if other.Get("Authorization") != "" {
+			t.Errorf("%s: credentials sent to the redirect target", name)
+		}
+	}
+}
+
+// TestClientAuthFuncRedirect checks the headers an AuthFunc sets go to
+// the server only, not to the host a redirect leads to
+func TestClientAuthFuncRedirect(t *testing.T) {
+	var other http.Header
+	elsewhere := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		other = r.Header.Clone()
+		io.WriteString(w, "elsewhere")
+	}))
+	defer elsewhere.Close()
+	var got []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = append(got, r.Header.Get("X-Api-Key"))
+		http.Redirect(w, r, elsewhere.URL+"/moved", http.StatusFound)
+	}))
+	defer ts.Close()
+
+	c, err := NewClient(ts.URL, nil, AuthFunc(func(req *http.Request) error {
+		req.Header.Set("X-Api-Key", "secret")
+		return nil
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	b, err := c.ReadFile(context.Background(), "f")
+	if err != nil || string(b) != "elsewhere" {
+		t.Fatalf("ReadFile = %q, %v", b, err)
+	}
+	if len(got) != 1 || got[0] != "secret" {
+		t.Errorf("X-Api-Key sent to the server: %q", got)
+	}
+	if k := other.Get("X-Api-Key"); k != "" {
+		t.Errorf("X-Api-Key: %s sent to the redirect target", k)
+	}
+}
+
+func TestParseAuthParams(t *testing.T) {
+	got := parseAuthParams(`realm="a \"b\", c", nonce=xyz , qop="auth,auth-int",opaque="", Stale=TRUE`)
+	want := map[string]string{"realm": `a "b", c`, "nonce": "xyz", "qop": "auth,auth-int", "opaque": "", "stale": "TRUE"}
+	if len(got) != len(want) {
+		t.Errorf("got %q", got)
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("%s = %q, want %q", k, got[k], v)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package webdav
+
+import (
+	"context"
+	"encoding/xml"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// RemoveAll removes names with a single batch delete request, which the
+// server must allow with Server.BatchDelete. It is sent to the deepest
+// collection holding all of names. Members that could not be removed are
+// returned as a *MultiError, the others are gone.
+func (c *Client) RemoveAll(ctx context.Context, names []string, opts ...RequestOption) error {
+	if len(names) == 0 {
+		return nil
+	}
+	dir := ""
+	for i, name := range names {
+		d := path.Dir(path.Clean("/" + name))
+		if i == 0 {
+			dir = d
+			continue
+		}
+		for dir != "/" && d != dir && !strings.HasPrefix(d, dir+"/") {
+			dir = path.Dir(dir)
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="utf-8"?>` + "\n" + `<B:delete xmlns:B="` + BatchNamespace + `" xmlns:D="DAV:">`)
+	for _, name := range names {
+		u, err := url.Parse(c.url(name, false))
+		if err != nil {
+			return err
+		}
+		b.WriteString(`<B:member><D:href>`)
+		xml.EscapeText(&b, []byte(u.EscapedPath()))
+		b.WriteString(`</D:href></B:member>`)
+	}
+	b.WriteString(`</B:delete>`)
+
+	req, err := c.newRequest(ctx, "POST", dir, true, strings.NewReader(b.String()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/xml; charset=utf-8")
+	c.addLockTokens(req, names...)
+	for _, opt := range opts {
+		opt(req)
+	}
+
+	resp, err := c.do(req, StatusMulti)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return multiError("DELETE", resp.Body)
+}
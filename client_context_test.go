@@ -0,0 +1,128 @@
+package webdav
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// tricklingReader yields a byte every few milliseconds, forever
+type tricklingReader struct{}
+
+func (tricklingReader) Read(p []byte) (int, error) {
+	time.Sleep(5 * time.Millisecond)
+	if len(p) == 0 {
+		return 0, nil
+	}
+	p[0] = 'x'
+	return 1, nil
+}
+
+// slowServer trickles GET responses and reads PUT bodies without end, and
+// never answers anything else
+func slowServer(t testing.TB) *httptest.Server {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "GET":
+			for r.Context().Err() == nil {
+				w.Write([]byte("x"))
+				w.(http.Flusher).Flush()
+				time.Sleep(5 * time.Millisecond)
+			}
+		case "PUT":
+			io.Copy(io.Discard, r.Body)
+		default:
+			// the server only notices a client going away once the body is read
+			io.Copy(io.Discard, r.Body)
+			<-r.Context().Done()
+		}
+	}))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+// returnsPromptly runs fn, cancels its context after a while and checks
+// fn returns the context's error soon after
+func returnsPromptly(t *testing.T, fn func(ctx context.Context) error) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- fn(ctx) }()
+
+	time.Sleep(50 * time.Millisecond)
+	select {
+	case err := <-done:
+		t.Fatalf("returned before the cancel: %v", err)
+	default:
+	}
+	cancel()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("returned %v, want context.Canceled", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("still running a second after the cancel")
+	}
+}
+
+func TestClientContextCancel(t *testing.T) {
+	ts := slowServer(t)
+	c, err := NewClient(ts.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	t.Run("download", func(t *testing.T) {
+		returnsPromptly(t, func(ctx context.Context) error {
+			_, err := c.ReadFile(ctx, "f")
+			return err
+		})
+	})
+	t.Run("download to WriterAt", func(t *testing.T) {
+		returnsPromptly(t, func(ctx context.Context) error {
+			_, err := c.Download(ctx, "f", &memWriterAt{}, nil)
+			return err
+		})
+	})
+	t.Run("upload", func(t *testing.T) {
+		returnsPromptly(t, func(ctx context.Context) error {
+			return c.Put(ctx, "f", tricklingReader{})
+		})
+	})
+	t.Run("upload with length", func(t *testing.T) {
+		returnsPromptly(t, func(ctx context.Context) error {
+			return c.Put(ctx, "f", tricklingReader{}, ContentLength(1<<20))
+		})
+	})
+	t.Run("propfind", func(t *testing.T) {
+		returnsPromptly(t, func(ctx context.Context) error {
+			_, err := c.Stat(ctx, "f")
+			return err
+		})
+	})
+	t.Run("deadline", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		if _, err := c.ReadDir(ctx, "dir"); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("ReadDir past the deadline = %v", err)
+		}
+	})
+}
+
+// memWriterAt is an io.WriterAt in memory
+type memWriterAt struct {
+	buf []byte
+}
+
+func (m *memWriterAt) WriteAt(p []byte, off int64) (int, error) {
+	if end := int(off) + len(p); end > len(m.buf) {
+		m.buf = append(m.buf, make([]byte, end-len(m.buf))...)
+	}
+	return copy(m.buf[off:], p), nil
+}
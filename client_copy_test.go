@@ -0,0 +1,96 @@
+package webdav
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientCopyMove(t *testing.T) {
+	ctx := context.Background()
+	_, _, c := newDAVServer(t, Dir(t.TempDir()))
+	for name, content := range map[string]string{"a": "alpha", "b": "beta"} {
+		if err := c.WriteFile(ctx, name, []byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	content := func(name string) string {
+		t.Helper()
+		b, err := c.ReadFile(ctx, name)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		return string(b)
+	}
+
+	if err := c.Copy(ctx, "a", "b", false); !errors.Is(err, ErrPreconditionFailed) {
+		t.Errorf("Copy onto b without overwrite = %v", err)
+	}
+	if got := content("b"); got != "beta" {
+		t.Errorf("b = %q after a refused Copy", got)
+	}
+	if err := c.Copy(ctx, "a", "b", true); err != nil {
+		t.Fatal(err)
+	}
+	if got := content("b"); got != "alpha" {
+		t.Errorf("b = %q after Copy", got)
+	}
+	if err := c.Copy(ctx, "missing", "c", true); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Copy of a missing file = %v", err)
+	}
+
+	if err := c.WriteFile(ctx, "b", []byte("beta")); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Move(ctx, "a", "b", false); !errors.Is(err, ErrPreconditionFailed) {
+		t.Errorf("Move onto b without overwrite = %v", err)
+	}
+	if err := c.Move(ctx, "a", "c", false); err != nil {
+		t.Fatal(err)
+	}
+	if got := content("c"); got != "alpha" {
+		t.Errorf("c = %q after Move", got)
+	}
+	if _, err := c.ReadFile(ctx, "a"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("a still there after Move: %v", err)
+	}
+	if err := c.Move(ctx, "c", "b", true); err != nil {
+		t.Fatal(err)
+	}
+	if got := content("b"); got != "alpha" {
+		t.Errorf("b = %q after Move with overwrite", got)
+	}
+}
+
+func TestClientCopyMultiStatus(t *testing.T) {
+	var got http.Header
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+		w.WriteHeader(StatusMulti)
+		fmt.Fprint(w, `<?xml version="1.0"?><D:multistatus xmlns:D="DAV:">`+
+			`<D:response><D:href>/dst/ok</D:href><D:status>HTTP/1.1 201 Created</D:status></D:response>`+
+			`<D:response><D:href>/dst/locked</D:href><D:status>HTTP/1.1 423 Locked</D:status></D:response>`+
+			`</D:multistatus>`)
+	}))
+	defer ts.Close()
+	c, err := NewClient(ts.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	err = c.Copy(context.Background(), "src", "dst", true, Depth("infinity"))
+	var merr *MultiError
+	if !errors.As(err, &merr) || len(merr.Errors) != 1 || merr.Errors[0].URL != "/dst/locked" {
+		t.Fatalf("Copy = %v, want a MultiError for /dst/locked", err)
+	}
+	if !errors.Is(err, ErrLocked) {
+		t.Error("the MultiError doesn't match ErrLocked")
+	}
+	if got.Get("Destination") != ts.URL+"/dst" || got.Get("Overwrite") != "T" || got.Get("Depth") != "infinity" {
+		t.Errorf("sent Destination %q Overwrite %q Depth %q", got.Get("Destination"), got.Get("Overwrite"), got.Get("Depth"))
+	}
+}
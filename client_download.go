@@ -0,0 +1,358 @@
+package webdav
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaults of DownloadOptions
+const (
+	DefaultDownloadRetries   = 5
+	DefaultDownloadChunkSize = 8 << 20
+)
+
+// DownloadOptions configures Download, a nil *DownloadOptions uses the
+// defaults
+type DownloadOptions struct {
+	// number of ranges fetched concurrently, only used when the server
+	// sends a strong ETag and accepts ranges
+	Parallel int
+
+	// size of the ranges fetched concurrently
+	ChunkSize int64
+
+	// attempts to continue after an interrupted transfer, negative
+	// disables retrying
+	Retries int
+
+	// called after every write with the bytes stored so far and the size
+	// of the resource, -1 if unknown. The count goes back to zero when the
+	// download has to start over.
+	Progress func(done, total int64)
+}
+
+// errChanged aborts a parallel download when the resource changes
+var errChanged = errors.New("webdav: resource changed during download")
+
+// Download stores the content of name in w. A transfer that breaks off is
+// continued with a Range request, guarded by If-Range with the ETag so a
+// resource changed in between is fetched again from the start. Without a
+// strong ETag every retry starts over. It returns the size of the content.
+func (c *Client) Download(ctx context.Context, name string, w io.WriterAt, opts *DownloadOptions) (int64, error) {
+	d := &download{c: c, ctx: ctx, name: name, w: w, total: -1}
+	if opts != nil {
+		d.opts = *opts
+	}
+	if d.opts.Retries == 0 {
+		d.opts.Retries = DefaultDownloadRetries
+	}
+	if d.opts.ChunkSize <= 0 {
+		d.opts.ChunkSize = DefaultDownloadChunkSize
+	}
+	d.t = c.track(ctx, name, -1)
+	defer d.t.finish()
+
+	if d.opts.Parallel > 1 {
+		n, err := d.parallel()
+		if err != errChanged {
+			return n, err
+		}
+		d.report(-d.done)
+	}
+	return d.sequential()
+}
+
+// DownloadFile stores the content of name in the local file path, which is
+// created or truncated
+func (c *Client) DownloadFile(ctx context.Context, name, path string, opts *DownloadOptions) (int64, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := c.Download(ctx, name, f, opts)
+	if err == nil {
+		err = f.Truncate(n)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return n, err
+}
+
+type download struct {
+	c    *Client
+	ctx  context.Context
+	name string
+	w    io.WriterAt
+	opts DownloadOptions
+	t    *tracker // nil without a Progress
+
+	mu    sync.Mutex
+	done  int64
+	total int64
+}
+
+func (d *download) report(n int64) {
+	d.mu.Lock()
+	d.done += n
+	done, total := d.done, d.total
+	d.mu.Unlock()
+
+	d.t.setTotal(total)
+	d.t.add(n)
+	if d.opts.Progress != nil {
+		d.opts.Progress(done, total)
+	}
+}
+
+// sequential fetches the whole resource with one request, continuing with
+// open ended ranges after failures
+func (d *download) sequential() (int64, error) {
+	var off int64
+	var etag string
+
+	for attempt := 0; ; attempt++ {
+		resp, err := d.c.getRange(d.ctx, d.name, off, -1, etag)
+		if err == nil {
+			if resp.StatusCode == StatusPartialContent {
+				start, _, size, ok := parseContentRange(resp.Header.Get("Content-Range"))
+				if !ok || start != off {
+					resp.Body.Close()
+					return off, fmt.Errorf("webdav: GET %s: unexpected Content-Range %q", d.name, resp.Header.Get("Content-Range"))
+				}
+				d.total = size
+			} else {
+				// the whole content, either the first attempt or the
+				// resource changed since
+				d.report(-off)
+				off = 0
+				d.total = resp.ContentLength
+				etag = strongETag(resp.Header.Get("ETag"))
+			}
+
+			var n int64
+			n, err = d.copy(resp.Body, off)
+			resp.Body.Close()
+			off += n
+			if err == nil && (d.total < 0 || off >= d.total) {
+				return off, nil
+			}
+			if err == nil {
+				err = io.ErrUnexpectedEOF
+			}
+		}
+
+		if attempt >= d.opts.Retries || !d.retryable(d.ctx, err) {
+			return off, err
+		}
+		if etag == "" {
+			// nothing to check the next range against
+			d.report(-off)
+			off = 0
+		}
+		if err := d.backoff(d.ctx, attempt); err != nil {
+			return off, err
+		}
+	}
+}
+
+// parallel fetches ChunkSize ranges with Parallel workers. It returns
+// errChanged if the resource can't be fetched in ranges or changes.
+func (d *download) parallel() (int64, error) {
+	req, err := d.c.newRequest(d.ctx, "HEAD", d.name, false, nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := d.c.do(req, StatusOK)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+
+	etag := strongETag(resp.Header.Get("ETag"))
+	size := resp.ContentLength
+	if etag == "" || size <= 0 || !hasToken(resp.Header.Get("Accept-Ranges"), "bytes") {
+		return 0, errChanged
+	}
+	d.total = size
+
+	ctx, cancel := context.WithCancel(d.ctx)
+	defer cancel()
+
+	chunks := make(chan int64)
+	go func() {
+		defer close(chunks)
+		for off := int64(0); off < size; off += d.opts.ChunkSize {
+			select {
+			case chunks <- off:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	var once sync.Once
+	var firstErr error
+	for i := 0; i < d.opts.Parallel; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for off := range chunks {
+				end := min(off+d.opts.ChunkSize, size) - 1
+				if err := d.chunk(ctx, off, end, etag); err != nil {
+					once.Do(func() { firstErr = err })
+					cancel()
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return d.done, firstErr
+	}
+	return size, nil
+}
+
+// chunk fetches the bytes from start to end inclusive
+func (d *download) chunk(ctx context.Context, start, end int64, etag string) error {
+	for attempt := 0; ; attempt++ {
+		resp, err := d.c.getRange(ctx, d.name, start, end, etag)
+		if err == nil {
+			if resp.StatusCode != StatusPartialContent {
+				resp.Body.Close()
+				return errChanged
+			}
+			var n int64
+			n, err = d.copy(io.LimitReader(resp.Body, end-start+1), start)
+			resp.Body.Close()
+			start += n
+			if start > end {
+				return nil
+			}
+			if err == nil {
+				err = io.ErrUnexpectedEOF
+			}
+		}
+
+		if attempt >= d.opts.Retries || !d.retryable(ctx, err) {
+			return err
+		}
+		if err := d.backoff(ctx, attempt); err != nil {
+			return err
+		}
+	}
+}
+
+// copy writes r to w from off on and reports the progress
+func (d *download) copy(r io.Reader, off int64) (int64, error) {
+	buf := make([]byte, 32<<10)
+	var written int64
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			if _, werr := d.w.WriteAt(buf[:n], off+written); werr != nil {
+				return written, werr
+			}
+			written += int64(n)
+			d.report(int64(n))
+		}
+		if err == io.EOF {
+			return written, nil
+		}
+		if err != nil {
+			return written, err
+		}
+	}
+}
+
+// retryable reports whether a failed attempt is worth repeating: broken
+// connections and server errors are, everything else is final
+func (d *download) retryable(ctx context.Context, err error) bool {
+	if ctx.Err() != nil || errors.Is(err, ErrClientClosed) {
+		return false
+	}
+	var se *StatusError
+	if errors.As(err, &se) {
+		return se.Code >= 500
+	}
+	return true
+}
+
+func (d *download) backoff(ctx context.Context, attempt int) error {
+	t := time.NewTimer(time.Duration(attempt+1) * 200 * time.Millisecond)
+	defer t.Stop()
+
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// getRange starts a GET of the bytes from start to end inclusive, end < 0
+// meaning to the end. With ifRange set, a changed resource is sent whole
+// with 200 instead.
+func (c *Client) getRange(ctx context.Context, name string, start, end int64, ifRange string) (*http.Response, error) {
+	req, err := c.newRequest(ctx, "GET", name, false, nil)
+	if err != nil {
+		return nil, err
+	}
+	if start > 0 || end >= 0 {
+		r := "bytes=" + strconv.FormatInt(start, 10) + "-"
+		if end >= 0 {
+			r += strconv.FormatInt(end, 10)
+		}
+		req.Header.Set("Range", r)
+		if ifRange != "" {
+			req.Header.Set("If-Range", ifRange)
+		}
+	}
+	return c.do(req, StatusOK, StatusPartialContent)
+}
+
+// strongETag returns tag unless it is empty or weak, If-Range only works
+// with strong tags
+func strongETag(tag string) string {
+	if strings.HasPrefix(tag, "W/") {
+		return ""
+	}
+	return tag
+}
+
+// parseContentRange parses "bytes start-end/size", size is -1 for "*"
+func parseContentRange(s string) (start, end, size int64, ok bool) {
+	s, found := strings.CutPrefix(strings.TrimSpace(s), "bytes ")
+	if !found {
+		return 0, 0, 0, false
+	}
+	rng, sz, found := strings.Cut(s, "/")
+	if !found {
+		return 0, 0, 0, false
+	}
+	a, b, found := strings.Cut(rng, "-")
+	if !found {
+		return 0, 0, 0, false
+	}
+
+	var err1, err2, err3 error
+	start, err1 = strconv.ParseInt(a, 10, 64)
+	end, err2 = strconv.ParseInt(b, 10, 64)
+	size = -1
+	if sz != "*" {
+		size, err3 = strconv.ParseInt(sz, 10, 64)
+	}
+	return start, end, size, err1 == nil && err2 == nil && err3 == nil && start <= end
+}
@@ -0,0 +1,167 @@
+package webdav
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// cutWriter aborts the response after limit bytes of body
+type cutWriter struct {
+	http.ResponseWriter
+	limit int
+}
+
+func (w *cutWriter) Write(p []byte) (int, error) {
+	if len(p) > w.limit {
+		w.ResponseWriter.Write(p[:w.limit])
+		w.ResponseWriter.(http.Flusher).Flush()
+		panic(http.ErrAbortHandler)
+	}
+	w.limit -= len(p)
+	return w.ResponseWriter.Write(p)
+}
+
+// flakyServer serves content with Range support and drops the connection
+// partway through the first drops responses. The content can be replaced
+// with set, which also changes the ETag.
+type flakyServer struct {
+	mu       sync.Mutex
+	content  []byte
+	etag     string
+	drops    int
+	requests int
+}
+
+func (s *flakyServer) set(content []byte, etag string) {
+	s.mu.Lock()
+	s.content, s.etag = content, etag
+	s.mu.Unlock()
+}
+
+func (s *flakyServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	content, etag := s.content, s.etag
+	s.requests++
+	drop := r.Method == "GET" && s.drops > 0
+	if drop {
+		s.drops--
+	}
+	s.mu.Unlock()
+
+	w.Header().Set("ETag", etag)
+	if drop {
+		w = &cutWriter{ResponseWriter: w, limit: 100 << 10}
+	}
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(content))
+}
+
+func randomBytes(t testing.TB, n int) []byte {
+	t.Helper()
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func fileHash(t *testing.T, path string) [32]byte {
+	t.Helper()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sha256.Sum256(b)
+}
+
+func TestClientDownloadResumes(t *testing.T) {
+	content := randomBytes(t, 1<<20)
+	want := sha256.Sum256(content)
+
+	for _, tc := range []struct {
+		name string
+		opts *DownloadOptions
+	}{
+		{"sequential", nil},
+		{"parallel", &DownloadOptions{Parallel: 4, ChunkSize: 128 << 10}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s := &flakyServer{content: content, etag: `"v1"`, drops: 3}
+			ts := httptest.NewServer(s)
+			defer ts.Close()
+			c, err := NewClient(ts.URL, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+
+			path := filepath.Join(t.TempDir(), "out")
+			n, err := c.DownloadFile(context.Background(), "file", path, tc.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != int64(len(content)) {
+				t.Errorf("DownloadFile = %d bytes, want %d", n, len(content))
+			}
+			if fileHash(t, path) != want {
+				t.Error("downloaded file differs from the served content")
+			}
+			if s.drops != 0 {
+				t.Errorf("%d drops left, the download never got interrupted", s.drops)
+			}
+		})
+	}
+}
+
+func TestClientDownloadRestartsChangedResource(t *testing.T) {
+	old := randomBytes(t, 512<<10)
+	changed := randomBytes(t, 700<<10)
+
+	s := &flakyServer{content: old, etag: `"v1"`, drops: 1}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// replace the content after the broken first response
+		defer s.set(changed, `"v2"`)
+		s.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+	c, err := NewClient(ts.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	path := filepath.Join(t.TempDir(), "out")
+	if _, err := c.DownloadFile(context.Background(), "file", path, nil); err != nil {
+		t.Fatal(err)
+	}
+	if fileHash(t, path) != sha256.Sum256(changed) {
+		t.Error("a resource changed between attempts was spliced instead of fetched again")
+	}
+}
+
+func TestClientDownloadGivesUp(t *testing.T) {
+	s := &flakyServer{content: randomBytes(t, 512<<10), etag: `"v1"`, drops: 10}
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+	c, err := NewClient(ts.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	path := filepath.Join(t.TempDir(), "out")
+	if _, err := c.DownloadFile(context.Background(), "file", path, &DownloadOptions{Retries: 1}); err == nil {
+		t.Fatal("DownloadFile succeeded over a connection that always drops")
+	}
+	if s.requests != 2 {
+		t.Errorf("%d requests, want 2 with Retries 1", s.requests)
+	}
+}
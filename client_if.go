@@ -0,0 +1,167 @@
+package webdav
+
+import (
+	"net/http"
+	"strings"
+)
+
+// An IfList is one list of an RFC 4918 If header: lock tokens and entity
+// tags that must all match the state of Resource, an absolute URL, or of
+// the request-URI if Resource is empty
+type IfList struct {
+	Resource string
+	Tokens   []string
+	ETags    []string
+}
+
+// FormatIf returns an If header of which at least one of lists must hold,
+// RFC 4918 section 10.4. The header has either only untagged lists for the
+// request-URI or only lists tagged with their resource, so if any list has
+// a Resource the others are tagged with requestURI. Lists of the same
+// resource share its tag. Tokens and entity tags are enclosed in <> and
+// quotes unless they already are. Lists without conditions are left out,
+// the grammar has no empty list.
+func FormatIf(requestURI string, lists ...IfList) string {
+	nonEmpty := lists[:0:0]
+	for _, l := range lists {
+		if len(l.Tokens) > 0 || len(l.ETags) > 0 {
+			nonEmpty = append(nonEmpty, l)
+		}
+	}
+	lists = nonEmpty
+
+	tagged := false
+	for _, l := range lists {
+		if l.Resource != "" && l.Resource != requestURI {
+			tagged = true
+		}
+	}
+
+	var b strings.Builder
+	if !tagged {
+		for _, l := range lists {
+			writeIfList(&b, l)
+		}
+		return b.String()
+	}
+
+	var order []string
+	byResource := make(map[string][]IfList)
+	for _, l := range lists {
+		r := l.Resource
+		if r == "" {
+			r = requestURI
+		}
+		if _, ok := byResource[r]; !ok {
+			order = append(order, r)
+		}
+		byResource[r] = append(byResource[r], l)
+	}
+	for _, r := range order {
+		if b.Len() > 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteString(codedURL(r))
+		for _, l := range byResource[r] {
+			writeIfList(&b, l)
+		}
+	}
+	return b.String()
+}
+
+func writeIfList(b *strings.Builder, l IfList) {
+	if b.Len() > 0 {
+		b.WriteByte(' ')
+	}
+	b.WriteByte('(')
+	n := 0
+	for _, token := range l.Tokens {
+		if n++; n > 1 {
+			b.WriteByte(' ')
+		}
+		b.WriteString(codedURL(token))
+	}
+	for _, etag := range l.ETags {
+		if n++; n > 1 {
+			b.WriteByte(' ')
+		}
+		b.WriteString("[" + quoteETag(etag) + "]")
+	}
+	b.WriteByte(')')
+}
+
+// codedURL encloses s in <> unless it already is
+func codedURL(s string) string {
+	if strings.HasPrefix(s, "<") && strings.HasSuffix(s, ">") {
+		return s
+	}
+	return "<" + s + ">"
+}
+
+// quoteETag quotes a bare entity tag, or one with a quote missing
+func quoteETag(etag string) string {
+	weak := strings.HasPrefix(etag, `W/"`)
+	tag := strings.TrimPrefix(etag, "W/")
+	if !weak {
+		tag = etag
+	}
+	if len(tag) < 2 || tag[0] != '"' || tag[len(tag)-1] != '"' {
+		tag = `"` + strings.Trim(tag, `"`) + `"`
+	}
+	if weak {
+		return "W/" + tag
+	}
+	return tag
+}
+
+// addIf adds l to the If header of req as another alternative
+func addIf(req *http.Request, l IfList) {
+	uri := req.URL.String()
+	cur := req.Header.Get("If")
+	switch {
+	case cur == "":
+		req.Header.Set("If", FormatIf(uri, l))
+	case strings.HasPrefix(cur, "("):
+		if l.Resource == "" || l.Resource == uri {
+			l.Resource = ""
+			req.Header.Set("If", cur+" "+FormatIf(uri, l))
+		} else {
+			req.Header.Set("If", codedURL(uri)+" "+cur+" "+FormatIf(uri, l))
+		}
+	default:
+		if l.Resource == "" {
+			l.Resource = uri
+		}
+		req.Header.Set("If", cur+" "+codedURL(l.Resource)+" "+FormatIf(l.Resource, l))
+	}
+}
+
+// IfMatch makes a request conditional on the resource having the entity
+// tag etag, it fails with ErrPreconditionFailed otherwise
+func IfMatch(etag string) RequestOption {
+	return header("If-Match", quoteETag(etag))
+}
+
+// IfNoneMatchAny makes a request fail with ErrPreconditionFailed if the
+// resource exists, e.g. a PUT that only creates
+func IfNoneMatchAny() RequestOption {
+	return header("If-None-Match", "*")
+}
+
+// WithLockToken submits the lock token of a lock on the request-URI that
+// the Client doesn't hold itself, e.g. one taken by another process.
+// Requests on locked resources without it fail with ErrLocked.
+func WithLockToken(token string) RequestOption {
+	return func(req *http.Request) {
+		addIf(req, IfList{Tokens: []string{token}})
+	}
+}
+
+// WithResourceLockToken submits the lock token of a lock on another
+// resource than the request-URI, such as the destination of a MOVE.
+// resource is its absolute URL, see Lock.URL.
+func WithResourceLockToken(resource, token string) RequestOption {
+	return func(req *http.Request) {
+		addIf(req, IfList{Resource: resource, Tokens: []string{token}})
+	}
+}
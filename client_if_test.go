@@ -0,0 +1,293 @@
+package webdav
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// validIf reports whether h follows the If header grammar of RFC 4918
+// section 10.4: only untagged lists or only tagged ones, every list with
+// at least one condition, every condition a coded URL or an entity tag in
+// brackets, optionally preceded by Not
+func validIf(h string) bool {
+	s := strings.TrimSpace(h)
+	if s == "" {
+		return false
+	}
+	tagged := s[0] == '<'
+	for s != "" {
+		if tagged {
+			if s[0] != '<' {
+				// more lists of the same resource
+				if s[0] != '(' {
+					return false
+				}
+			} else {
+				end := strings.IndexByte(s, '>')
+				if end < 0 {
+					return false
+				}
+				s = strings.TrimLeft(s[end+1:], " ")
+				if s == "" || s[0] != '(' {
+					return false
+				}
+			}
+		}
+		if s[0] != '(' {
+			return false
+		}
+		end := strings.IndexByte(s, ')')
+		if end < 0 {
+			return false
+		}
+		conds := strings.TrimSpace(s[1:end])
+		if conds == "" {
+			return false
+		}
+		for conds != "" {
+			conds = strings.TrimPrefix(conds, "Not ")
+			var close byte
+			switch conds[0] {
+			case '<':
+				close = '>'
+			case '[':
+				close = ']'
+			default:
+				return false
+			}
+			i := strings.IndexByte(conds, close)
+			if i < 0 {
+				return false
+			}
+			if close == ']' {
+				etag := strings.TrimPrefix(conds[1:i], "W/")
+				if len(etag) < 2 || etag[0] != '"' || etag[len(etag)-1] != '"' {
+					return false
+				}
+			}
+			conds = strings.TrimLeft(conds[i+1:], " ")
+		}
+		s = strings.TrimLeft(s[end+1:], " ")
+	}
+	return true
+}
+
+func TestFormatIf(t *testing.T) {
+	const uri = "http://example.com/dav/a"
+	const other = "http://example.com/dav/b"
+	for _, tc := range []struct {
+		name  string
+		lists []IfList
+		want  string
+	}{
+		{"nothing", nil, ""},
+		{"only empty lists", []IfList{{}, {Resource: other}}, ""},
+		{"token", []IfList{{Tokens: []string{"urn:uuid:1"}}}, "(<urn:uuid:1>)"},
+		{"coded token", []IfList{{Tokens: []string{"<urn:uuid:1>"}}}, "(<urn:uuid:1>)"},
+		{"bare etag", []IfList{{ETags: []string{"abc"}}}, `(["abc"])`},
+		{"quoted etag", []IfList{{ETags: []string{`"abc"`}}}, `(["abc"])`},
+		{"weak etag", []IfList{{ETags: []string{`W/"abc"`}}}, `([W/"abc"])`},
+		{"unbalanced etag", []IfList{{ETags: []string{`"abc`}}}, `(["abc"])`},
+		{"unbalanced weak etag", []IfList{{ETags: []string{`W/"abc`}}}, `([W/"abc"])`},
+		{
+			"token and etag",
+			[]IfList{{Tokens: []string{"urn:uuid:1"}, ETags: []string{"e"}}},
+			`(<urn:uuid:1> ["e"])`,
+		},
+		{
+			"several of each",
+			[]IfList{{Tokens: []string{"t1", "t2"}, ETags: []string{"e1", "e2"}}},
+			`(<t1> <t2> ["e1"] ["e2"])`,
+		},
+		{
+			"alternatives",
+			[]IfList{{Tokens: []string{"t1"}}, {ETags: []string{"e"}}},
+			`(<t1>) (["e"])`,
+		},
+		{
+			"resource is the request-URI",
+			[]IfList{{Resource: uri, Tokens: []string{"t1"}}, {Tokens: []string{"t2"}}},
+			"(<t1>) (<t2>)",
+		},
+		{
+			"other resource",
+			[]IfList{{Resource: other, Tokens: []string{"t1"}}},
+			"<" + other + "> (<t1>)",
+		},
+		{
+			"mixed resources are all tagged",
+			[]IfList{{Tokens: []string{"t1"}}, {Resource: other, Tokens: []string{"t2"}}},
+			"<" + uri + "> (<t1>) <" + other + "> (<t2>)",
+		},
+		{
+			"lists of a resource share the tag",
+			[]IfList{
+				{Resource: other, Tokens: []string{"t1"}},
+				{Tokens: []string{"t2"}},
+				{Resource: other, ETags: []string{"e"}},
+			},
+			"<" + other + `> (<t1>) (["e"]) <` + uri + "> (<t2>)",
+		},
+		{
+			"coded resource",
+			[]IfList{{Resource: "<" + other + ">", Tokens: []string{"t1"}}},
+			"<" + other + "> (<t1>)",
+		},
+		{
+			"empty list among others",
+			[]IfList{{}, {Resource: other, Tokens: []string{"t1"}}, {Resource: other}},
+			"<" + other + "> (<t1>)",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got := FormatIf(uri, tc.lists...)
+			if got != tc.want {
+				t.Errorf("FormatIf = %s\nwant       %s", got, tc.want)
+			}
+			if got != "" && !validIf(got) {
+				t.Errorf("FormatIf = %s, not a valid If header", got)
+			}
+		})
+	}
+}
+
+func TestValidIf(t *testing.T) {
+	for _, h := range []string{
+		"(<t>)",
+		`(Not <t> ["e"]) (<u>)`,
+		`<http://a/b> (<t>) (["e"]) <http://a/c> (<u>)`,
+	} {
+		if !validIf(h) {
+			t.Errorf("validIf(%s) = false", h)
+		}
+	}
+	for _, h := range []string{
+		"",
+		"()",
+		"<t>",
+		"(<t>) <http://a/b> (<u>)",
+		"(t)",
+		"([e])",
+		"(<t>",
+	} {
+		if validIf(h) {
+			t.Errorf("validIf(%s) = true", h)
+		}
+	}
+}
+
+func TestAddIf(t *testing.T) {
+	const uri = "http://example.com/dav/a"
+	const other = "http://example.com/dav/b"
+	for _, tc := range []struct {
+		name string
+		opts []RequestOption
+		want string
+	}{
+		{"one token", []RequestOption{WithLockToken("t1")}, "(<t1>)"},
+		{"two tokens", []RequestOption{WithLockToken("t1"), WithLockToken("t2")}, "(<t1>) (<t2>)"},
+		{
+			"untagged then tagged",
+			[]RequestOption{WithLockToken("t1"), WithResourceLockToken(other, "t2")},
+			"<" + uri + "> (<t1>) <" + other + "> (<t2>)",
+		},
+		{
+			"tagged then untagged",
+			[]RequestOption{WithResourceLockToken(other, "t2"), WithLockToken("t1")},
+			"<" + other + "> (<t2>) <" + uri + "> (<t1>)",
+		},
+		{
+			"resource token for the request-URI",
+			[]RequestOption{WithLockToken("t1"), WithResourceLockToken(uri, "t2")},
+			"(<t1>) (<t2>)",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req, err := http.NewRequest("MOVE", uri, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, opt := range tc.opts {
+				opt(req)
+			}
+			got := req.Header.Get("If")
+			if got != tc.want {
+				t.Errorf("If = %s\nwant %s", got, tc.want)
+			}
+			if !validIf(got) {
+				t.Errorf("If = %s, not a valid If header", got)
+			}
+		})
+	}
+}
+
+func TestClientConditionalErrors(t *testing.T) {
+	ctx := context.Background()
+	_, _, c := newDAVServer(t, NewMemFS())
+	if err := c.WriteFile(ctx, "f", []byte("v1")); err != nil {
+		t.Fatal(err)
+	}
+
+	err := c.WriteFile(ctx, "f", []byte("v2"), IfNoneMatchAny())
+	if !errors.Is(err, ErrPreconditionFailed) || errors.Is(err, ErrLocked) {
+		t.Errorf("create-only PUT of an existing file = %v, want ErrPreconditionFailed", err)
+	}
+	if err := c.WriteFile(ctx, "g", []byte("new"), IfNoneMatchAny()); err != nil {
+		t.Errorf("create-only PUT of a new file = %v", err)
+	}
+	err = c.WriteFile(ctx, "f", []byte("v2"), IfMatch("no such etag"))
+	if !errors.Is(err, ErrPreconditionFailed) {
+		t.Errorf("PUT with a stale If-Match = %v, want ErrPreconditionFailed", err)
+	}
+	if b, _ := c.ReadFile(ctx, "f"); string(b) != "v1" {
+		t.Errorf("failed conditional PUTs changed the file to %q", b)
+	}
+}
+
+// FuzzFormatIf formats If headers of a token and an entity tag, for the
+// request-URI or another resource. Tokens and tags a client could send
+// give a header of valid grammar.
+func FuzzFormatIf(f *testing.F) {
+	f.Add("", "urn:uuid:181d4fae-7d8c-11d0-a765-00a0c91e6bf2", "")
+	f.Add("http://example.com/dav/b", "opaquelocktoken:1", `W/"abc"`)
+	f.Add("", "", `"x"`)
+	f.Add("<http://example.com/dav/b>", "<urn:uuid:1>", "abc")
+
+	const uri = "http://example.com/dav/a"
+	f.Fuzz(func(t *testing.T, resource, token, etag string) {
+		var l IfList
+		l.Resource = resource
+		if token != "" {
+			l.Tokens = []string{token}
+		}
+		if etag != "" {
+			l.ETags = []string{etag}
+		}
+		h := FormatIf(uri, l)
+		if token == "" && etag == "" {
+			if h != "" {
+				t.Fatalf("FormatIf of an empty list = %s", h)
+			}
+			return
+		}
+		clean := func(s string) bool {
+			if strings.HasPrefix(s, "<") && strings.HasSuffix(s, ">") {
+				s = s[1 : len(s)-1]
+			}
+			return s != "" && !strings.ContainsAny(s, "<>()[]\" \t\r\n")
+		}
+		tag := strings.Trim(strings.TrimPrefix(etag, "W/"), `"`)
+		if (token != "" && !clean(token)) || (etag != "" && !clean(tag)) || (resource != "" && !clean(resource)) {
+			return
+		}
+		if !validIf(h) {
+			t.Fatalf("FormatIf(%q, %q, %q) = %s, not a valid If header", resource, token, etag, h)
+		}
+		if token != "" && !strings.Contains(h, "<"+strings.Trim(token, "<>")+">") {
+			t.Fatalf("FormatIf = %s, without the token %q", h, token)
+		}
+	})
+}
@@ -0,0 +1,229 @@
+package webdav
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LockOptions configures a LOCK request, the zero value asks for an
+// exclusive, infinite depth lock without timeout
+type LockOptions struct {
+	Shared bool
+
+	// "0" locks only the resource, anything else locks the whole subtree
+	Depth string
+
+	// requested lifetime, zero asks for an infinite lock
+	Timeout time.Duration
+
+	// free form owner information, e.g. a mailto: URL
+	Owner string
+}
+
+// A Lock is a lock held by the Client. As long as it is held, the Client
+// adds its token to every Put, Delete, Copy and Move touching the locked
+// resource.
+type Lock struct {
+	Token string
+	Name  string
+	Depth string
+
+	// lifetime granted by the server, zero for infinite
+	Timeout time.Duration
+
+	client *Client
+}
+
+// URL returns the absolute URL of the locked resource, as needed by
+// WithResourceLockToken
+func (l *Lock) URL() string {
+	return l.client.url(l.Name, false)
+}
+
+// covers reports whether the lock applies to the cleaned path name
+func (l *Lock) covers(name string) bool {
+	if name == l.Name {
+		return true
+	}
+	if l.Depth == "0" {
+		return false
+	}
+	return l.Name == "/" || strings.HasPrefix(name, l.Name+"/")
+}
+
+// Lock locks name, which is created empty if it does not exist
+func (c *Client) Lock(ctx context.Context, name string, opts *LockOptions) (*Lock, error) {
+	if opts == nil {
+		opts = &LockOptions{}
+	}
+	depth := "infinity"
+	if opts.Depth == "0" {
+		depth = "0"
+	}
+	scope := "exclusive"
+	if opts.Shared {
+		scope = "shared"
+	}
+
+	var owner strings.Builder
+	if opts.Owner != "" {
+		owner.WriteString("<D:owner>")
+		xml.EscapeText(&owner, []byte(opts.Owner))
+		owner.WriteString("</D:owner>")
+	}
+	body := `<?xml version="1.0" encoding="utf-8"?>
+<D:lockinfo xmlns:D="DAV:"><D:lockscope><D:` + scope + `/></D:lockscope>` +
+		`<D:locktype><D:write/></D:locktype>` + owner.String() + `</D:lockinfo>`
+
+	req, err := c.newRequest(ctx, "LOCK", name, false, strings.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/xml; charset=utf-8")
+	req.Header.Set("Depth", depth)
+	req.Header.Set("Timeout", formatTimeout(opts.Timeout))
+
+	resp, err := c.do(req, StatusOK, StatusCreated)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	lock := &Lock{Name: path.Clean("/" + name), Depth: depth, client: c}
+	var prop struct {
+		Locks []xmlNode `xml:"DAV: lockdiscovery>activelock"`
+	}
+	if err := newXMLDecoder(resp.Body, 0).Decode(&prop); err != nil {
+		return nil, err
+	}
+	token := strings.Trim(resp.Header.Get("Lock-Token"), "<> ")
+	lock.Timeout = opts.Timeout
+	for _, al := range prop.Locks {
+		t := activeLockToken(&al)
+		if token != "" && t != token {
+			continue
+		}
+		token = t
+		if to := al.child(davName("timeout")); to != nil {
+			lock.Timeout = parseTimeout(to.Text)
+		}
+		break
+	}
+	if token == "" {
+		return nil, fmt.Errorf("webdav: LOCK %s: no lock token in response", name)
+	}
+	lock.Token = token
+
+	c.mu.Lock()
+	if c.locks == nil {
+		c.locks = make(map[string]*Lock)
+	}
+	c.locks[lock.Token] = lock
+	c.mu.Unlock()
+	return lock, nil
+}
+
+func activeLockToken(al *xmlNode) string {
+	if lt := al.child(davName("locktoken")); lt != nil {
+		if href := lt.child(davName("href")); href != nil {
+			return strings.TrimSpace(href.Text)
+		}
+	}
+	return ""
+}
+
+// Refresh renews the lock for its original timeout
+func (l *Lock) Refresh(ctx context.Context) error {
+	req, err := l.client.newRequest(ctx, "LOCK", l.Name, false, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("If", "(<"+l.Token+">)")
+	req.Header.Set("Timeout", formatTimeout(l.Timeout))
+
+	resp, err := l.client.do(req, StatusOK)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var prop struct {
+		Timeout string `xml:"DAV: lockdiscovery>activelock>timeout"`
+	}
+	if newXMLDecoder(resp.Body, 0).Decode(&prop) == nil && prop.Timeout != "" {
+		l.Timeout = parseTimeout(prop.Timeout)
+	}
+	return nil
+}
+
+// Unlock releases a lock and stops using its token
+func (c *Client) Unlock(ctx context.Context, lock *Lock) error {
+	req, err := c.newRequest(ctx, "UNLOCK", lock.Name, false, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Lock-Token", "<"+lock.Token+">")
+
+	resp, err := c.do(req, StatusOK, StatusNoContent)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	c.mu.Lock()
+	delete(c.locks, lock.Token)
+	c.mu.Unlock()
+	return nil
+}
+
+// addLockTokens sets the If header for the locks held on the given names.
+// A single lock on the request-URI uses the untagged form, otherwise every
+// token is tagged with the URL of its locked resource.
+func (c *Client) addLockTokens(req *http.Request, names ...string) {
+	c.mu.Lock()
+	var held []*Lock
+	for _, l := range c.locks {
+		for _, name := range names {
+			if l.covers(path.Clean("/" + name)) {
+				held = append(held, l)
+				break
+			}
+		}
+	}
+	c.mu.Unlock()
+
+	if len(held) == 0 {
+		return
+	}
+	lists := make([]IfList, len(held))
+	for i, l := range held {
+		lists[i] = IfList{Resource: l.URL(), Tokens: []string{l.Token}}
+	}
+	req.Header.Set("If", FormatIf(req.URL.String(), lists...))
+}
+
+// formatTimeout renders a Timeout header value, RFC 4918 section 10.7
+func formatTimeout(d time.Duration) string {
+	if d <= 0 {
+		return "Infinite"
+	}
+	return "Second-" + strconv.FormatInt(int64(d/time.Second), 10)
+}
+
+// parseTimeout parses "Second-n" or "Infinite", returning zero for the latter
+func parseTimeout(s string) time.Duration {
+	s = strings.TrimSpace(s)
+	if i := strings.IndexByte(s, ','); i >= 0 {
+		s = s[:i]
+	}
+	if n, err := strconv.ParseInt(strings.TrimPrefix(s, "Second-"), 10, 64); err == nil {
+		return time.Duration(n) * time.Second
+	}
+	return 0
+}
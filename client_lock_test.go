@@ -0,0 +1,164 @@
+package webdav
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// lockingServer enforces the locks granted by the FakeLocks of a Server:
+// writes to a locked path need its token in the If header
+type lockingServer struct {
+	*davServer
+
+	mu       sync.Mutex
+	locks    map[string]string // path by token
+	timeouts []string          // of every LOCK
+}
+
+func (l *lockingServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := path.Clean(r.URL.Path)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if r.Method == "UNLOCK" {
+		token := strings.Trim(r.Header.Get("Lock-Token"), "<>")
+		if l.locks[token] != name {
+			w.WriteHeader(StatusConflict)
+			return
+		}
+		delete(l.locks, token)
+	}
+	if r.Method == "LOCK" || r.Method == "PUT" || r.Method == "DELETE" {
+		for token, locked := range l.locks {
+			if (name == locked || strings.HasPrefix(name, locked+"/")) && !strings.Contains(r.Header.Get("If"), "<"+token+">") {
+				w.WriteHeader(StatusLocked)
+				return
+			}
+		}
+	}
+
+	if r.Method != "LOCK" {
+		l.davServer.ServeHTTP(w, r)
+		return
+	}
+	l.timeouts = append(l.timeouts, r.Header.Get("Timeout"))
+	rec := httptest.NewRecorder()
+	l.davServer.ServeHTTP(rec, r)
+	token := strings.Trim(rec.Header().Get("Lock-Token"), "<>")
+	if l.locks == nil {
+		l.locks = make(map[string]string)
+	}
+	l.locks[token] = name
+	for k, v := range rec.Header() {
+		w.Header()[k] = v
+	}
+	w.WriteHeader(rec.Code)
+	rec.Body.WriteTo(w)
+}
+
+func TestClientLock(t *testing.T) {
+	ctx := context.Background()
+	d, _, _ := newDAVServer(t, NewMemFS())
+	d.FakeLocks = true
+	ls := &lockingServer{davServer: d}
+	ts := httptest.NewServer(ls)
+	defer ts.Close()
+	newClient := func() *Client {
+		c, err := NewClient(ts.URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { c.Close() })
+		return c
+	}
+	owner, other := newClient(), newClient()
+
+	if err := owner.Mkcol(ctx, "dir"); err != nil {
+		t.Fatal(err)
+	}
+	lock, err := owner.Lock(ctx, "dir/f.txt", &LockOptions{Timeout: time.Minute, Owner: "mailto:a@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(lock.Token, "urn:uuid:") || lock.Name != "/dir/f.txt" || lock.Timeout != time.Minute {
+		t.Errorf("Lock = %+v", lock)
+	}
+	if b, err := owner.ReadFile(ctx, "dir/f.txt"); err != nil || len(b) != 0 {
+		t.Errorf("locking an unmapped URL didn't create it empty: %q, %v", b, err)
+	}
+
+	// the token goes along with writes of the owner only
+	if err := owner.WriteFile(ctx, "dir/f.txt", []byte("mine")); err != nil {
+		t.Errorf("owner's PUT: %v", err)
+	}
+	if err := other.WriteFile(ctx, "dir/f.txt", []byte("theirs")); !errors.Is(err, ErrLocked) {
+		t.Errorf("other's PUT = %v, want ErrLocked", err)
+	}
+	if err := other.Delete(ctx, "dir/f.txt"); !errors.Is(err, ErrLocked) {
+		t.Errorf("other's DELETE = %v, want ErrLocked", err)
+	}
+	if err := owner.WriteFile(ctx, "dir/g.txt", []byte("unlocked")); err != nil {
+		t.Errorf("PUT next to the lock: %v", err)
+	}
+
+	if err := lock.Refresh(ctx); err != nil {
+		t.Errorf("Refresh: %v", err)
+	}
+	if len(ls.timeouts) != 2 || ls.timeouts[1] != "Second-60" {
+		t.Errorf("Timeout headers sent: %q", ls.timeouts)
+	}
+
+	if err := owner.Unlock(ctx, lock); err != nil {
+		t.Fatal(err)
+	}
+	if err := other.WriteFile(ctx, "dir/f.txt", []byte("theirs")); err != nil {
+		t.Errorf("other's PUT after Unlock: %v", err)
+	}
+	if err := owner.Unlock(ctx, lock); err == nil {
+		t.Error("second Unlock succeeded")
+	}
+
+	// an infinite depth lock on a collection covers its members
+	dirLock, err := owner.Lock(ctx, "dir", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dirLock.Depth != "infinity" || dirLock.Timeout != 0 {
+		t.Errorf("Lock of dir = %+v", dirLock)
+	}
+	if err := owner.WriteFile(ctx, "dir/f.txt", []byte("mine again")); err != nil {
+		t.Errorf("owner's PUT below the locked collection: %v", err)
+	}
+	if err := other.WriteFile(ctx, "dir/f.txt", []byte("theirs")); !errors.Is(err, ErrLocked) {
+		t.Errorf("other's PUT below the locked collection = %v, want ErrLocked", err)
+	}
+	if err := owner.Unlock(ctx, dirLock); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTimeoutHeader(t *testing.T) {
+	for _, d := range []time.Duration{0, time.Second, time.Hour} {
+		if got := parseTimeout(formatTimeout(d)); got != d {
+			t.Errorf("%v round-trips to %v", d, got)
+		}
+	}
+	for s, want := range map[string]time.Duration{
+		"Infinite":                 0,
+		"Second-3600":              time.Hour,
+		" Second-5, Infinite":      5 * time.Second,
+		"Infinite, Second-4100000": 0,
+		"garbage":                  0,
+	} {
+		if got := parseTimeout(s); got != want {
+			t.Errorf("parseTimeout(%q) = %v, want %v", s, got, want)
+		}
+	}
+}
@@ -0,0 +1,337 @@
+package webdav
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultTreeParallel is the number of files Mirror transfers at once
+const DefaultTreeParallel = 4
+
+// MirrorOptions configures Mirror, a nil *MirrorOptions uses the defaults
+type MirrorOptions struct {
+	// number of files downloaded concurrently
+	Parallel int
+
+	// stop at the first failure instead of reporting all of them at the end
+	Strict bool
+
+	// optional local file recording the ETag of every mirrored file, a
+	// file whose ETag and local copy are unchanged is skipped
+	StateFile string
+
+	// options for every file download
+	Download *DownloadOptions
+}
+
+// A FileError is the failure of a single file in a tree operation
+type FileError struct {
+	Name string
+	Err  error
+}
+
+func (e *FileError) Error() string { return e.Name + ": " + e.Err.Error() }
+func (e *FileError) Unwrap() error { return e.Err }
+
+// A TreeError lists the files a tree operation such as Mirror failed on
+type TreeError struct {
+	Op     string
+	Errors []*FileError
+}
+
+func (e *TreeError) Error() string {
+	msg := fmt.Sprintf("webdav: %s: %d failed", e.Op, len(e.Errors))
+	if len(e.Errors) > 0 {
+		msg += ", first " + e.Errors[0].Error()
+	}
+	return msg
+}
+
+// Unwrap lets errors.Is and errors.As look at every file error
+func (e *TreeError) Unwrap() []error {
+	errs := make([]error, len(e.Errors))
+	for i, err := range e.Errors {
+		errs[i] = err
+	}
+	return errs
+}
+
+// Mirror copies the remote tree below remoteRoot into localDir. Files are
+// downloaded concurrently to temporary names and renamed into place with
+// the modification time of the server. Files whose local copy has the
+// remote size and modification time, or the ETag recorded in the state
+// file, are skipped. Local files missing remotely are left alone.
+func (c *Client) Mirror(ctx context.Context, remoteRoot, localDir string, opts *MirrorOptions) error {
+	var o MirrorOptions
+	if opts != nil {
+		o = *opts
+	}
+	if o.Parallel <= 0 {
+		o.Parallel = DefaultTreeParallel
+	}
+
+	st, err := loadTreeState(o.StateFile)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(localDir, 0755); err != nil {
+		return err
+	}
+
+	root := path.Clean("/" + remoteRoot)
+	ctx, agg := c.trackTree(ctx, root)
+	defer agg.finish()
+	t := newTreeRun(ctx, "mirror", o.Strict, o.Parallel)
+
+	werr := c.Walk(t.ctx, root, func(name string, fi os.FileInfo, err error) error {
+		rel, ok := relPath(root, name)
+		if !ok {
+			// root itself
+			return err
+		}
+		if err != nil {
+			return t.fail(rel, err)
+		}
+
+		local := filepath.Join(localDir, filepath.FromSlash(rel))
+		if fi.IsDir() {
+			if err := os.MkdirAll(local, 0755); err != nil {
+				return t.fail(rel, err)
+			}
+			return nil
+		}
+
+		rfi := fi.(*RemoteFileInfo)
+		if lfi := localUpToDate(local, rfi, st.get(rel)); lfi != nil {
+			st.set(rel, treeEntry{ETag: rfi.ETag(), Size: lfi.Size(), ModTime: lfi.ModTime()})
+			return nil
+		}
+		agg.grow(rfi.Size())
+		t.run(func(ctx context.Context) {
+			lfi, err := c.mirrorFile(ctx, name, local, rfi, o.Download)
+			if err != nil {
+				t.fail(rel, err)
+				return
+			}
+			st.set(rel, treeEntry{ETag: rfi.ETag(), Size: lfi.Size(), ModTime: lfi.ModTime()})
+		})
+		return nil
+	})
+	err = t.wait(werr)
+
+	if o.StateFile != "" {
+		if serr := st.save(o.StateFile); err == nil {
+			err = serr
+		}
+	}
+	return err
+}
+
+// mirrorFile downloads name to a temporary file next to local and renames
+// it into place
+func (c *Client) mirrorFile(ctx context.Context, name, local string, fi *RemoteFileInfo, opts *DownloadOptions) (os.FileInfo, error) {
+	tmp := filepath.Join(filepath.Dir(local), TempPrefix+filepath.Base(local))
+	if _, err := c.DownloadFile(ctx, name, tmp, opts); err != nil {
+		os.Remove(tmp)
+		return nil, err
+	}
+	if mt := fi.ModTime(); !mt.IsZero() {
+		// a local filesystem that can't set times still gets the file, the
+		// state file keeps the mtime it ended up with
+		os.Chtimes(tmp, mt, mt)
+	}
+	if err := os.Rename(tmp, local); err != nil {
+		os.Remove(tmp)
+		return nil, err
+	}
+	return os.Stat(local)
+}
+
+// localUpToDate returns the local file if it matches the remote one
+func localUpToDate(local string, fi *RemoteFileInfo, prev *treeEntry) os.FileInfo {
+	lfi, err := os.Stat(local)
+	if err != nil || !lfi.Mode().IsRegular() || lfi.Size() != fi.Size() {
+		return nil
+	}
+	if prev != nil && fi.ETag() != "" {
+		if prev.ETag == fi.ETag() && prev.Size == lfi.Size() && prev.ModTime.Equal(lfi.ModTime()) {
+			return lfi
+		}
+		return nil
+	}
+	if !fi.ModTime().IsZero() && lfi.ModTime().Equal(fi.ModTime()) {
+		return lfi
+	}
+	return nil
+}
+
+// relPath returns name relative to root, false for root itself
+func relPath(root, name string) (string, bool) {
+	if name == root {
+		return "", false
+	}
+	if root == "/" {
+		return strings.TrimPrefix(name, "/"), true
+	}
+	rel, ok := strings.CutPrefix(name, root+"/")
+	return rel, ok
+}
+
+// treeRun runs the transfers of a tree operation on a bounded number of
+// goroutines and collects their failures
+type treeRun struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	op     string
+	strict bool
+
+	sem chan struct{}
+	wg  sync.WaitGroup
+
+	mu   sync.Mutex
+	errs []*FileError
+}
+
+func newTreeRun(ctx context.Context, op string, strict bool, parallel int) *treeRun {
+	t := &treeRun{op: op, strict: strict, sem: make(chan struct{}, parallel)}
+	t.ctx, t.cancel = context.WithCancel(ctx)
+	return t
+}
+
+// run starts fn once a slot is free, or not at all if the run was canceled
+func (t *treeRun) run(fn func(ctx context.Context)) {
+	select {
+	case t.sem <- struct{}{}:
+	case <-t.ctx.Done():
+		return
+	}
+	t.wg.Add(1)
+	go func() {
+		defer func() {
+			<-t.sem
+			t.wg.Done()
+		}()
+		fn(t.ctx)
+	}()
+}
+
+// fail records the failure of name, in strict mode it cancels the run and
+// returns an error to stop the walk
+func (t *treeRun) fail(name string, err error) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.strict && len(t.errs) > 0 {
+		// failures caused by the cancellation
+		return err
+	}
+	t.errs = append(t.errs, &FileError{Name: name, Err: err})
+	if t.strict {
+		t.cancel()
+		return err
+	}
+	return nil
+}
+
+// wait waits for the running transfers and returns the collected failures
+func (t *treeRun) wait(walkErr error) error {
+	t.wg.Wait()
+	t.cancel()
+
+	if len(t.errs) > 0 {
+		return &TreeError{Op: t.op, Errors: t.errs}
+	}
+	return walkErr
+}
+
+// treeState is the state file of the tree operations, the last seen
+// version of every file by its slash separated relative name
+type treeState struct {
+	mu    sync.Mutex
+	Files map[string]treeEntry `json:"files"`
+	seen  map[string]bool
+}
+
+// treeEntry records the local file and the ETag of the remote one, Sync
+// also records the remote size and modification time for servers without
+// ETags
+type treeEntry struct {
+	ETag    string    `json:"etag,omitempty"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mtime"`
+
+	RemoteSize    int64     `json:"rsize,omitempty"`
+	RemoteModTime time.Time `json:"rmtime"`
+}
+
+func loadTreeState(file string) (*treeState, error) {
+	st := &treeState{Files: make(map[string]treeEntry), seen: make(map[string]bool)}
+	if file == "" {
+		return st, nil
+	}
+
+	data, err := os.ReadFile(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return st, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, st); err != nil {
+		return nil, fmt.Errorf("webdav: state file %s: %v", file, err)
+	}
+	if st.Files == nil {
+		st.Files = make(map[string]treeEntry)
+	}
+	return st, nil
+}
+
+func (st *treeState) get(name string) *treeEntry {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if e, ok := st.Files[name]; ok {
+		return &e
+	}
+	return nil
+}
+
+func (st *treeState) set(name string, e treeEntry) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.Files[name] = e
+	st.seen[name] = true
+}
+
+// save writes the entries set in this run, replacing the file
+// atomically
+func (st *treeState) save(file string) error {
+	st.mu.Lock()
+	files := make(map[string]treeEntry, len(st.seen))
+	for name := range st.seen {
+		if e, ok := st.Files[name]; ok {
+			files[name] = e
+		}
+	}
+	st.mu.Unlock()
+
+	data, err := json.MarshalIndent(struct {
+		Files map[string]treeEntry `json:"files"`
+	}{files}, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	tmp := filepath.Join(filepath.Dir(file), TempPrefix+filepath.Base(file))
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, file)
+}
@@ -0,0 +1,197 @@
+package webdav
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// methodCounter counts the requests passed on to h by method
+type methodCounter struct {
+	h  http.Handler
+	mu sync.Mutex
+	n  map[string]int
+}
+
+func (m *methodCounter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	if m.n == nil {
+		m.n = make(map[string]int)
+	}
+	m.n[r.Method]++
+	m.mu.Unlock()
+	m.h.ServeHTTP(w, r)
+}
+
+// take returns the count of method and resets it
+func (m *methodCounter) take(method string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n := m.n[method]
+	delete(m.n, method)
+	return n
+}
+
+// countedDAVServer is newDAVServer with the requests counted by method
+func countedDAVServer(t testing.TB, fsys FileSystem) (*methodCounter, *Client) {
+	t.Helper()
+	mc := &methodCounter{h: &davServer{Server: &Server{Fs: fsys, TrimPrefix: "/"}}}
+	ts := httptest.NewServer(mc)
+	t.Cleanup(ts.Close)
+	c, err := NewClient(ts.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return mc, c
+}
+
+// mirrorTree is the remote tree of the mirror tests
+var mirrorTree = map[string]string{
+	"top.txt":              "top",
+	"a/one.txt":            "one",
+	"a/b/two.txt":          "two",
+	"a/b/c/three.txt":      "three",
+	"with space/ü & %.txt": "escaped",
+	"empty.txt":            "",
+}
+
+func newMirrorFS(t *testing.T) *MemFS {
+	m := NewMemFS()
+	for _, dir := range []string{"/a", "/a/b", "/a/b/c", "/with space", "/void"} {
+		if err := m.Mkdir(dir); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name, data := range mirrorTree {
+		writeMem(t, m, "/"+name, []byte(data))
+	}
+	return m
+}
+
+// checkMirror compares localDir with mirrorTree and returns the local
+// modification times
+func checkMirror(t *testing.T, localDir string) map[string]time.Time {
+	t.Helper()
+	times := make(map[string]time.Time)
+	for name, data := range mirrorTree {
+		local := filepath.Join(localDir, filepath.FromSlash(name))
+		b, err := os.ReadFile(local)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if string(b) != data {
+			t.Errorf("%s = %q, want %q", name, b, data)
+		}
+		fi, _ := os.Stat(local)
+		times[name] = fi.ModTime()
+	}
+	if fi, err := os.Stat(filepath.Join(localDir, "void")); err != nil || !fi.IsDir() {
+		t.Errorf("empty collection not mirrored: %v", err)
+	}
+	return times
+}
+
+func TestClientMirror(t *testing.T) {
+	for _, state := range []bool{false, true} {
+		name := "mtime"
+		if state {
+			name = "state file"
+		}
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			m := newMirrorFS(t)
+			mc, c := countedDAVServer(t, m)
+
+			local := filepath.Join(t.TempDir(), "mirror")
+			opts := &MirrorOptions{Parallel: 3}
+			if state {
+				opts.StateFile = filepath.Join(t.TempDir(), "state.json")
+			}
+
+			if err := c.Mirror(ctx, "/", local, opts); err != nil {
+				t.Fatal(err)
+			}
+			if n := mc.take("GET"); n != len(mirrorTree) {
+				t.Errorf("first run: %d GETs, want %d", n, len(mirrorTree))
+			}
+			remote := memFiles(t, m)
+			for name, mt := range checkMirror(t, local) {
+				if rt := remote["/"+name].modTime; !mt.Equal(rt.Truncate(time.Second)) {
+					t.Errorf("%s modified %v locally, %v remotely", name, mt, rt)
+				}
+			}
+
+			if err := c.Mirror(ctx, "/", local, opts); err != nil {
+				t.Fatal(err)
+			}
+			if n := mc.take("GET"); n != 0 {
+				t.Errorf("second run: %d GETs, want none", n)
+			}
+
+			writeMem(t, m, "/a/one.txt", []byte("changed"))
+			if err := m.Chtimes("/a/one.txt", time.Now().Add(time.Hour), time.Now().Add(time.Hour)); err != nil {
+				t.Fatal(err)
+			}
+			if err := c.Mirror(ctx, "/", local, opts); err != nil {
+				t.Fatal(err)
+			}
+			if n := mc.take("GET"); n != 1 {
+				t.Errorf("after one change: %d GETs, want 1", n)
+			}
+			if b, _ := os.ReadFile(filepath.Join(local, "a", "one.txt")); string(b) != "changed" {
+				t.Errorf("changed file mirrored as %q", b)
+			}
+		})
+	}
+}
+
+func TestClientMirrorCollectsFailures(t *testing.T) {
+	m := newMirrorFS(t)
+	d := &davServer{Server: &Server{Fs: m, TrimPrefix: "/"}}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" && r.URL.Path == "/a/b/two.txt" {
+			w.WriteHeader(StatusForbidden)
+			return
+		}
+		d.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+	c, err := NewClient(ts.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	local := t.TempDir()
+	err = c.Mirror(context.Background(), "/", local, &MirrorOptions{Parallel: 1})
+	var te *TreeError
+	if !errors.As(err, &te) || len(te.Errors) != 1 || te.Errors[0].Name != "a/b/two.txt" {
+		t.Fatalf("Mirror = %v, want one failure for a/b/two.txt", err)
+	}
+	if !errors.Is(err, ErrForbidden) {
+		t.Errorf("Mirror = %v, doesn't match ErrForbidden", err)
+	}
+	// everything else got mirrored
+	if b, err := os.ReadFile(filepath.Join(local, "a", "b", "c", "three.txt")); err != nil || string(b) != "three" {
+		t.Errorf("three.txt = %q, %v", b, err)
+	}
+	if _, err := os.Stat(filepath.Join(local, "a", "b", "two.txt")); !os.IsNotExist(err) {
+		t.Errorf("failed file left behind: %v", err)
+	}
+	if tmp := tempEntries(t, filepath.Join(local, "a", "b")); len(tmp) > 0 {
+		t.Errorf("temporary files left behind: %v", tmp)
+	}
+
+	err = c.Mirror(context.Background(), "/", t.TempDir(), &MirrorOptions{Parallel: 1, Strict: true})
+	if !errors.As(err, &te) || len(te.Errors) != 1 {
+		t.Errorf("strict Mirror = %v, want the one failure", err)
+	}
+}
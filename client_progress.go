@@ -0,0 +1,249 @@
+package webdav
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// A Progress receives the progress of the Client's transfers. Update is
+// called with the bytes transferred so far and the size of the transfer,
+// -1 if unknown, and a last time with finished set, whether the transfer
+// succeeded or not. The count goes back to zero when a transfer starts
+// over.
+//
+// Updates are delivered from a separate goroutine and dropped in favor of
+// newer ones while Update runs, so a slow Progress never slows a transfer
+// down. Tree operations such as Mirror additionally report their
+// aggregate under the name of their remote root.
+type Progress interface {
+	Update(name string, done, total int64, finished bool)
+}
+
+// ProgressFunc adapts a function to the Progress interface
+type ProgressFunc func(name string, done, total int64, finished bool)
+
+func (f ProgressFunc) Update(name string, done, total int64, finished bool) {
+	f(name, done, total, finished)
+}
+
+// defaults of the update granularity
+const (
+	DefaultProgressBytes    = 256 << 10
+	DefaultProgressInterval = 200 * time.Millisecond
+)
+
+// WithProgress reports the progress of every transfer to p, unless the
+// context of the call carries its own from ContextWithProgress
+func WithProgress(p Progress) ClientOption {
+	return func(cfg *clientConfig) {
+		cfg.progress = p
+	}
+}
+
+// ProgressEvery sets how often progress is reported: when at least bytes
+// more were transferred or interval has passed since the last update
+func ProgressEvery(bytes int64, interval time.Duration) ClientOption {
+	return func(cfg *clientConfig) {
+		cfg.progressBytes = bytes
+		cfg.progressInterval = interval
+	}
+}
+
+type progressKey struct{}
+
+// ContextWithProgress returns a context that makes the Client calls using
+// it report to p instead of the Client's Progress
+func ContextWithProgress(ctx context.Context, p Progress) context.Context {
+	return context.WithValue(ctx, progressKey{}, p)
+}
+
+func (c *Client) progressOf(ctx context.Context) Progress {
+	if p, ok := ctx.Value(progressKey{}).(Progress); ok {
+		return p
+	}
+	return c.progress
+}
+
+// track starts reporting a transfer, it returns nil if there's no Progress.
+// The methods of a nil *tracker do nothing.
+func (c *Client) track(ctx context.Context, name string, total int64) *tracker {
+	p := c.progressOf(ctx)
+	if p == nil {
+		return nil
+	}
+
+	t := &tracker{
+		p:        p,
+		name:     name,
+		total:    total,
+		every:    c.progressBytes,
+		interval: c.progressInterval,
+		signal:   make(chan struct{}, 1),
+	}
+	if t.every <= 0 {
+		t.every = DefaultProgressBytes
+	}
+	if t.interval <= 0 {
+		t.interval = DefaultProgressInterval
+	}
+	t.parent, _ = ctx.Value(aggregateKey{}).(*tracker)
+	t.last = time.Now()
+	go t.deliver()
+	return t
+}
+
+// tracker throttles the updates of one transfer and hands them to its
+// delivering goroutine, which only ever sees the latest one
+type tracker struct {
+	p        Progress
+	name     string
+	every    int64
+	interval time.Duration
+	signal   chan struct{}
+	parent   *tracker // the aggregate of a tree operation
+
+	mu       sync.Mutex
+	done     int64
+	total    int64
+	lastDone int64
+	last     time.Time
+	pending  *progressUpdate
+	finished bool
+}
+
+type progressUpdate struct {
+	done, total int64
+	finished    bool
+}
+
+func (t *tracker) deliver() {
+	for range t.signal {
+		t.mu.Lock()
+		u := t.pending
+		t.pending = nil
+		t.mu.Unlock()
+
+		if u == nil {
+			continue
+		}
+		t.p.Update(t.name, u.done, u.total, u.finished)
+		if u.finished {
+			return
+		}
+	}
+}
+
+// post queues the current state, the caller holds t.mu
+func (t *tracker) post(finished bool) {
+	t.pending = &progressUpdate{done: t.done, total: t.total, finished: finished}
+	t.lastDone, t.last = t.done, time.Now()
+	select {
+	case t.signal <- struct{}{}:
+	default:
+	}
+}
+
+// add counts n more bytes, negative to take back a restarted part
+func (t *tracker) add(n int64) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	if t.finished {
+		t.mu.Unlock()
+		return
+	}
+	t.done += n
+	if d := t.done - t.lastDone; d >= t.every || d < 0 || time.Since(t.last) >= t.interval {
+		t.post(false)
+	}
+	t.mu.Unlock()
+
+	t.parent.add(n)
+}
+
+// grow adds n to the total, for aggregates that learn it as they go
+func (t *tracker) grow(n int64) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	t.total += n
+	t.mu.Unlock()
+}
+
+func (t *tracker) setTotal(n int64) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	t.total = n
+	t.mu.Unlock()
+}
+
+// reset starts the count over
+func (t *tracker) reset() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	if t.finished || t.done == 0 {
+		t.mu.Unlock()
+		return
+	}
+	done := t.done
+	t.done = 0
+	t.post(false)
+	t.mu.Unlock()
+
+	t.parent.add(-done)
+}
+
+// finish sends the final update, it must be called once the transfer ends
+func (t *tracker) finish() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.finished {
+		t.finished = true
+		t.post(true)
+	}
+}
+
+// progressBody counts the bytes read through it, finishing its tracker on
+// Close if closeFinishes is set
+type progressBody struct {
+	io.ReadCloser
+	t             *tracker
+	closeFinishes bool
+}
+
+func (b *progressBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.t.add(int64(n))
+	return n, err
+}
+
+func (b *progressBody) Close() error {
+	err := b.ReadCloser.Close()
+	if b.closeFinishes {
+		b.t.finish()
+	}
+	return err
+}
+
+type aggregateKey struct{}
+
+// trackTree returns a context whose transfers are also summed up under
+// root, and the tracker of the sum
+func (c *Client) trackTree(ctx context.Context, root string) (context.Context, *tracker) {
+	agg := c.track(ctx, root, 0)
+	if agg == nil {
+		return ctx, nil
+	}
+	return context.WithValue(ctx, aggregateKey{}, agg), agg
+}
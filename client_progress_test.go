@@ -0,0 +1,197 @@
+package webdav
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// progressRecorder keeps every update by name
+type progressRecorder struct {
+	mu      sync.Mutex
+	updates map[string][]progressUpdate
+	delay   time.Duration // slows every Update down
+}
+
+func (r *progressRecorder) Update(name string, done, total int64, finished bool) {
+	time.Sleep(r.delay)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.updates == nil {
+		r.updates = make(map[string][]progressUpdate)
+	}
+	r.updates[name] = append(r.updates[name], progressUpdate{done, total, finished})
+}
+
+// wait returns the updates of name once the finishing one arrived
+func (r *progressRecorder) wait(t *testing.T, name string) []progressUpdate {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		r.mu.Lock()
+		us := r.updates[name]
+		r.mu.Unlock()
+		if len(us) > 0 && us[len(us)-1].finished {
+			return us
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("no finishing update for %s, got %v", name, us)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// pacedWriter writes responses in small pieces with pauses in between,
+// so a transfer lasts long enough for intermediate updates
+type pacedWriter struct {
+	http.ResponseWriter
+}
+
+func (w pacedWriter) Write(p []byte) (int, error) {
+	var written int
+	for len(p) > 0 {
+		n, err := w.ResponseWriter.Write(p[:min(len(p), 64<<10)])
+		written += n
+		if err != nil {
+			return written, err
+		}
+		w.ResponseWriter.(http.Flusher).Flush()
+		time.Sleep(2 * time.Millisecond)
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// pacedReader is the request body counterpart of pacedWriter
+type pacedReader struct {
+	r io.Reader
+}
+
+func (r pacedReader) Read(p []byte) (int, error) {
+	time.Sleep(2 * time.Millisecond)
+	return r.r.Read(p[:min(len(p), 64<<10)])
+}
+
+// checkProgress checks that updates count up to the final done and total
+func checkProgress(t *testing.T, name string, us []progressUpdate, size int64) {
+	t.Helper()
+	if len(us) < 2 {
+		t.Errorf("%s: only %d updates", name, len(us))
+	}
+	var prev int64
+	for i, u := range us {
+		if u.done < prev {
+			t.Errorf("%s: update %d went back from %d to %d", name, i, prev, u.done)
+		}
+		if u.finished != (i == len(us)-1) {
+			t.Errorf("%s: update %d of %d has finished %v", name, i, len(us), u.finished)
+		}
+		prev = u.done
+	}
+	if last := us[len(us)-1]; last.done != size || last.total != size {
+		t.Errorf("%s: last update %d of %d, want %d of %d", name, last.done, last.total, size, size)
+	}
+}
+
+func TestClientProgress(t *testing.T) {
+	ctx := context.Background()
+	rec := &progressRecorder{}
+	m := NewMemFS()
+	d := &davServer{Server: &Server{Fs: m, TrimPrefix: "/"}}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		d.ServeHTTP(pacedWriter{w}, r)
+	}))
+	defer ts.Close()
+	c, err := NewClient(ts.URL, nil, WithProgress(rec), ProgressEvery(64<<10, time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	content := randomBytes(t, 2<<20)
+	size := int64(len(content))
+
+	t.Run("put", func(t *testing.T) {
+		if err := c.Put(ctx, "/up", pacedReader{bytes.NewReader(content)}, ContentLength(size)); err != nil {
+			t.Fatal(err)
+		}
+		checkProgress(t, "/up", rec.wait(t, "/up"), size)
+	})
+	t.Run("put chunked", func(t *testing.T) {
+		if err := c.Put(ctx, "/chunked", struct{ io.Reader }{pacedReader{bytes.NewReader(content)}}); err != nil {
+			t.Fatal(err)
+		}
+		us := rec.wait(t, "/chunked")
+		for _, u := range us {
+			if u.total != -1 {
+				t.Fatalf("total %d for a body of unknown size", u.total)
+			}
+		}
+		if last := us[len(us)-1]; last.done != size {
+			t.Errorf("last update %d, want %d", last.done, size)
+		}
+	})
+	t.Run("download", func(t *testing.T) {
+		writeMem(t, m, "/down", content)
+		if _, err := c.DownloadFile(ctx, "/down", filepath.Join(t.TempDir(), "down"), nil); err != nil {
+			t.Fatal(err)
+		}
+		checkProgress(t, "/down", rec.wait(t, "/down"), size)
+	})
+	t.Run("per call", func(t *testing.T) {
+		own := &progressRecorder{}
+		if err := c.Put(ContextWithProgress(ctx, own), "/own", pacedReader{bytes.NewReader(content)}, ContentLength(size)); err != nil {
+			t.Fatal(err)
+		}
+		checkProgress(t, "/own", own.wait(t, "/own"), size)
+		rec.mu.Lock()
+		defer rec.mu.Unlock()
+		if us := rec.updates["/own"]; len(us) > 0 {
+			t.Errorf("the Client's Progress got %d updates of a call with its own", len(us))
+		}
+	})
+	t.Run("mirror", func(t *testing.T) {
+		m.Mkdir("/tree")
+		writeMem(t, m, "/tree/x", content)
+		writeMem(t, m, "/tree/y", content[:size/2])
+		if err := c.Mirror(ctx, "/tree", t.TempDir(), nil); err != nil {
+			t.Fatal(err)
+		}
+		checkProgress(t, "/tree/x", rec.wait(t, "/tree/x"), size)
+		checkProgress(t, "/tree", rec.wait(t, "/tree"), size+size/2)
+	})
+	t.Run("failed", func(t *testing.T) {
+		if _, err := c.DownloadFile(ctx, "/missing", filepath.Join(t.TempDir(), "x"), nil); err == nil {
+			t.Fatal("download of a missing file succeeded")
+		}
+		if us := rec.wait(t, "/missing"); len(us) != 1 {
+			t.Errorf("failed download: %v, want only the finishing update", us)
+		}
+	})
+}
+
+func TestClientProgressSlowConsumer(t *testing.T) {
+	rec := &progressRecorder{delay: 100 * time.Millisecond}
+	_, _, c := newDAVServer(t, NewMemFS(), WithProgress(rec), ProgressEvery(1, time.Nanosecond))
+	content := randomBytes(t, 4<<20)
+
+	start := time.Now()
+	if err := c.Put(context.Background(), "/slow", bytes.NewReader(content)); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d > 2*time.Second {
+		t.Errorf("a slow Progress held the upload up for %v", d)
+	}
+	us := rec.wait(t, "/slow")
+	if len(us) > 10 {
+		t.Errorf("%d updates delivered to a consumer slower than the transfer", len(us))
+	}
+	if last := us[len(us)-1]; last.done != int64(len(content)) {
+		t.Errorf("last update %d, want %d", last.done, len(content))
+	}
+}
@@ -0,0 +1,284 @@
+package webdav
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// properties requested by Stat and ReadDir
+const propfindBody = `<?xml version="1.0" encoding="utf-8"?>
+<D:propfind xmlns:D="DAV:"><D:prop>
+<D:resourcetype/><D:getcontentlength/><D:getlastmodified/><D:getetag/><D:getcontenttype/>
+</D:prop></D:propfind>`
+
+// A RemoteFileInfo describes a resource on a WebDAV server, it is the
+// os.FileInfo returned by the Client
+type RemoteFileInfo struct {
+	name        string
+	size        int64
+	modTime     time.Time
+	isDir       bool
+	etag        string
+	contentType string
+}
+
+func (fi *RemoteFileInfo) Name() string       { return fi.name }
+func (fi *RemoteFileInfo) Size() int64        { return fi.size }
+func (fi *RemoteFileInfo) ModTime() time.Time { return fi.modTime }
+func (fi *RemoteFileInfo) IsDir() bool        { return fi.isDir }
+func (fi *RemoteFileInfo) Sys() interface{}   { return nil }
+
+func (fi *RemoteFileInfo) Mode() os.FileMode {
+	if fi.isDir {
+		return os.ModeDir | 0755
+	}
+	return 0644
+}
+
+// ETag returns the entity tag reported by the server, including quotes
+func (fi *RemoteFileInfo) ETag() string { return fi.etag }
+
+// ContentType returns the content type reported by the server
+func (fi *RemoteFileInfo) ContentType() string { return fi.contentType }
+
+// xmlNode is any XML element, used for property values
+type xmlNode struct {
+	XMLName  xml.Name
+	Attrs    []xml.Attr `xml:",any,attr"`
+	Text     string     `xml:",chardata"`
+	Children []xmlNode  `xml:",any"`
+}
+
+// child returns the first child element named name
+func (n *xmlNode) child(name xml.Name) *xmlNode {
+	for i := range n.Children {
+		if n.Children[i].XMLName == name {
+			return &n.Children[i]
+		}
+	}
+	return nil
+}
+
+type multistatus struct {
+	Responses []msResponse `xml:"DAV: response"`
+}
+
+type msResponse struct {
+	Hrefs     []string     `xml:"DAV: href"`
+	Status    string       `xml:"DAV: status"`
+	Propstats []msPropstat `xml:"DAV: propstat"`
+	Error     *xmlNode     `xml:"DAV: error"`
+}
+
+type msPropstat struct {
+	Prop   xmlNode `xml:"DAV: prop"`
+	Status string  `xml:"DAV: status"`
+}
+
+// parseStatus returns the code of a "HTTP/1.1 200 OK" status line
+func parseStatus(s string) int {
+	fields := strings.Fields(s)
+	if len(fields) < 2 {
+		return 0
+	}
+	code, _ := strconv.Atoi(fields[1])
+	return code
+}
+
+// props returns the properties of the propstats with a 2xx status
+func (r *msResponse) props() map[xml.Name]*xmlNode {
+	props := make(map[xml.Name]*xmlNode)
+	for i := range r.Propstats {
+		ps := &r.Propstats[i]
+		if code := parseStatus(ps.Status); code < 200 || code > 299 {
+			continue
+		}
+		for j := range ps.Prop.Children {
+			props[ps.Prop.Children[j].XMLName] = &ps.Prop.Children[j]
+		}
+	}
+	return props
+}
+
+// hrefPath returns the decoded path of an href, which servers send as an
+// absolute URI or an absolute path, escaped or not
+func hrefPath(href string) string {
+	href = strings.TrimSpace(href)
+	if u, err := url.Parse(href); err == nil {
+		href = u.Path
+	} else if p, err := url.PathUnescape(href); err == nil {
+		href = p
+	}
+
+	if href == "" {
+		return "/"
+	}
+	return path.Clean("/" + href)
+}
+
+func davName(local string) xml.Name {
+	return xml.Name{Space: "DAV:", Local: local}
+}
+
+// fileInfo builds the RemoteFileInfo of a response
+func (r *msResponse) fileInfo(p string) *RemoteFileInfo {
+	props := r.props()
+	fi := &RemoteFileInfo{name: path.Base(p)}
+
+	if rt := props[davName("resourcetype")]; rt != nil {
+		fi.isDir = rt.child(davName("collection")) != nil
+	}
+	if n := props[davName("getcontentlength")]; n != nil {
+		fi.size, _ = strconv.ParseInt(strings.TrimSpace(n.Text), 10, 64)
+	}
+	if n := props[davName("getlastmodified")]; n != nil {
+		fi.modTime, _ = http.ParseTime(strings.TrimSpace(n.Text))
+	}
+	if n := props[davName("getetag")]; n != nil {
+		fi.etag = strings.TrimSpace(n.Text)
+	}
+	if n := props[davName("getcontenttype")]; n != nil {
+		fi.contentType = strings.TrimSpace(n.Text)
+	}
+	return fi
+}
+
+// propfind sends a PROPFIND with the given depth and body and returns the
+// parsed multistatus and the decoded path that was requested
+func (c *Client) propfind(ctx context.Context, name, depth, body string) (*multistatus, string, error) {
+	req, err := c.newRequest(ctx, "PROPFIND", name, depth != "0", strings.NewReader(body))
+	if err != nil {
+		return nil, "", err
+	}
+	req.Header.Set("Depth", depth)
+	req.Header.Set("Content-Type", "application/xml; charset=utf-8")
+
+	resp, err := c.do(req, StatusMulti)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	ms, err := parseMultistatus(resp.Body)
+	if err != nil {
+		return nil, "", err
+	}
+	// the path that answered, after redirects
+	return ms, path.Clean(resp.Request.URL.Path), nil
+}
+
+// parseMultistatus decodes a multistatus body, of any length as listings
+// of large collections are, but no deeper than maxXMLDepth
+func parseMultistatus(r io.Reader) (*multistatus, error) {
+	var ms multistatus
+	if err := newXMLDecoder(r, 0).Decode(&ms); err != nil {
+		return nil, err
+	}
+	return &ms, nil
+}
+
+// Stat returns the properties of name with a Depth 0 PROPFIND
+func (c *Client) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	ms, reqPath, err := c.propfind(ctx, name, "0", propfindBody)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := c.self(ms, name, reqPath)
+	if err != nil {
+		return nil, err
+	}
+	return r.fileInfo(reqPath), nil
+}
+
+// self returns the response for the requested resource of a Depth 0
+// PROPFIND
+func (c *Client) self(ms *multistatus, name, reqPath string) (*msResponse, error) {
+	for i := range ms.Responses {
+		r := &ms.Responses[i]
+		for _, href := range r.Hrefs {
+			if hrefPath(href) != reqPath {
+				continue
+			}
+			if code := parseStatus(r.Status); code != 0 && (code < 200 || code > 299) {
+				return nil, &StatusError{Method: "PROPFIND", URL: c.url(name, false), Code: code}
+			}
+			return r, nil
+		}
+	}
+
+	// some servers answer with a different href for the only response,
+	// e.g. after an internal rewrite
+	if len(ms.Responses) == 1 {
+		return &ms.Responses[0], nil
+	}
+	return nil, &StatusError{Method: "PROPFIND", URL: c.url(name, false), Code: StatusNotFound}
+}
+
+const quotaBody = `<?xml version="1.0" encoding="utf-8"?>
+<D:propfind xmlns:D="DAV:"><D:prop>
+<D:quota-used-bytes/><D:quota-available-bytes/>
+</D:prop></D:propfind>`
+
+// Quota returns the RFC 4331 quota of the collection name, the bytes used
+// and the bytes still available. It returns ErrNotSupported if the server
+// doesn't report both. Some servers send negative values for an unknown
+// or unlimited quota, e.g. Nextcloud -3 for unlimited, they are passed on
+// as they are.
+func (c *Client) Quota(ctx context.Context, name string) (used, available int64, err error) {
+	ms, reqPath, err := c.propfind(ctx, name, "0", quotaBody)
+	if err != nil {
+		return 0, 0, err
+	}
+	r, err := c.self(ms, name, reqPath)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	props := r.props()
+	u, a := props[davName("quota-used-bytes")], props[davName("quota-available-bytes")]
+	if u == nil || a == nil {
+		return 0, 0, ErrNotSupported
+	}
+	if used, err = strconv.ParseInt(strings.TrimSpace(u.Text), 10, 64); err != nil {
+		return 0, 0, fmt.Errorf("webdav: quota-used-bytes: %v", err)
+	}
+	if available, err = strconv.ParseInt(strings.TrimSpace(a.Text), 10, 64); err != nil {
+		return 0, 0, fmt.Errorf("webdav: quota-available-bytes: %v", err)
+	}
+	return used, available, nil
+}
+
+// ReadDir returns the members of the collection name, sorted as the
+// server sent them, with a Depth 1 PROPFIND
+func (c *Client) ReadDir(ctx context.Context, name string) ([]os.FileInfo, error) {
+	ms, reqPath, err := c.propfind(ctx, name, "1", propfindBody)
+	if err != nil {
+		return nil, err
+	}
+
+	var fis []os.FileInfo
+	for i := range ms.Responses {
+		r := &ms.Responses[i]
+		for _, href := range r.Hrefs {
+			p := hrefPath(href)
+			if p == reqPath || path.Dir(p) != reqPath {
+				continue
+			}
+			if code := parseStatus(r.Status); code != 0 && (code < 200 || code > 299) {
+				continue
+			}
+			fis = append(fis, r.fileInfo(p))
+		}
+	}
+	return fis, nil
+}
@@ -0,0 +1,138 @@
+package webdav
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A PropError is returned by SetProps when the server refused some of the
+// changes, with the status of every property that failed. Servers that
+// apply a PROPPATCH atomically fail the others with 424 Failed Dependency.
+type PropError struct {
+	URL    string
+	Errors map[xml.Name]*StatusError
+}
+
+func (e *PropError) Error() string {
+	names := make([]string, 0, len(e.Errors))
+	for name, err := range e.Errors {
+		names = append(names, fmt.Sprintf("{%s}%s: %d", name.Space, name.Local, err.Code))
+	}
+	sort.Strings(names)
+	return fmt.Sprintf("webdav: PROPPATCH %s: %s", e.URL, strings.Join(names, ", "))
+}
+
+// Unwrap lets errors.Is and errors.As look at every property error
+func (e *PropError) Unwrap() []error {
+	errs := make([]error, 0, len(e.Errors))
+	for _, err := range e.Errors {
+		errs = append(errs, err)
+	}
+	return errs
+}
+
+// SetProps sets the properties in set to their text values and removes
+// those in remove with one PROPPATCH. Properties in other namespaces than
+// DAV: are sent with their own namespace declaration, so any xml.Name
+// round-trips. It returns a *PropError if some of the changes failed.
+func (c *Client) SetProps(ctx context.Context, name string, set map[xml.Name]string, remove []xml.Name) error {
+	req, err := c.newRequest(ctx, "PROPPATCH", name, false, strings.NewReader(propertyupdate(set, remove)))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/xml; charset=utf-8")
+	c.addLockTokens(req, name)
+
+	resp, err := c.do(req, StatusMulti, StatusOK, StatusNoContent)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != StatusMulti {
+		return nil
+	}
+	ms, err := parseMultistatus(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	perr := &PropError{URL: req.URL.String(), Errors: make(map[xml.Name]*StatusError)}
+	for _, r := range ms.Responses {
+		if code := parseStatus(r.Status); code != 0 && (code < 200 || code > 299) {
+			return &StatusError{Method: "PROPPATCH", URL: req.URL.String(), Code: code}
+		}
+		for _, ps := range r.Propstats {
+			code := parseStatus(ps.Status)
+			if code >= 200 && code <= 299 {
+				continue
+			}
+			for _, p := range ps.Prop.Children {
+				perr.Errors[p.XMLName] = &StatusError{Method: "PROPPATCH", URL: req.URL.String(), Code: code}
+			}
+		}
+	}
+	if len(perr.Errors) > 0 {
+		return perr
+	}
+	return nil
+}
+
+// SetModTime sets the modification time of name with the DAV:lastmodified
+// property as ownCloud and Nextcloud take it, in Unix seconds
+func (c *Client) SetModTime(ctx context.Context, name string, t time.Time) error {
+	set := map[xml.Name]string{davName("lastmodified"): strconv.FormatInt(t.Unix(), 10)}
+	return c.SetProps(ctx, name, set, nil)
+}
+
+// propertyupdate builds a PROPPATCH body, every property declares its own
+// namespace
+func propertyupdate(set map[xml.Name]string, remove []xml.Name) string {
+	names := make([]xml.Name, 0, len(set))
+	for name := range set {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if names[i].Space != names[j].Space {
+			return names[i].Space < names[j].Space
+		}
+		return names[i].Local < names[j].Local
+	})
+
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="utf-8"?>` + "\n" + `<D:propertyupdate xmlns:D="DAV:">`)
+	if len(names) > 0 {
+		b.WriteString("<D:set><D:prop>")
+		for _, name := range names {
+			writeProp(&b, name, set[name])
+		}
+		b.WriteString("</D:prop></D:set>")
+	}
+	if len(remove) > 0 {
+		b.WriteString("<D:remove><D:prop>")
+		for _, name := range remove {
+			writeProp(&b, name, "")
+		}
+		b.WriteString("</D:prop></D:remove>")
+	}
+	b.WriteString("</D:propertyupdate>")
+	return b.String()
+}
+
+func writeProp(b *strings.Builder, name xml.Name, value string) {
+	tag, decl := "P:"+name.Local, "xmlns:P"
+	if name.Space == "" {
+		// a prefix can't be bound to no namespace
+		tag, decl = name.Local, "xmlns"
+	}
+	b.WriteString("<" + tag + " " + decl + `="`)
+	xml.EscapeText(b, []byte(name.Space))
+	b.WriteString(`">`)
+	xml.EscapeText(b, []byte(value))
+	b.WriteString("</" + tag + ">")
+}
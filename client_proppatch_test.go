@@ -0,0 +1,166 @@
+package webdav
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// propertyupdateBody is a PROPPATCH body as a server decodes it
+type propertyupdateBody struct {
+	Set []struct {
+		Prop xmlNode `xml:"DAV: prop"`
+	} `xml:"DAV: set"`
+	Remove []struct {
+		Prop xmlNode `xml:"DAV: prop"`
+	} `xml:"DAV: remove"`
+}
+
+// newPropServer answers every PROPPATCH with the multistatus body, and
+// hands the request bodies to got
+func newPropServer(t *testing.T, multistatus string, got chan<- string) *Client {
+	t.Helper()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		if got != nil {
+			got <- string(b)
+		}
+		w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+		w.WriteHeader(StatusMulti)
+		io.WriteString(w, multistatus)
+	}))
+	t.Cleanup(ts.Close)
+	c, err := NewClient(ts.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func TestSetPropsNamespaces(t *testing.T) {
+	bodies := make(chan string, 1)
+	c := newPropServer(t, `<D:multistatus xmlns:D="DAV:"><D:response><D:href>/a</D:href>`+
+		`<D:propstat><D:prop/><D:status>HTTP/1.1 200 OK</D:status></D:propstat></D:response></D:multistatus>`, bodies)
+
+	set := map[xml.Name]string{
+		{Space: "urn:x-test", Local: "color"}: `blue & <green>`,
+		{Space: "", Local: "plain"}:           "no namespace",
+		{Space: "DAV:", Local: "displayname"}: "A",
+	}
+	remove := []xml.Name{{Space: "urn:x-other", Local: "gone"}, {Local: "bare"}}
+	if err := c.SetProps(context.Background(), "a", set, remove); err != nil {
+		t.Fatal(err)
+	}
+
+	var update propertyupdateBody
+	if err := xml.Unmarshal([]byte(<-bodies), &update); err != nil {
+		t.Fatal(err)
+	}
+	if len(update.Set) != 1 || len(update.Remove) != 1 {
+		t.Fatalf("body %+v", update)
+	}
+	got := map[xml.Name]string{}
+	for _, p := range update.Set[0].Prop.Children {
+		got[p.XMLName] = p.Text
+	}
+	if len(got) != len(set) {
+		t.Errorf("set %v, want %v", got, set)
+	}
+	for name, value := range set {
+		if v, ok := got[name]; !ok || v != value {
+			t.Errorf("set {%s}%s = %q, %v, want %q", name.Space, name.Local, v, ok, value)
+		}
+	}
+	var removed []xml.Name
+	for _, p := range update.Remove[0].Prop.Children {
+		removed = append(removed, p.XMLName)
+	}
+	if len(removed) != 2 || removed[0] != remove[0] || removed[1] != remove[1] {
+		t.Errorf("removed %v, want %v", removed, remove)
+	}
+}
+
+func TestSetPropsFailedDependency(t *testing.T) {
+	c := newPropServer(t, `<D:multistatus xmlns:D="DAV:" xmlns:X="urn:x-test"><D:response><D:href>/a</D:href>`+
+		`<D:propstat><D:prop><X:color/></D:prop><D:status>HTTP/1.1 403 Forbidden</D:status></D:propstat>`+
+		`<D:propstat><D:prop><plain xmlns=""/><D:displayname/></D:prop><D:status>HTTP/1.1 424 Failed Dependency</D:status></D:propstat>`+
+		`</D:response></D:multistatus>`, nil)
+
+	err := c.SetProps(context.Background(), "a", map[xml.Name]string{
+		{Space: "urn:x-test", Local: "color"}: "blue",
+		{Local: "plain"}:                      "v",
+		{Space: "DAV:", Local: "displayname"}: "A",
+	}, nil)
+	var perr *PropError
+	if !errors.As(err, &perr) {
+		t.Fatalf("SetProps = %v, want a *PropError", err)
+	}
+	for name, code := range map[xml.Name]int{
+		{Space: "urn:x-test", Local: "color"}: 403,
+		{Local: "plain"}:                      424,
+		{Space: "DAV:", Local: "displayname"}: 424,
+	} {
+		if e := perr.Errors[name]; e == nil || e.Code != code {
+			t.Errorf("{%s}%s: %v, want %d", name.Space, name.Local, e, code)
+		}
+	}
+	var serr *StatusError
+	if !errors.As(err, &serr) {
+		t.Errorf("%v doesn't unwrap to a *StatusError", err)
+	}
+	if msg := err.Error(); !strings.Contains(msg, "{urn:x-test}color: 403") || !strings.Contains(msg, "{}plain: 424") {
+		t.Errorf("Error() = %q", msg)
+	}
+
+	// a failed response, rather than failed properties, is a StatusError
+	c = newPropServer(t, `<D:multistatus xmlns:D="DAV:"><D:response><D:href>/a</D:href>`+
+		`<D:status>HTTP/1.1 423 Locked</D:status></D:response></D:multistatus>`, nil)
+	err = c.SetProps(context.Background(), "a", map[xml.Name]string{{Local: "plain"}: "v"}, nil)
+	if !errors.As(err, &serr) || serr.Code != 423 || errors.As(err, &perr) {
+		t.Errorf("SetProps of a locked resource = %v", err)
+	}
+}
+
+func TestSetModTime(t *testing.T) {
+	ctx := context.Background()
+	_, _, c := newDAVServer(t, NewMemFS())
+	if err := c.WriteFile(ctx, "a.txt", []byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Date(2001, 2, 3, 4, 5, 6, 700, time.UTC)
+	if err := c.SetModTime(ctx, "a.txt", mtime); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := c.Stat(ctx, "a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !fi.ModTime().Equal(mtime.Truncate(time.Second)) {
+		t.Errorf("modified %v, want %v", fi.ModTime(), mtime.Truncate(time.Second))
+	}
+
+	// the value is Unix seconds, as X-OC-MTime
+	bodies := make(chan string, 1)
+	c = newPropServer(t, `<D:multistatus xmlns:D="DAV:"/>`, bodies)
+	if err := c.SetModTime(ctx, "a.txt", mtime); err != nil {
+		t.Fatal(err)
+	}
+	var update propertyupdateBody
+	if err := xml.Unmarshal([]byte(<-bodies), &update); err != nil {
+		t.Fatal(err)
+	}
+	if len(update.Set) != 1 || len(update.Set[0].Prop.Children) != 1 {
+		t.Fatalf("body %+v", update)
+	}
+	p := update.Set[0].Prop.Children[0]
+	if p.XMLName != davName("lastmodified") || p.Text != "981173106" {
+		t.Errorf("set {%s}%s = %q", p.XMLName.Space, p.XMLName.Local, p.Text)
+	}
+}
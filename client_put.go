@@ -0,0 +1,95 @@
+package webdav
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"hash"
+	"io"
+	"net/http"
+)
+
+// bodies larger than this wait for 100 Continue even if they could be
+// sent again
+const expectContinueSize = 1 << 20
+
+// ContentLength declares the size of a Put body read from a plain
+// io.Reader, so it is sent with Content-Length instead of chunked
+func ContentLength(n int64) RequestOption {
+	return func(req *http.Request) {
+		if n == 0 {
+			req.Body = http.NoBody
+			req.GetBody = nil
+		}
+		req.ContentLength = n
+	}
+}
+
+// ContentMD5 sends the precomputed MD5 sum of the body in a Content-MD5
+// header
+func ContentMD5(sum []byte) RequestOption {
+	return func(req *http.Request) {
+		req.Header.Set("Content-MD5", base64.StdEncoding.EncodeToString(sum))
+	}
+}
+
+// ContentSHA256 sends the precomputed SHA-256 sum of the body in a Digest
+// header, RFC 3230
+func ContentSHA256(sum []byte) RequestOption {
+	return func(req *http.Request) {
+		req.Header.Set("Digest", "sha-256="+base64.StdEncoding.EncodeToString(sum))
+	}
+}
+
+// DigestTrailer computes the SHA-256 sum of the body while it is sent and
+// appends it as a Digest trailer, for bodies whose sum isn't known in
+// advance. The body is sent chunked and can't be sent twice.
+func DigestTrailer() RequestOption {
+	return func(req *http.Request) {
+		if req.Body == nil || req.Body == http.NoBody {
+			return
+		}
+		if req.Trailer == nil {
+			req.Trailer = make(http.Header)
+		}
+		req.Trailer["Digest"] = nil
+		req.Body = &trailerBody{ReadCloser: req.Body, h: sha256.New(), req: req, key: "Digest", prefix: "sha-256="}
+		req.GetBody = nil
+		req.ContentLength = -1
+	}
+}
+
+// MD5Trailer is DigestTrailer with the MD5 sum in a Content-MD5 trailer
+func MD5Trailer() RequestOption {
+	return func(req *http.Request) {
+		if req.Body == nil || req.Body == http.NoBody {
+			return
+		}
+		if req.Trailer == nil {
+			req.Trailer = make(http.Header)
+		}
+		req.Trailer["Content-Md5"] = nil
+		req.Body = &trailerBody{ReadCloser: req.Body, h: md5.New(), req: req, key: "Content-Md5"}
+		req.GetBody = nil
+		req.ContentLength = -1
+	}
+}
+
+// trailerBody hashes a request body and sets the trailer at EOF, before
+// the transport writes the trailers
+type trailerBody struct {
+	io.ReadCloser
+	h      hash.Hash
+	req    *http.Request
+	key    string
+	prefix string
+}
+
+func (b *trailerBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.h.Write(p[:n])
+	if err == io.EOF {
+		b.req.Trailer[b.key] = []string{b.prefix + base64.StdEncoding.EncodeToString(b.h.Sum(nil))}
+	}
+	return n, err
+}
@@ -0,0 +1,181 @@
+package webdav
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// patternReader produces n bytes without holding them in memory
+type patternReader struct {
+	n    int64
+	read atomic.Int64
+}
+
+func (r *patternReader) Read(p []byte) (int, error) {
+	left := r.n - r.read.Load()
+	if left <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > left {
+		p = p[:left]
+	}
+	for i := range p {
+		p[i] = byte(i)
+	}
+	r.read.Add(int64(len(p)))
+	return len(p), nil
+}
+
+// heapPeak samples the heap in use until stop is called and returns the
+// largest value seen
+func heapPeak() (stop func() uint64) {
+	var peak uint64
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		var ms runtime.MemStats
+		for {
+			runtime.ReadMemStats(&ms)
+			peak = max(peak, ms.HeapInuse)
+			select {
+			case <-done:
+				return
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+	}()
+	return func() uint64 {
+		close(done)
+		wg.Wait()
+		return peak
+	}
+}
+
+func TestClientPutStreamsFlat(t *testing.T) {
+	if testing.Short() {
+		t.Skip("uploads 1 GB")
+	}
+	const size = 1 << 30
+
+	var received atomic.Int64
+	var chunked atomic.Bool
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		chunked.Store(len(r.TransferEncoding) > 0)
+		n, _ := io.Copy(io.Discard, r.Body)
+		received.Store(n)
+		w.WriteHeader(StatusCreated)
+	}))
+	defer ts.Close()
+	c, err := NewClient(ts.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	for _, tc := range []struct {
+		name    string
+		opts    []RequestOption
+		chunked bool
+	}{
+		{"chunked", nil, true},
+		{"content-length", []RequestOption{ContentLength(size)}, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			runtime.GC()
+			var ms runtime.MemStats
+			runtime.ReadMemStats(&ms)
+			base := ms.HeapInuse
+
+			stop := heapPeak()
+			err := c.Put(context.Background(), "big", &patternReader{n: size}, tc.opts...)
+			peak := stop()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if received.Load() != size {
+				t.Errorf("server received %d bytes, want %d", received.Load(), size)
+			}
+			if chunked.Load() != tc.chunked {
+				t.Errorf("chunked = %v, want %v", chunked.Load(), tc.chunked)
+			}
+			if grew := int64(peak) - int64(base); grew > 32<<20 {
+				t.Errorf("heap grew by %d MB during a 1 GB upload", grew>>20)
+			}
+		})
+	}
+}
+
+func TestClientPutRejectedBeforeBody(t *testing.T) {
+	for _, tc := range []struct {
+		status int
+		want   error
+	}{
+		{StatusInsufficientStorage, ErrInsufficientStorage},
+		{StatusRequestTooLarge, ErrTooLarge},
+		{StatusLocked, ErrLocked},
+		{StatusUnauthorized, nil},
+	} {
+		t.Run(StatusText(tc.status), func(t *testing.T) {
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Header.Get("Expect") != "100-continue" {
+					t.Errorf("Expect = %q", r.Header.Get("Expect"))
+				}
+				// answer without reading the body, so no 100 Continue is sent
+				w.WriteHeader(tc.status)
+			}))
+			defer ts.Close()
+			c, err := NewClient(ts.URL, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+
+			src := &patternReader{n: 64 << 20}
+			err = c.Put(context.Background(), "file", src)
+			var se *StatusError
+			if !errors.As(err, &se) || se.Code != tc.status {
+				t.Fatalf("Put = %v, want status %d", err, tc.status)
+			}
+			if tc.want != nil && !errors.Is(err, tc.want) {
+				t.Errorf("Put = %v, want %v", err, tc.want)
+			}
+			if n := src.read.Load(); n > 0 {
+				t.Errorf("%d bytes of the source were consumed by a rejected PUT", n)
+			}
+		})
+	}
+}
+
+func TestClientPutDigestTrailer(t *testing.T) {
+	var trailer string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		trailer = r.Trailer.Get("Digest")
+		w.WriteHeader(StatusCreated)
+	}))
+	defer ts.Close()
+	c, err := NewClient(ts.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if err := c.Put(context.Background(), "file", strings.NewReader("hello"), DigestTrailer()); err != nil {
+		t.Fatal(err)
+	}
+	// sha-256 of "hello"
+	if want := "sha-256=LPJNul+wow4m6DsqxbninhsWHlwfp0JecwQzYpOLmCQ="; trailer != want {
+		t.Errorf("Digest trailer = %q, want %q", trailer, want)
+	}
+}
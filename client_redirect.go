@@ -0,0 +1,70 @@
+package webdav
+
+import (
+	"io"
+	"net/http"
+	"strings"
+)
+
+// maxRedirects is the number of redirects followed for one request
+const maxRedirects = 5
+
+// defaultRedirects are the methods that follow redirects unless
+// FollowRedirects says otherwise, those that don't change anything
+var defaultRedirects = []string{"GET", "HEAD", "OPTIONS", "PROPFIND"}
+
+// FollowRedirects sets the methods that follow redirects, by default GET,
+// HEAD, OPTIONS and PROPFIND. A redirected request is sent again with the
+// same method and body, so a method with a body that can't be sent twice
+// doesn't follow. No arguments disable redirects, a redirect is then
+// returned as a *StatusError.
+func FollowRedirects(methods ...string) ClientOption {
+	return func(cfg *clientConfig) {
+		cfg.redirects = append([]string{}, methods...)
+	}
+}
+
+// redirect returns the request that follows a redirect answer to req, or
+// nil if resp is to be returned. req is the request as made, without the
+// credentials send adds, so the next starts from the headers the client
+// set and nothing an AuthFunc set follows it. send authorizes it again if
+// it stays on the host of the base URL. The final request becomes
+// resp.Request, so the callers see the URL that answered.
+func (c *Client) redirect(req *http.Request, resp *http.Response) *http.Request {
+	switch resp.StatusCode {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+	case http.StatusSeeOther:
+		if req.Method != "GET" && req.Method != "HEAD" {
+			return nil
+		}
+	default:
+		return nil
+	}
+	if !c.redirects[strings.ToUpper(req.Method)] {
+		return nil
+	}
+	loc, err := resp.Location()
+	if err != nil || (loc.Scheme != "http" && loc.Scheme != "https") {
+		return nil
+	}
+
+	next := req.Clone(req.Context())
+	next.URL = loc
+	next.Host = ""
+	if req.Body != nil && req.Body != http.NoBody {
+		if req.GetBody == nil {
+			return nil
+		}
+		if next.Body, err = req.GetBody(); err != nil {
+			return nil
+		}
+	}
+	if loc.Scheme != c.base.Scheme || loc.Host != c.base.Host {
+		next.Header.Del("Authorization")
+		next.Header.Del("Cookie")
+	}
+
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+	return next
+}
@@ -0,0 +1,223 @@
+package webdav
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// hop is a request seen by redirectServer
+type hop struct {
+	method, path string
+	body         int
+}
+
+// redirectServer serves fsys below / and sends /old1/x through a 301, a
+// 302 and a 307 to /x. /loop redirects to itself.
+func redirectServer(t *testing.T, fsys FileSystem) (*httptest.Server, func() []hop) {
+	var mu sync.Mutex
+	var hops []hop
+	d := &davServer{Server: &Server{Fs: fsys, TrimPrefix: "/"}}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		r.Body = io.NopCloser(strings.NewReader(string(body)))
+		mu.Lock()
+		hops = append(hops, hop{r.Method, r.URL.Path, len(body)})
+		mu.Unlock()
+
+		p := r.URL.Path
+		switch {
+		case p == "/loop":
+			http.Redirect(w, r, "/loop", http.StatusFound)
+		case strings.HasPrefix(p, "/old1/"):
+			http.Redirect(w, r, "/old2/"+p[len("/old1/"):], http.StatusMovedPermanently)
+		case strings.HasPrefix(p, "/old2/"):
+			http.Redirect(w, r, "/old3/"+p[len("/old2/"):], http.StatusFound)
+		case strings.HasPrefix(p, "/old3/"):
+			http.Redirect(w, r, "/"+p[len("/old3/"):], http.StatusTemporaryRedirect)
+		default:
+			d.ServeHTTP(w, r)
+		}
+	}))
+	t.Cleanup(ts.Close)
+	return ts, func() []hop {
+		mu.Lock()
+		defer mu.Unlock()
+		h := hops
+		hops = nil
+		return h
+	}
+}
+
+func TestClientRedirectPropfind(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemFS()
+	m.Mkdir("/dir")
+	writeMem(t, m, "/dir/a", []byte("a"))
+	writeMem(t, m, "/dir/b", []byte("bb"))
+	ts, hops := redirectServer(t, m)
+	c, err := NewClient(ts.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	fi, err := c.Stat(ctx, "old1/dir")
+	if err != nil || !fi.IsDir() {
+		t.Fatalf("Stat through redirects = %v, %v", fi, err)
+	}
+	h := hops()
+	if len(h) != 4 {
+		t.Fatalf("%d requests, want 4: %v", len(h), h)
+	}
+	for _, r := range h {
+		if r.method != "PROPFIND" || r.body == 0 || r.body != h[0].body {
+			t.Errorf("redirected request %+v, want PROPFIND with the %d byte body", r, h[0].body)
+		}
+	}
+
+	// the listing is read relative to the URL that answered
+	fis, err := c.ReadDir(ctx, "old1/dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, fi := range fis {
+		names = append(names, fi.Name())
+	}
+	if strings.Join(names, ",") != "a,b" {
+		t.Errorf("ReadDir through redirects = %v", names)
+	}
+
+	if b, err := c.ReadFile(ctx, "old1/dir/b"); err != nil || string(b) != "bb" {
+		t.Errorf("GET through redirects = %q, %v", b, err)
+	}
+	hops()
+
+	_, err = c.Stat(ctx, "loop")
+	if err == nil || !strings.Contains(err.Error(), "redirects") {
+		t.Errorf("Stat of a redirect loop = %v", err)
+	}
+	if n := len(hops()); n != maxRedirects+1 {
+		t.Errorf("redirect loop: %d requests, want %d", n, maxRedirects+1)
+	}
+}
+
+func TestClientRedirectPut(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemFS()
+	ts, hops := redirectServer(t, m)
+
+	c, err := NewClient(ts.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	err = c.WriteFile(ctx, "old1/f", []byte("data"))
+	var se *StatusError
+	if !errors.As(err, &se) || se.Code != http.StatusMovedPermanently {
+		t.Errorf("PUT without following redirects = %v, want a 301 StatusError", err)
+	}
+	if h := hops(); len(h) != 1 {
+		t.Errorf("PUT followed a redirect by default: %v", h)
+	}
+	if _, ok := memFiles(t, m)["/f"]; ok {
+		t.Error("PUT stored the file though the redirect wasn't followed")
+	}
+
+	c, err = NewClient(ts.URL, nil, FollowRedirects("PUT", "PROPFIND"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if err := c.WriteFile(ctx, "old1/f", []byte("data")); err != nil {
+		t.Fatal(err)
+	}
+	h := hops()
+	if len(h) != 4 {
+		t.Fatalf("%d requests, want 4: %v", len(h), h)
+	}
+	for _, r := range h {
+		if r.method != "PUT" || r.body != 4 {
+			t.Errorf("redirected request %+v, want PUT with the 4 byte body", r)
+		}
+	}
+	if got := string(memFiles(t, m)["/f"].data); got != "data" {
+		t.Errorf("file = %q after a redirected PUT", got)
+	}
+
+	// a body that can't be sent again isn't redirected
+	err = c.Put(ctx, "old1/g", struct{ io.Reader }{strings.NewReader("once")})
+	if !errors.As(err, &se) || se.Code != http.StatusMovedPermanently {
+		t.Errorf("PUT of a one-shot body = %v, want a 301 StatusError", err)
+	}
+	hops()
+
+	// no methods disables redirects
+	c, err = NewClient(ts.URL, nil, FollowRedirects())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if _, err := c.Stat(ctx, "old1/f"); !errors.As(err, &se) || se.Code != http.StatusMovedPermanently {
+		t.Errorf("Stat with redirects disabled = %v", err)
+	}
+}
+
+// TestClientRedirectAuth checks a redirect on the server's host is sent
+// with the credentials of an AuthFunc and one to another host without them
+func TestClientRedirectAuth(t *testing.T) {
+	var mu sync.Mutex
+	var seen []http.Header
+	record := func(r *http.Request) {
+		mu.Lock()
+		seen = append(seen, r.Header.Clone())
+		mu.Unlock()
+	}
+	elsewhere := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		record(r)
+		io.WriteString(w, "elsewhere")
+	}))
+	defer elsewhere.Close()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		record(r)
+		if r.URL.Path == "/a" {
+			http.Redirect(w, r, "/b", http.StatusFound)
+			return
+		}
+		http.Redirect(w, r, elsewhere.URL+"/c", http.StatusTemporaryRedirect)
+	}))
+	defer ts.Close()
+
+	c, err := NewClient(ts.URL, nil, AuthFunc(func(req *http.Request) error {
+		req.Header.Set("Authorization", "Bearer token")
+		req.Header.Set("X-Api-Key", "secret")
+		return nil
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	b, err := c.ReadFile(context.Background(), "a")
+	if err != nil || string(b) != "elsewhere" {
+		t.Fatalf("ReadFile = %q, %v", b, err)
+	}
+	if len(seen) != 3 {
+		t.Fatalf("%d requests, want 3", len(seen))
+	}
+	for i, h := range seen[:2] {
+		if h.Get("Authorization") != "Bearer token" || h.Get("X-Api-Key") != "secret" {
+			t.Errorf("request %d to the server without credentials: %v", i, h)
+		}
+	}
+	for _, k := range []string{"Authorization", "X-Api-Key"} {
+		if v := seen[2].Get(k); v != "" {
+			t.Errorf("%s: %s sent to the redirect target", k, v)
+		}
+	}
+}
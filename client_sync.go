@@ -0,0 +1,345 @@
+package webdav
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// A SyncResolution decides a conflict found by Sync
+type SyncResolution int
+
+const (
+	// SyncSkip leaves both sides alone, the conflict is found again by the
+	// next Sync
+	SyncSkip SyncResolution = iota
+
+	// SyncKeepLocal replaces or deletes the remote file
+	SyncKeepLocal
+
+	// SyncKeepRemote replaces or deletes the local file
+	SyncKeepRemote
+)
+
+// A SyncConflict is a file changed on both sides since the last Sync. Local
+// or Remote is nil if the file was deleted on that side.
+type SyncConflict struct {
+	Name   string
+	Local  os.FileInfo
+	Remote os.FileInfo
+}
+
+// SyncOptions configures Sync
+type SyncOptions struct {
+	// number of files transferred concurrently
+	Parallel int
+
+	// decides the conflicts, nil skips all of them
+	Conflict func(c *SyncConflict) SyncResolution
+}
+
+// SyncResult lists what Sync did by slash separated relative name
+type SyncResult struct {
+	Uploaded      []string
+	Downloaded    []string
+	DeletedLocal  []string
+	DeletedRemote []string
+	Conflicts     []string // skipped conflicts
+}
+
+// Sync brings localDir and the remote tree below remoteRoot in line with
+// each other. The state file records every file as of the last Sync, so
+// the changes on both sides since then can be told apart: a file changed
+// on one side is copied to the other, a file deleted on one side is
+// deleted on the other, and a file changed on both sides is a conflict
+// for opts.Conflict. Deletions are only propagated for files recorded in
+// the state file. Remote changes are detected by ETag, or by size and
+// modification time if the server sends no ETags.
+func (c *Client) Sync(ctx context.Context, localDir, remoteRoot, stateFile string, opts *SyncOptions) (*SyncResult, error) {
+	var o SyncOptions
+	if opts != nil {
+		o = *opts
+	}
+	if o.Parallel <= 0 {
+		o.Parallel = DefaultTreeParallel
+	}
+	if stateFile == "" {
+		return nil, errors.New("webdav: Sync needs a state file")
+	}
+
+	st, err := loadTreeState(stateFile)
+	if err != nil {
+		return nil, err
+	}
+	root := path.Clean("/" + remoteRoot)
+	remote, err := c.remoteTree(ctx, root)
+	if err != nil {
+		return nil, err
+	}
+	if remote == nil {
+		if err := c.mkcolAll(ctx, root); err != nil {
+			return nil, err
+		}
+	}
+	local, err := localTree(localDir, stateFile)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make(map[string]bool)
+	for name := range local {
+		names[name] = true
+	}
+	for name, fi := range remote {
+		if !fi.IsDir() {
+			names[name] = true
+		}
+	}
+	for name := range st.Files {
+		names[name] = true
+	}
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+
+	ctx, agg := c.trackTree(ctx, root)
+	defer agg.finish()
+	s := &syncer{c: c, root: root, localDir: localDir, st: st, res: &SyncResult{}, agg: agg}
+	t := newTreeRun(ctx, "sync", false, o.Parallel)
+	for _, name := range sorted {
+		lfi := local[name]
+		rfi := remote[name]
+		if rfi != nil && rfi.IsDir() {
+			rfi = nil
+		}
+		s.decide(t, &o, name, lfi, rfi, st.get(name))
+	}
+	err = t.wait(nil)
+
+	for _, list := range [][]string{s.res.Uploaded, s.res.Downloaded, s.res.DeletedLocal, s.res.DeletedRemote, s.res.Conflicts} {
+		sort.Strings(list)
+	}
+	if serr := st.save(stateFile); err == nil {
+		err = serr
+	}
+	return s.res, err
+}
+
+// localTree lists the regular files below dir by slash separated relative
+// name, leaving out temporary files and the state file
+func localTree(dir, stateFile string) (map[string]os.FileInfo, error) {
+	abs, _ := filepath.Abs(stateFile)
+	tree := make(map[string]os.FileInfo)
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() || strings.HasPrefix(d.Name(), TempPrefix) {
+			return nil
+		}
+		if a, _ := filepath.Abs(p); a == abs {
+			return nil
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, p)
+		tree[filepath.ToSlash(rel)] = fi
+		return nil
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		return tree, os.MkdirAll(dir, 0755)
+	}
+	return tree, err
+}
+
+type syncer struct {
+	c        *Client
+	root     string
+	localDir string
+	st       *treeState
+	res      *SyncResult
+	agg      *tracker
+}
+
+// decide compares both sides of name with the state and starts the action
+func (s *syncer) decide(t *treeRun, o *SyncOptions, name string, lfi os.FileInfo, rfi *RemoteFileInfo, prev *treeEntry) {
+	if prev == nil {
+		switch {
+		case lfi != nil && rfi != nil:
+			s.conflict(t, o, name, lfi, rfi, prev)
+		case lfi != nil:
+			s.upload(t, name, lfi, ifUnchanged(nil))
+		case rfi != nil:
+			s.download(t, name, rfi)
+		}
+		return
+	}
+
+	localChanged := lfi == nil || lfi.Size() != prev.Size || !lfi.ModTime().Equal(prev.ModTime)
+	remoteChanged := rfi == nil || remoteModified(rfi, prev)
+
+	switch {
+	case lfi == nil && rfi == nil:
+		// deleted on both sides
+	case !localChanged && !remoteChanged:
+		s.st.set(name, *prev)
+	case !remoteChanged:
+		if lfi == nil {
+			s.deleteRemote(t, name, ifUnchanged(rfi))
+		} else {
+			s.upload(t, name, lfi, ifUnchanged(rfi))
+		}
+	case !localChanged:
+		if rfi == nil {
+			s.deleteLocal(t, name)
+		} else {
+			s.download(t, name, rfi)
+		}
+	default:
+		s.conflict(t, o, name, lfi, rfi, prev)
+	}
+}
+
+// ifUnchanged makes a request conditional on the remote file still being
+// as seen while listing, or still missing if rfi is nil
+func ifUnchanged(rfi *RemoteFileInfo) []RequestOption {
+	switch {
+	case rfi == nil:
+		return []RequestOption{IfNoneMatchAny()}
+	case rfi.ETag() != "":
+		return []RequestOption{IfMatch(rfi.ETag())}
+	}
+	return nil
+}
+
+// remoteModified reports whether the remote file differs from the state
+func remoteModified(rfi *RemoteFileInfo, prev *treeEntry) bool {
+	if rfi.ETag() != "" && prev.ETag != "" {
+		return rfi.ETag() != prev.ETag
+	}
+	return rfi.Size() != prev.RemoteSize || !rfi.ModTime().Equal(prev.RemoteModTime)
+}
+
+func (s *syncer) conflict(t *treeRun, o *SyncOptions, name string, lfi os.FileInfo, rfi *RemoteFileInfo, prev *treeEntry) {
+	res := SyncSkip
+	if o.Conflict != nil {
+		c := &SyncConflict{Name: name, Local: lfi}
+		if rfi != nil {
+			c.Remote = rfi
+		}
+		res = o.Conflict(c)
+	}
+
+	switch {
+	case res == SyncKeepLocal && lfi != nil:
+		s.upload(t, name, lfi, nil)
+	case res == SyncKeepLocal:
+		s.deleteRemote(t, name, nil)
+	case res == SyncKeepRemote && rfi != nil:
+		s.download(t, name, rfi)
+	case res == SyncKeepRemote:
+		s.deleteLocal(t, name)
+	default:
+		if prev != nil {
+			s.st.set(name, *prev)
+		}
+		s.record(&s.res.Conflicts, name)
+	}
+}
+
+func (s *syncer) record(list *[]string, name string) {
+	s.st.mu.Lock()
+	*list = append(*list, name)
+	s.st.mu.Unlock()
+}
+
+// upload puts the local file, cond are the conditions from ifUnchanged
+func (s *syncer) upload(t *treeRun, name string, lfi os.FileInfo, cond []RequestOption) {
+	s.agg.grow(lfi.Size())
+	t.run(func(ctx context.Context) {
+		remote := path.Join(s.root, name)
+		if dir := path.Dir(remote); dir != s.root {
+			if err := s.c.mkcolAll(ctx, dir); err != nil {
+				t.fail(name, err)
+				return
+			}
+		}
+
+		f, err := os.Open(filepath.Join(s.localDir, filepath.FromSlash(name)))
+		if err != nil {
+			t.fail(name, err)
+			return
+		}
+		defer f.Close()
+
+		opts := append([]RequestOption{ContentLength(lfi.Size())}, cond...)
+		if err := s.c.Put(ctx, remote, f, opts...); err != nil {
+			t.fail(name, err)
+			return
+		}
+
+		fi, err := s.c.Stat(ctx, remote)
+		if err != nil {
+			t.fail(name, err)
+			return
+		}
+		s.setBoth(name, lfi, fi.(*RemoteFileInfo))
+		s.record(&s.res.Uploaded, name)
+	})
+}
+
+func (s *syncer) download(t *treeRun, name string, rfi *RemoteFileInfo) {
+	s.agg.grow(rfi.Size())
+	t.run(func(ctx context.Context) {
+		local := filepath.Join(s.localDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(local), 0755); err != nil {
+			t.fail(name, err)
+			return
+		}
+		lfi, err := s.c.mirrorFile(ctx, path.Join(s.root, name), local, rfi, nil)
+		if err != nil {
+			t.fail(name, err)
+			return
+		}
+		s.setBoth(name, lfi, rfi)
+		s.record(&s.res.Downloaded, name)
+	})
+}
+
+// deleteRemote deletes the remote file, cond are the conditions from
+// ifUnchanged
+func (s *syncer) deleteRemote(t *treeRun, name string, cond []RequestOption) {
+	if err := s.c.Delete(t.ctx, path.Join(s.root, name), cond...); err != nil && !errors.Is(err, ErrNotFound) {
+		t.fail(name, err)
+		return
+	}
+	s.record(&s.res.DeletedRemote, name)
+}
+
+func (s *syncer) deleteLocal(t *treeRun, name string) {
+	err := os.Remove(filepath.Join(s.localDir, filepath.FromSlash(name)))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		t.fail(name, err)
+		return
+	}
+	s.record(&s.res.DeletedLocal, name)
+}
+
+func (s *syncer) setBoth(name string, lfi os.FileInfo, rfi *RemoteFileInfo) {
+	s.st.set(name, treeEntry{
+		ETag:          rfi.ETag(),
+		Size:          lfi.Size(),
+		ModTime:       lfi.ModTime(),
+		RemoteSize:    rfi.Size(),
+		RemoteModTime: rfi.ModTime(),
+	})
+}
@@ -0,0 +1,283 @@
+package webdav
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// syncFixture is a local directory and a MemFS-backed server synced once
+type syncFixture struct {
+	t     *testing.T
+	m     *MemFS
+	c     *Client
+	local string
+	state string
+}
+
+func newSyncFixture(t *testing.T) *syncFixture {
+	f := &syncFixture{t: t, m: NewMemFS(), local: t.TempDir()}
+	f.state = filepath.Join(t.TempDir(), "state.json")
+	_, _, f.c = newDAVServer(t, f.m)
+	for _, name := range []string{"a.txt", "b.txt", "c.txt"} {
+		writeMem(t, f.m, "/"+name, []byte("remote "+name))
+	}
+	writeLocal(t, f.local, map[string]string{"sub/d.txt": "local d"})
+
+	res := f.sync(nil)
+	if want := []string{"a.txt", "b.txt", "c.txt"}; !reflect.DeepEqual(res.Downloaded, want) {
+		t.Fatalf("first Sync downloaded %v, want %v", res.Downloaded, want)
+	}
+	if want := []string{"sub/d.txt"}; !reflect.DeepEqual(res.Uploaded, want) {
+		t.Fatalf("first Sync uploaded %v, want %v", res.Uploaded, want)
+	}
+	return f
+}
+
+func (f *syncFixture) sync(conflict func(*SyncConflict) SyncResolution) *SyncResult {
+	f.t.Helper()
+	res, err := f.c.Sync(context.Background(), f.local, "/", f.state, &SyncOptions{Conflict: conflict})
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	return res
+}
+
+// writeLocalFile changes a local file, the modification time is moved so
+// the change shows with coarse file system clocks too
+func (f *syncFixture) writeLocalFile(name, data string) {
+	f.t.Helper()
+	writeLocal(f.t, f.local, map[string]string{name: data})
+	mt := time.Now().Add(time.Minute)
+	if err := os.Chtimes(filepath.Join(f.local, filepath.FromSlash(name)), mt, mt); err != nil {
+		f.t.Fatal(err)
+	}
+}
+
+func (f *syncFixture) writeRemote(name, data string) {
+	f.t.Helper()
+	writeMem(f.t, f.m, "/"+name, []byte(data))
+	mt := time.Now().Add(time.Minute)
+	if err := f.m.Chtimes("/"+name, mt, mt); err != nil {
+		f.t.Fatal(err)
+	}
+}
+
+func (f *syncFixture) removeLocal(name string) {
+	f.t.Helper()
+	if err := os.Remove(filepath.Join(f.local, filepath.FromSlash(name))); err != nil {
+		f.t.Fatal(err)
+	}
+}
+
+func (f *syncFixture) removeRemote(name string) {
+	f.t.Helper()
+	if err := f.m.Remove("/" + name); err != nil {
+		f.t.Fatal(err)
+	}
+}
+
+// both returns the local and remote content of name, "-" for a missing
+// file
+func (f *syncFixture) both(name string) (local, remote string) {
+	f.t.Helper()
+	local, remote = "-", "-"
+	if b, err := os.ReadFile(filepath.Join(f.local, filepath.FromSlash(name))); err == nil {
+		local = string(b)
+	}
+	if n, ok := memFiles(f.t, f.m)["/"+name]; ok {
+		remote = string(n.data)
+	}
+	return local, remote
+}
+
+// summary is a SyncResult as "list:name" entries
+func summary(res *SyncResult) string {
+	var s []string
+	for _, l := range []struct {
+		key   string
+		names []string
+	}{
+		{"up", res.Uploaded},
+		{"down", res.Downloaded},
+		{"dellocal", res.DeletedLocal},
+		{"delremote", res.DeletedRemote},
+		{"conflict", res.Conflicts},
+	} {
+		for _, name := range l.names {
+			s = append(s, l.key+":"+name)
+		}
+	}
+	return strings.Join(s, " ")
+}
+
+func TestClientSync(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		change     func(f *syncFixture)
+		resolve    SyncResolution
+		want       string
+		file       string
+		local      string
+		remote     string
+		conflicted *SyncConflict // names the sides the conflict had
+	}{
+		{
+			name:   "local add",
+			change: func(f *syncFixture) { f.writeLocalFile("new/e.txt", "local e") },
+			want:   "up:new/e.txt", file: "new/e.txt", local: "local e", remote: "local e",
+		},
+		{
+			name:   "remote add",
+			change: func(f *syncFixture) { f.writeRemote("e.txt", "remote e") },
+			want:   "down:e.txt", file: "e.txt", local: "remote e", remote: "remote e",
+		},
+		{
+			name:   "local modify",
+			change: func(f *syncFixture) { f.writeLocalFile("a.txt", "local change") },
+			want:   "up:a.txt", file: "a.txt", local: "local change", remote: "local change",
+		},
+		{
+			name:   "remote modify",
+			change: func(f *syncFixture) { f.writeRemote("sub/d.txt", "remote change") },
+			want:   "down:sub/d.txt", file: "sub/d.txt", local: "remote change", remote: "remote change",
+		},
+		{
+			name:   "local delete",
+			change: func(f *syncFixture) { f.removeLocal("b.txt") },
+			want:   "delremote:b.txt", file: "b.txt", local: "-", remote: "-",
+		},
+		{
+			name:   "remote delete",
+			change: func(f *syncFixture) { f.removeRemote("b.txt") },
+			want:   "dellocal:b.txt", file: "b.txt", local: "-", remote: "-",
+		},
+		{
+			name:   "both deleted",
+			change: func(f *syncFixture) { f.removeLocal("c.txt"); f.removeRemote("c.txt") },
+			want:   "", file: "c.txt", local: "-", remote: "-",
+		},
+		{
+			name: "both modified, skipped",
+			change: func(f *syncFixture) {
+				f.writeLocalFile("a.txt", "local change")
+				f.writeRemote("a.txt", "remote change")
+			},
+			want: "conflict:a.txt", file: "a.txt", local: "local change", remote: "remote change",
+			conflicted: &SyncConflict{Name: "a.txt", Local: dummyInfo{}, Remote: dummyInfo{}},
+		},
+		{
+			name: "both modified, keep local",
+			change: func(f *syncFixture) {
+				f.writeLocalFile("a.txt", "local change")
+				f.writeRemote("a.txt", "remote change")
+			},
+			resolve: SyncKeepLocal,
+			want:    "up:a.txt", file: "a.txt", local: "local change", remote: "local change",
+		},
+		{
+			name: "both modified, keep remote",
+			change: func(f *syncFixture) {
+				f.writeLocalFile("a.txt", "local change")
+				f.writeRemote("a.txt", "remote change")
+			},
+			resolve: SyncKeepRemote,
+			want:    "down:a.txt", file: "a.txt", local: "remote change", remote: "remote change",
+		},
+		{
+			name: "local modify, remote delete",
+			change: func(f *syncFixture) {
+				f.writeLocalFile("a.txt", "local change")
+				f.removeRemote("a.txt")
+			},
+			want: "conflict:a.txt", file: "a.txt", local: "local change", remote: "-",
+			conflicted: &SyncConflict{Name: "a.txt", Local: dummyInfo{}},
+		},
+		{
+			name: "local delete, remote modify, keep remote",
+			change: func(f *syncFixture) {
+				f.removeLocal("a.txt")
+				f.writeRemote("a.txt", "remote change")
+			},
+			resolve: SyncKeepRemote,
+			want:    "down:a.txt", file: "a.txt", local: "remote change", remote: "remote change",
+		},
+		{
+			name: "local delete, remote modify, keep local",
+			change: func(f *syncFixture) {
+				f.removeLocal("a.txt")
+				f.writeRemote("a.txt", "remote change")
+			},
+			resolve: SyncKeepLocal,
+			want:    "delremote:a.txt", file: "a.txt", local: "-", remote: "-",
+		},
+		{
+			name: "added on both sides",
+			change: func(f *syncFixture) {
+				f.writeLocalFile("e.txt", "local e")
+				f.writeRemote("e.txt", "remote e")
+			},
+			want: "conflict:e.txt", file: "e.txt", local: "local e", remote: "remote e",
+			conflicted: &SyncConflict{Name: "e.txt", Local: dummyInfo{}, Remote: dummyInfo{}},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			f := newSyncFixture(t)
+			tc.change(f)
+
+			var seen []*SyncConflict
+			res := f.sync(func(c *SyncConflict) SyncResolution {
+				seen = append(seen, c)
+				return tc.resolve
+			})
+			if got := summary(res); got != tc.want {
+				t.Errorf("Sync did %q, want %q", got, tc.want)
+			}
+			if local, remote := f.both(tc.file); local != tc.local || remote != tc.remote {
+				t.Errorf("%s is %q locally and %q remotely, want %q and %q", tc.file, local, remote, tc.local, tc.remote)
+			}
+			if c := tc.conflicted; c != nil {
+				if len(seen) != 1 || seen[0].Name != c.Name || (seen[0].Local == nil) != (c.Local == nil) || (seen[0].Remote == nil) != (c.Remote == nil) {
+					t.Errorf("conflicts %+v, want one like %+v", seen, c)
+				}
+			}
+
+			// the next Sync finds only the skipped conflict again
+			want := ""
+			if tc.resolve == SyncSkip && strings.HasPrefix(tc.want, "conflict:") {
+				want = tc.want
+			}
+			if got := summary(f.sync(nil)); got != want {
+				t.Errorf("second Sync did %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestClientSyncNoDeleteWithoutState(t *testing.T) {
+	f := newSyncFixture(t)
+	// losing the state file turns every file into an addition on both
+	// sides, nothing may be deleted
+	if err := os.Remove(f.state); err != nil {
+		t.Fatal(err)
+	}
+	f.removeLocal("a.txt")
+	f.removeRemote("b.txt")
+
+	res := f.sync(nil)
+	if got, want := summary(res), "up:b.txt down:a.txt conflict:c.txt conflict:sub/d.txt"; got != want {
+		t.Errorf("Sync without state did %q, want %q", got, want)
+	}
+	for _, name := range []string{"a.txt", "b.txt"} {
+		if local, remote := f.both(name); local == "-" || remote == "-" {
+			t.Errorf("%s deleted without a state entry", name)
+		}
+	}
+}
+
+// dummyInfo marks a side of an expected conflict as present
+type dummyInfo struct{ os.FileInfo }
@@ -0,0 +1,266 @@
+package webdav
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// davServer adds to a Server what the Client needs and Server lacks:
+// PROPFIND of depth 0 and 1, MKCOL, MOVE, and PROPPATCH of DAV:lastmodified.
+// It lets client tests run against a Server over any FileSystem.
+type davServer struct {
+	*Server
+	quota func() (used, available int64) // reported by PROPFIND if set
+}
+
+func (d *davServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := path.Clean(r.URL.Path)
+	switch r.Method {
+	case "PROPFIND":
+		d.propfind(w, r, name)
+	case "MKCOL":
+		if _, err := d.stat(name); err == nil {
+			w.WriteHeader(StatusMethodNotAllowed)
+		} else if fi, err := d.stat(path.Dir(name)); err != nil || !fi.IsDir() {
+			w.WriteHeader(StatusConflict)
+		} else if err := d.Fs.Mkdir(name); err != nil {
+			w.WriteHeader(StatusInternalServerError)
+		} else {
+			w.WriteHeader(StatusCreated)
+		}
+	case "MOVE":
+		d.move(w, r, name)
+	case "PROPPATCH":
+		d.proppatch(w, r, name)
+	default:
+		d.Server.ServeHTTP(w, r)
+	}
+}
+
+func (d *davServer) propfind(w http.ResponseWriter, r *http.Request, name string) {
+	fi, err := d.stat(name)
+	if err != nil {
+		w.WriteHeader(StatusNotFound)
+		return
+	}
+	type member struct {
+		name string
+		fi   os.FileInfo
+	}
+	members := []member{{name, fi}}
+	if fi.IsDir() && r.Header.Get("Depth") != "0" {
+		f, err := d.Fs.Open(name)
+		if err != nil {
+			w.WriteHeader(StatusInternalServerError)
+			return
+		}
+		fis, err := f.Readdir(0)
+		f.Close()
+		if err != nil {
+			w.WriteHeader(StatusInternalServerError)
+			return
+		}
+		for _, child := range fis {
+			members = append(members, member{path.Join(name, child.Name()), child})
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(StatusMulti)
+	fmt.Fprint(w, `<?xml version="1.0" encoding="utf-8"?>`+"\n"+`<D:multistatus xmlns:D="DAV:">`)
+	for _, m := range members {
+		href := (&url.URL{Path: m.name}).EscapedPath()
+		if m.fi.IsDir() && href != "/" {
+			href += "/"
+		}
+		fmt.Fprint(w, "<D:response><D:href>")
+		xml.EscapeText(w, []byte(href))
+		fmt.Fprint(w, "</D:href><D:propstat><D:prop>")
+		if m.fi.IsDir() {
+			fmt.Fprint(w, "<D:resourcetype><D:collection/></D:resourcetype>")
+		} else {
+			fmt.Fprintf(w, "<D:resourcetype/><D:getcontentlength>%d</D:getcontentlength>", m.fi.Size())
+			if et, ok := d.Fs.(ETagger); ok {
+				if tag, err := et.ETag(m.name); err == nil {
+					fmt.Fprintf(w, `<D:getetag>"%s"</D:getetag>`, tag)
+				}
+			}
+		}
+		fmt.Fprintf(w, "<D:getlastmodified>%s</D:getlastmodified>", m.fi.ModTime().UTC().Format(http.TimeFormat))
+		if d.quota != nil && m.fi.IsDir() {
+			used, available := d.quota()
+			fmt.Fprintf(w, "<D:quota-used-bytes>%d</D:quota-used-bytes><D:quota-available-bytes>%d</D:quota-available-bytes>", used, available)
+		}
+		fmt.Fprint(w, "</D:prop><D:status>HTTP/1.1 200 OK</D:status></D:propstat></D:response>")
+	}
+	fmt.Fprint(w, "</D:multistatus>")
+}
+
+func (d *davServer) move(w http.ResponseWriter, r *http.Request, name string) {
+	dest, err := url.Parse(r.Header.Get("Destination"))
+	rn, ok := d.Fs.(Renamer)
+	if err != nil || !ok {
+		w.WriteHeader(StatusBadRequest)
+		return
+	}
+	to := path.Clean(dest.Path)
+	status := StatusCreated
+	if _, err := d.stat(to); err == nil {
+		if r.Header.Get("Overwrite") == "F" {
+			w.WriteHeader(StatusPreconditionFailed)
+			return
+		}
+		status = StatusNoContent
+	}
+	if err := rn.Rename(name, to); err != nil {
+		w.WriteHeader(StatusConflict)
+		return
+	}
+	w.WriteHeader(status)
+}
+
+func (d *davServer) proppatch(w http.ResponseWriter, r *http.Request, name string) {
+	var update struct {
+		Set []struct {
+			Prop xmlNode `xml:"DAV: prop"`
+		} `xml:"DAV: set"`
+	}
+	if err := xml.NewDecoder(r.Body).Decode(&update); err != nil {
+		w.WriteHeader(StatusBadRequest)
+		return
+	}
+	c, ok := d.Fs.(Chtimer)
+	status := StatusForbidden
+	for _, set := range update.Set {
+		for _, p := range set.Prop.Children {
+			secs, err := strconv.ParseInt(strings.TrimSpace(p.Text), 10, 64)
+			if p.XMLName != davName("lastmodified") || err != nil || !ok {
+				continue
+			}
+			if c.Chtimes(name, time.Unix(secs, 0), time.Unix(secs, 0)) == nil {
+				status = StatusOK
+			}
+		}
+	}
+	w.WriteHeader(StatusMulti)
+	fmt.Fprintf(w, `<D:multistatus xmlns:D="DAV:"><D:response><D:href>%s</D:href>`+
+		`<D:propstat><D:prop><D:lastmodified/></D:prop><D:status>HTTP/1.1 %d %s</D:status></D:propstat>`+
+		`</D:response></D:multistatus>`, name, status, StatusText(status))
+}
+
+// newDAVServer serves fsys for client tests and returns a Client for it
+func newDAVServer(t testing.TB, fsys FileSystem, opts ...ClientOption) (*davServer, *httptest.Server, *Client) {
+	t.Helper()
+	d := &davServer{Server: &Server{Fs: fsys, TrimPrefix: "/"}}
+	ts := httptest.NewServer(d)
+	t.Cleanup(ts.Close)
+	c, err := NewClient(ts.URL, nil, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return d, ts, c
+}
+
+func TestClientCoreOperations(t *testing.T) {
+	ctx := context.Background()
+	_, _, c := newDAVServer(t, NewMemFS())
+
+	if err := c.Mkcol(ctx, "dir"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.WriteFile(ctx, "dir/a.txt", []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := c.ReadFile(ctx, "dir/a.txt"); err != nil || string(b) != "hello" {
+		t.Fatalf("ReadFile = %q, %v", b, err)
+	}
+	if err := c.Put(ctx, "dir/a.txt", strings.NewReader("replaced")); err != nil {
+		t.Fatal(err)
+	}
+
+	fi, err := c.Stat(ctx, "dir/a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Name() != "a.txt" || fi.Size() != int64(len("replaced")) || fi.IsDir() {
+		t.Errorf("Stat = %s %d %v", fi.Name(), fi.Size(), fi.IsDir())
+	}
+	if fi, err := c.Stat(ctx, "dir"); err != nil || !fi.IsDir() {
+		t.Errorf("Stat of a collection = %v, %v", fi, err)
+	}
+
+	if err := c.Copy(ctx, "dir/a.txt", "dir/b.txt", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Copy(ctx, "dir/a.txt", "dir/b.txt", false); !errors.Is(err, ErrPreconditionFailed) {
+		t.Errorf("Copy without overwrite onto an existing file = %v", err)
+	}
+	fis, err := c.ReadDir(ctx, "dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, fi := range fis {
+		names = append(names, fi.Name())
+	}
+	if strings.Join(names, ",") != "a.txt,b.txt" {
+		t.Errorf("ReadDir = %v", names)
+	}
+
+	if err := c.Delete(ctx, "dir/a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.ReadFile(ctx, "dir/a.txt"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("ReadFile after Delete = %v, want ErrNotFound", err)
+	}
+	var serr *StatusError
+	if _, err := c.Stat(ctx, "missing"); !errors.As(err, &serr) || serr.Code != StatusNotFound {
+		t.Errorf("Stat of a missing file = %v", err)
+	}
+}
+
+func TestClientBasePath(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemFS()
+	ts := newTestServer(t, &Server{Fs: m, TrimPrefix: "/dav/"})
+	c, err := NewClient(ts.URL+"/dav/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if err := c.WriteFile(ctx, "with space & percent%.txt", []byte("x")); err != nil {
+		t.Fatal(err)
+	}
+	f, err := m.Open("/with space & percent%.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	if b, err := c.ReadFile(ctx, "/with space & percent%.txt"); err != nil || string(b) != "x" {
+		t.Errorf("ReadFile = %q, %v", b, err)
+	}
+}
+
+func TestClientClosed(t *testing.T) {
+	_, _, c := newDAVServer(t, NewMemFS())
+	c.Close()
+	if _, err := c.ReadFile(context.Background(), "a"); err != ErrClientClosed {
+		t.Errorf("ReadFile after Close = %v, want ErrClientClosed", err)
+	}
+	if err := c.WriteFile(context.Background(), "a", nil); err != ErrClientClosed {
+		t.Errorf("WriteFile after Close = %v, want ErrClientClosed", err)
+	}
+}
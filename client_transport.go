@@ -0,0 +1,172 @@
+package webdav
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// defaults of the *http.Client built by NewClient. Like
+// http.DefaultTransport it takes its proxy from the HTTP_PROXY, HTTPS_PROXY
+// and NO_PROXY environment variables and verifies server certificates.
+const (
+	DefaultClientMaxConns    = 8
+	DefaultClientIdleTimeout = 90 * time.Second
+)
+
+func newHTTPClient(cfg *clientConfig) (*http.Client, error) {
+	rt := cfg.transport
+	if rt == nil {
+		t := http.DefaultTransport.(*http.Transport).Clone()
+		t.MaxConnsPerHost = DefaultClientMaxConns
+		t.MaxIdleConnsPerHost = DefaultClientMaxConns
+		t.IdleConnTimeout = DefaultClientIdleTimeout
+		rt = t
+	} else if t, ok := rt.(*http.Transport); ok {
+		// options must not change the caller's transport
+		rt = t.Clone()
+	}
+
+	hc := &http.Client{Transport: rt}
+	for _, fn := range cfg.custom {
+		if err := fn(hc); err != nil {
+			return nil, err
+		}
+	}
+	return hc, nil
+}
+
+// withTransport adds an option that needs an *http.Transport to change
+func withTransport(cfg *clientConfig, name string, fn func(t *http.Transport)) {
+	cfg.custom = append(cfg.custom, func(hc *http.Client) error {
+		t, ok := hc.Transport.(*http.Transport)
+		if !ok {
+			return fmt.Errorf("webdav: %s needs an *http.Transport, not %T", name, hc.Transport)
+		}
+		fn(t)
+		return nil
+	})
+}
+
+// withTLS adds an option that changes the TLS configuration
+func withTLS(cfg *clientConfig, name string, fn func(tc *tls.Config)) {
+	withTransport(cfg, name, func(t *http.Transport) {
+		if t.TLSClientConfig == nil {
+			t.TLSClientConfig = &tls.Config{}
+		}
+		fn(t.TLSClientConfig)
+	})
+}
+
+// Transport sends the requests with rt instead of a transport built by
+// NewClient. The TLS and pool options only work with an *http.Transport,
+// which is copied before they change it.
+func Transport(rt http.RoundTripper) ClientOption {
+	return func(cfg *clientConfig) {
+		if rt == nil {
+			cfg.err = errors.New("webdav: nil transport")
+			return
+		}
+		cfg.transport = rt
+	}
+}
+
+// MaxConns limits the connections to the server, idle or in use
+func MaxConns(n int) ClientOption {
+	return func(cfg *clientConfig) {
+		withTransport(cfg, "MaxConns", func(t *http.Transport) {
+			t.MaxConnsPerHost = n
+			t.MaxIdleConnsPerHost = n
+		})
+	}
+}
+
+// IdleTimeout sets how long an unused connection is kept open
+func IdleTimeout(d time.Duration) ClientOption {
+	return func(cfg *clientConfig) {
+		withTransport(cfg, "IdleTimeout", func(t *http.Transport) {
+			t.IdleConnTimeout = d
+		})
+	}
+}
+
+// Timeout limits every request including the transfer of its body, zero
+// means no limit. Long transfers are better bounded with a context.
+func Timeout(d time.Duration) ClientOption {
+	return func(cfg *clientConfig) {
+		cfg.custom = append(cfg.custom, func(hc *http.Client) error {
+			hc.Timeout = d
+			return nil
+		})
+	}
+}
+
+// Proxy sends every request through the proxy at proxyURL instead of the
+// one from the environment
+func Proxy(proxyURL string) ClientOption {
+	return func(cfg *clientConfig) {
+		u, err := url.Parse(proxyURL)
+		if err != nil {
+			cfg.err = fmt.Errorf("webdav: bad proxy URL: %v", err)
+			return
+		}
+		withTransport(cfg, "Proxy", func(t *http.Transport) {
+			t.Proxy = http.ProxyURL(u)
+		})
+	}
+}
+
+// TLSConfig replaces the TLS configuration, later TLS options adjust a copy
+// of it
+func TLSConfig(tc *tls.Config) ClientOption {
+	return func(cfg *clientConfig) {
+		if tc == nil {
+			cfg.err = errors.New("webdav: nil TLS config")
+			return
+		}
+		withTransport(cfg, "TLSConfig", func(t *http.Transport) {
+			t.TLSClientConfig = tc.Clone()
+		})
+	}
+}
+
+// RootCAs verifies server certificates against pool instead of the system
+// roots, e.g. for an appliance with a self-signed certificate
+func RootCAs(pool *x509.CertPool) ClientOption {
+	return func(cfg *clientConfig) {
+		if pool == nil {
+			cfg.err = errors.New("webdav: nil certificate pool")
+			return
+		}
+		withTLS(cfg, "RootCAs", func(tc *tls.Config) {
+			tc.RootCAs = pool
+		})
+	}
+}
+
+// ClientCertificate presents cert to servers that ask for one
+func ClientCertificate(cert tls.Certificate) ClientOption {
+	return func(cfg *clientConfig) {
+		if len(cert.Certificate) == 0 {
+			cfg.err = errors.New("webdav: empty client certificate")
+			return
+		}
+		withTLS(cfg, "ClientCertificate", func(tc *tls.Config) {
+			tc.Certificates = append(tc.Certificates, cert)
+		})
+	}
+}
+
+// ServerName verifies the server certificate for name instead of the host
+// of the URL, e.g. when connecting by IP address
+func ServerName(name string) ClientOption {
+	return func(cfg *clientConfig) {
+		withTLS(cfg, "ServerName", func(tc *tls.Config) {
+			tc.ServerName = name
+		})
+	}
+}
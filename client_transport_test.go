@@ -0,0 +1,238 @@
+package webdav
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// waitGoroutines waits for the number of goroutines to drop to n
+func waitGoroutines(t testing.TB, n int) {
+	t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for runtime.NumGoroutine() > n && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := runtime.NumGoroutine(); got > n {
+		buf := make([]byte, 1<<16)
+		t.Errorf("%d goroutines left running:\n%s", got-n, buf[:runtime.Stack(buf, true)])
+	}
+}
+
+func TestClientCloseLeaksNothing(t *testing.T) {
+	stall := make(chan struct{})
+	var (
+		mu    sync.Mutex
+		conns = make(map[net.Conn]http.ConnState)
+	)
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/stall" {
+			w.WriteHeader(StatusOK)
+			w.(http.Flusher).Flush()
+			select {
+			case <-stall:
+			case <-r.Context().Done():
+			}
+			return
+		}
+		io.WriteString(w, "content")
+	}))
+	ts.Config.ConnState = func(c net.Conn, state http.ConnState) {
+		mu.Lock()
+		defer mu.Unlock()
+		if state == http.StateClosed || state == http.StateHijacked {
+			delete(conns, c)
+		} else {
+			conns[c] = state
+		}
+	}
+	ts.Start()
+	defer ts.Close()
+	defer close(stall)
+	before := runtime.NumGoroutine()
+
+	c, err := NewClient(ts.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := c.ReadFile(ctx, "small"); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	body, err := c.Open(ctx, "stall")
+	if err != nil {
+		t.Fatal(err)
+	}
+	read := make(chan error, 1)
+	go func() {
+		_, err := io.ReadAll(body)
+		read <- err
+	}()
+
+	c.Close()
+	select {
+	case err := <-read:
+		if err != ErrClientClosed {
+			t.Errorf("read in flight at Close = %v, want ErrClientClosed", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Close didn't abort the read in flight")
+	}
+	body.Close()
+
+	waitGoroutines(t, before)
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		mu.Lock()
+		n := len(conns)
+		mu.Unlock()
+		if n == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Errorf("%d connections still open after Close", n)
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// clientCert makes a CA and a client certificate it signed
+func clientCert(t testing.TB) (*x509.CertPool, tls.Certificate) {
+	t.Helper()
+	newCert := func(tmpl, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey, []byte) {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if parent == nil {
+			parent, parentKey = tmpl, key
+		}
+		der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cert, key, der
+	}
+	now := time.Now()
+	ca, caKey, _ := newCert(&x509.Certificate{
+		SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: "test CA"},
+		NotBefore: now.Add(-time.Hour), NotAfter: now.Add(time.Hour),
+		IsCA: true, BasicConstraintsValid: true, KeyUsage: x509.KeyUsageCertSign,
+	}, nil, nil)
+	_, key, der := newCert(&x509.Certificate{
+		SerialNumber: big.NewInt(2), Subject: pkix.Name{CommonName: "client"},
+		NotBefore: now.Add(-time.Hour), NotAfter: now.Add(time.Hour),
+		KeyUsage: x509.KeyUsageDigitalSignature, ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca, caKey)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+	return pool, tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestClientTLS(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { io.WriteString(w, "secure") })
+	ts := httptest.NewTLSServer(handler)
+	defer ts.Close()
+	serverCAs := x509.NewCertPool()
+	serverCAs.AddCert(ts.Certificate())
+
+	clientCAs, cert := clientCert(t)
+	mtls := httptest.NewUnstartedServer(handler)
+	mtls.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	mtls.StartTLS()
+	defer mtls.Close()
+
+	for _, tc := range []struct {
+		name string
+		url  string
+		opts []ClientOption
+		ok   bool
+	}{
+		{"system roots", ts.URL, nil, false},
+		{"custom roots", ts.URL, []ClientOption{RootCAs(serverCAs)}, true},
+		{"server name", ts.URL, []ClientOption{RootCAs(serverCAs), ServerName("example.com")}, true},
+		{"wrong server name", ts.URL, []ClientOption{RootCAs(serverCAs), ServerName("wrong.test")}, false},
+		{"tls config", ts.URL, []ClientOption{TLSConfig(&tls.Config{RootCAs: serverCAs})}, true},
+		{"no client certificate", mtls.URL, []ClientOption{RootCAs(serverCAs)}, false},
+		{"client certificate", mtls.URL, []ClientOption{RootCAs(serverCAs), ClientCertificate(cert)}, true},
+		{"client certificate after tls config", mtls.URL,
+			[]ClientOption{TLSConfig(&tls.Config{RootCAs: serverCAs}), ClientCertificate(cert), MaxConns(2), IdleTimeout(time.Second)}, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c, err := NewClient(tc.url, nil, tc.opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+			b, err := c.ReadFile(context.Background(), "f")
+			if tc.ok && (err != nil || string(b) != "secure") {
+				t.Errorf("ReadFile = %q, %v", b, err)
+			}
+			if !tc.ok && err == nil {
+				t.Error("ReadFile succeeded")
+			}
+		})
+	}
+}
+
+func TestClientTransportOptionErrors(t *testing.T) {
+	custom := roundTripFunc(func(*http.Request) (*http.Response, error) { return nil, io.EOF })
+	for name, opts := range map[string][]ClientOption{
+		"nil transport":       {Transport(nil)},
+		"nil tls config":      {TLSConfig(nil)},
+		"nil pool":            {RootCAs(nil)},
+		"empty certificate":   {ClientCertificate(tls.Certificate{})},
+		"bad proxy":           {Proxy("http://bad host:x")},
+		"tls on custom":       {Transport(custom), RootCAs(x509.NewCertPool())},
+		"pool size on custom": {Transport(custom), MaxConns(1)},
+	} {
+		if _, err := NewClient("https://example.com", nil, opts...); err == nil {
+			t.Errorf("%s: NewClient succeeded", name)
+		}
+	}
+	if _, err := NewClient("https://example.com", http.DefaultClient, MaxConns(1)); err == nil || !strings.Contains(err.Error(), "transport") {
+		t.Errorf("transport option with an *http.Client = %v", err)
+	}
+
+	// the caller's transport is copied, not changed
+	tr := &http.Transport{}
+	if _, err := NewClient("https://example.com", nil, Transport(tr), MaxConns(3)); err != nil {
+		t.Fatal(err)
+	}
+	if tr.MaxConnsPerHost != 0 {
+		t.Error("MaxConns changed the caller's transport")
+	}
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (fn roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) { return fn(req) }
@@ -0,0 +1,285 @@
+package webdav
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// UploadOptions configures Upload, a nil *UploadOptions uses the defaults
+type UploadOptions struct {
+	// number of files uploaded concurrently
+	Parallel int
+
+	// stop at the first failure, and only replace a remote file if it
+	// still has the ETag it had when the upload started, so concurrent
+	// remote edits aren't overwritten
+	Strict bool
+
+	// delete remote files and collections that don't exist locally, only
+	// done when every upload succeeded
+	Delete bool
+
+	// upload the targets of symbolic links instead of skipping them
+	FollowSymlinks bool
+
+	// path.Match patterns, a file or directory whose name or slash
+	// separated relative path matches one is skipped, e.g. ".*"
+	Exclude []string
+
+	// options for every PUT
+	Put []RequestOption
+}
+
+// Upload copies the local tree below localDir to remoteRoot, creating the
+// collections it lacks. A file is skipped if the remote one has the same
+// size and is not older.
+func (c *Client) Upload(ctx context.Context, localDir, remoteRoot string, opts *UploadOptions) error {
+	var o UploadOptions
+	if opts != nil {
+		o = *opts
+	}
+	if o.Parallel <= 0 {
+		o.Parallel = DefaultTreeParallel
+	}
+	root := path.Clean("/" + remoteRoot)
+
+	remote, err := c.remoteTree(ctx, root)
+	if err != nil {
+		return err
+	}
+	if remote == nil {
+		if err := c.mkcolAll(ctx, root); err != nil {
+			return err
+		}
+		remote = make(map[string]*RemoteFileInfo)
+	}
+
+	ctx, agg := c.trackTree(ctx, root)
+	defer agg.finish()
+	t := newTreeRun(ctx, "upload", o.Strict, o.Parallel)
+	seen := make(map[string]bool)
+	u := &uploader{c: c, o: &o, t: t, agg: agg, root: root, remote: remote, seen: seen, visited: make(map[string]bool)}
+	werr := u.dir(localDir, "")
+	t.wg.Wait()
+
+	if o.Delete && werr == nil && len(t.errs) == 0 {
+		u.deleteMissing()
+	}
+	return t.wait(werr)
+}
+
+// remoteTree lists the remote tree below root by relative name, nil if
+// root doesn't exist
+func (c *Client) remoteTree(ctx context.Context, root string) (map[string]*RemoteFileInfo, error) {
+	tree := make(map[string]*RemoteFileInfo)
+	err := c.Walk(ctx, root, func(name string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if rel, ok := relPath(root, name); ok {
+			tree[rel] = fi.(*RemoteFileInfo)
+		}
+		return nil
+	})
+	if errors.Is(err, ErrNotFound) {
+		return nil, nil
+	}
+	return tree, err
+}
+
+// mkcolAll creates the collection name and any missing parents
+func (c *Client) mkcolAll(ctx context.Context, name string) error {
+	err := c.Mkcol(ctx, name)
+	var se *StatusError
+	if errors.As(err, &se) {
+		switch se.Code {
+		case StatusMethodNotAllowed:
+			// already exists
+			return nil
+		case StatusConflict:
+			if parent := path.Dir(path.Clean("/" + name)); parent != "/" {
+				if err := c.mkcolAll(ctx, parent); err != nil {
+					return err
+				}
+				return c.Mkcol(ctx, name)
+			}
+		}
+	}
+	return err
+}
+
+type uploader struct {
+	c      *Client
+	o      *UploadOptions
+	t      *treeRun
+	agg    *tracker
+	root   string
+	remote map[string]*RemoteFileInfo
+
+	// relative names found locally, only touched by the walking goroutine
+	seen map[string]bool
+
+	// real paths of the directories being walked, against symlink loops
+	visited map[string]bool
+}
+
+// dir uploads the local directory dir, rel is its relative name
+func (u *uploader) dir(dir, rel string) error {
+	if real, err := filepath.EvalSymlinks(dir); err == nil {
+		if u.visited[real] {
+			// a symbolic link to one of its own parents
+			return nil
+		}
+		u.visited[real] = true
+		defer delete(u.visited, real)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return u.t.fail(rel, err)
+	}
+	for _, e := range entries {
+		if err := u.t.ctx.Err(); err != nil {
+			return err
+		}
+
+		name := path.Join(rel, e.Name())
+		if u.excluded(name) {
+			continue
+		}
+		local := filepath.Join(dir, e.Name())
+
+		mode := e.Type()
+		if mode&fs.ModeSymlink != 0 {
+			if !u.o.FollowSymlinks {
+				continue
+			}
+			fi, err := os.Stat(local)
+			if err != nil {
+				if err := u.t.fail(name, err); err != nil {
+					return err
+				}
+				continue
+			}
+			mode = fi.Mode().Type()
+		}
+
+		switch {
+		case mode.IsDir():
+			u.seen[name] = true
+			if rfi := u.remote[name]; rfi == nil || !rfi.IsDir() {
+				if err := u.c.Mkcol(u.t.ctx, path.Join(u.root, name)); err != nil {
+					if err := u.t.fail(name, err); err != nil {
+						return err
+					}
+					continue
+				}
+			}
+			if err := u.dir(local, name); err != nil {
+				return err
+			}
+		case mode.IsRegular():
+			u.seen[name] = true
+			if err := u.file(local, name); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// file starts the upload of a local file unless the remote one is current
+func (u *uploader) file(local, name string) error {
+	fi, err := os.Stat(local)
+	if err != nil {
+		return u.t.fail(name, err)
+	}
+	rfi := u.remote[name]
+	if rfi != nil && !rfi.IsDir() && rfi.Size() == fi.Size() && !rfi.ModTime().Before(fi.ModTime().Truncate(1e9)) {
+		return nil
+	}
+
+	opts := append([]RequestOption{ContentLength(fi.Size())}, u.o.Put...)
+	if u.o.Strict {
+		if rfi != nil && rfi.ETag() != "" {
+			opts = append(opts, IfMatch(rfi.ETag()))
+		} else if rfi == nil {
+			opts = append(opts, IfNoneMatchAny())
+		}
+	}
+
+	u.agg.grow(fi.Size())
+	u.t.run(func(ctx context.Context) {
+		f, err := os.Open(local)
+		if err != nil {
+			u.t.fail(name, err)
+			return
+		}
+		defer f.Close()
+
+		if err := u.c.Put(ctx, path.Join(u.root, name), f, opts...); err != nil {
+			u.t.fail(name, err)
+		}
+	})
+	return nil
+}
+
+// deleteMissing deletes the remote entries not found locally, a missing
+// collection is deleted with everything in it
+func (u *uploader) deleteMissing() {
+	names := make([]string, 0, len(u.remote))
+	for name := range u.remote {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var deleted []string
+	for _, name := range names {
+		if u.seen[name] || u.excluded(name) || under(deleted, name) {
+			continue
+		}
+		if err := u.c.Delete(u.t.ctx, path.Join(u.root, name)); err != nil {
+			if u.t.fail(name, err) != nil {
+				return
+			}
+			continue
+		}
+		deleted = append(deleted, name)
+	}
+}
+
+func (u *uploader) excluded(name string) bool {
+	for _, pattern := range u.o.Exclude {
+		if ok, _ := path.Match(pattern, path.Base(name)); ok {
+			return true
+		}
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// under reports whether name is below one of the collections dirs
+func under(dirs []string, name string) bool {
+	for _, dir := range dirs {
+		if strings.HasPrefix(name, dir+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// header returns a RequestOption setting a header
+func header(key, value string) RequestOption {
+	return func(req *http.Request) {
+		req.Header.Set(key, value)
+	}
+}
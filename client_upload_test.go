@@ -0,0 +1,150 @@
+package webdav
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+// writeLocal creates the files of tree below dir
+func writeLocal(t *testing.T, dir string, tree map[string]string) {
+	t.Helper()
+	for name, data := range tree {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestClientUpload(t *testing.T) {
+	ctx := context.Background()
+	local := t.TempDir()
+	writeLocal(t, local, map[string]string{
+		"top.txt":          "top",
+		"a/one.txt":        "one",
+		"a/b/c/three.txt":  "three",
+		"with space/ü.txt": "escaped",
+		".hidden/secret":   "excluded",
+		"a/.dotfile":       "excluded",
+	})
+	if err := os.Mkdir(filepath.Join(local, "void"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	m := NewMemFS()
+	if err := m.Mkdir("/dest"); err != nil {
+		t.Fatal(err)
+	}
+	writeMem(t, m, "/dest/stale.txt", []byte("not local"))
+	mc, c := countedDAVServer(t, m)
+
+	opts := &UploadOptions{Parallel: 3, Exclude: []string{".*"}}
+	if err := c.Upload(ctx, local, "/dest", opts); err != nil {
+		t.Fatal(err)
+	}
+	if n := mc.take("PUT"); n != 4 {
+		t.Errorf("first run: %d PUTs, want 4", n)
+	}
+	files := memFiles(t, m)
+	for name, want := range map[string]string{
+		"/dest/top.txt":          "top",
+		"/dest/a/one.txt":        "one",
+		"/dest/a/b/c/three.txt":  "three",
+		"/dest/with space/ü.txt": "escaped",
+		"/dest/stale.txt":        "not local",
+	} {
+		if got := string(files[name].data); got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+	if !files["/dest/void"].dir {
+		t.Error("empty directory not created remotely")
+	}
+	for _, name := range []string{"/dest/.hidden", "/dest/.hidden/secret", "/dest/a/.dotfile"} {
+		if _, ok := files[name]; ok {
+			t.Errorf("excluded %s uploaded", name)
+		}
+	}
+
+	// unchanged files aren't sent again, and Delete removes what is only
+	// remote
+	opts.Delete = true
+	if err := c.Upload(ctx, local, "/dest", opts); err != nil {
+		t.Fatal(err)
+	}
+	if n := mc.take("PUT"); n != 0 {
+		t.Errorf("second run: %d PUTs, want none", n)
+	}
+	files = memFiles(t, m)
+	if _, ok := files["/dest/stale.txt"]; ok {
+		t.Error("Delete left a file that isn't local")
+	}
+	if _, ok := files["/dest/top.txt"]; !ok {
+		t.Error("Delete removed a local file")
+	}
+
+	// a new root is created with its parents
+	if err := c.Upload(ctx, filepath.Join(local, "a"), "/new/deep/root", nil); err != nil {
+		t.Fatal(err)
+	}
+	if b := memFiles(t, m)["/new/deep/root/b/c/three.txt"].data; string(b) != "three" {
+		t.Errorf("upload to a new root: three.txt = %q", b)
+	}
+}
+
+func TestClientUploadSymlinks(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symbolic links need privileges on windows")
+	}
+	ctx := context.Background()
+	local := t.TempDir()
+	outside := t.TempDir()
+	writeLocal(t, local, map[string]string{"file.txt": "file"})
+	writeLocal(t, outside, map[string]string{"target.txt": "target", "sub/deep.txt": "deep"})
+	for link, target := range map[string]string{
+		"link.txt": filepath.Join(outside, "target.txt"),
+		"linkdir":  filepath.Join(outside, "sub"),
+		"loop":     local,
+		"dangling": filepath.Join(outside, "missing"),
+	} {
+		if err := os.Symlink(target, filepath.Join(local, link)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	m := NewMemFS()
+	_, c := countedDAVServer(t, m)
+	if err := c.Upload(ctx, local, "/skip", nil); err != nil {
+		t.Fatal(err)
+	}
+	files := memFiles(t, m)
+	if len(files) != 2 || files["/skip/file.txt"].data == nil {
+		t.Errorf("upload skipping links created %v", files)
+	}
+
+	err := c.Upload(ctx, local, "/follow", &UploadOptions{FollowSymlinks: true})
+	var te *TreeError
+	if !errors.As(err, &te) || len(te.Errors) != 1 || te.Errors[0].Name != "dangling" {
+		t.Errorf("Upload = %v, want one failure for the dangling link", err)
+	}
+	files = memFiles(t, m)
+	for name, want := range map[string]string{
+		"/follow/file.txt":         "file",
+		"/follow/link.txt":         "target",
+		"/follow/linkdir/deep.txt": "deep",
+	} {
+		if got := string(files[name].data); got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+	if _, ok := files["/follow/loop/file.txt"]; ok {
+		t.Error("a link to a parent was walked")
+	}
+}
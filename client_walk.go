@@ -0,0 +1,72 @@
+package webdav
+
+import (
+	"context"
+	"io/fs"
+	"path"
+	"sort"
+)
+
+// Walk calls fn for root and everything below it, breadth first, listing
+// every collection with its own Depth 1 PROPFIND rather than one Depth
+// infinity request many servers refuse. Names passed to fn are cleaned
+// absolute paths, the entries of a collection come sorted by name.
+//
+// A collection that can't be listed is passed to fn a second time with the
+// error, so one unreadable collection doesn't end the walk unless fn
+// returns the error. Returning fs.SkipDir skips a collection or, for a
+// file, the rest of its collection, fs.SkipAll ends the walk.
+func (c *Client) Walk(ctx context.Context, root string, fn WalkFunc) error {
+	root = path.Clean("/" + root)
+	fi, err := c.Stat(ctx, root)
+	if err != nil {
+		return skipDir(fn(root, nil, err))
+	}
+	if err := fn(root, fi, nil); err != nil || !fi.IsDir() {
+		return skipDir(err)
+	}
+
+	queue := []string{root}
+	for len(queue) > 0 {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		dir := queue[0]
+		queue = queue[1:]
+
+		fis, err := c.ReadDir(ctx, dir)
+		if err != nil {
+			if err := fn(dir, nil, err); err != nil && err != fs.SkipDir {
+				return skipDir(err)
+			}
+			continue
+		}
+		sort.Slice(fis, func(i, j int) bool { return fis[i].Name() < fis[j].Name() })
+
+		for _, fi := range fis {
+			name := path.Join(dir, fi.Name())
+			err := fn(name, fi, nil)
+			if err == fs.SkipDir {
+				if fi.IsDir() {
+					continue
+				}
+				break
+			}
+			if err != nil {
+				return skipDir(err)
+			}
+			if fi.IsDir() {
+				queue = append(queue, name)
+			}
+		}
+	}
+	return nil
+}
+
+// skipDir turns the errors that only end the walk into nil
+func skipDir(err error) error {
+	if err == fs.SkipDir || err == fs.SkipAll {
+		return nil
+	}
+	return err
+}
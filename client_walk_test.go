@@ -0,0 +1,219 @@
+package webdav
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// walkNames walks root and returns the names passed to fn, errors marked
+// with a trailing "!". The trees of the tests name files with an f, it
+// checks the FileInfo of everything else is a collection.
+func walkNames(t *testing.T, c *Client, root string, fn WalkFunc) ([]string, error) {
+	t.Helper()
+	var names []string
+	err := c.Walk(context.Background(), root, func(name string, fi os.FileInfo, err error) error {
+		if err != nil {
+			names = append(names, name+"!")
+		} else {
+			if _, ok := fi.(*RemoteFileInfo); !ok {
+				t.Errorf("%s: FileInfo is a %T", name, fi)
+			}
+			if fi.IsDir() == strings.HasPrefix(path.Base(name), "f") {
+				t.Errorf("%s: IsDir %v", name, fi.IsDir())
+			}
+			names = append(names, name)
+		}
+		if fn != nil {
+			return fn(name, fi, err)
+		}
+		return nil
+	})
+	return names, err
+}
+
+func TestClientWalkDeep(t *testing.T) {
+	m := NewMemFS()
+	want := []string{"/"}
+	dir := ""
+	for i := 0; i < 40; i++ {
+		dir += fmt.Sprintf("/d%02d", i)
+		if err := m.Mkdir(dir); err != nil {
+			t.Fatal(err)
+		}
+		writeMem(t, m, dir+"/f", []byte("x"))
+		want = append(want, dir)
+	}
+	mc, c := countedDAVServer(t, m)
+
+	names, err := walkNames(t, c, "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var full []string
+	for _, name := range want {
+		if name != "/" {
+			full = append(full, name+"/f")
+		}
+	}
+	if len(names) != len(want)+len(full) {
+		t.Fatalf("%d names, want %d: %v", len(names), len(want)+len(full), names)
+	}
+	// every collection is listed with its own Depth 1 PROPFIND, plus the
+	// Stat of the root
+	if n := mc.take("PROPFIND"); n != len(want)+1 {
+		t.Errorf("%d PROPFINDs, want %d", n, len(want)+1)
+	}
+	// breadth first: a collection is visited before anything two levels
+	// further down
+	pos := make(map[string]int)
+	for i, name := range names {
+		pos[name] = i
+	}
+	for _, name := range full {
+		if dir := path.Dir(name); pos[dir] > pos[name] {
+			t.Errorf("%s visited before its collection", name)
+		}
+	}
+}
+
+func TestClientWalkWide(t *testing.T) {
+	m := NewMemFS()
+	var want []string
+	for i := 0; i < 300; i++ {
+		writeMem(t, m, fmt.Sprintf("/f%03d", i), nil)
+		want = append(want, fmt.Sprintf("/f%03d", i))
+	}
+	for i := 0; i < 30; i++ {
+		// empty collections
+		if err := m.Mkdir(fmt.Sprintf("/e%02d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, c := countedDAVServer(t, m)
+
+	names, err := walkNames(t, c, "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var dirs []string
+	for i := 0; i < 30; i++ {
+		dirs = append(dirs, fmt.Sprintf("/e%02d", i))
+	}
+	if got := append([]string{"/"}, append(dirs, want...)...); !reflect.DeepEqual(names, got) {
+		t.Errorf("Walk = %v\nwant %v", names, got)
+	}
+}
+
+func TestClientWalkSkip(t *testing.T) {
+	m := NewMemFS()
+	for _, dir := range []string{"/a", "/a/skipped", "/b", "/c"} {
+		m.Mkdir(dir)
+	}
+	for _, name := range []string{"/a/f1", "/a/skipped/f", "/b/f1", "/b/f2", "/b/f3", "/c/f"} {
+		writeMem(t, m, name, nil)
+	}
+	_, c := countedDAVServer(t, m)
+
+	names, err := walkNames(t, c, "/", func(name string, fi os.FileInfo, err error) error {
+		switch name {
+		case "/a/skipped", "/b/f2":
+			return fs.SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"/", "/a", "/b", "/c", "/a/f1", "/a/skipped", "/b/f1", "/b/f2", "/c/f"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("Walk with SkipDir = %v\nwant %v", names, want)
+	}
+
+	names, err = walkNames(t, c, "/", func(name string, fi os.FileInfo, err error) error {
+		if name == "/b" {
+			return fs.SkipAll
+		}
+		return nil
+	})
+	if err != nil || !reflect.DeepEqual(names, []string{"/", "/a", "/b"}) {
+		t.Errorf("Walk with SkipAll = %v, %v", names, err)
+	}
+
+	stop := errors.New("stop")
+	_, err = walkNames(t, c, "/", func(name string, fi os.FileInfo, err error) error {
+		if name == "/a/f1" {
+			return stop
+		}
+		return nil
+	})
+	if err != stop {
+		t.Errorf("Walk = %v, want the error of fn", err)
+	}
+
+	names, err = walkNames(t, c, "/b/f1", nil)
+	if err != nil || !reflect.DeepEqual(names, []string{"/b/f1"}) {
+		t.Errorf("Walk of a file = %v, %v", names, err)
+	}
+}
+
+func TestClientWalkUnreadable(t *testing.T) {
+	m := NewMemFS()
+	for _, dir := range []string{"/a", "/locked", "/z"} {
+		m.Mkdir(dir)
+	}
+	for _, name := range []string{"/a/f", "/locked/f", "/z/f"} {
+		writeMem(t, m, name, nil)
+	}
+	d := &davServer{Server: &Server{Fs: m, TrimPrefix: "/"}}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "PROPFIND" && strings.TrimSuffix(r.URL.Path, "/") == "/locked" && r.Header.Get("Depth") == "1" {
+			w.WriteHeader(StatusForbidden)
+			return
+		}
+		d.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+	c, err := NewClient(ts.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	var got error
+	names, err := walkNames(t, c, "/", func(name string, fi os.FileInfo, err error) error {
+		if err != nil {
+			got = err
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"/", "/a", "/locked", "/z", "/a/f", "/locked!", "/z/f"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("Walk = %v\nwant %v", names, want)
+	}
+	if !errors.Is(got, ErrForbidden) {
+		t.Errorf("error passed to fn = %v, want ErrForbidden", got)
+	}
+
+	// returning the error ends the walk with it
+	_, err = walkNames(t, c, "/", func(name string, fi os.FileInfo, err error) error { return err })
+	if !errors.Is(err, ErrForbidden) {
+		t.Errorf("Walk = %v, want ErrForbidden", err)
+	}
+
+	names, err = walkNames(t, c, "/missing", nil)
+	if err != nil || !reflect.DeepEqual(names, []string{"/missing!"}) {
+		t.Errorf("Walk of a missing root = %v, %v", names, err)
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/rbastic/webdav"
+)
+
+// lsEntry is a line of ls -json
+type lsEntry struct {
+	Name    string    `json:"name"`
+	Dir     bool      `json:"dir,omitempty"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mtime"`
+	ETag    string    `json:"etag,omitempty"`
+}
+
+func cmdLs(ctx context.Context, c *webdav.Client, args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("ls", flag.ContinueOnError)
+	asJSON := fs.Bool("json", false, "")
+	if err := parse(fs, args, 0, 1); err != nil {
+		return err
+	}
+
+	fis, err := c.ReadDir(ctx, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		entries := make([]lsEntry, 0, len(fis))
+		for _, fi := range fis {
+			e := lsEntry{Name: fi.Name(), Dir: fi.IsDir(), Size: fi.Size(), ModTime: fi.ModTime()}
+			if r, ok := fi.(*webdav.RemoteFileInfo); ok {
+				e.ETag = r.ETag()
+			}
+			entries = append(entries, e)
+		}
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(entries)
+	}
+
+	tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', tabwriter.AlignRight)
+	for _, fi := range fis {
+		name := fi.Name()
+		if fi.IsDir() {
+			name += "/"
+		}
+		fmt.Fprintf(tw, "%d\t%s\t\t%s\n", fi.Size(), fi.ModTime().Local().Format("2006-01-02 15:04"), name)
+	}
+	return tw.Flush()
+}
+
+func cmdGet(ctx context.Context, c *webdav.Client, args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("get", flag.ContinueOnError)
+	if err := parse(fs, args, 1, 2); err != nil {
+		return err
+	}
+	name, local := fs.Arg(0), fs.Arg(1)
+	if local == "" {
+		local = path.Base(name)
+	}
+
+	if local == "-" {
+		rc, err := c.Open(ctx, name)
+		if err != nil {
+			return err
+		}
+		defer rc.Close()
+		_, err = io.Copy(out, rc)
+		return err
+	}
+	_, err := c.DownloadFile(ctx, name, local, nil)
+	return err
+}
+
+func cmdPut(ctx context.Context, c *webdav.Client, args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("put", flag.ContinueOnError)
+	if err := parse(fs, args, 1, 2); err != nil {
+		return err
+	}
+	local, name := fs.Arg(0), fs.Arg(1)
+
+	var r io.Reader = os.Stdin
+	if local != "-" {
+		f, err := os.Open(local)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	if name == "" || strings.HasSuffix(name, "/") {
+		if local == "-" {
+			return errUsage
+		}
+		name += filepath.Base(local)
+	}
+	return c.Put(ctx, name, r)
+}
+
+func cmdRm(ctx context.Context, c *webdav.Client, args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("rm", flag.ContinueOnError)
+	if err := parse(fs, args, 1, 1); err != nil {
+		return err
+	}
+	return c.Delete(ctx, fs.Arg(0))
+}
+
+func cmdMkdir(ctx context.Context, c *webdav.Client, args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("mkdir", flag.ContinueOnError)
+	if err := parse(fs, args, 1, 1); err != nil {
+		return err
+	}
+	return c.Mkcol(ctx, fs.Arg(0))
+}
+
+func cmdMv(ctx context.Context, c *webdav.Client, args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("mv", flag.ContinueOnError)
+	noClobber := fs.Bool("n", false, "")
+	if err := parse(fs, args, 2, 2); err != nil {
+		return err
+	}
+	return c.Move(ctx, fs.Arg(0), fs.Arg(1), !*noClobber)
+}
+
+func cmdCp(ctx context.Context, c *webdav.Client, args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("cp", flag.ContinueOnError)
+	noClobber := fs.Bool("n", false, "")
+	if err := parse(fs, args, 2, 2); err != nil {
+		return err
+	}
+	return c.Copy(ctx, fs.Arg(0), fs.Arg(1), !*noClobber)
+}
+
+// cmdLock prints the token of the lock it takes, for unlock and for other
+// clients to submit
+func cmdLock(ctx context.Context, c *webdav.Client, args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("lock", flag.ContinueOnError)
+	shared := fs.Bool("shared", false, "")
+	depth := fs.String("depth", "infinity", "")
+	timeout := fs.Duration("timeout", 0, "")
+	if err := parse(fs, args, 1, 1); err != nil {
+		return err
+	}
+
+	l, err := c.Lock(ctx, fs.Arg(0), &webdav.LockOptions{Shared: *shared, Depth: *depth, Timeout: *timeout})
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(out, l.Token)
+	return nil
+}
+
+func cmdUnlock(ctx context.Context, c *webdav.Client, args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("unlock", flag.ContinueOnError)
+	if err := parse(fs, args, 2, 2); err != nil {
+		return err
+	}
+	return c.Unlock(ctx, &webdav.Lock{Name: fs.Arg(0), Token: fs.Arg(1)})
+}
+
+func cmdMirror(ctx context.Context, c *webdav.Client, args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("mirror", flag.ContinueOnError)
+	parallel := fs.Int("parallel", 4, "")
+	if err := parse(fs, args, 2, 2); err != nil {
+		return err
+	}
+	return c.Mirror(ctx, fs.Arg(0), fs.Arg(1), &webdav.MirrorOptions{Parallel: *parallel})
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rbastic/webdav"
+)
+
+// davFront serves root with a webdav.Server, adding the PROPFIND, MKCOL
+// and MOVE the commands need and the Server lacks
+type davFront struct {
+	root string
+	dav  *webdav.Server
+}
+
+func (d davFront) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := path.Clean(r.URL.Path)
+	local := filepath.Join(d.root, filepath.FromSlash(name))
+	switch r.Method {
+	case "PROPFIND":
+		fi, err := os.Stat(local)
+		if err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fis := []os.FileInfo{fi}
+		names := []string{name}
+		if fi.IsDir() && r.Header.Get("Depth") != "0" {
+			entries, _ := os.ReadDir(local)
+			for _, e := range entries {
+				if efi, err := e.Info(); err == nil {
+					fis = append(fis, efi)
+					names = append(names, path.Join(name, e.Name()))
+				}
+			}
+		}
+		w.WriteHeader(webdav.StatusMulti)
+		fmt.Fprint(w, `<D:multistatus xmlns:D="DAV:">`)
+		for i, fi := range fis {
+			href := (&url.URL{Path: names[i]}).EscapedPath()
+			prop := fmt.Sprintf("<D:resourcetype/><D:getcontentlength>%d</D:getcontentlength>", fi.Size())
+			if fi.IsDir() {
+				href = strings.TrimSuffix(href, "/") + "/"
+				prop = "<D:resourcetype><D:collection/></D:resourcetype>"
+			}
+			fmt.Fprintf(w, "<D:response><D:href>%s</D:href><D:propstat><D:prop>%s<D:getlastmodified>%s</D:getlastmodified>"+
+				"</D:prop><D:status>HTTP/1.1 200 OK</D:status></D:propstat></D:response>",
+				href, prop, fi.ModTime().UTC().Format(http.TimeFormat))
+		}
+		fmt.Fprint(w, "</D:multistatus>")
+	case "MKCOL":
+		if err := os.Mkdir(local, 0o755); err != nil {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	case "MOVE":
+		dest, _ := url.Parse(r.Header.Get("Destination"))
+		to := filepath.Join(d.root, filepath.FromSlash(path.Clean(dest.Path)))
+		status := http.StatusCreated
+		if _, err := os.Stat(to); err == nil {
+			if r.Header.Get("Overwrite") == "F" {
+				w.WriteHeader(http.StatusPreconditionFailed)
+				return
+			}
+			status = http.StatusNoContent
+		}
+		if err := os.Rename(local, to); err != nil {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		w.WriteHeader(status)
+	default:
+		d.dav.ServeHTTP(w, r)
+	}
+}
+
+// newFront serves a temporary directory to davcli, behind basic auth for
+// alice if auth is set, and returns the directory and the server
+func newFront(t *testing.T, auth bool) (string, *httptest.Server) {
+	t.Helper()
+	root := t.TempDir()
+	var h http.Handler = davFront{root, &webdav.Server{Fs: webdav.Dir(root), TrimPrefix: "/", FakeLocks: true}}
+	if auth {
+		next := h
+		h = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if u, p, ok := r.BasicAuth(); !ok || u != "alice" || p != "secret" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+	ts := httptest.NewServer(h)
+	t.Cleanup(ts.Close)
+	return root, ts
+}
+
+// davcli runs the command line args, returning its exit status and output
+func davcli(args ...string) (int, string, string) {
+	var out, errOut bytes.Buffer
+	code := run(args, &out, &errOut)
+	return code, out.String(), errOut.String()
+}
+
+func TestCommands(t *testing.T) {
+	root, ts := newFront(t, false)
+	ok := func(args ...string) string {
+		t.Helper()
+		code, out, errOut := davcli(append([]string{"-url", ts.URL}, args...)...)
+		if code != 0 {
+			t.Fatalf("davcli %s: exit %d: %s", strings.Join(args, " "), code, errOut)
+		}
+		return out
+	}
+	file := func(name string) string {
+		b, err := os.ReadFile(filepath.Join(root, filepath.FromSlash(name)))
+		if err != nil {
+			return "<" + err.Error() + ">"
+		}
+		return string(b)
+	}
+
+	local := filepath.Join(t.TempDir(), "notes.txt")
+	os.WriteFile(local, []byte("hello"), 0o600)
+
+	ok("mkdir", "/docs")
+	ok("put", local, "/docs/")
+	if got := file("docs/notes.txt"); got != "hello" {
+		t.Errorf("put stored %q", got)
+	}
+	ok("put", local, "/docs/named.txt")
+	if got := ok("get", "/docs/notes.txt", "-"); got != "hello" {
+		t.Errorf("get - printed %q", got)
+	}
+	dl := filepath.Join(t.TempDir(), "dl.txt")
+	ok("get", "/docs/notes.txt", dl)
+	if b, _ := os.ReadFile(dl); string(b) != "hello" {
+		t.Errorf("get saved %q", b)
+	}
+
+	var entries []lsEntry
+	if err := json.Unmarshal([]byte(ok("ls", "-json", "/docs")), &entries); err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].Name != "named.txt" || entries[1].Name != "notes.txt" || entries[1].Size != 5 {
+		t.Errorf("ls -json = %+v", entries)
+	}
+	if out := ok("ls", "/"); !strings.Contains(out, "docs/") {
+		t.Errorf("ls / = %q", out)
+	}
+
+	ok("cp", "/docs/notes.txt", "/copy.txt")
+	ok("mv", "/copy.txt", "/moved.txt")
+	if file("moved.txt") != "hello" || !strings.HasPrefix(file("copy.txt"), "<") {
+		t.Errorf("cp then mv left copy %q, moved %q", file("copy.txt"), file("moved.txt"))
+	}
+	ok("rm", "/moved.txt")
+	if !strings.HasPrefix(file("moved.txt"), "<") {
+		t.Error("rm left moved.txt")
+	}
+
+	token := strings.TrimSpace(ok("lock", "-depth", "0", "/docs/notes.txt"))
+	if token == "" {
+		t.Fatal("lock printed no token")
+	}
+	ok("unlock", "/docs/notes.txt", token)
+
+	mirror := t.TempDir()
+	ok("mirror", "/docs", mirror)
+	if b, err := os.ReadFile(filepath.Join(mirror, "named.txt")); err != nil || string(b) != "hello" {
+		t.Errorf("mirror wrote %q, %v", b, err)
+	}
+}
+
+func TestExitCodes(t *testing.T) {
+	_, ts := newFront(t, true)
+	_, ts2 := newFront(t, false)
+	gone := httptest.NewServer(http.NotFoundHandler())
+	gone.Close()
+	ctx := context.Background()
+	c, _ := webdav.NewClient(ts2.URL, nil)
+	defer c.Close()
+	c.WriteFile(ctx, "a", []byte("a"))
+	c.WriteFile(ctx, "b", []byte("b"))
+
+	for _, tc := range []struct {
+		args []string
+		code int
+	}{
+		{[]string{"-url", ts.URL, "-user", "alice", "-password", "secret", "ls"}, 0},
+		{[]string{"-url", ts.URL}, exitUsage},
+		{[]string{"-url", ts.URL, "frob"}, exitUsage},
+		{[]string{"-url", ts.URL, "rm"}, exitUsage},
+		{[]string{"-url", ts.URL, "-user", "alice", "-password", "wrong", "ls"}, exitAuth},
+		{[]string{"-url", ts2.URL, "cp", "-n", "/a", "/b"}, exitPrecondition},
+		{[]string{"-url", ts2.URL, "get", "/missing", "-"}, exitError},
+		{[]string{"-url", gone.URL, "ls"}, exitTransport},
+	} {
+		if code, _, errOut := davcli(tc.args...); code != tc.code {
+			t.Errorf("davcli %s: exit %d, want %d: %s", strings.Join(tc.args, " "), code, tc.code, errOut)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+// Command davcli talks to a WebDAV server.
+//
+//	davcli -url https://example.com/dav -user alice ls /photos
+//
+// The URL and credentials can also come from DAV_URL, DAV_USER and
+// DAV_PASSWORD. Run davcli -help for the commands.
+//
+// The exit status tells failures apart for scripts: 1 for other errors,
+// 2 for wrong usage, 3 when the server refused the credentials (401 or
+// 403), 4 when a precondition or lock stood in the way (412 or 423) and 5
+// when the server could not be reached.
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/signal"
+	"sort"
+
+	"github.com/rbastic/webdav"
+)
+
+// exit statuses
+const (
+	exitError        = 1
+	exitUsage        = 2
+	exitAuth         = 3
+	exitPrecondition = 4
+	exitTransport    = 5
+)
+
+// errUsage is returned by commands called with the wrong arguments
+var errUsage = errors.New("usage")
+
+// a command runs with the arguments after its name
+type command struct {
+	usage string
+	run   func(ctx context.Context, c *webdav.Client, args []string, out io.Writer) error
+}
+
+var commands = map[string]command{
+	"ls":     {"ls [-json] [path]", cmdLs},
+	"get":    {"get remote [local|-]", cmdGet},
+	"put":    {"put local|- [remote]", cmdPut},
+	"rm":     {"rm path", cmdRm},
+	"mkdir":  {"mkdir path", cmdMkdir},
+	"mv":     {"mv [-n] src dst", cmdMv},
+	"cp":     {"cp [-n] src dst", cmdCp},
+	"lock":   {"lock [-shared] [-depth 0|infinity] [-timeout d] path", cmdLock},
+	"unlock": {"unlock path token", cmdUnlock},
+	"mirror": {"mirror [-parallel n] remote local", cmdMirror},
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+func run(args []string, out, errOut io.Writer) int {
+	fs := flag.NewFlagSet("davcli", flag.ContinueOnError)
+	fs.SetOutput(errOut)
+	base := fs.String("url", os.Getenv("DAV_URL"), "base URL of the server")
+	user := fs.String("user", os.Getenv("DAV_USER"), "user name")
+	password := fs.String("password", os.Getenv("DAV_PASSWORD"), "password")
+	digest := fs.Bool("digest", false, "use Digest instead of Basic authentication")
+	insecure := fs.Bool("insecure", false, "accept any TLS certificate, e.g. a self-signed one")
+	fs.Usage = func() {
+		fmt.Fprintln(errOut, "usage: davcli [flags] command [args]\n\ncommands:")
+		names := make([]string, 0, len(commands))
+		for name := range commands {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintln(errOut, "  "+commands[name].usage)
+		}
+		fmt.Fprintln(errOut, "\nflags:")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	if fs.NArg() == 0 || *base == "" {
+		fs.Usage()
+		return exitUsage
+	}
+	cmd, ok := commands[fs.Arg(0)]
+	if !ok {
+		fmt.Fprintln(errOut, "davcli: unknown command", fs.Arg(0))
+		return exitUsage
+	}
+
+	var opts []webdav.ClientOption
+	if *user != "" {
+		if *digest {
+			opts = append(opts, webdav.DigestAuth(*user, *password))
+		} else {
+			opts = append(opts, webdav.BasicAuth(*user, *password))
+		}
+	}
+	if *insecure {
+		opts = append(opts, webdav.TLSConfig(&tls.Config{InsecureSkipVerify: true}))
+	}
+	c, err := webdav.NewClient(*base, nil, opts...)
+	if err != nil {
+		fmt.Fprintln(errOut, "davcli:", err)
+		return exitUsage
+	}
+	defer c.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	if err := cmd.run(ctx, c, fs.Args()[1:], out); err != nil {
+		if err == errUsage {
+			fmt.Fprintln(errOut, "usage: davcli", cmd.usage)
+			return exitUsage
+		}
+		fmt.Fprintln(errOut, "davcli:", err)
+		return exitCode(err)
+	}
+	return 0
+}
+
+// exitCode is the exit status for err
+func exitCode(err error) int {
+	var se *webdav.StatusError
+	if errors.As(err, &se) {
+		switch se.Code {
+		case webdav.StatusUnauthorized, webdav.StatusForbidden:
+			return exitAuth
+		case webdav.StatusPreconditionFailed, webdav.StatusLocked:
+			return exitPrecondition
+		}
+		return exitError
+	}
+
+	var ue *url.Error
+	if errors.As(err, &ue) {
+		return exitTransport
+	}
+	return exitError
+}
+
+// parse parses the flags of a command, returning errUsage unless it is
+// left with between min and max arguments
+func parse(fs *flag.FlagSet, args []string, min, max int) error {
+	fs.SetOutput(io.Discard)
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	if fs.NArg() < min || fs.NArg() > max {
+		return errUsage
+	}
+	return nil
+}
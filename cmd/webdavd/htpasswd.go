@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bufio"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// users maps user names to htpasswd hashes
+type users map[string]string
+
+// loadUsers reads an htpasswd file. Only the hashes that need nothing but
+// the standard library are accepted: MD5 ($apr1$, the htpasswd default),
+// SHA-1 ({SHA}) and plain text. Any other scheme, bcrypt, SHA-crypt ($5$,
+// $6$) or DES crypt, is rejected rather than compared as plain text.
+func loadUsers(name string) (users, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	u := make(users)
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		user, hash, ok := strings.Cut(line, ":")
+		if !ok || user == "" {
+			return nil, fmt.Errorf("%s:%d: not user:hash", name, n)
+		}
+		if err := checkScheme(hash); err != nil {
+			return nil, fmt.Errorf("%s:%d: %v, use htpasswd -m", name, n, err)
+		}
+		u[user] = hash
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if len(u) == 0 {
+		return nil, fmt.Errorf("%s: no users", name)
+	}
+	return u, nil
+}
+
+// checkScheme returns an error unless check understands hash. A plain
+// password of 13 crypt alphabet characters is taken for DES, as Apache
+// does outside Windows.
+func checkScheme(hash string) error {
+	switch {
+	case strings.HasPrefix(hash, "$apr1$"), strings.HasPrefix(hash, "{SHA}"):
+		return nil
+	case strings.HasPrefix(hash, "$2"):
+		return errors.New("bcrypt is not supported")
+	case strings.HasPrefix(hash, "$"):
+		scheme, _, _ := strings.Cut(hash[1:], "$")
+		return fmt.Errorf("hash scheme $%s$ is not supported", scheme)
+	case len(hash) == 13 && strings.Trim(hash, cryptAlphabet) == "":
+		return errors.New("DES crypt is not supported")
+	}
+	return nil
+}
+
+// cryptAlphabet holds the characters of crypt hashes
+const cryptAlphabet = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+func (u users) check(user, password string) bool {
+	hash, ok := u[user]
+	if !ok {
+		return false
+	}
+
+	var got string
+	switch {
+	case strings.HasPrefix(hash, "$apr1$"):
+		salt, _, _ := strings.Cut(hash[len("$apr1$"):], "$")
+		got = apr1(password, salt)
+	case strings.HasPrefix(hash, "{SHA}"):
+		sum := sha1.Sum([]byte(password))
+		got = "{SHA}" + base64.StdEncoding.EncodeToString(sum[:])
+	default:
+		got = password
+	}
+	return subtle.ConstantTimeCompare([]byte(got), []byte(hash)) == 1
+}
+
+// basicAuth lets requests through to h only with the credentials of one of
+// the users
+func (u users) basicAuth(realm string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, password, ok := r.BasicAuth()
+		if !ok || !u.check(user, password) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="`+realm+`", charset="UTF-8"`)
+			http.Error(w, "authentication required", http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// apr1 is the Apache variant of the MD5 crypt hash
+func apr1(password, salt string) string {
+	const magic = "$apr1$"
+	pw := []byte(password)
+	if len(salt) > 8 {
+		salt = salt[:8]
+	}
+
+	alt := md5.New()
+	alt.Write(pw)
+	alt.Write([]byte(salt))
+	alt.Write(pw)
+	altSum := alt.Sum(nil)
+
+	h := md5.New()
+	h.Write(pw)
+	h.Write([]byte(magic + salt))
+	for i := len(pw); i > 0; i -= 16 {
+		h.Write(altSum[:min(i, 16)])
+	}
+	for i := len(pw); i > 0; i >>= 1 {
+		if i&1 != 0 {
+			h.Write([]byte{0})
+		} else {
+			h.Write(pw[:1])
+		}
+	}
+	sum := h.Sum(nil)
+
+	for i := 0; i < 1000; i++ {
+		h := md5.New()
+		if i&1 != 0 {
+			h.Write(pw)
+		} else {
+			h.Write(sum)
+		}
+		if i%3 != 0 {
+			h.Write([]byte(salt))
+		}
+		if i%7 != 0 {
+			h.Write(pw)
+		}
+		if i&1 != 0 {
+			h.Write(sum)
+		} else {
+			h.Write(pw)
+		}
+		sum = h.Sum(nil)
+	}
+
+	var b strings.Builder
+	b.WriteString(magic + salt + "$")
+	to64 := func(v uint32, n int) {
+		for ; n > 0; n-- {
+			b.WriteByte(cryptAlphabet[v&0x3f])
+			v >>= 6
+		}
+	}
+	for _, i := range [][3]int{{0, 6, 12}, {1, 7, 13}, {2, 8, 14}, {3, 9, 15}, {4, 10, 5}} {
+		to64(uint32(sum[i[0]])<<16|uint32(sum[i[1]])<<8|uint32(sum[i[2]]), 4)
+	}
+	to64(uint32(sum[11]), 2)
+	return b.String()
+}
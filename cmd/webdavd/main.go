@@ -0,0 +1,235 @@
+// Command webdavd serves a directory over WebDAV.
+//
+//	webdavd -root /srv/files -addr :8080 -prefix /dav -users htpasswd
+//
+// Every flag can also be set with an environment variable, WEBDAVD_ followed
+// by the flag name in upper case with dashes as underscores, e.g.
+// WEBDAVD_ROOT or WEBDAVD_MAX_UPLOAD. Flags win over the environment.
+// Logging goes through glog. -log sends it to stderr or to files in a
+// directory, glog's own -log_dir and -logtostderr flags work as well.
+//
+// SIGINT and SIGTERM stop accepting connections and let running requests
+// finish for up to -grace before the process exits.
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/rbastic/webdav"
+)
+
+type config struct {
+	root      string
+	addr      string
+	prefix    string
+	cert      string
+	key       string
+	users     string
+	realm     string
+	readOnly  bool
+	listings  bool
+	fakeLocks bool
+	zip       bool
+	changes   bool
+	maxUpload int64
+	grace     time.Duration
+	check     bool
+	log       string
+}
+
+func main() {
+	cfg, err := parseFlags(flag.CommandLine, os.Args[1:])
+	if err == nil {
+		err = setLog(flag.CommandLine, cfg.log)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "webdavd:", err)
+		os.Exit(2)
+	}
+	if err := run(cfg); err != nil {
+		glog.Errorln("webdavd:", err)
+		glog.Flush()
+		os.Exit(1)
+	}
+	glog.Flush()
+}
+
+func parseFlags(fs *flag.FlagSet, args []string) (*config, error) {
+	cfg := &config{}
+	fs.StringVar(&cfg.root, "root", env("root", "."), "directory to serve")
+	fs.StringVar(&cfg.addr, "addr", env("addr", ":8080"), "address to listen on")
+	fs.StringVar(&cfg.prefix, "prefix", env("prefix", "/"), "URL path the directory is served under")
+	fs.StringVar(&cfg.cert, "cert", env("cert", ""), "TLS certificate file, serves HTTPS together with -key")
+	fs.StringVar(&cfg.key, "key", env("key", ""), "TLS key file")
+	fs.StringVar(&cfg.users, "users", env("users", ""), "htpasswd file of the users allowed in, anyone if empty")
+	fs.StringVar(&cfg.realm, "realm", env("realm", "webdav"), "basic authentication realm")
+	fs.BoolVar(&cfg.readOnly, "read-only", envBool("read-only"), "refuse PUT, COPY and DELETE")
+	fs.BoolVar(&cfg.listings, "listings", envBool("listings"), "answer GET of a directory with a listing")
+	fs.BoolVar(&cfg.zip, "zip", envBool("zip"), "offer directories as zip downloads")
+	fs.BoolVar(&cfg.changes, "changes", envBool("changes"), "serve a change feed at <directory>/.changes")
+	fs.BoolVar(&cfg.fakeLocks, "fake-locks", envBool("fake-locks"), "grant LOCK without locking, for Office and Finder")
+	fs.Int64Var(&cfg.maxUpload, "max-upload", envInt("max-upload"), "largest file accepted in bytes, 0 for no limit")
+	fs.DurationVar(&cfg.grace, "grace", envDuration("grace", 30*time.Second), "time running requests get to finish on shutdown")
+	fs.BoolVar(&cfg.check, "check", false, "validate the configuration and exit")
+	fs.StringVar(&cfg.log, "log", env("log", ""), `where to log: "stderr", or a directory for log files`)
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected arguments %q", fs.Args())
+	}
+	return cfg, nil
+}
+
+// setLog points glog, whose flags are in fs, at dest: stderr, a directory,
+// or where glog's flags say if dest is empty
+func setLog(fs *flag.FlagSet, dest string) error {
+	switch dest {
+	case "":
+		return nil
+	case "stderr":
+		return fs.Set("logtostderr", "true")
+	}
+	fi, err := os.Stat(dest)
+	if err != nil {
+		return fmt.Errorf("-log: %v", err)
+	}
+	if !fi.IsDir() {
+		return fmt.Errorf("-log: %s is not a directory", dest)
+	}
+	return fs.Set("log_dir", dest)
+}
+
+// env returns the environment variable for flag name, or def
+func env(name, def string) string {
+	if v, ok := os.LookupEnv("WEBDAVD_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))); ok {
+		return v
+	}
+	return def
+}
+
+func envBool(name string) bool {
+	b, _ := strconv.ParseBool(env(name, "false"))
+	return b
+}
+
+func envInt(name string) int64 {
+	n, _ := strconv.ParseInt(env(name, "0"), 10, 64)
+	return n
+}
+
+func envDuration(name string, def time.Duration) time.Duration {
+	if d, err := time.ParseDuration(env(name, "")); err == nil {
+		return d
+	}
+	return def
+}
+
+// handler builds the http.Handler for cfg, checking everything that can
+// be checked before listening
+func handler(cfg *config) (*webdav.Server, http.Handler, error) {
+	fi, err := os.Stat(cfg.root)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !fi.IsDir() {
+		return nil, nil, fmt.Errorf("%s is not a directory", cfg.root)
+	}
+	if (cfg.cert == "") != (cfg.key == "") {
+		return nil, nil, errors.New("-cert and -key go together")
+	}
+	if cfg.cert != "" {
+		if _, err := tls.LoadX509KeyPair(cfg.cert, cfg.key); err != nil {
+			return nil, nil, err
+		}
+	}
+	if cfg.maxUpload < 0 {
+		return nil, nil, errors.New("-max-upload must not be negative")
+	}
+
+	prefix := "/" + strings.Trim(cfg.prefix, "/")
+	if prefix != "/" {
+		prefix += "/"
+	}
+	dav := &webdav.Server{
+		Fs:            webdav.NewLockedFS(webdav.Dir(cfg.root)),
+		TrimPrefix:    prefix,
+		ReadOnly:      cfg.readOnly,
+		Listings:      cfg.listings,
+		MaxUploadSize: cfg.maxUpload,
+		FakeLocks:     cfg.fakeLocks,
+		ZipDownloads:  cfg.zip,
+		ChangeFeed:    cfg.changes,
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(prefix, dav)
+	if prefix != "/" {
+		// the prefix itself, without the slash
+		mux.Handle(strings.TrimSuffix(prefix, "/"), dav)
+	}
+
+	var h http.Handler = mux
+	if cfg.users != "" {
+		u, err := loadUsers(cfg.users)
+		if err != nil {
+			return nil, nil, err
+		}
+		h = u.basicAuth(cfg.realm, h)
+	}
+	return dav, h, nil
+}
+
+func run(cfg *config) error {
+	dav, h, err := handler(cfg)
+	if err != nil {
+		return err
+	}
+	if cfg.check {
+		fmt.Println("webdavd: configuration ok")
+		return nil
+	}
+	defer dav.Close()
+
+	srv := &http.Server{
+		Addr:              cfg.addr,
+		Handler:           h,
+		ReadHeaderTimeout: 30 * time.Second,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	errc := make(chan error, 1)
+	go func() {
+		glog.Infoln("webdavd: serving", cfg.root, "on", cfg.addr, "under", dav.TrimPrefix)
+		if cfg.cert != "" {
+			errc <- srv.ListenAndServeTLS(cfg.cert, cfg.key)
+		} else {
+			errc <- srv.ListenAndServe()
+		}
+	}()
+
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+
+	glog.Infoln("webdavd: shutting down")
+	sctx, cancel := context.WithTimeout(context.Background(), cfg.grace)
+	defer cancel()
+	return srv.Shutdown(sctx)
+}
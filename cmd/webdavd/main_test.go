@@ -0,0 +1,184 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeUsers(t *testing.T, lines ...string) string {
+	t.Helper()
+	name := filepath.Join(t.TempDir(), "htpasswd")
+	if err := os.WriteFile(name, []byte(strings.Join(lines, "\n")+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+func TestLoadUsers(t *testing.T) {
+	u, err := loadUsers(writeUsers(t,
+		"# comment",
+		"md5:$apr1$saltsalt$LrttParrLPdxvgutaSXWJ0",
+		"sha:{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=",
+		"plain:secret",
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, user := range []string{"md5", "sha", "plain"} {
+		if !u.check(user, "secret") {
+			t.Errorf("%s: secret refused", user)
+		}
+		if u.check(user, "Secret") {
+			t.Errorf("%s: Secret accepted", user)
+		}
+	}
+	if u.check("nobody", "secret") {
+		t.Error("unknown user accepted")
+	}
+
+	for _, tc := range []struct{ hash, err string }{
+		{"$2y$05$c4WoMPo3SXsafkva.HHa6uXQZWr7oboPiC2bT/r7q1BB8I2s0BRqC", "bcrypt"},
+		{"$5$saltsalt$0IyaXrmV7.sGNS6tirgqHLqX/G.FBvgkYA.lpPdS5sA", "$5$"},
+		{"$6$saltsalt$TVLlQcbpFVof5W3Yz4DTP6gRstiNuHwwTt6GLc1E5n0U0aDehy0S5knV8wiOQSpT0Y77vwPZN.Pq.H91p5hVO1", "$6$"},
+		{"abJnggxhB/yWI", "DES"},
+	} {
+		_, err := loadUsers(writeUsers(t, "plain:secret", "alice:"+tc.hash))
+		if err == nil || !strings.Contains(err.Error(), tc.err) || !strings.Contains(err.Error(), ":2:") {
+			t.Errorf("%s: %v, want an error about %s on line 2", tc.hash, err, tc.err)
+		}
+	}
+	if _, err := loadUsers(writeUsers(t, "# nobody")); err == nil {
+		t.Error("a file without users loaded")
+	}
+}
+
+func TestParseFlags(t *testing.T) {
+	t.Setenv("WEBDAVD_ROOT", "/from/env")
+	t.Setenv("WEBDAVD_MAX_UPLOAD", "1024")
+	t.Setenv("WEBDAVD_READ_ONLY", "true")
+	cfg, err := parseFlags(flag.NewFlagSet("webdavd", flag.ContinueOnError), []string{"-root", "/from/flag", "-log", "stderr"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.root != "/from/flag" || cfg.maxUpload != 1024 || !cfg.readOnly || cfg.log != "stderr" {
+		t.Errorf("config %+v", cfg)
+	}
+	if _, err := parseFlags(flag.NewFlagSet("webdavd", flag.ContinueOnError), []string{"extra"}); err == nil {
+		t.Error("extra argument accepted")
+	}
+}
+
+func TestSetLog(t *testing.T) {
+	// the flags glog registers
+	fs := flag.NewFlagSet("glog", flag.ContinueOnError)
+	toStderr := fs.Bool("logtostderr", false, "")
+	dir := fs.String("log_dir", "", "")
+
+	if err := setLog(fs, ""); err != nil || *toStderr || *dir != "" {
+		t.Errorf("empty -log: %v, logtostderr %v, log_dir %q", err, *toStderr, *dir)
+	}
+	if err := setLog(fs, "stderr"); err != nil || !*toStderr {
+		t.Errorf("-log stderr: %v, logtostderr %v", err, *toStderr)
+	}
+	logs := t.TempDir()
+	if err := setLog(fs, logs); err != nil || *dir != logs {
+		t.Errorf("-log %s: %v, log_dir %q", logs, err, *dir)
+	}
+	if err := setLog(fs, filepath.Join(logs, "missing")); err == nil {
+		t.Error("-log of a missing directory accepted")
+	}
+}
+
+// TestServe runs the handler of a configuration, with users and a
+// prefix, and sends it what a client would
+func TestServe(t *testing.T) {
+	root := t.TempDir()
+	cfg := &config{
+		root:     root,
+		prefix:   "dav",
+		users:    writeUsers(t, "alice:$apr1$saltsalt$LrttParrLPdxvgutaSXWJ0"),
+		realm:    "files",
+		listings: true,
+	}
+	dav, h, err := handler(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dav.Close()
+	ts := httptest.NewServer(h)
+	defer ts.Close()
+
+	do := func(method, path, body, user string) (*http.Response, string) {
+		t.Helper()
+		req, err := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if user != "" {
+			req.SetBasicAuth(user, "secret")
+		}
+		resp, err := ts.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(resp.Body)
+		return resp, string(b)
+	}
+
+	resp, _ := do("PUT", "/dav/f.txt", "hello", "")
+	if resp.StatusCode != http.StatusUnauthorized || !strings.Contains(resp.Header.Get("WWW-Authenticate"), `realm="files"`) {
+		t.Errorf("anonymous PUT: %d, %q", resp.StatusCode, resp.Header.Get("WWW-Authenticate"))
+	}
+	if resp, _ := do("PUT", "/dav/f.txt", "hello", "alice"); resp.StatusCode != http.StatusCreated {
+		t.Errorf("PUT: %d", resp.StatusCode)
+	}
+	if b, err := os.ReadFile(filepath.Join(root, "f.txt")); err != nil || string(b) != "hello" {
+		t.Errorf("f.txt holds %q, %v", b, err)
+	}
+	if resp, body := do("GET", "/dav/f.txt", "", "alice"); resp.StatusCode != http.StatusOK || body != "hello" {
+		t.Errorf("GET: %d %q", resp.StatusCode, body)
+	}
+	if resp, _ := do("GET", "/dav", "", "alice"); resp.StatusCode != http.StatusOK {
+		t.Errorf("GET of the prefix: %d", resp.StatusCode)
+	}
+	if resp, _ := do("GET", "/f.txt", "", "alice"); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("GET outside the prefix: %d", resp.StatusCode)
+	}
+
+	cfg.readOnly = true
+	ro, h, err := handler(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ro.Close()
+	ts.Close()
+	ts = httptest.NewServer(h)
+	defer ts.Close()
+	if resp, _ := do("DELETE", "/dav/f.txt", "", "alice"); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("read-only DELETE: %d", resp.StatusCode)
+	}
+}
+
+func TestHandlerRejects(t *testing.T) {
+	root := t.TempDir()
+	file := filepath.Join(root, "file")
+	os.WriteFile(file, nil, 0o600)
+	for _, cfg := range []*config{
+		{root: filepath.Join(root, "missing")},
+		{root: file},
+		{root: root, cert: "cert.pem"},
+		{root: root, maxUpload: -1},
+		{root: root, users: writeUsers(t, "alice:$6$saltsalt$x")},
+	} {
+		if _, _, err := handler(cfg); err == nil {
+			t.Errorf("%+v accepted", cfg)
+		}
+	}
+}
@@ -0,0 +1,273 @@
+package webdav
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// exchange is a request sent by conformanceClient and its answer, with
+// both dumped for failure messages
+type exchange struct {
+	resp *http.Response
+	body string
+	dump string
+}
+
+// want fails the test unless the answer has status
+func (e *exchange) want(t *testing.T, status int) {
+	t.Helper()
+	if e.resp.StatusCode != status {
+		t.Fatalf("status %d, want %d\n%s", e.resp.StatusCode, status, e.dump)
+	}
+}
+
+// errorf fails the test, printing the exchange
+func (e *exchange) errorf(t *testing.T, format string, args ...any) {
+	t.Helper()
+	t.Errorf(format+"\n%s", append(args, e.dump)...)
+}
+
+// conformanceClient sends requests to a test server as a user of its
+// basic auth, or as nobody if user is empty
+type conformanceClient struct {
+	ts         *httptest.Server
+	user, pass string
+}
+
+func (c conformanceClient) do(t *testing.T, method, name, body string, header ...string) *exchange {
+	t.Helper()
+	var r io.Reader
+	if body != "" {
+		r = strings.NewReader(body)
+	}
+	req, err := http.NewRequest(method, c.ts.URL+name, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	if c.user != "" {
+		req.SetBasicAuth(c.user, c.pass)
+	}
+	reqDump, _ := httputil.DumpRequestOut(req, true)
+	resp, err := c.ts.Client().Do(req)
+	if err != nil {
+		t.Fatalf("%v\n%s", err, reqDump)
+	}
+	defer resp.Body.Close()
+	respDump, _ := httputil.DumpResponse(resp, true)
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &exchange{resp, string(b), "--- request\n" + string(reqDump) + "\n--- response\n" + string(respDump)}
+}
+
+// requireAuth lets only user with pass through to h, as cmd/webdavd does
+func requireAuth(h http.Handler, user, pass string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if u, p, ok := r.BasicAuth(); !ok || u != user || p != pass {
+			w.Header().Set("WWW-Authenticate", `Basic realm="webdav"`)
+			w.WriteHeader(StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// refuseRemoveFS fails the Remove of one name
+type refuseRemoveFS struct {
+	FileSystem
+	name string
+}
+
+func (f refuseRemoveFS) Remove(name string) error {
+	if path.Clean("/"+name) == f.name {
+		return errors.New("refused")
+	}
+	return f.FileSystem.Remove(name)
+}
+
+// testServerConformance runs client sequences against a Server over the
+// FileSystem newFS returns, so every backend can be held to the same
+// behavior. A failure prints the request and response that caused it.
+func testServerConformance(t *testing.T, newFS func(t *testing.T) FileSystem) {
+	fsys := newFS(t)
+	var mu sync.Mutex
+	var principals []string
+	s := &Server{
+		Fs:          fsys,
+		TrimPrefix:  "/",
+		Listings:    true,
+		FakeLocks:   true,
+		BatchDelete: true,
+		Accounting: func(u Usage) {
+			mu.Lock()
+			principals = append(principals, u.Principal)
+			mu.Unlock()
+		},
+	}
+	ts := httptest.NewServer(requireAuth(s, "alice", "secret"))
+	t.Cleanup(ts.Close)
+	c := conformanceClient{ts, "alice", "secret"}
+
+	t.Run("auth challenge", func(t *testing.T) {
+		for _, anon := range []conformanceClient{{ts: ts}, {ts, "alice", "wrong"}} {
+			e := anon.do(t, "PUT", "/intruder", "x")
+			e.want(t, StatusUnauthorized)
+			if !strings.HasPrefix(e.resp.Header.Get("WWW-Authenticate"), "Basic ") {
+				e.errorf(t, "no Basic challenge")
+			}
+		}
+		c.do(t, "GET", "/intruder", "").want(t, StatusNotFound)
+
+		mu.Lock()
+		principals = nil
+		mu.Unlock()
+		c.do(t, "PUT", "/mine", "x").want(t, StatusCreated)
+		mu.Lock()
+		defer mu.Unlock()
+		if len(principals) != 1 || principals[0] != "alice" {
+			t.Errorf("accounted principals %q, want alice", principals)
+		}
+	})
+
+	t.Run("put then get ranges", func(t *testing.T) {
+		var b strings.Builder
+		for i := 0; i < 100; i++ {
+			b.WriteString("0123456789")
+		}
+		content := b.String()
+		c.do(t, "PUT", "/ranges.txt", content).want(t, StatusCreated)
+
+		e := c.do(t, "GET", "/ranges.txt", "")
+		e.want(t, StatusOK)
+		if e.body != content {
+			e.errorf(t, "GET returned %d bytes, want %d", len(e.body), len(content))
+		}
+		for _, tc := range []struct{ rng, want string }{
+			{"bytes=10-19", "0123456789"},
+			{"bytes=995-", "56789"},
+			{"bytes=-3", "789"},
+		} {
+			e := c.do(t, "GET", "/ranges.txt", "", "Range", tc.rng)
+			e.want(t, StatusPartialContent)
+			if e.body != tc.want {
+				e.errorf(t, "Range %s = %q, want %q", tc.rng, e.body, tc.want)
+			}
+		}
+		e = c.do(t, "GET", "/ranges.txt", "", "Range", "bytes=0-1,5-6")
+		e.want(t, StatusPartialContent)
+		if ct := e.resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "multipart/byteranges") {
+			e.errorf(t, "two ranges answered as %q", ct)
+		}
+		c.do(t, "GET", "/ranges.txt", "", "Range", "bytes=5000-").want(t, http.StatusRequestedRangeNotSatisfiable)
+	})
+
+	t.Run("nested put then listing", func(t *testing.T) {
+		// MKCOL and PROPFIND aren't served; PUT makes the collection and
+		// the listing shows its members
+		c.do(t, "PUT", "/coll/a.txt", "a").want(t, StatusCreated)
+		c.do(t, "PUT", "/coll/b.txt", "bb").want(t, StatusCreated)
+		e := c.do(t, "GET", "/coll", "", "Accept", "application/json")
+		e.want(t, StatusOK)
+		var entries []listEntry
+		if err := json.Unmarshal([]byte(e.body), &entries); err != nil {
+			e.errorf(t, "listing: %v", err)
+		}
+		var names []string
+		for _, le := range entries {
+			names = append(names, le.Name)
+		}
+		if strings.Join(names, ",") != "a.txt,b.txt" {
+			e.errorf(t, "listing %v, want a.txt and b.txt", names)
+		}
+		c.do(t, "PUT", "/coll", "x").want(t, StatusMethodNotAllowed)
+	})
+
+	t.Run("lock put unlock", func(t *testing.T) {
+		e := c.do(t, "LOCK", "/locked.txt", `<?xml version="1.0"?><lockinfo xmlns="DAV:"><lockscope><exclusive/></lockscope><locktype><write/></locktype></lockinfo>`)
+		e.want(t, StatusCreated)
+		token := e.resp.Header.Get("Lock-Token")
+		if token == "" {
+			e.errorf(t, "no Lock-Token")
+		}
+		c.do(t, "PUT", "/locked.txt", "content", "If", "("+token+")").want(t, StatusNoContent)
+		c.do(t, "UNLOCK", "/locked.txt", "", "Lock-Token", token).want(t, StatusNoContent)
+		if e := c.do(t, "GET", "/locked.txt", ""); e.body != "content" {
+			e.errorf(t, "locked file holds %q", e.body)
+		}
+	})
+
+	t.Run("copy without overwrite", func(t *testing.T) {
+		c.do(t, "PUT", "/src.txt", "new").want(t, StatusCreated)
+		c.do(t, "PUT", "/dst.txt", "old").want(t, StatusCreated)
+		c.do(t, "COPY", "/src.txt", "", "Destination", ts.URL+"/dst.txt", "Overwrite", "F").want(t, StatusPreconditionFailed)
+		if e := c.do(t, "GET", "/dst.txt", ""); e.body != "old" {
+			e.errorf(t, "COPY with Overwrite: F replaced the destination with %q", e.body)
+		}
+		c.do(t, "COPY", "/src.txt", "", "Destination", ts.URL+"/dst.txt").want(t, StatusNoContent)
+		if e := c.do(t, "GET", "/dst.txt", ""); e.body != "new" {
+			e.errorf(t, "destination holds %q after COPY", e.body)
+		}
+	})
+
+	t.Run("delete tree with an undeletable member", func(t *testing.T) {
+		for _, name := range []string{"/tree/a", "/tree/keep", "/tree/b"} {
+			c.do(t, "PUT", name, "x").want(t, StatusCreated)
+		}
+		rs := &Server{Fs: refuseRemoveFS{fsys, "/tree/keep"}, TrimPrefix: "/", BatchDelete: true}
+		rts := httptest.NewServer(rs)
+		defer rts.Close()
+		rc := conformanceClient{ts: rts}
+
+		e := rc.do(t, "POST", "/tree", `{"members": [{"href": "/tree/a"}, {"href": "/tree/keep"}, {"href": "/tree/b"}]}`,
+			"Content-Type", "application/json")
+		e.want(t, StatusMulti)
+		for _, want := range []string{
+			"<D:href>/tree/a</D:href><D:status>HTTP/1.1 204 ",
+			"<D:href>/tree/keep</D:href><D:status>HTTP/1.1 500 ",
+			"<D:href>/tree/b</D:href><D:status>HTTP/1.1 204 ",
+		} {
+			if !strings.Contains(e.body, want) {
+				e.errorf(t, "multistatus lacks %s", want)
+			}
+		}
+		c.do(t, "GET", "/tree/a", "").want(t, StatusNotFound)
+		c.do(t, "GET", "/tree/b", "").want(t, StatusNotFound)
+		c.do(t, "GET", "/tree/keep", "").want(t, StatusOK)
+	})
+
+	t.Run("conditional put", func(t *testing.T) {
+		c.do(t, "PUT", "/cond.txt", "v1", "If-None-Match", "*").want(t, StatusCreated)
+		c.do(t, "PUT", "/cond.txt", "v2", "If-None-Match", "*").want(t, StatusPreconditionFailed)
+		c.do(t, "PUT", "/cond.txt", "v2", "If-Match", `"stale"`).want(t, StatusPreconditionFailed)
+		c.do(t, "PUT", "/absent.txt", "v1", "If-Match", "*").want(t, StatusPreconditionFailed)
+		c.do(t, "GET", "/absent.txt", "").want(t, StatusNotFound)
+		c.do(t, "PUT", "/cond.txt", "v2", "If-Match", "*").want(t, StatusNoContent)
+		if e := c.do(t, "GET", "/cond.txt", ""); e.body != "v2" {
+			e.errorf(t, "cond.txt holds %q", e.body)
+		}
+	})
+}
+
+func TestServerConformance(t *testing.T) {
+	t.Run("memfs", func(t *testing.T) {
+		testServerConformance(t, func(t *testing.T) FileSystem { return NewMemFS() })
+	})
+	t.Run("dir", func(t *testing.T) {
+		testServerConformance(t, func(t *testing.T) FileSystem { return Dir(t.TempDir()) })
+	})
+	t.Run("lockedfs", func(t *testing.T) {
+		testServerConformance(t, func(t *testing.T) FileSystem { return NewLockedFS(Dir(t.TempDir())) })
+	})
+}
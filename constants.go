@@ -11,6 +11,7 @@ const (
 	StatusCreated             = http.StatusCreated
 	StatusAccepted            = http.StatusAccepted
 	StatusNoContent           = http.StatusNoContent
+	StatusPartialContent      = http.StatusPartialContent
 	StatusMovedPermanently    = http.StatusMovedPermanently
 	StatusMovedTemporarily    = 302 // TODO: duplicate of http.StatusFound ?
 	StatusNotModified         = http.StatusNotModified
@@ -18,11 +19,14 @@ const (
 	StatusUnauthorized        = http.StatusUnauthorized
 	StatusForbidden           = http.StatusForbidden
 	StatusNotFound            = http.StatusNotFound
+	StatusRequestTimeout      = http.StatusRequestTimeout
 	StatusInternalServerError = http.StatusInternalServerError
 	StatusNotImplemented      = http.StatusNotImplemented
 	StatusMethodNotAllowed    = http.StatusMethodNotAllowed
 	StatusConflict            = http.StatusConflict
 	StatusPreconditionFailed  = http.StatusPreconditionFailed
+	StatusRequestTooLarge     = http.StatusRequestEntityTooLarge
+	StatusServiceUnavailable  = http.StatusServiceUnavailable
 )
 
 // extended status codes, http://www.webdav.org/specs/rfc4918.html#status.code.extensions.to.http11
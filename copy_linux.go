@@ -0,0 +1,22 @@
+//go:build linux
+
+package webdav
+
+import (
+	"os"
+	"syscall"
+)
+
+// FICLONE from linux/fs.h
+const ficlone = 0x40049409
+
+// cloneFile asks the kernel to share src's extents with dst (btrfs, xfs,
+// overlayfs on top of those). Filesystems without reflink support fail
+// with EOPNOTSUPP/EXDEV/EINVAL and the caller falls back to copying.
+func cloneFile(dst, src *os.File) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, dst.Fd(), ficlone, src.Fd())
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
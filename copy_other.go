@@ -0,0 +1,11 @@
+//go:build !linux
+
+package webdav
+
+import "os"
+
+// cloneFile is not available on this platform, the caller always falls
+// back to copying.
+func cloneFile(dst, src *os.File) error {
+	return ErrNotImplemented
+}
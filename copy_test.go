@@ -0,0 +1,232 @@
+package webdav
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"syscall"
+	"testing"
+)
+
+// faultyFile fails its Write once failAfter bytes were written, or its
+// Close or Sync with the given errors
+type faultyFile struct {
+	File
+	failAfter int64 // negative for never
+	closeErr  error
+	syncErr   error
+	syncs     *atomic.Int32
+	written   int64
+}
+
+func (f *faultyFile) Write(p []byte) (int, error) {
+	if f.failAfter >= 0 && f.written+int64(len(p)) > f.failAfter {
+		n, _ := f.File.Write(p[:f.failAfter-f.written])
+		f.written += int64(n)
+		return n, syscall.ENOSPC
+	}
+	n, err := f.File.Write(p)
+	f.written += int64(n)
+	return n, err
+}
+
+func (f *faultyFile) Sync() error {
+	f.syncs.Add(1)
+	if f.syncErr != nil {
+		return f.syncErr
+	}
+	return f.File.(*os.File).Sync()
+}
+
+func (f *faultyFile) Close() error {
+	err := f.File.Close()
+	if f.closeErr != nil {
+		return f.closeErr
+	}
+	return err
+}
+
+// faultyFS is a Dir whose new files fail as fault says: "write" midway
+// through, "close" and "close-enospc" when closed, "sync" when synced, and
+// "native" fails CopyFile after writing half of the copy. It counts the
+// Syncs of its files in syncs.
+type faultyFS struct {
+	Dir
+	fault string
+	syncs *atomic.Int32
+}
+
+func (d faultyFS) wrap(f File) File {
+	ff := &faultyFile{File: f, failAfter: -1, syncs: d.syncs}
+	if ff.syncs == nil {
+		ff.syncs = new(atomic.Int32)
+	}
+	switch d.fault {
+	case "write":
+		ff.failAfter = 100 << 10
+	case "close":
+		ff.closeErr = errors.New("close failed")
+	case "close-enospc":
+		ff.closeErr = &os.PathError{Op: "close", Path: "x", Err: syscall.ENOSPC}
+	case "sync":
+		ff.syncErr = syscall.EIO
+	}
+	return ff
+}
+
+func (d faultyFS) Create(name string) (File, error) {
+	f, err := d.Dir.Create(name)
+	if err != nil {
+		return nil, err
+	}
+	return d.wrap(f), nil
+}
+
+func (d faultyFS) CreateTemp(dir, pattern string) (File, string, error) {
+	f, name, err := d.Dir.CreateTemp(dir, pattern)
+	if err != nil {
+		return nil, "", err
+	}
+	return d.wrap(f), name, nil
+}
+
+func (d faultyFS) CopyFile(src, dst string) error {
+	if d.fault != "native" {
+		// copied through Write, where the other faults happen
+		return ErrNotImplemented
+	}
+	in, err := d.Dir.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := d.Dir.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	buf := make([]byte, 1000)
+	in.Read(buf)
+	out.Write(buf)
+	return errors.New("native copy failed")
+}
+
+// renameCopierDir is a renameOnlyDir that also copies natively
+type renameCopierDir struct {
+	renameOnlyDir
+	Copier
+}
+
+// copyBackends are the ways COPY can write its destination
+var copyBackends = map[string]func(fsys faultyFS) FileSystem{
+	"tempfile": func(fsys faultyFS) FileSystem { return fsys },
+	"rename":   func(fsys faultyFS) FileSystem { return renameCopierDir{renameOnlyDir{fsys, fsys, fsys}, fsys} },
+}
+
+func TestCopyFailureKeepsDestination(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789abcdef"), 16<<10)
+	for name, mk := range copyBackends {
+		for _, tc := range []struct {
+			fault  string
+			status int
+		}{
+			{"write", StatusInsufficientStorage},
+			{"close", StatusConflict},
+			{"native", StatusConflict},
+		} {
+			t.Run(name+"/"+tc.fault, func(t *testing.T) {
+				root := t.TempDir()
+				if err := os.WriteFile(filepath.Join(root, "src"), content, 0644); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(filepath.Join(root, "old"), []byte("previous"), 0640); err != nil {
+					t.Fatal(err)
+				}
+				ts := newTestServer(t, &Server{Fs: mk(faultyFS{Dir: Dir(root), fault: tc.fault})})
+
+				resp, _ := request(t, ts, "COPY", "/src", "", "Destination", ts.URL+"/old")
+				wantStatus(t, resp, tc.status)
+				resp, _ = request(t, ts, "COPY", "/src", "", "Destination", ts.URL+"/new")
+				wantStatus(t, resp, tc.status)
+
+				if b, err := os.ReadFile(filepath.Join(root, "old")); err != nil || string(b) != "previous" {
+					t.Errorf("destination = %q, %v after a failed COPY", b, err)
+				}
+				if fi, err := os.Stat(filepath.Join(root, "old")); err != nil || fi.Mode().Perm() != 0640 {
+					t.Errorf("destination mode changed by a failed COPY: %v, %v", fi.Mode(), err)
+				}
+				if _, err := os.Stat(filepath.Join(root, "new")); !os.IsNotExist(err) {
+					t.Errorf("failed COPY to a new name left it: %v", err)
+				}
+				if tmp := tempEntries(t, root); len(tmp) > 0 {
+					t.Errorf("temporary files left: %v", tmp)
+				}
+			})
+		}
+	}
+}
+
+func TestCopyReplacesDestination(t *testing.T) {
+	backends := map[string]func(root string) FileSystem{
+		"dir":      func(root string) FileSystem { return Dir(root) },
+		"rename":   func(root string) FileSystem { return renameOnlyDir{Dir(root), Dir(root), Dir(root)} },
+		"fallback": func(root string) FileSystem { return faultyFS{Dir: Dir(root)} },
+	}
+	for name, mk := range backends {
+		t.Run(name, func(t *testing.T) {
+			root := t.TempDir()
+			if err := os.WriteFile(filepath.Join(root, "src"), []byte("content"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			old := filepath.Join(root, "old")
+			if err := os.WriteFile(old, []byte("previous"), 0640); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Chmod(old, 0640); err != nil {
+				t.Fatal(err)
+			}
+			ts := newTestServer(t, &Server{Fs: mk(root)})
+
+			resp, _ := request(t, ts, "COPY", "/src", "", "Destination", ts.URL+"/old")
+			wantStatus(t, resp, StatusNoContent)
+			resp, _ = request(t, ts, "COPY", "/src", "", "Destination", ts.URL+"/new")
+			wantStatus(t, resp, StatusCreated)
+
+			for _, n := range []string{"old", "new"} {
+				if b, err := os.ReadFile(filepath.Join(root, n)); err != nil || string(b) != "content" {
+					t.Errorf("%s = %q, %v", n, b, err)
+				}
+			}
+			if fi, err := os.Stat(old); err != nil || fi.Mode().Perm() != 0640 {
+				t.Errorf("replaced destination has mode %v, %v, want 0640", fi.Mode(), err)
+			}
+			if tmp := tempEntries(t, root); len(tmp) > 0 {
+				t.Errorf("temporary files left: %v", tmp)
+			}
+		})
+	}
+}
+
+func TestCopyMemFS(t *testing.T) {
+	m := NewMemFS()
+	writeMem(t, m, "/src", []byte("content"))
+	writeMem(t, m, "/old", []byte("previous"))
+	ts := newTestServer(t, &Server{Fs: m})
+
+	resp, _ := request(t, ts, "COPY", "/src", "", "Destination", ts.URL+"/old")
+	wantStatus(t, resp, StatusNoContent)
+	resp, _ = request(t, ts, "COPY", "/src", "", "Destination", ts.URL+"/new")
+	wantStatus(t, resp, StatusCreated)
+
+	files := memFiles(t, m)
+	if len(files) != 3 {
+		t.Errorf("%d files after two COPYs, want 3", len(files))
+	}
+	for _, n := range []string{"/old", "/new"} {
+		if got := string(files[n].data); got != "content" {
+			t.Errorf("%s = %q", n, got)
+		}
+	}
+}
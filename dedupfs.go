@@ -0,0 +1,447 @@
+package webdav
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"hash"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// layout of a DedupFS on its inner FileSystem
+const (
+	dedupDir      = "/.dedup"
+	dedupObjects  = dedupDir + "/objects"
+	dedupTmp      = dedupDir + "/tmp"
+	dedupManifest = dedupDir + "/manifest.json"
+)
+
+// DedupFS stores every distinct file content once on an inner FileSystem,
+// under a path derived from its SHA-256, and keeps a manifest mapping the
+// visible paths to content hashes. Uploading the same content to several
+// paths costs only manifest entries, and Rename never touches content.
+//
+// The manifest is rewritten on every change, through a temporary file and
+// Rename, so a crash leaves either the old or the new version. Objects
+// written before a crash but never referenced are left behind. The inner
+// FileSystem must implement Renamer.
+type DedupFS struct {
+	fs FileSystem
+
+	mu       sync.Mutex
+	manifest dedupState
+	refs     map[string]int
+}
+
+type dedupState struct {
+	Files map[string]dedupEntry `json:"files"`
+	Dirs  map[string]time.Time  `json:"dirs"`
+}
+
+type dedupEntry struct {
+	Hash    string    `json:"hash"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mtime"`
+}
+
+// NewDedupFS returns a DedupFS storing its data on fs, loading the manifest
+// left by a previous instance if there is one
+func NewDedupFS(fs FileSystem) (*DedupFS, error) {
+	if _, ok := fs.(Renamer); !ok {
+		return nil, errors.New("dedupfs: inner FileSystem must implement Renamer")
+	}
+
+	d := &DedupFS{
+		fs: fs,
+		manifest: dedupState{
+			Files: make(map[string]dedupEntry),
+			Dirs:  make(map[string]time.Time),
+		},
+		refs: make(map[string]int),
+	}
+	for _, dir := range []string{dedupObjects, dedupTmp} {
+		if err := fs.Mkdir(dir); err != nil {
+			return nil, err
+		}
+	}
+
+	f, err := fs.Open(dedupManifest)
+	if os.IsNotExist(err) {
+		return d, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	if err := json.NewDecoder(f).Decode(&d.manifest); err != nil {
+		return nil, err
+	}
+	for _, e := range d.manifest.Files {
+		d.refs[e.Hash]++
+	}
+	return d, nil
+}
+
+func objectPath(sum string) string {
+	return path.Join(dedupObjects, sum[:2], sum)
+}
+
+// save writes the manifest, the caller holds d.mu
+func (d *DedupFS) save() error {
+	tmp := path.Join(dedupTmp, "manifest-"+randomSuffix())
+	f, err := d.fs.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	if err := json.NewEncoder(f).Encode(&d.manifest); err != nil {
+		f.Close()
+		d.fs.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		d.fs.Remove(tmp)
+		return err
+	}
+	return d.fs.(Renamer).Rename(tmp, dedupManifest)
+}
+
+// unref drops a reference to an object, deleting it with the last one.
+// The caller holds d.mu.
+func (d *DedupFS) unref(sum string) {
+	d.refs[sum]--
+	if d.refs[sum] > 0 {
+		return
+	}
+	delete(d.refs, sum)
+	d.fs.Remove(objectPath(sum))
+}
+
+// isDir reports whether name is a visible directory, the caller holds d.mu
+func (d *DedupFS) isDir(name string) bool {
+	if name == "/" {
+		return true
+	}
+	_, ok := d.manifest.Dirs[name]
+	return ok
+}
+
+// Open opens a file or directory for reading
+func (d *DedupFS) Open(name string) (File, error) {
+	name = path.Clean("/" + name)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.isDir(name) {
+		return &dedupDirFile{info: d.dirInfo(name), entries: d.list(name)}, nil
+	}
+
+	e, ok := d.manifest.Files[name]
+	if !ok {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+	f, err := d.fs.Open(objectPath(e.Hash))
+	if err != nil {
+		return nil, err
+	}
+	return &dedupFile{File: f, info: &memFileInfo{
+		name: path.Base(name), size: e.Size, mode: 0644, modTime: e.ModTime,
+	}}, nil
+}
+
+func (d *DedupFS) dirInfo(name string) *memFileInfo {
+	return &memFileInfo{name: path.Base(name), mode: os.ModeDir | 0755, modTime: d.manifest.Dirs[name]}
+}
+
+// list returns the entries of a directory, the caller holds d.mu
+func (d *DedupFS) list(dir string) []os.FileInfo {
+	var fis []os.FileInfo
+	for p, e := range d.manifest.Files {
+		if path.Dir(p) == dir {
+			fis = append(fis, &memFileInfo{name: path.Base(p), size: e.Size, mode: 0644, modTime: e.ModTime})
+		}
+	}
+	for p := range d.manifest.Dirs {
+		if path.Dir(p) == dir {
+			fis = append(fis, d.dirInfo(p))
+		}
+	}
+	sort.Slice(fis, func(i, j int) bool { return fis[i].Name() < fis[j].Name() })
+	return fis
+}
+
+// Create returns a file whose content is hashed while it is written and
+// stored, or found to be already stored, on Close
+func (d *DedupFS) Create(name string) (File, error) {
+	name = path.Clean("/" + name)
+
+	d.mu.Lock()
+	ok := d.isDir(path.Dir(name)) && !d.isDir(name)
+	d.mu.Unlock()
+	if !ok {
+		return nil, &os.PathError{Op: "create", Path: name, Err: os.ErrInvalid}
+	}
+
+	tmp := path.Join(dedupTmp, "object-"+randomSuffix())
+	f, err := d.fs.Create(tmp)
+	if err != nil {
+		return nil, err
+	}
+	return &dedupWriter{File: f, d: d, name: name, tmp: tmp, hash: sha256.New()}, nil
+}
+
+// Mkdir creates a directory and any missing parents
+func (d *DedupFS) Mkdir(name string) error {
+	name = path.Clean("/" + name)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	changed := false
+	for p := name; p != "/"; p = path.Dir(p) {
+		if _, ok := d.manifest.Files[p]; ok {
+			return &os.PathError{Op: "mkdir", Path: p, Err: errNotDir}
+		}
+		if !d.isDir(p) {
+			d.manifest.Dirs[p] = time.Now()
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	return d.save()
+}
+
+// Remove removes a file, deleting its content once no other path refers
+// to it, or an empty directory
+func (d *DedupFS) Remove(name string) error {
+	name = path.Clean("/" + name)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if e, ok := d.manifest.Files[name]; ok {
+		delete(d.manifest.Files, name)
+		if err := d.save(); err != nil {
+			d.manifest.Files[name] = e
+			return err
+		}
+		d.unref(e.Hash)
+		return nil
+	}
+
+	if name == "/" || !d.isDir(name) {
+		return &os.PathError{Op: "remove", Path: name, Err: os.ErrNotExist}
+	}
+	if len(d.list(name)) > 0 {
+		return &os.PathError{Op: "remove", Path: name, Err: errNotEmpty}
+	}
+	delete(d.manifest.Dirs, name)
+	return d.save()
+}
+
+// Rename moves a file or a directory tree by rewriting the manifest only
+func (d *DedupFS) Rename(oldname, newname string) error {
+	oldname, newname = path.Clean("/"+oldname), path.Clean("/"+newname)
+	if oldname == newname {
+		return nil
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if !d.isDir(path.Dir(newname)) || d.isDir(newname) || strings.HasPrefix(newname, oldname+"/") {
+		return &os.PathError{Op: "rename", Path: newname, Err: os.ErrInvalid}
+	}
+
+	if e, ok := d.manifest.Files[oldname]; ok {
+		old, replaced := d.manifest.Files[newname]
+		delete(d.manifest.Files, oldname)
+		d.manifest.Files[newname] = e
+		if err := d.save(); err != nil {
+			d.manifest.Files[oldname] = e
+			if replaced {
+				d.manifest.Files[newname] = old
+			} else {
+				delete(d.manifest.Files, newname)
+			}
+			return err
+		}
+		if replaced {
+			d.unref(old.Hash)
+		}
+		return nil
+	}
+
+	if oldname == "/" || !d.isDir(oldname) {
+		return &os.PathError{Op: "rename", Path: oldname, Err: os.ErrNotExist}
+	}
+	if _, ok := d.manifest.Files[newname]; ok {
+		return &os.PathError{Op: "rename", Path: newname, Err: os.ErrExist}
+	}
+
+	move := func(p string) (string, bool) {
+		if p == oldname {
+			return newname, true
+		}
+		if strings.HasPrefix(p, oldname+"/") {
+			return newname + strings.TrimPrefix(p, oldname), true
+		}
+		return p, false
+	}
+	for p, e := range d.manifest.Files {
+		if np, ok := move(p); ok {
+			delete(d.manifest.Files, p)
+			d.manifest.Files[np] = e
+		}
+	}
+	for p, t := range d.manifest.Dirs {
+		if np, ok := move(p); ok {
+			delete(d.manifest.Dirs, p)
+			d.manifest.Dirs[np] = t
+		}
+	}
+	return d.save()
+}
+
+// ETag returns the content hash of a file, see ETagger
+func (d *DedupFS) ETag(name string) (string, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	e, ok := d.manifest.Files[path.Clean("/"+name)]
+	if !ok {
+		return "", os.ErrNotExist
+	}
+	return e.Hash, nil
+}
+
+// commit stores the object written to tmp and points name at it
+func (d *DedupFS) commit(name, tmp, sum string, size int64) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.refs[sum] > 0 {
+		d.fs.Remove(tmp)
+	} else {
+		obj := objectPath(sum)
+		if err := d.fs.Mkdir(path.Dir(obj)); err != nil {
+			return err
+		}
+		if err := d.fs.(Renamer).Rename(tmp, obj); err != nil {
+			return err
+		}
+	}
+
+	old, replaced := d.manifest.Files[name]
+	d.manifest.Files[name] = dedupEntry{Hash: sum, Size: size, ModTime: time.Now()}
+	d.refs[sum]++
+	if err := d.save(); err != nil {
+		if replaced {
+			d.manifest.Files[name] = old
+		} else {
+			delete(d.manifest.Files, name)
+		}
+		d.unref(sum)
+		return err
+	}
+	if replaced {
+		d.unref(old.Hash)
+	}
+	return nil
+}
+
+// dedupWriter hashes content on its way to a temporary object
+type dedupWriter struct {
+	File
+	d    *DedupFS
+	name string
+	tmp  string
+	hash hash.Hash
+	size int64
+}
+
+func (w *dedupWriter) Write(p []byte) (int, error) {
+	n, err := w.File.Write(p)
+	w.hash.Write(p[:n])
+	w.size += int64(n)
+	return n, err
+}
+
+func (w *dedupWriter) Seek(offset int64, whence int) (int64, error) {
+	// hashing on the way in requires sequential writes
+	if offset == 0 && whence == io.SeekCurrent {
+		return w.size, nil
+	}
+	return 0, &os.PathError{Op: "seek", Path: w.name, Err: os.ErrInvalid}
+}
+
+func (w *dedupWriter) Stat() (os.FileInfo, error) {
+	return &memFileInfo{name: path.Base(w.name), size: w.size, mode: 0644, modTime: time.Now()}, nil
+}
+
+func (w *dedupWriter) Close() error {
+	if err := w.File.Close(); err != nil {
+		w.d.fs.Remove(w.tmp)
+		return err
+	}
+	return w.d.commit(w.name, w.tmp, hex.EncodeToString(w.hash.Sum(nil)), w.size)
+}
+
+// dedupFile reads an object under its visible name and times
+type dedupFile struct {
+	File
+	info os.FileInfo
+}
+
+func (f *dedupFile) Stat() (os.FileInfo, error) {
+	return f.info, nil
+}
+
+// dedupDirFile lists a directory from the manifest
+type dedupDirFile struct {
+	info    os.FileInfo
+	entries []os.FileInfo
+}
+
+func (f *dedupDirFile) Stat() (os.FileInfo, error) {
+	return f.info, nil
+}
+
+func (f *dedupDirFile) Readdir(count int) ([]os.FileInfo, error) {
+	if count <= 0 || count > len(f.entries) {
+		if count > 0 && len(f.entries) == 0 {
+			return nil, io.EOF
+		}
+		count = len(f.entries)
+	}
+	fis := f.entries[:count]
+	f.entries = f.entries[count:]
+	return fis, nil
+}
+
+func (f *dedupDirFile) Read([]byte) (int, error) {
+	return 0, &os.PathError{Op: "read", Path: f.info.Name(), Err: errIsDir}
+}
+
+func (f *dedupDirFile) Write([]byte) (int, error) {
+	return 0, &os.PathError{Op: "write", Path: f.info.Name(), Err: errIsDir}
+}
+
+func (f *dedupDirFile) Seek(int64, int) (int64, error) {
+	return 0, nil
+}
+
+func (f *dedupDirFile) Close() error {
+	return nil
+}
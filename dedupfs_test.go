@@ -0,0 +1,130 @@
+package webdav
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// objects returns the sizes of the objects a DedupFS keeps in root
+func objects(t testing.TB, root string) []int64 {
+	t.Helper()
+	var sizes []int64
+	err := filepath.Walk(filepath.Join(root, filepath.FromSlash(dedupObjects)), func(p string, fi os.FileInfo, err error) error {
+		if err == nil && fi.Mode().IsRegular() {
+			sizes = append(sizes, fi.Size())
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sizes
+}
+
+func readAll(t testing.TB, fsys FileSystem, name string) []byte {
+	t.Helper()
+	f, err := fsys.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	b, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestDedupFSStoresContentOnce(t *testing.T) {
+	root := t.TempDir()
+	d, err := NewDedupFS(Dir(root))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := newTestServer(t, &Server{Fs: d})
+
+	content := make([]byte, 10<<20)
+	for i := range content {
+		content[i] = byte(i*31 + i>>12)
+	}
+	sum := sha256.Sum256(content)
+	paths := []string{"/a.bin", "/one/b.bin", "/one/two/c.bin"}
+	if err := d.Mkdir("/one/two"); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range paths {
+		resp, _ := request(t, ts, "PUT", p, string(content))
+		wantStatus(t, resp, StatusCreated)
+	}
+
+	if got := objects(t, root); len(got) != 1 || got[0] != int64(len(content)) {
+		t.Fatalf("objects stored: %v, want one of %d bytes", got, len(content))
+	}
+	if tag, err := d.ETag(paths[1]); err != nil || tag != hex.EncodeToString(sum[:]) {
+		t.Errorf("ETag = %q, %v, want the SHA-256", tag, err)
+	}
+
+	if err := d.Remove(paths[0]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Open(paths[0]); !os.IsNotExist(err) {
+		t.Errorf("removed path opens: %v", err)
+	}
+	for _, p := range paths[1:] {
+		if !bytes.Equal(readAll(t, d, p), content) {
+			t.Errorf("%s changed after another copy was removed", p)
+		}
+	}
+	if got := objects(t, root); len(got) != 1 {
+		t.Errorf("objects stored after one removal: %v", got)
+	}
+
+	// a restart finds the same state
+	d2, err := NewDedupFS(Dir(root))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d2.Rename(paths[1], "/moved.bin"); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(readAll(t, d2, "/moved.bin"), content) {
+		t.Error("/moved.bin differs after the rename")
+	}
+	for _, p := range []string{"/moved.bin", paths[2]} {
+		if err := d2.Remove(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := objects(t, root); len(got) != 0 {
+		t.Errorf("objects left after every path was removed: %v", got)
+	}
+}
+
+func TestDedupFSReplaceDropsOldContent(t *testing.T) {
+	root := t.TempDir()
+	d, err := NewDedupFS(Dir(root))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, content := range []string{"first", "second"} {
+		f, err := d.Create("/f")
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(f, content)
+		if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := string(readAll(t, d, "/f")); got != "second" {
+		t.Errorf("/f = %q", got)
+	}
+	if got := objects(t, root); len(got) != 1 || got[0] != int64(len("second")) {
+		t.Errorf("objects stored: %v", got)
+	}
+}
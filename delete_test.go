@@ -0,0 +1,163 @@
+package webdav
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestDeleteStaleETag deletes a file with the ETag it had before another
+// client replaced it, on backends with and without ETags of their own
+func TestDeleteStaleETag(t *testing.T) {
+	for name, mk := range map[string]func(root string) FileSystem{
+		"dir":      func(root string) FileSystem { return Dir(root) },
+		"memfs":    func(string) FileSystem { return NewMemFS() },
+		"lockedfs": func(root string) FileSystem { return NewLockedFS(Dir(root)) },
+		"dedupfs": func(root string) FileSystem {
+			d, err := NewDedupFS(Dir(root))
+			if err != nil {
+				t.Fatal(err)
+			}
+			return d
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			fsys := mk(t.TempDir())
+			ts := newTestServer(t, &Server{Fs: fsys})
+
+			request(t, ts, "PUT", "/f.txt", "first")
+			resp, _ := request(t, ts, "GET", "/f.txt", "")
+			stale := resp.Header.Get("ETag")
+			if !strings.HasPrefix(stale, `"`) {
+				t.Fatalf("GET sent no strong ETag: %q", stale)
+			}
+			request(t, ts, "PUT", "/f.txt", "replaced by another client")
+			resp, _ = request(t, ts, "HEAD", "/f.txt", "")
+			current := resp.Header.Get("ETag")
+			if current == stale {
+				t.Fatalf("ETag %s unchanged by a PUT", current)
+			}
+
+			resp, _ = request(t, ts, "DELETE", "/f.txt", "", "If-Match", stale)
+			wantStatus(t, resp, StatusPreconditionFailed)
+			if got := string(readAll(t, fsys, "/f.txt")); got != "replaced by another client" {
+				t.Errorf("f.txt holds %q after a refused DELETE", got)
+			}
+			resp, _ = request(t, ts, "DELETE", "/f.txt", "", "If", "(["+stale+"])")
+			wantStatus(t, resp, StatusPreconditionFailed)
+
+			resp, _ = request(t, ts, "DELETE", "/f.txt", "", "If-Match", current)
+			wantStatus(t, resp, StatusNoContent)
+			resp, _ = request(t, ts, "DELETE", "/f.txt", "", "If-Match", "*")
+			wantStatus(t, resp, StatusNotFound)
+		})
+	}
+}
+
+// TestDeleteCollection deletes a tree, which is refused as there is no
+// recursive delete, after the preconditions on the tree and its members
+func TestDeleteCollection(t *testing.T) {
+	m := NewMemFS()
+	mkdirAll(m, "/tree/sub")
+	writeMem(t, m, "/tree/a", []byte("a"))
+	writeMem(t, m, "/tree/sub/b", []byte("b"))
+	ts := newTestServer(t, &Server{Fs: m})
+
+	resp, _ := request(t, ts, "HEAD", "/tree/sub/b", "")
+	current := resp.Header.Get("ETag")
+	child := ts.URL + "/tree/sub/b"
+	for _, tc := range []struct {
+		header []string
+		status int
+	}{
+		{nil, StatusForbidden},
+		{[]string{"If-Match", `"stale"`}, StatusPreconditionFailed},
+		{[]string{"If", "<" + child + `> (["stale"])`}, StatusPreconditionFailed},
+		{[]string{"If", "<" + child + "> ([" + current + "])"}, StatusForbidden},
+	} {
+		resp, _ := request(t, ts, "DELETE", "/tree", "", tc.header...)
+		if resp.StatusCode != tc.status {
+			t.Errorf("DELETE /tree %q: %d, want %d", tc.header, resp.StatusCode, tc.status)
+		}
+	}
+	for _, name := range []string{"/tree/a", "/tree/sub/b"} {
+		if _, err := (&Server{Fs: m}).stat(name); err != nil {
+			t.Errorf("%s: %v after DELETE of its collection", name, err)
+		}
+	}
+}
+
+func TestParseIf(t *testing.T) {
+	for _, tc := range []struct {
+		h    string
+		want []ifCondList
+	}{
+		{`(<urn:uuid:a>)`, []ifCondList{{"", []ifCond{{token: "urn:uuid:a"}}}}},
+		{`(<urn:uuid:a> ["x"]) (Not <DAV:no-lock>)`, []ifCondList{
+			{"", []ifCond{{token: "urn:uuid:a"}, {etag: `"x"`}}},
+			{"", []ifCond{{not: true, token: "DAV:no-lock"}}},
+		}},
+		{`<http://h/a> (["x"]) (["y"]) <http://h/b> (not [W/"z"])`, []ifCondList{
+			{"http://h/a", []ifCond{{etag: `"x"`}}},
+			{"http://h/a", []ifCond{{etag: `"y"`}}},
+			{"http://h/b", []ifCond{{not: true, etag: `W/"z"`}}},
+		}},
+		{"", nil},
+		{"()", nil},
+		{"(", nil},
+		{"(<a>", nil},
+		{`(["x"]`, nil},
+		{"<http://h/a>", nil},
+		{"<http://h/a> x", nil},
+		{"(x)", nil},
+		{"(<>)", nil},
+		{"(<a>) <http://h/b> (<c>)", nil},
+		{"<http://h/b> (<c>) (<a>) junk", nil},
+	} {
+		got, ok := parseIf(tc.h)
+		if ok != (tc.want != nil) || !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("parseIf(%q) = %+v, %v, want %+v", tc.h, got, ok, tc.want)
+		}
+	}
+}
+
+// TestIfHeader sends PUTs with If headers, lists of the request-URI and
+// of other resources, to Servers with and without FakeLocks
+func TestIfHeader(t *testing.T) {
+	for _, fake := range []bool{false, true} {
+		m := NewMemFS()
+		writeMem(t, m, "/f.txt", []byte("f"))
+		writeMem(t, m, "/other.txt", []byte("other"))
+		ts := newTestServer(t, &Server{Fs: m, FakeLocks: fake})
+		etag := func(name string) string {
+			resp, _ := request(t, ts, "HEAD", name, "")
+			return resp.Header.Get("ETag")
+		}
+		other := "<" + ts.URL + "/other.txt>"
+		// with FakeLocks every token is current
+		lockOK := map[bool]int{false: StatusPreconditionFailed, true: StatusNoContent}[fake]
+
+		for _, tc := range []struct {
+			h      string
+			status int
+		}{
+			{"([" + etag("/f.txt") + "])", StatusNoContent},
+			{`(["stale"])`, StatusPreconditionFailed},
+			{`(Not ["stale"])`, StatusNoContent},
+			{"(<urn:uuid:4f2a0b7e-1c3d-4e5f-8a9b-0c1d2e3f4a5b>)", lockOK},
+			{"(<DAV:no-lock>)", StatusPreconditionFailed},
+			{"(Not <DAV:no-lock>)", StatusNoContent},
+			{`(<DAV:no-lock>) (Not ["stale"])`, StatusNoContent},
+			{other + " ([" + etag("/other.txt") + "])", StatusNoContent},
+			{other + ` (["stale"])`, StatusPreconditionFailed},
+			{"<http://elsewhere.example.com/x> (Not [\"a\"])", StatusNoContent},
+			{"(", StatusBadRequest},
+			{`(["a"]) ` + other + ` (["b"])`, StatusBadRequest},
+		} {
+			resp, _ := request(t, ts, "PUT", "/f.txt", "f", "If", tc.h)
+			if resp.StatusCode != tc.status {
+				t.Errorf("FakeLocks %v, If: %s: %d, want %d", fake, tc.h, resp.StatusCode, tc.status)
+			}
+		}
+	}
+}
@@ -0,0 +1,180 @@
+//go:build linux
+
+package webdav
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/golang/glog"
+)
+
+const inotifyMask = syscall.IN_CREATE | syscall.IN_MODIFY | syscall.IN_DELETE |
+	syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO | syscall.IN_DELETE_SELF
+
+// DirWatchWindow is how long Dir.Watch waits for a path to go quiet before
+// reporting it, see Coalesce
+var DirWatchWindow = 100 * time.Millisecond
+
+// Watch reports changes below prefix using inotify, adding watches for
+// directories as they are created
+func (d Dir) Watch(prefix string) (<-chan Event, func(), error) {
+	root, err := d.sanitizePath(prefix)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	fd, err := syscall.InotifyInit1(syscall.IN_NONBLOCK | syscall.IN_CLOEXEC)
+	if err != nil {
+		return nil, nil, err
+	}
+	// a non-blocking fd goes through the runtime poller, so Close unblocks Read
+	f := os.NewFile(uintptr(fd), "inotify")
+
+	w := &dirWatch{
+		fd:   fd,
+		f:    f,
+		root: path.Clean("/" + prefix),
+		dirs: make(map[int32]watchedDir),
+		ch:   make(chan Event, 64),
+		stop: make(chan struct{}),
+	}
+	if err := w.addTree(root, w.root); err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+
+	go w.run()
+
+	var once sync.Once
+	return coalesce(w.ch, DirWatchWindow, w.stop), func() {
+		once.Do(func() {
+			close(w.stop)
+			f.Close()
+		})
+	}, nil
+}
+
+type dirWatch struct {
+	fd   int
+	f    *os.File
+	root string // FileSystem path of the watch
+	dirs map[int32]watchedDir
+	ch   chan Event
+	stop chan struct{} // closed by the stop function
+}
+
+type watchedDir struct {
+	host string // native path
+	name string // FileSystem path
+}
+
+// addTree watches dir and every directory below it
+func (w *dirWatch) addTree(dir, name string) error {
+	return filepath.Walk(dir, func(p string, fi os.FileInfo, err error) error {
+		if err != nil || !fi.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+
+		wd, err := syscall.InotifyAddWatch(w.fd, p, inotifyMask)
+		if err != nil {
+			return err
+		}
+		w.dirs[int32(wd)] = watchedDir{host: p, name: path.Join(name, filepath.ToSlash(rel))}
+		return nil
+	})
+}
+
+func (w *dirWatch) run() {
+	defer close(w.ch)
+
+	buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+	for {
+		n, err := w.f.Read(buf)
+		if err != nil {
+			return
+		}
+
+		for off := 0; off+syscall.SizeofInotifyEvent <= n; {
+			raw := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[off]))
+			nameBytes := buf[off+syscall.SizeofInotifyEvent : off+syscall.SizeofInotifyEvent+int(raw.Len)]
+			off += syscall.SizeofInotifyEvent + int(raw.Len)
+
+			if !w.handle(raw.Wd, raw.Mask, string(trimNul(nameBytes))) {
+				return
+			}
+		}
+	}
+}
+
+// handle turns an inotify event into an Event, it returns false once the
+// watch is stopped
+func (w *dirWatch) handle(wd int32, mask uint32, name string) bool {
+	if mask&syscall.IN_Q_OVERFLOW != 0 {
+		// comes with wd -1, for no directory in particular
+		glog.Infoln("DAV:", "inotify queue overflow, events lost")
+		return w.send(Event{Op: OpResync, Path: w.root, Time: time.Now()})
+	}
+	dir, ok := w.dirs[wd]
+	if !ok {
+		return true
+	}
+	if mask&(syscall.IN_IGNORED|syscall.IN_DELETE_SELF) != 0 {
+		delete(w.dirs, wd)
+		return true
+	}
+	if strings.HasPrefix(name, TempPrefix) {
+		// an upload shows up when it is renamed into place
+		return true
+	}
+
+	p := path.Join(dir.name, name)
+	ev := Event{Path: p, Time: time.Now()}
+	switch {
+	case mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0:
+		ev.Op = OpCreate
+		if mask&syscall.IN_ISDIR != 0 {
+			if err := w.addTree(filepath.Join(dir.host, name), p); err != nil {
+				glog.Infoln("DAV:", "error watching new directory", p, "error", err)
+			}
+		}
+	case mask&syscall.IN_MODIFY != 0:
+		ev.Op = OpWrite
+	case mask&syscall.IN_DELETE != 0:
+		ev.Op = OpRemove
+	case mask&syscall.IN_MOVED_FROM != 0:
+		ev.Op = OpRename
+	default:
+		return true
+	}
+	return w.send(ev)
+}
+
+// send delivers ev unless the watch is stopped first
+func (w *dirWatch) send(ev Event) bool {
+	select {
+	case w.ch <- ev:
+		return true
+	case <-w.stop:
+		return false
+	}
+}
+
+func trimNul(b []byte) []byte {
+	for i, c := range b {
+		if c == 0 {
+			return b[:i]
+		}
+	}
+	return b
+}
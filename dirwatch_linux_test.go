@@ -0,0 +1,87 @@
+//go:build linux
+
+package webdav
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestDirWatchCoalesces(t *testing.T) {
+	root := t.TempDir()
+	ch, stop, err := Dir(root).Watch("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Create(filepath.Join(root, "a.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		f.WriteString("more")
+	}
+	f.Close()
+	if err := os.WriteFile(filepath.Join(root, TempPrefix+"upload"), []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case ev := <-ch:
+		if ev.Op != OpCreate || ev.Path != "/a.txt" {
+			t.Errorf("got %v %s, want create /a.txt", ev.Op, ev.Path)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("no event")
+	}
+	stop()
+	for _, ev := range collect(t, ch, 2*time.Second) {
+		t.Errorf("unexpected %v %s", ev.Op, ev.Path)
+	}
+}
+
+func TestDirWatchStopWithoutReader(t *testing.T) {
+	before := runtime.NumGoroutine()
+	root := t.TempDir()
+	ch, stop, err := Dir(root).Watch("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// far more events than the channels buffer, none of them received
+	for i := 0; i < 500; i++ {
+		os.WriteFile(filepath.Join(root, "f"+string(rune('a'+i%26))+string(rune('a'+i/26))), nil, 0644)
+	}
+	time.Sleep(2 * DirWatchWindow)
+	stop()
+	collect(t, ch, 2*time.Second)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := runtime.NumGoroutine(); n > before {
+		t.Errorf("%d goroutines left running after stop", n-before)
+	}
+}
+
+func TestDirWatchOverflowResyncs(t *testing.T) {
+	w := &dirWatch{root: "/sub", dirs: map[int32]watchedDir{}, ch: make(chan Event, 1), stop: make(chan struct{})}
+	if !w.handle(-1, syscall.IN_Q_OVERFLOW, "") {
+		t.Fatal("handle reported the watch stopped")
+	}
+	if ev := <-w.ch; ev.Op != OpResync || ev.Path != "/sub" {
+		t.Errorf("got %v %s, want resync /sub", ev.Op, ev.Path)
+	}
+
+	// a full channel doesn't hold up a stopped watch
+	w.ch <- Event{}
+	close(w.stop)
+	if w.handle(-1, syscall.IN_Q_OVERFLOW, "") {
+		t.Error("handle didn't report the watch stopped")
+	}
+}
@@ -0,0 +1,8 @@
+//go:build !linux
+
+package webdav
+
+// Watch is only implemented on linux for now
+func (d Dir) Watch(prefix string) (<-chan Event, func(), error) {
+	return nil, nil, ErrNotImplemented
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"log"
+
+	"github.com/rbastic/webdav"
+)
+
+func main() {
+	c, err := webdav.NewClient("http://localhost:8081/webdav/", nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer c.Close()
+
+	ctx := context.Background()
+	if err := c.WriteFile(ctx, "hello.txt", []byte("Hello, WebDAV\n")); err != nil {
+		log.Fatal(err)
+	}
+
+	b, err := c.ReadFile(ctx, "hello.txt")
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("hello.txt: %q", b)
+
+	// ReadDir needs PROPFIND, which the example server doesn't answer;
+	// point it at another server to list a collection
+	fi, err := c.ReadDir(ctx, ".")
+	if err != nil {
+		log.Println(err)
+	}
+
+	for _, i := range fi {
+		name := i.Name()
+		if i.IsDir() {
+			name += "/"
+		}
+
+		log.Println(name)
+	}
+}
@@ -19,12 +19,14 @@ func main() {
 	// http.StripPrefix is not working, webdav.Server has no knowledge
 	// of stripped component, but needs for COPY/MOVE methods.
 	// Destination path is supplied as header and needs to be stripped.
-	http.Handle("/webdav/", &webdav.Server{
-		Fs:         webdav.Dir(path),
+	dav := &webdav.Server{
+		// LockedFS keeps concurrent PUTs of one file from interleaving
+		Fs:         webdav.NewLockedFS(webdav.Dir(path)),
 		TrimPrefix: "/webdav/",
 		Listings:   true,
-	})
+	}
 
+	http.Handle("/webdav/", dav)
 	http.HandleFunc("/", index)
 
 	log.Println("Listening on http://127.0.0.1:8081")
@@ -0,0 +1,175 @@
+package webdav_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/rbastic/webdav"
+)
+
+// Serve /srv/files under /dav/, with directory listings.
+func ExampleServer() {
+	http.Handle("/dav/", &webdav.Server{
+		Fs:         webdav.Dir("/srv/files"),
+		TrimPrefix: "/dav/",
+		Listings:   true,
+	})
+	log.Fatal(http.ListenAndServe(":8080", nil))
+}
+
+func ExampleServer_readOnly() {
+	fsys := webdav.NewMemFS()
+	ts := httptest.NewServer(&webdav.Server{Fs: fsys, ReadOnly: true})
+	defer ts.Close()
+
+	req, _ := http.NewRequest("PUT", ts.URL+"/f.txt", strings.NewReader("hello"))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		log.Fatal(err)
+	}
+	resp.Body.Close()
+	fmt.Println(resp.StatusCode, webdav.StatusText(resp.StatusCode))
+	// Output: 403 Forbidden
+}
+
+func ExampleHandler() {
+	ts := httptest.NewServer(webdav.Handler(webdav.NewMemFS()))
+	defer ts.Close()
+
+	req, _ := http.NewRequest("PUT", ts.URL+"/notes.txt", strings.NewReader("hello"))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		log.Fatal(err)
+	}
+	resp.Body.Close()
+	fmt.Println("PUT", resp.StatusCode)
+
+	resp, err = http.Get(ts.URL + "/notes.txt")
+	if err != nil {
+		log.Fatal(err)
+	}
+	b, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	fmt.Println("GET", resp.StatusCode, string(b))
+	// Output:
+	// PUT 201
+	// GET 200 hello
+}
+
+// A Dir serves a directory of the local file system. Names are cleaned
+// and kept inside it.
+func ExampleDir() {
+	root, err := os.MkdirTemp("", "webdav-example-")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	os.WriteFile(filepath.Join(root, "secret.txt"), []byte("inside"), 0o600)
+
+	fsys := webdav.Dir(root)
+	f, err := fsys.Open("/../../secret.txt")
+	if err != nil {
+		log.Fatal(err)
+	}
+	b, _ := io.ReadAll(f)
+	f.Close()
+	fmt.Println(string(b))
+	// Output: inside
+}
+
+// Wrappers compose: here a Dir is read through a read-ahead cache, with
+// the requests for each file serialized by a LockedFS.
+func ExampleNewLockedFS() {
+	root, err := os.MkdirTemp("", "webdav-example-")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	os.WriteFile(filepath.Join(root, "big.bin"), []byte("data"), 0o600)
+
+	fsys := webdav.NewReadAheadFS(webdav.NewLockedFS(webdav.Dir(root)), 0, 0)
+	ts := httptest.NewServer(&webdav.Server{Fs: fsys, ReadOnly: true})
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/big.bin")
+	if err != nil {
+		log.Fatal(err)
+	}
+	b, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	fmt.Println(resp.StatusCode, string(b))
+	// Output: 200 data
+}
+
+func ExampleClient_Put() {
+	fsys := webdav.NewMemFS()
+	ts := httptest.NewServer(webdav.Handler(fsys))
+	defer ts.Close()
+
+	c, err := webdav.NewClient(ts.URL, nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer c.Close()
+	if err := c.Put(context.Background(), "report.txt", strings.NewReader("quarterly numbers")); err != nil {
+		log.Fatal(err)
+	}
+
+	f, err := fsys.Open("/report.txt")
+	if err != nil {
+		log.Fatal(err)
+	}
+	b, _ := io.ReadAll(f)
+	f.Close()
+	fmt.Println(string(b))
+	// Output: quarterly numbers
+}
+
+func ExampleClient_ReadFile() {
+	ts := httptest.NewServer(webdav.Handler(webdav.NewMemFS()))
+	defer ts.Close()
+
+	c, err := webdav.NewClient(ts.URL, nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer c.Close()
+	ctx := context.Background()
+	if err := c.WriteFile(ctx, "hello.txt", []byte("hello, world")); err != nil {
+		log.Fatal(err)
+	}
+	b, err := c.ReadFile(ctx, "hello.txt")
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(string(b))
+	// Output: hello, world
+}
+
+func ExampleStatusText() {
+	fmt.Println(webdav.StatusText(webdav.StatusMulti))
+	fmt.Println(webdav.StatusText(webdav.StatusLocked))
+	fmt.Println(webdav.StatusText(http.StatusNotFound))
+	// Output:
+	// Multi-Status
+	// Locked
+	// Not Found
+}
+
+// Lists of the request-URI are untagged, unless another resource is named.
+func ExampleFormatIf() {
+	const f = "http://example.com/dav/f.txt"
+	lock := webdav.IfList{Tokens: []string{"urn:uuid:4f2a0b7e-1c3d-4e5f-8a9b-0c1d2e3f4a5b"}}
+	fmt.Println(webdav.FormatIf(f, lock))
+	fmt.Println(webdav.FormatIf(f, lock, webdav.IfList{Resource: "http://example.com/dav/", ETags: []string{"v2"}}))
+	// Output:
+	// (<urn:uuid:4f2a0b7e-1c3d-4e5f-8a9b-0c1d2e3f4a5b>)
+	// <http://example.com/dav/f.txt> (<urn:uuid:4f2a0b7e-1c3d-4e5f-8a9b-0c1d2e3f4a5b>) <http://example.com/dav/> (["v2"])
+}
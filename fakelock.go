@@ -0,0 +1,154 @@
+package webdav
+
+import (
+	"crypto/rand"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/golang/glog"
+)
+
+// lockinfo is the part of a LOCK body that is echoed back
+type lockinfo struct {
+	Scope struct {
+		Shared *struct{} `xml:"shared"`
+	} `xml:"lockscope"`
+	Owner struct {
+		Href string `xml:"href"`
+		Text string `xml:",chardata"`
+	} `xml:"owner"`
+}
+
+// doFakeLock answers LOCK for Server.FakeLocks: every lock is granted with
+// a new token, or the submitted one for a refresh, and nothing is locked.
+// An unmapped URL is created empty as RFC 4918 section 9.10.4 asks, Office
+// locks before its first PUT.
+func (s *Server) doFakeLock(w http.ResponseWriter, r *http.Request) {
+	if s.ReadOnly {
+		glog.Infoln("DAV:", "LOCK Forbidden: server is ReadOnly")
+		w.WriteHeader(StatusForbidden)
+		return
+	}
+	name := s.url2path(r.URL)
+
+	var info lockinfo
+	token := ifLockToken(r.Header.Get("If"))
+	if r.ContentLength != 0 {
+		if err := newXMLDecoder(io.LimitReader(r.Body, 64<<10), 1000).Decode(&info); err != nil && token == "" {
+			glog.Infoln("DAV:", "LOCK bad body", r.URL, "error", err)
+			w.WriteHeader(StatusBadRequest)
+			return
+		}
+	}
+	if token == "" || r.ContentLength > 0 {
+		token = lockToken()
+	}
+
+	status := StatusOK
+	if _, err := s.stat(name); err != nil {
+		if err := s.Fs.Mkdir(path.Dir(name)); err != nil {
+			glog.Infoln("DAV:", "LOCK error making directory", path.Dir(name), "error", err)
+		}
+		f, err := s.Fs.Create(name)
+		if err == nil {
+			err = f.Close()
+		}
+		if err != nil {
+			glog.Infoln("DAV:", "LOCK error creating", name, "error", err)
+			w.WriteHeader(StatusConflict)
+			return
+		}
+		s.publish(OpCreate, name)
+		status = StatusCreated
+	}
+
+	scope := "exclusive"
+	if info.Scope.Shared != nil {
+		scope = "shared"
+	}
+	depth := "infinity"
+	if r.Header.Get("Depth") == "0" {
+		depth = "0"
+	}
+	timeout := "Second-3600"
+	// the timeout is echoed into the XML answer, only well formed ones
+	t := strings.TrimSpace(strings.Split(r.Header.Get("Timeout"), ",")[0])
+	if _, err := strconv.ParseUint(strings.TrimPrefix(t, "Second-"), 10, 32); t == "Infinite" || strings.HasPrefix(t, "Second-") && err == nil {
+		timeout = t
+	}
+
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="utf-8"?>` + "\n" + `<D:prop xmlns:D="DAV:"><D:lockdiscovery><D:activelock>`)
+	b.WriteString(`<D:locktype><D:write/></D:locktype><D:lockscope><D:` + scope + `/></D:lockscope>`)
+	b.WriteString(`<D:depth>` + depth + `</D:depth>`)
+	if owner := strings.TrimSpace(info.Owner.Href); owner != "" {
+		b.WriteString(`<D:owner><D:href>`)
+		xml.EscapeText(&b, []byte(owner))
+		b.WriteString(`</D:href></D:owner>`)
+	} else if owner := strings.TrimSpace(info.Owner.Text); owner != "" {
+		b.WriteString(`<D:owner>`)
+		xml.EscapeText(&b, []byte(owner))
+		b.WriteString(`</D:owner>`)
+	}
+	b.WriteString(`<D:timeout>` + timeout + `</D:timeout>`)
+	b.WriteString(`<D:locktoken><D:href>`)
+	xml.EscapeText(&b, []byte(token))
+	b.WriteString(`</D:href></D:locktoken><D:lockroot><D:href>`)
+	xml.EscapeText(&b, []byte(r.URL.EscapedPath()))
+	b.WriteString(`</D:href></D:lockroot></D:activelock></D:lockdiscovery></D:prop>`)
+
+	glog.Infoln("DAV:", "LOCK granted without locking", name, token)
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.Header().Set("Lock-Token", "<"+token+">")
+	w.WriteHeader(status)
+	io.WriteString(w, b.String())
+}
+
+// doFakeUnlock answers UNLOCK for Server.FakeLocks, any token is accepted
+func (s *Server) doFakeUnlock(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Lock-Token") == "" {
+		glog.Infoln("DAV:", "UNLOCK without Lock-Token", r.URL)
+		w.WriteHeader(StatusBadRequest)
+		return
+	}
+	w.WriteHeader(StatusNoContent)
+}
+
+// ifLockToken returns the first lock token of an If header, for a refresh
+func ifLockToken(h string) string {
+	for {
+		i := strings.IndexByte(h, '<')
+		if i < 0 {
+			return ""
+		}
+		j := strings.IndexByte(h[i:], '>')
+		if j < 0 {
+			return ""
+		}
+		// tagged lists start with the resource, an http URL
+		if t := h[i+1 : i+j]; isLockToken(t) {
+			return t
+		}
+		h = h[i+j+1:]
+	}
+}
+
+// isLockToken reports whether t has the form of a lock token rather than
+// of a resource, an http URL
+func isLockToken(t string) bool {
+	return strings.HasPrefix(t, "urn:uuid:") || strings.HasPrefix(t, "opaquelocktoken:")
+}
+
+// lockToken returns a new urn:uuid: lock token, RFC 4918 section 6.5
+func lockToken() string {
+	var u [16]byte
+	rand.Read(u[:])
+	u[6] = u[6]&0x0f | 0x40
+	u[8] = u[8]&0x3f | 0x80
+	return fmt.Sprintf("urn:uuid:%x-%x-%x-%x-%x", u[0:4], u[4:6], u[6:8], u[8:10], u[10:])
+}
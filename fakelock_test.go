@@ -0,0 +1,115 @@
+package webdav
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const exclusiveLock = `<?xml version="1.0" encoding="utf-8"?>
+<D:lockinfo xmlns:D="DAV:"><D:lockscope><D:exclusive/></D:lockscope><D:locktype><D:write/></D:locktype>
+<D:owner><D:href>mailto:alice@example.com</D:href></D:owner></D:lockinfo>`
+
+func TestFakeLocks(t *testing.T) {
+	m := NewMemFS()
+	ts := newTestServer(t, &Server{Fs: m, FakeLocks: true})
+
+	resp, _ := request(t, ts, "OPTIONS", "/", "")
+	if dav := resp.Header.Get("DAV"); !strings.Contains(dav, "2") {
+		t.Errorf("DAV: %q, want class 2", dav)
+	}
+
+	// the sequence Office and the Finder use to save a new file
+	resp, body := request(t, ts, "LOCK", "/doc.docx", exclusiveLock, "Timeout", "Second-600")
+	wantStatus(t, resp, StatusCreated)
+	token := resp.Header.Get("Lock-Token")
+	if !strings.HasPrefix(token, "<urn:uuid:") || !strings.Contains(body, strings.Trim(token, "<>")) ||
+		!strings.Contains(body, "mailto:alice@example.com") || !strings.Contains(body, "Second-600") {
+		t.Fatalf("LOCK: token %s, body %s", token, body)
+	}
+	resp, _ = request(t, ts, "PUT", "/doc.docx", "saved", "If", "("+token+")")
+	wantStatus(t, resp, StatusNoContent)
+	resp, _ = request(t, ts, "UNLOCK", "/doc.docx", "", "Lock-Token", token)
+	wantStatus(t, resp, StatusNoContent)
+	if got := string(readAll(t, m, "/doc.docx")); got != "saved" {
+		t.Errorf("doc.docx holds %q", got)
+	}
+
+	// nothing is enforced: another client locks and writes a locked file
+	resp, _ = request(t, ts, "LOCK", "/doc.docx", exclusiveLock)
+	wantStatus(t, resp, StatusOK)
+	held := resp.Header.Get("Lock-Token")
+	resp, _ = request(t, ts, "LOCK", "/doc.docx", exclusiveLock)
+	wantStatus(t, resp, StatusOK)
+	if other := resp.Header.Get("Lock-Token"); other == held {
+		t.Errorf("two LOCKs got the same token %s", held)
+	}
+	resp, _ = request(t, ts, "PUT", "/doc.docx", "overwritten")
+	wantStatus(t, resp, StatusNoContent)
+	resp, _ = request(t, ts, "UNLOCK", "/doc.docx", "", "Lock-Token", "<urn:uuid:never-granted>")
+	wantStatus(t, resp, StatusNoContent)
+}
+
+// TestFakeLocksDontBlock runs LOCK, PUT and UNLOCK sequences on one file
+// from many clients at once, none waits for another's lock
+func TestFakeLocksDontBlock(t *testing.T) {
+	m := NewMemFS()
+	writeMem(t, m, "/shared.xlsx", []byte("v0"))
+	ts := newTestServer(t, &Server{Fs: m, FakeLocks: true})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	do := func(method, body string, header ...string) (*http.Response, error) {
+		req, err := http.NewRequestWithContext(ctx, method, ts.URL+"/shared.xlsx", strings.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		for i := 0; i+1 < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		resp, err := ts.Client().Do(req)
+		if err == nil {
+			resp.Body.Close()
+		}
+		return resp, err
+	}
+
+	const clients = 20
+	// every client takes its lock before any releases one
+	var locked sync.WaitGroup
+	locked.Add(clients)
+	errs := make(chan error, clients)
+	for i := 0; i < clients; i++ {
+		go func(i int) {
+			resp, err := do("LOCK", exclusiveLock)
+			locked.Done()
+			if err != nil || resp.StatusCode != StatusOK {
+				errs <- fmt.Errorf("client %d LOCK: %v %v", i, resp, err)
+				return
+			}
+			token := resp.Header.Get("Lock-Token")
+			locked.Wait()
+			if resp, err := do("PUT", fmt.Sprintf("v%d", i), "If", "("+token+")"); err != nil || resp.StatusCode != StatusNoContent {
+				errs <- fmt.Errorf("client %d PUT: %v %v", i, resp, err)
+				return
+			}
+			if resp, err := do("UNLOCK", "", "Lock-Token", token); err != nil || resp.StatusCode != StatusNoContent {
+				errs <- fmt.Errorf("client %d UNLOCK: %v %v", i, resp, err)
+				return
+			}
+			errs <- nil
+		}(i)
+	}
+	for i := 0; i < clients; i++ {
+		if err := <-errs; err != nil {
+			t.Error(err)
+		}
+	}
+	if got := string(readAll(t, m, "/shared.xlsx")); !strings.HasPrefix(got, "v") {
+		t.Errorf("shared.xlsx holds %q", got)
+	}
+}
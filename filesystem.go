@@ -1,11 +1,16 @@
 package webdav
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"io"
 	"os"
 	"path"
 	"path/filepath"
+	"runtime"
 	"strings"
+	"time"
 )
 
 // A FileSystem implements access to a collection of named files.
@@ -36,6 +41,141 @@ type File interface {
 	*/
 }
 
+// Wrappers around a FileSystem implement every optional interface below and
+// return ErrNotImplemented from the methods their inner FileSystem lacks;
+// the server treats that the same as the interface being absent.
+
+// A Preallocator is a File that can reserve space for size bytes before
+// they are written, so running out of space is noticed before the transfer
+// rather than halfway through it.
+type Preallocator interface {
+	Preallocate(size int64) error
+}
+
+// A ContextReaderAt is a File whose ReadAt can be given up on, like one
+// reading over the network. ReadAheadFS cancels the reads ahead it no
+// longer needs through it.
+type ContextReaderAt interface {
+	ReadAtContext(ctx context.Context, p []byte, off int64) (int, error)
+}
+
+// A Copier is a FileSystem that can copy a file by itself, without the
+// content passing through the server (reflinks, S3 CopyObject, ...).
+// The server prefers it over Open+Create+io.Copy when present.
+type Copier interface {
+	CopyFile(src, dst string) error
+}
+
+// A Renamer is a FileSystem that can move a file to a new name, replacing
+// any file already there.
+type Renamer interface {
+	Rename(oldname, newname string) error
+}
+
+// A TempFiler is a FileSystem that can create a temporary file in a given
+// directory and later promote it with Rename. The server writes PUT and
+// COPY bodies through it so that a file is either fully replaced or left
+// untouched. CreateTemp returns the new file and its FileSystem name, which
+// starts with TempPrefix so listings and cleanup jobs can recognize it.
+type TempFiler interface {
+	Renamer
+	CreateTemp(dir, pattern string) (File, string, error)
+}
+
+// An OpenFiler is a FileSystem that can open a file with os.OpenFile flags.
+// The server creates new files with O_EXCL through it, so that whether a
+// PUT created or replaced a file is decided by the open itself, and a PUT
+// with If-None-Match: * can't replace a file created meanwhile.
+type OpenFiler interface {
+	OpenFile(name string, flag int, perm os.FileMode) (File, error)
+}
+
+// TempPrefix starts the name of every temporary file created by the server.
+const TempPrefix = ".davtmp-"
+
+// An ETagger is a FileSystem that can provide a strong entity tag for a
+// file, typically a content hash it already stores. The tag is returned
+// without the surrounding quotes.
+type ETagger interface {
+	ETag(name string) (string, error)
+}
+
+// A ChecksumFS is a FileSystem that can tell the checksum of a file, hex
+// encoded, without the client downloading it. algo is a digest algorithm
+// name as in RFC 3230, like "SHA-256"; ErrNotImplemented is returned for
+// the ones it doesn't support.
+type ChecksumFS interface {
+	Checksum(name, algo string) (string, error)
+}
+
+// A ChecksumCache is a ChecksumFS that keeps the checksums of files until
+// they change. GET and HEAD only send cached checksums, so they never read
+// a whole file before answering, and PUT caches the checksum it computes
+// of the upload.
+type ChecksumCache interface {
+	ChecksumFS
+	// CachedChecksum returns the checksum of name if one is cached for
+	// the file as it is now
+	CachedChecksum(name, algo string) (string, bool)
+	// CacheChecksum caches sum as the checksum of name, if the file has
+	// size bytes
+	CacheChecksum(name, algo, sum string, size int64)
+}
+
+// A Chtimer is a FileSystem that can set the access and modification times
+// of a file, for clients that preserve them.
+type Chtimer interface {
+	Chtimes(name string, atime, mtime time.Time) error
+}
+
+// A Chmoder is a FileSystem that can change the permission bits of a file.
+type Chmoder interface {
+	Chmod(name string, mode os.FileMode) error
+}
+
+// An OwnerFS is a FileSystem that can tell who owns a file and its
+// permission bits, for admin tools. ErrNotImplemented is returned where
+// files have no owners.
+type OwnerFS interface {
+	Owner(name string) (FileOwner, error)
+}
+
+// FileOwner is the owner, group and permission bits of a file. User and
+// Group are the names of UID and GID, empty when they can't be looked up.
+type FileOwner struct {
+	UID, GID    int
+	User, Group string
+	Mode        os.FileMode
+}
+
+// A Trasher is a FileSystem whose Remove moves files to a trash, from which
+// they can be restored or purged, see TrashFS.
+type Trasher interface {
+	// Trash lists the oldest n entries of the trash, all of them for n <= 0
+	Trash(n int) ([]TrashEntry, error)
+	// TrashEntry returns the entry id, an os.ErrNotExist error if there is
+	// none
+	TrashEntry(id string) (TrashEntry, error)
+	// Restore moves the entry id back to name, replacing a file there
+	Restore(id, name string) error
+	Purge(id string) error
+}
+
+// TrashEntry is a file in the trash of a Trasher
+type TrashEntry struct {
+	ID      string // names it to Restore and Purge
+	Path    string // where it was removed from
+	Deleted time.Time
+	Size    int64
+}
+
+// A FileSystemCloser is a FileSystem holding resources (connections, pools,
+// background workers) that must be released when the Server is closed.
+type FileSystemCloser interface {
+	FileSystem
+	Close() error
+}
+
 // A Dir implements webdav.FileSystem using the native file
 // system restricted to a specific directory tree.
 //
@@ -43,8 +183,9 @@ type File interface {
 type Dir string
 
 func (d Dir) sanitizePath(name string) (string, error) {
-	if filepath.Separator != '/' && strings.IndexRune(name, filepath.Separator) >= 0 ||
-		strings.Contains(name, "\x00") {
+	// backslashes are refused on every platform, not only where they
+	// separate paths, see Server.BackslashSeparators
+	if strings.ContainsAny(name, "\\\x00") || isVolumePath(name) {
 		return "", ErrInvalidCharPath
 	}
 
@@ -53,7 +194,31 @@ func (d Dir) sanitizePath(name string) (string, error) {
 		dir = "."
 	}
 
-	return filepath.Join(dir, filepath.FromSlash(path.Clean("/"+name))), nil
+	p := filepath.Join(dir, filepath.FromSlash(path.Clean("/"+name)))
+
+	// nothing above should let p leave dir, make sure regardless
+	rel, err := filepath.Rel(filepath.Clean(dir), p)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", ErrInvalidCharPath
+	}
+	return p, nil
+}
+
+// isVolumePath reports whether Windows would read name as something other
+// than a path below the Dir: a drive letter as in C:, or a colon elsewhere,
+// which names an alternate data stream, or an element with trailing dots or
+// spaces, which Windows drops. UNC prefixes as in \\server\share never get
+// here, having backslashes. Elsewhere all of these are ordinary names.
+func isVolumePath(name string) bool {
+	if runtime.GOOS != "windows" {
+		return false
+	}
+	for _, e := range strings.Split(strings.TrimLeft(name, "/"), "/") {
+		if strings.Contains(e, ":") || e != "." && e != ".." && strings.TrimRight(e, ". ") != e {
+			return true
+		}
+	}
+	return false
 }
 
 // Open calls sanitizePath() and attempts to os.Open()
@@ -84,6 +249,20 @@ func (d Dir) Create(name string) (File, error) {
 	return f, nil
 }
 
+// OpenFile calls sanitizePath() and attempts to os.OpenFile()
+func (d Dir) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	p, err := d.sanitizePath(name)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(p, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
 // Mkdir creates a new directory with the specified name
 func (d Dir) Mkdir(name string) error {
 	p, err := d.sanitizePath(name)
@@ -104,13 +283,213 @@ func (d Dir) Remove(name string) error {
 	return os.Remove(p)
 }
 
-// mockup zero content file aka only header
-type emptyFile struct{}
+// Chtimes calls os.Chtimes() with a sanitized path
+func (d Dir) Chtimes(name string, atime, mtime time.Time) error {
+	p, err := d.sanitizePath(name)
+	if err != nil {
+		return err
+	}
+
+	return os.Chtimes(p, atime, mtime)
+}
+
+// Chmod calls os.Chmod() with the sanitized path
+func (d Dir) Chmod(name string, mode os.FileMode) error {
+	p, err := d.sanitizePath(name)
+	if err != nil {
+		return err
+	}
+
+	return os.Chmod(p, mode)
+}
+
+// Owner returns the owner of a file from its stat, on unix systems only
+func (d Dir) Owner(name string) (FileOwner, error) {
+	p, err := d.sanitizePath(name)
+	if err != nil {
+		return FileOwner{}, err
+	}
+
+	fi, err := os.Stat(p)
+	if err != nil {
+		return FileOwner{}, err
+	}
+	o, ok := fileOwner(fi)
+	if !ok {
+		return FileOwner{}, ErrNotImplemented
+	}
+	return o, nil
+}
+
+// Rename calls os.Rename() with sanitized paths
+func (d Dir) Rename(oldname, newname string) error {
+	op, err := d.sanitizePath(oldname)
+	if err != nil {
+		return err
+	}
+	np, err := d.sanitizePath(newname)
+	if err != nil {
+		return err
+	}
 
-func (e emptyFile) Read(p []byte) (n int, err error) {
-	return 0, io.EOF
+	return os.Rename(op, np)
 }
 
-func (e emptyFile) Seek(offset int64, whence int) (ret int64, err error) {
-	return 0, nil
+// CreateTemp creates a new file in the sanitized directory dir, see
+// createTemp
+func (d Dir) CreateTemp(dir, pattern string) (File, string, error) {
+	p, err := d.sanitizePath(dir)
+	if err != nil {
+		return nil, "", err
+	}
+
+	f, err := createTemp(p, pattern)
+	if err != nil {
+		return nil, "", err
+	}
+	return f, path.Join(dir, filepath.Base(f.Name())), nil
+}
+
+// createTemp is os.CreateTemp asking for mode 0666 as os.Create does,
+// rather than 0600, so a temporary file renamed into place gets the mode
+// the umask gives new files
+func createTemp(dir, pattern string) (*os.File, error) {
+	prefix, suffix := pattern, ""
+	if j := strings.LastIndex(pattern, "*"); j >= 0 {
+		prefix, suffix = pattern[:j], pattern[j+1:]
+	}
+	for try := 0; ; try++ {
+		name := filepath.Join(dir, prefix+randomSuffix()+suffix)
+		f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0666)
+		if os.IsExist(err) && try < 100 {
+			continue
+		}
+		return f, err
+	}
+}
+
+// Checksum returns the SHA-256 of a file. It is computed on first use and
+// cached in an extended attribute of the file on Linux, together with the
+// size and modification time it was computed at, so a modified file is
+// hashed again.
+func (d Dir) Checksum(name, algo string) (string, error) {
+	if !isSHA256(algo) {
+		return "", ErrNotImplemented
+	}
+	p, err := d.sanitizePath(name)
+	if err != nil {
+		return "", err
+	}
+
+	f, err := os.Open(p)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	if fi.IsDir() {
+		return "", &os.PathError{Op: "checksum", Path: name, Err: errIsDir}
+	}
+	if sum, ok := cachedChecksum(p, fi); ok {
+		return sum, nil
+	}
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	sum := hex.EncodeToString(h.Sum(nil))
+
+	// a file written to while it was read is not cached
+	if after, err := f.Stat(); err == nil && after.Size() == fi.Size() && after.ModTime().Equal(fi.ModTime()) {
+		cacheChecksum(p, fi, sum)
+	}
+	return sum, nil
+}
+
+// CachedChecksum returns the SHA-256 of a file cached by Checksum or
+// CacheChecksum, if the file's size and modification time are still those
+// it was cached at. Nothing is cached outside Linux, or on filesystems
+// without user extended attributes.
+func (d Dir) CachedChecksum(name, algo string) (string, bool) {
+	p, err := d.sanitizePath(name)
+	if err != nil || !isSHA256(algo) {
+		return "", false
+	}
+	fi, err := os.Stat(p)
+	if err != nil || fi.IsDir() {
+		return "", false
+	}
+	return cachedChecksum(p, fi)
+}
+
+// CacheChecksum caches sum as the SHA-256 of a file of size bytes
+func (d Dir) CacheChecksum(name, algo, sum string, size int64) {
+	p, err := d.sanitizePath(name)
+	if err != nil || !isSHA256(algo) {
+		return
+	}
+	if fi, err := os.Stat(p); err == nil && fi.Mode().IsRegular() && fi.Size() == size {
+		cacheChecksum(p, fi, sum)
+	}
+}
+
+// isSHA256 reports whether algo names SHA-256
+func isSHA256(algo string) bool {
+	return strings.EqualFold(algo, "SHA-256") || strings.EqualFold(algo, "SHA256")
+}
+
+// CopyFile copies src to dst, cloning the file where the underlying
+// filesystem supports it and falling back to a plain copy otherwise. The
+// copy is made in a temporary file renamed over dst, so a failed copy
+// leaves dst as it was, and a replaced dst keeps its mode.
+func (d Dir) CopyFile(src, dst string) error {
+	sp, err := d.sanitizePath(src)
+	if err != nil {
+		return err
+	}
+	dp, err := d.sanitizePath(dst)
+	if err != nil {
+		return err
+	}
+
+	in, err := os.Open(sp)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := createTemp(filepath.Dir(dp), TempPrefix+"*")
+	if err != nil {
+		return err
+	}
+	fail := func(err error) error {
+		out.Close()
+		os.Remove(out.Name())
+		return err
+	}
+
+	if cloneFile(out, in) != nil {
+		// on linux *os.File.ReadFrom uses copy_file_range(2) when it can
+		if _, err := io.Copy(out, in); err != nil {
+			return fail(err)
+		}
+	}
+	if fi, err := os.Stat(dp); err == nil {
+		if err := out.Chmod(fi.Mode().Perm()); err != nil {
+			return fail(err)
+		}
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(out.Name())
+		return err
+	}
+	if err := os.Rename(out.Name(), dp); err != nil {
+		os.Remove(out.Name())
+		return err
+	}
+	return nil
 }
@@ -0,0 +1,306 @@
+package webdav
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// tempEntries returns the names in dir starting with TempPrefix
+func tempEntries(t testing.TB, dir string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), TempPrefix) {
+			names = append(names, e.Name())
+		}
+	}
+	return names
+}
+
+func TestDirCopyFile(t *testing.T) {
+	root := t.TempDir()
+	d := Dir(root)
+	if err := os.WriteFile(filepath.Join(root, "src"), []byte("new content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "dst"), []byte("old"), 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := d.CopyFile("src", "dst"); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(filepath.Join(root, "dst"))
+	if err != nil || string(b) != "new content" {
+		t.Fatalf("dst = %q, %v", b, err)
+	}
+	fi, err := os.Stat(filepath.Join(root, "dst"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0640 {
+		t.Errorf("replaced dst has mode %v, want 0640", fi.Mode().Perm())
+	}
+	if tmp := tempEntries(t, root); len(tmp) > 0 {
+		t.Errorf("temporary files left: %v", tmp)
+	}
+}
+
+func TestDirCopyFileFailureKeepsDestination(t *testing.T) {
+	root := t.TempDir()
+	d := Dir(root)
+	if err := os.WriteFile(filepath.Join(root, "dst"), []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(root, "dir"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	// reading a directory fails after the temporary file was created
+	if err := d.CopyFile("dir", "dst"); err == nil {
+		t.Fatal("copying a directory succeeded")
+	}
+	b, err := os.ReadFile(filepath.Join(root, "dst"))
+	if err != nil || string(b) != "old" {
+		t.Fatalf("dst = %q, %v after a failed copy", b, err)
+	}
+	if tmp := tempEntries(t, root); len(tmp) > 0 {
+		t.Errorf("temporary files left: %v", tmp)
+	}
+}
+
+// BenchmarkCopyFile compares Dir.CopyFile, which clones or uses
+// copy_file_range where it can, with copying through a buffer, on a sparse
+// file of 256 MiB
+func BenchmarkCopyFile(b *testing.B) {
+	root := b.TempDir()
+	const size = 256 << 20
+	f, err := os.Create(filepath.Join(root, "sparse"))
+	if err != nil {
+		b.Fatal(err)
+	}
+	if _, err := f.WriteAt([]byte("head"), 0); err != nil {
+		b.Fatal(err)
+	}
+	if err := f.Truncate(size); err != nil {
+		b.Fatal(err)
+	}
+	f.Close()
+	d := Dir(root)
+
+	b.Run("native", func(b *testing.B) {
+		b.SetBytes(size)
+		for i := 0; i < b.N; i++ {
+			if err := d.CopyFile("sparse", "copy"); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("generic", func(b *testing.B) {
+		b.SetBytes(size)
+		buf := make([]byte, DefaultCopyBufferSize)
+		for i := 0; i < b.N; i++ {
+			in, err := d.Open("sparse")
+			if err != nil {
+				b.Fatal(err)
+			}
+			out, err := d.Create("copy")
+			if err != nil {
+				b.Fatal(err)
+			}
+			// hide ReaderFrom and WriterTo, as with a FileSystem other than Dir
+			if _, err := io.CopyBuffer(struct{ io.Writer }{out}, struct{ io.Reader }{in}, buf); err != nil {
+				b.Fatal(err)
+			}
+			in.Close()
+			if err := out.Close(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// sanitizeSeeds are names that once escaped, or could escape, a root
+var sanitizeSeeds = []string{
+	"/a/b",
+	"../../etc/passwd",
+	"/a/../../b",
+	"..",
+	"/%2e%2e/%2e%2e/etc",
+	"/\xc0\xae\xc0\xae/etc",
+	`a\..\..\secret`,
+	`\\server\share\x`,
+	"//server/share/x",
+	"C:/Windows",
+	"/c:foo",
+	"a/file.txt:stream",
+	"a/trailing. /b",
+	"a/dots.../b",
+	"a\x00b",
+	"/./././",
+	"",
+}
+
+func TestSanitizePath(t *testing.T) {
+	root := t.TempDir()
+	d := Dir(root)
+	// names Windows reads as volumes or streams are fine elsewhere
+	unix := func(s string) string {
+		if runtime.GOOS == "windows" {
+			return ""
+		}
+		return s
+	}
+	for _, tc := range []struct {
+		name string
+		want string // below root, "" for an error
+	}{
+		{"/a/b", "a/b"},
+		{"", "."},
+		{"../../etc/passwd", "etc/passwd"},
+		{"/a/../../b", "b"},
+		{"/%2e%2e/x", "%2e%2e/x"},
+		{"/\xc0\xae\xc0\xae/x", "\xc0\xae\xc0\xae/x"},
+		{`a\..\..\secret`, ""},
+		{`\\server\share\x`, ""},
+		{"//server/share/x", "server/share/x"},
+		{"a\x00b", ""},
+		{"/c:foo", unix("c:foo")},
+		{"C:/Windows", unix("C:/Windows")},
+		{"a/file.txt:stream", unix("a/file.txt:stream")},
+		{"a/trailing. /b", unix("a/trailing. /b")},
+	} {
+		p, err := d.sanitizePath(tc.name)
+		if tc.want == "" {
+			if err == nil {
+				t.Errorf("sanitizePath(%q) = %q, want an error", tc.name, p)
+			}
+			continue
+		}
+		if want := filepath.Join(root, filepath.FromSlash(tc.want)); err != nil || p != want {
+			t.Errorf("sanitizePath(%q) = %q, %v, want %q", tc.name, p, err, want)
+		}
+	}
+}
+
+// FuzzSanitizePath checks that whatever name a Dir is given, it either
+// refuses it or stays below its root. The inputs in testdata/fuzz are run
+// with every go test.
+func FuzzSanitizePath(f *testing.F) {
+	for _, s := range sanitizeSeeds {
+		f.Add(s)
+	}
+	root := f.TempDir()
+	d := Dir(root)
+	f.Fuzz(func(t *testing.T, name string) {
+		p, err := d.sanitizePath(name)
+		if err != nil {
+			return
+		}
+		if strings.ContainsAny(name, "\\\x00") {
+			t.Fatalf("sanitizePath(%q) = %q, accepting a backslash or NUL", name, p)
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) ||
+			filepath.IsAbs(rel) || filepath.VolumeName(rel) != "" {
+			t.Fatalf("sanitizePath(%q) = %q, outside %q", name, p, root)
+		}
+	})
+}
+
+// TestChtimesChmod sets the times and mode of a file on each backend and
+// through the wrappers, which must report them only when the backend has
+// them
+func TestChtimesChmod(t *testing.T) {
+	mtime := time.Unix(1600000000, 0)
+	trash := func(fs FileSystem) FileSystem {
+		tfs, err := NewTrashFS(fs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tfs
+	}
+	for name, mk := range map[string]func(root string) FileSystem{
+		"dir":       func(root string) FileSystem { return Dir(root) },
+		"memfs":     func(string) FileSystem { return NewMemFS() },
+		"lockedfs":  func(root string) FileSystem { return NewLockedFS(Dir(root)) },
+		"readahead": func(string) FileSystem { return NewReadAheadFS(NewMemFS(), 0, 0) },
+		"trashfs":   func(root string) FileSystem { return trash(Dir(root)) },
+	} {
+		t.Run(name, func(t *testing.T) {
+			fsys := mk(t.TempDir())
+			f, err := fsys.Create("/f.txt")
+			if err != nil {
+				t.Fatal(err)
+			}
+			f.Write([]byte("data"))
+			f.Close()
+			stat := func() os.FileInfo {
+				t.Helper()
+				fi, err := (&Server{Fs: fsys}).stat("/f.txt")
+				if err != nil {
+					t.Fatal(err)
+				}
+				return fi
+			}
+
+			c, ok := capability[Chtimer](fsys)
+			if !ok {
+				t.Fatal("no Chtimer")
+			}
+			if err := c.Chtimes("/f.txt", mtime, mtime); err != nil {
+				t.Fatal(err)
+			}
+			if got := stat().ModTime(); !got.Equal(mtime) {
+				t.Errorf("mtime %v, want %v", got, mtime)
+			}
+			if err := c.Chtimes("/missing", mtime, mtime); !os.IsNotExist(err) {
+				t.Errorf("Chtimes of a missing file: %v", err)
+			}
+
+			m, ok := capability[Chmoder](fsys)
+			if !ok {
+				t.Fatal("no Chmoder")
+			}
+			if err := m.Chmod("/f.txt", 0o600); err != nil {
+				t.Fatal(err)
+			}
+			// windows only keeps the write bit
+			if got := stat().Mode().Perm(); got != 0o600 && runtime.GOOS != "windows" {
+				t.Errorf("mode %v, want 0600", got)
+			}
+			if err := m.Chmod("/missing", 0o600); !os.IsNotExist(err) {
+				t.Errorf("Chmod of a missing file: %v", err)
+			}
+		})
+	}
+
+	// a backend without them, hidden in a struct, isn't made to have them
+	// by a wrapper
+	for name, fsys := range map[string]FileSystem{
+		"lockedfs":  NewLockedFS(struct{ FileSystem }{NewMemFS()}),
+		"readahead": NewReadAheadFS(struct{ FileSystem }{NewMemFS()}, 0, 0),
+	} {
+		if _, ok := capability[Chtimer](fsys); ok {
+			t.Errorf("%s: Chtimer of a backend without", name)
+		}
+		if _, ok := capability[Chmoder](fsys); ok {
+			t.Errorf("%s: Chmoder of a backend without", name)
+		}
+		if err := fsys.(Chtimer).Chtimes("/f.txt", mtime, mtime); err != ErrNotImplemented {
+			t.Errorf("%s: Chtimes %v, want ErrNotImplemented", name, err)
+		}
+		if err := fsys.(Chmoder).Chmod("/f.txt", 0o600); err != ErrNotImplemented {
+			t.Errorf("%s: Chmod %v, want ErrNotImplemented", name, err)
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package ftpfs
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// conn is a logged in control connection
+type conn struct {
+	fs     *FS
+	netc   net.Conn
+	tp     *textproto.Conn
+	host   string
+	noMLST bool
+	broken bool
+}
+
+func (fs *FS) dial() (*conn, error) {
+	d := net.Dialer{Timeout: fs.timeout()}
+	netc, err := d.Dial("tcp", fs.Addr)
+	if err != nil {
+		return nil, err
+	}
+
+	host, _, err := net.SplitHostPort(fs.Addr)
+	if err != nil {
+		netc.Close()
+		return nil, err
+	}
+
+	c := &conn{fs: fs, netc: netc, tp: textproto.NewConn(netc), host: host}
+	if err := c.login(); err != nil {
+		c.close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *conn) login() error {
+	c.deadline()
+	if _, _, err := c.tp.ReadResponse(220); err != nil {
+		return err
+	}
+
+	if c.fs.TLSConfig != nil {
+		if _, _, err := c.cmd(234, "AUTH TLS"); err != nil {
+			return err
+		}
+		c.netc = tls.Client(c.netc, c.tlsConfig())
+		c.tp = textproto.NewConn(c.netc)
+	}
+
+	user := c.fs.User
+	if user == "" {
+		user = "anonymous"
+	}
+	code, _, err := c.cmd(0, "USER %s", user)
+	if err != nil {
+		return err
+	}
+	switch code {
+	case 230:
+	case 331, 332:
+		if _, _, err := c.cmd(230, "PASS %s", c.fs.Password); err != nil {
+			return err
+		}
+	default:
+		return &textproto.Error{Code: code, Msg: "unexpected reply to USER"}
+	}
+
+	if c.fs.TLSConfig != nil {
+		if _, _, err := c.cmd(200, "PBSZ 0"); err != nil {
+			return err
+		}
+		if _, _, err := c.cmd(200, "PROT P"); err != nil {
+			return err
+		}
+	}
+
+	_, _, err = c.cmd(200, "TYPE I")
+	return err
+}
+
+func (c *conn) tlsConfig() *tls.Config {
+	cfg := c.fs.TLSConfig.Clone()
+	if cfg.ServerName == "" {
+		cfg.ServerName = c.host
+	}
+	if cfg.ClientSessionCache == nil {
+		// many servers require the data connection to resume the
+		// control connection's TLS session
+		cfg.ClientSessionCache = c.fs.sessions
+	}
+	return cfg
+}
+
+func (c *conn) deadline() {
+	c.netc.SetDeadline(time.Now().Add(c.fs.timeout()))
+}
+
+// cmd sends a command and reads the reply. With expect 0 any reply code is
+// accepted, otherwise the reply must match it (see textproto.ReadResponse).
+func (c *conn) cmd(expect int, format string, args ...interface{}) (int, string, error) {
+	c.deadline()
+	if _, err := c.tp.Cmd(format, args...); err != nil {
+		c.broken = true
+		return 0, "", err
+	}
+
+	code, msg, err := c.tp.ReadResponse(expect)
+	if err != nil {
+		if _, ok := err.(*textproto.Error); !ok {
+			c.broken = true
+		}
+	}
+	return code, msg, err
+}
+
+// transfer opens a passive data connection and issues cmd on it. The
+// caller must close the returned connection and then call finish.
+func (c *conn) transfer(format string, args ...interface{}) (net.Conn, error) {
+	addr, err := c.passive()
+	if err != nil {
+		return nil, err
+	}
+
+	d := net.Dialer{Timeout: c.fs.timeout()}
+	data, err := d.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, _, err := c.cmd(1, format, args...); err != nil {
+		data.Close()
+		return nil, err
+	}
+	c.netc.SetDeadline(time.Time{})
+
+	if c.fs.TLSConfig != nil {
+		data = tls.Client(data, c.tlsConfig())
+	}
+	return data, nil
+}
+
+// finish reads the reply sent once a transfer is complete
+func (c *conn) finish() error {
+	c.deadline()
+	_, _, err := c.tp.ReadResponse(2)
+	if err != nil {
+		if _, ok := err.(*textproto.Error); !ok {
+			c.broken = true
+		}
+	}
+	return err
+}
+
+// passive returns the address of a new data connection, EPSV first
+// unless disabled, then PASV
+func (c *conn) passive() (string, error) {
+	if !c.fs.DisableEPSV {
+		code, msg, err := c.cmd(0, "EPSV")
+		if err != nil {
+			return "", err
+		}
+		if code == 229 {
+			// 229 Entering Extended Passive Mode (|||6446|)
+			start, end := strings.Index(msg, "(|||"), strings.LastIndex(msg, "|)")
+			if start < 0 || end < start+4 {
+				return "", &textproto.Error{Code: code, Msg: "malformed EPSV reply: " + msg}
+			}
+			return net.JoinHostPort(c.host, msg[start+4:end]), nil
+		}
+	}
+
+	_, msg, err := c.cmd(227, "PASV")
+	if err != nil {
+		return "", err
+	}
+	// 227 Entering Passive Mode (h1,h2,h3,h4,p1,p2)
+	start, end := strings.Index(msg, "("), strings.LastIndex(msg, ")")
+	if start < 0 || end < start {
+		return "", &textproto.Error{Code: 227, Msg: "malformed PASV reply: " + msg}
+	}
+	parts := strings.Split(msg[start+1:end], ",")
+	if len(parts) != 6 {
+		return "", &textproto.Error{Code: 227, Msg: "malformed PASV reply: " + msg}
+	}
+	p1, err1 := strconv.Atoi(parts[4])
+	p2, err2 := strconv.Atoi(parts[5])
+	if err1 != nil || err2 != nil {
+		return "", &textproto.Error{Code: 227, Msg: "malformed PASV reply: " + msg}
+	}
+
+	// the advertised IP is often wrong behind NAT, always use the control
+	// connection's host
+	return net.JoinHostPort(c.host, fmt.Sprint(p1<<8|p2)), nil
+}
+
+func (c *conn) close() error {
+	return c.netc.Close()
+}
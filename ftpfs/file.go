@@ -0,0 +1,380 @@
+package ftpfs
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"net/textproto"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// file is either a directory, a file being read with RETR (restarted with
+// REST after a Seek) or a file being written with STOR
+type file struct {
+	fs   *FS
+	name string
+	info os.FileInfo
+
+	// active transfer, if any
+	conn *conn
+	data net.Conn
+
+	off     int64
+	writer  bool
+	listing []os.FileInfo
+	listed  bool
+	closed  bool
+}
+
+func (f *file) Stat() (os.FileInfo, error) {
+	if f.closed {
+		return nil, os.ErrClosed
+	}
+	return f.info, nil
+}
+
+func (f *file) Readdir(count int) ([]os.FileInfo, error) {
+	if f.closed {
+		return nil, os.ErrClosed
+	}
+	if !f.info.IsDir() {
+		return nil, pathError("readdir", f.name, errNotDir)
+	}
+
+	if !f.listed {
+		err := f.fs.do(func(c *conn) (err error) {
+			f.listing, err = c.list(f.name)
+			return err
+		})
+		if err != nil {
+			return nil, pathError("readdir", f.name, err)
+		}
+		f.listed = true
+	}
+
+	if count <= 0 {
+		fis := f.listing
+		f.listing = nil
+		return fis, nil
+	}
+	if len(f.listing) == 0 {
+		return nil, io.EOF
+	}
+	if count > len(f.listing) {
+		count = len(f.listing)
+	}
+	fis := f.listing[:count]
+	f.listing = f.listing[count:]
+	return fis, nil
+}
+
+func (f *file) Read(p []byte) (int, error) {
+	if f.closed {
+		return 0, os.ErrClosed
+	}
+	if f.writer {
+		return 0, pathError("read", f.name, errors.New("file opened for writing"))
+	}
+	if f.info.IsDir() {
+		return 0, pathError("read", f.name, errors.New("is a directory"))
+	}
+	if f.off >= f.info.Size() {
+		return 0, io.EOF
+	}
+
+	if f.data == nil {
+		if err := f.retrieve(); err != nil {
+			return 0, err
+		}
+	}
+
+	f.data.SetReadDeadline(time.Now().Add(f.fs.timeout()))
+	n, err := f.data.Read(p)
+	f.off += int64(n)
+	if err == io.EOF && f.off < f.info.Size() {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+// retrieve starts a RETR at the current offset
+func (f *file) retrieve() error {
+	c, err := f.fs.get()
+	if err != nil {
+		return err
+	}
+
+	if f.off > 0 {
+		if _, _, err := c.cmd(350, "REST %d", f.off); err != nil {
+			f.fs.put(c)
+			return pathError("read", f.name, err)
+		}
+	}
+
+	data, err := c.transfer("RETR %s", f.name)
+	if err != nil {
+		f.fs.put(c)
+		return pathError("read", f.name, err)
+	}
+
+	f.conn, f.data = c, data
+	return nil
+}
+
+// stop ends the active transfer and returns its connection to the pool
+func (f *file) stop() error {
+	if f.data == nil {
+		return nil
+	}
+
+	f.data.Close()
+	err := f.conn.finish()
+	if !f.writer && f.off < f.info.Size() {
+		// an aborted RETR ends with 426 or similar, that is expected
+		if _, ok := err.(*textproto.Error); ok {
+			err = nil
+		}
+	}
+
+	f.fs.put(f.conn)
+	f.conn, f.data = nil, nil
+	return err
+}
+
+func (f *file) Write(p []byte) (int, error) {
+	if f.closed {
+		return 0, os.ErrClosed
+	}
+	if !f.writer {
+		return 0, pathError("write", f.name, errors.New("file opened for reading"))
+	}
+
+	f.data.SetWriteDeadline(time.Now().Add(f.fs.timeout()))
+	n, err := f.data.Write(p)
+	f.off += int64(n)
+	return n, err
+}
+
+func (f *file) Seek(offset int64, whence int) (int64, error) {
+	if f.closed {
+		return 0, os.ErrClosed
+	}
+
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.off
+	case io.SeekEnd:
+		offset += f.info.Size()
+	default:
+		return 0, pathError("seek", f.name, os.ErrInvalid)
+	}
+	if offset < 0 || f.writer && offset != f.off {
+		return 0, pathError("seek", f.name, os.ErrInvalid)
+	}
+
+	if offset != f.off {
+		// restart the transfer at the new offset on the next Read
+		if err := f.stop(); err != nil {
+			return 0, err
+		}
+		f.off = offset
+	}
+	return offset, nil
+}
+
+// Close ends the transfer, for a file opened by Create this is where the
+// server confirms the upload
+func (f *file) Close() error {
+	if f.closed {
+		return os.ErrClosed
+	}
+	f.closed = true
+	return f.stop()
+}
+
+// stat uses MLST when the server has it and falls back to SIZE/MDTM,
+// telling directories apart by trying to CWD into them
+func (c *conn) stat(name string) (os.FileInfo, error) {
+	if name == "/" {
+		return &fileInfo{name: "/", isDir: true}, nil
+	}
+
+	if !c.noMLST {
+		code, msg, err := c.cmd(0, "MLST %s", name)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case code == 250:
+			// 250-Listing name\n facts; name\n250 End
+			for _, line := range strings.Split(msg, "\n") {
+				if strings.HasPrefix(line, " ") {
+					if fi := parseMLSx(strings.TrimPrefix(line, " ")); fi != nil {
+						fi.name = path.Base(name)
+						return fi, nil
+					}
+				}
+			}
+			return nil, &textproto.Error{Code: code, Msg: "malformed MLST reply: " + msg}
+		case code == 550:
+			return nil, os.ErrNotExist
+		case code >= 500:
+			c.noMLST = true
+		default:
+			return nil, &textproto.Error{Code: code, Msg: msg}
+		}
+	}
+
+	fi := &fileInfo{name: path.Base(name)}
+	if code, msg, err := c.cmd(0, "SIZE %s", name); err != nil {
+		return nil, err
+	} else if code == 213 {
+		fi.size, _ = strconv.ParseInt(strings.TrimSpace(msg), 10, 64)
+	} else if code, _, err := c.cmd(0, "CWD %s", name); err != nil {
+		return nil, err
+	} else if code == 250 {
+		fi.isDir = true
+		c.cmd(0, "CWD /")
+	} else {
+		return nil, os.ErrNotExist
+	}
+
+	if code, msg, err := c.cmd(0, "MDTM %s", name); err == nil && code == 213 {
+		fi.modTime, _ = time.Parse("20060102150405", strings.TrimSpace(msg))
+	}
+	return fi, nil
+}
+
+// list returns the entries of a directory, from MLSD when the server has it
+// and from a unix style LIST otherwise
+func (c *conn) list(name string) ([]os.FileInfo, error) {
+	cmd := "MLSD %s"
+	if c.noMLST {
+		cmd = "LIST %s"
+	}
+
+	data, err := c.transfer(cmd, name)
+	if err != nil {
+		if tpErr, ok := err.(*textproto.Error); ok && tpErr.Code >= 500 && !c.noMLST && tpErr.Code != 550 {
+			c.noMLST = true
+			return c.list(name)
+		}
+		return nil, err
+	}
+
+	var fis []os.FileInfo
+	s := bufio.NewScanner(data)
+	for s.Scan() {
+		line := strings.TrimRight(s.Text(), "\r")
+		var fi *fileInfo
+		if c.noMLST {
+			fi = parseLIST(line)
+		} else {
+			fi = parseMLSx(line)
+		}
+		if fi != nil && fi.name != "." && fi.name != ".." {
+			fis = append(fis, fi)
+		}
+	}
+	data.Close()
+
+	if err := c.finish(); err != nil {
+		return nil, err
+	}
+	return fis, s.Err()
+}
+
+// parseMLSx parses a "fact=value;fact=value; name" line as sent by MLST
+// and MLSD (RFC 3659). It returns nil for the current and parent entries.
+func parseMLSx(line string) *fileInfo {
+	i := strings.Index(line, " ")
+	if i < 0 {
+		return nil
+	}
+
+	fi := &fileInfo{name: line[i+1:]}
+	for _, fact := range strings.Split(line[:i], ";") {
+		kv := strings.SplitN(fact, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch strings.ToLower(kv[0]) {
+		case "type":
+			switch strings.ToLower(kv[1]) {
+			case "dir":
+				fi.isDir = true
+			case "cdir", "pdir":
+				return nil
+			}
+		case "size":
+			fi.size, _ = strconv.ParseInt(kv[1], 10, 64)
+		case "modify":
+			fi.modTime, _ = time.Parse("20060102150405", kv[1][:min(len(kv[1]), 14)])
+		}
+	}
+	return fi
+}
+
+// parseLIST parses a line of unix ls -l style output:
+//
+//	drwxr-xr-x   2 user group     4096 Mar  1 12:34 name with spaces
+func parseLIST(line string) *fileInfo {
+	fields := strings.Fields(line)
+	if len(fields) < 9 || len(fields[0]) < 10 {
+		return nil
+	}
+
+	fi := &fileInfo{isDir: fields[0][0] == 'd'}
+	fi.size, _ = strconv.ParseInt(fields[4], 10, 64)
+
+	stamp := strings.Join(fields[5:8], " ")
+	if t, err := time.Parse("Jan _2 15:04", stamp); err == nil {
+		now := time.Now()
+		fi.modTime = t.AddDate(now.Year(), 0, 0)
+		if fi.modTime.After(now) {
+			fi.modTime = fi.modTime.AddDate(-1, 0, 0)
+		}
+	} else if t, err := time.Parse("Jan _2 2006", stamp); err == nil {
+		fi.modTime = t
+	}
+
+	// the name is everything after the eighth field, spaces included
+	rest := line
+	for i := 0; i < 8; i++ {
+		rest = strings.TrimLeft(rest, " ")
+		rest = rest[strings.Index(rest, " "):]
+	}
+	fi.name = strings.TrimLeft(rest, " ")
+	if fields[0][0] == 'l' {
+		if i := strings.Index(fi.name, " -> "); i >= 0 {
+			fi.name = fi.name[:i]
+		}
+	}
+	return fi
+}
+
+type fileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	isDir   bool
+}
+
+func (fi *fileInfo) Name() string       { return fi.name }
+func (fi *fileInfo) Size() int64        { return fi.size }
+func (fi *fileInfo) ModTime() time.Time { return fi.modTime }
+func (fi *fileInfo) IsDir() bool        { return fi.isDir }
+func (fi *fileInfo) Sys() interface{}   { return nil }
+
+func (fi *fileInfo) Mode() os.FileMode {
+	if fi.isDir {
+		return os.ModeDir | 0755
+	}
+	return 0644
+}
@@ -0,0 +1,267 @@
+/*
+Package ftpfs implements webdav.FileSystem on top of an FTP server, so the
+webdav Server can front a host that only speaks FTP.
+
+	fs := &ftpfs.FS{Addr: "nas.local:21", User: "dav", Password: "secret"}
+	defer fs.Close()
+	http.Handle("/dav/", &webdav.Server{Fs: fs, TrimPrefix: "/dav/"})
+
+Every open file holds one control connection for the duration of its
+transfer, since an FTP connection can only run one transfer at a time.
+Connections come from a small pool bounded by MaxConns. Only passive mode
+is supported.
+*/
+package ftpfs
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/textproto"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rbastic/webdav"
+)
+
+// defaults for zero FS fields
+const (
+	DefaultTimeout  = 30 * time.Second
+	DefaultMaxConns = 4
+)
+
+// ErrClosed is returned by operations on a closed FS
+var ErrClosed = errors.New("ftpfs: file system closed")
+
+// FS is a webdav.FileSystem served by an FTP server
+type FS struct {
+	// host:port of the server
+	Addr string
+
+	// credentials, an empty User logs in as anonymous
+	User     string
+	Password string
+
+	// remote directory served as the root of the file system
+	Root string
+
+	// dial and command timeout
+	Timeout time.Duration
+
+	// enables explicit FTPS (AUTH TLS) for control and data connections
+	TLSConfig *tls.Config
+
+	// skip EPSV and go straight to PASV, for old servers and middleboxes
+	DisableEPSV bool
+
+	// maximum number of simultaneous control connections
+	MaxConns int
+
+	once     sync.Once
+	mu       sync.Mutex
+	idle     []*conn
+	wait     chan struct{}
+	closed   bool
+	sessions tls.ClientSessionCache
+}
+
+func (fs *FS) timeout() time.Duration {
+	if fs.Timeout <= 0 {
+		return DefaultTimeout
+	}
+	return fs.Timeout
+}
+
+func (fs *FS) init() {
+	fs.once.Do(func() {
+		max := fs.MaxConns
+		if max <= 0 {
+			max = DefaultMaxConns
+		}
+		fs.wait = make(chan struct{}, max)
+		fs.sessions = tls.NewLRUClientSessionCache(max)
+	})
+}
+
+// get returns an idle connection or dials a new one, blocking while
+// MaxConns connections are in use
+func (fs *FS) get() (*conn, error) {
+	fs.init()
+	fs.wait <- struct{}{}
+
+	fs.mu.Lock()
+	if fs.closed {
+		fs.mu.Unlock()
+		<-fs.wait
+		return nil, ErrClosed
+	}
+	if n := len(fs.idle); n > 0 {
+		c := fs.idle[n-1]
+		fs.idle = fs.idle[:n-1]
+		fs.mu.Unlock()
+		return c, nil
+	}
+	fs.mu.Unlock()
+
+	c, err := fs.dial()
+	if err != nil {
+		<-fs.wait
+		return nil, err
+	}
+	return c, nil
+}
+
+// put hands a connection back to the pool, broken ones are dropped
+func (fs *FS) put(c *conn) {
+	fs.mu.Lock()
+	if c.broken || fs.closed {
+		fs.mu.Unlock()
+		c.close()
+	} else {
+		fs.idle = append(fs.idle, c)
+		fs.mu.Unlock()
+	}
+	<-fs.wait
+}
+
+// Close logs out and closes the idle connections. Connections held by
+// open files are closed when those files are. Close implements
+// webdav.FileSystemCloser.
+func (fs *FS) Close() error {
+	fs.mu.Lock()
+	idle := fs.idle
+	fs.idle = nil
+	fs.closed = true
+	fs.mu.Unlock()
+
+	for _, c := range idle {
+		c.cmd(0, "QUIT")
+		c.close()
+	}
+	return nil
+}
+
+// remote maps a FileSystem name to the server path
+func (fs *FS) remote(name string) string {
+	return path.Join("/", fs.Root, path.Clean("/"+name))
+}
+
+// do runs fn on a pooled connection
+func (fs *FS) do(fn func(c *conn) error) error {
+	c, err := fs.get()
+	if err != nil {
+		return err
+	}
+	defer fs.put(c)
+
+	return fn(c)
+}
+
+// Open opens a file for reading or a directory for listing
+func (fs *FS) Open(name string) (webdav.File, error) {
+	var fi os.FileInfo
+	err := fs.do(func(c *conn) (err error) {
+		fi, err = c.stat(fs.remote(name))
+		return err
+	})
+	if err != nil {
+		return nil, pathError("open", name, err)
+	}
+
+	return &file{fs: fs, name: fs.remote(name), info: fi}, nil
+}
+
+// Create starts a STOR of the named file, the upload completes on Close
+func (fs *FS) Create(name string) (webdav.File, error) {
+	c, err := fs.get()
+	if err != nil {
+		return nil, err
+	}
+
+	remote := fs.remote(name)
+	data, err := c.transfer("STOR %s", remote)
+	if err != nil {
+		fs.put(c)
+		return nil, pathError("create", name, err)
+	}
+
+	return &file{
+		fs:     fs,
+		name:   remote,
+		info:   &fileInfo{name: path.Base(remote), modTime: time.Now()},
+		conn:   c,
+		data:   data,
+		writer: true,
+	}, nil
+}
+
+// Mkdir creates the named directory and any missing parents
+func (fs *FS) Mkdir(name string) error {
+	return fs.do(func(c *conn) error {
+		p := "/"
+		for _, elem := range strings.Split(strings.Trim(fs.remote(name), "/"), "/") {
+			if elem == "" {
+				continue
+			}
+			p = path.Join(p, elem)
+
+			if fi, err := c.stat(p); err == nil {
+				if !fi.IsDir() {
+					return pathError("mkdir", name, errNotDir)
+				}
+				continue
+			}
+			if _, _, err := c.cmd(257, "MKD %s", p); err != nil {
+				return pathError("mkdir", name, err)
+			}
+		}
+		return nil
+	})
+}
+
+// Remove deletes a file or an empty directory
+func (fs *FS) Remove(name string) error {
+	return fs.do(func(c *conn) error {
+		remote := fs.remote(name)
+		fi, err := c.stat(remote)
+		if err != nil {
+			return pathError("remove", name, err)
+		}
+
+		if fi.IsDir() {
+			_, _, err = c.cmd(250, "RMD %s", remote)
+		} else {
+			_, _, err = c.cmd(250, "DELE %s", remote)
+		}
+		return pathError("remove", name, err)
+	})
+}
+
+// Rename moves oldname to newname with RNFR/RNTO
+func (fs *FS) Rename(oldname, newname string) error {
+	return fs.do(func(c *conn) error {
+		if _, _, err := c.cmd(350, "RNFR %s", fs.remote(oldname)); err != nil {
+			return pathError("rename", oldname, err)
+		}
+		_, _, err := c.cmd(250, "RNTO %s", fs.remote(newname))
+		return pathError("rename", newname, err)
+	})
+}
+
+var errNotDir = errors.New("not a directory")
+
+// pathError wraps err like the os package does. A 550 reply, which
+// servers send for a missing file or directory, also matches
+// os.ErrNotExist.
+func pathError(op, name string, err error) error {
+	if err == nil {
+		return nil
+	}
+	if tpErr, ok := err.(*textproto.Error); ok && tpErr.Code == 550 {
+		err = fmt.Errorf("%w (%w)", os.ErrNotExist, tpErr)
+	}
+	return &os.PathError{Op: op, Path: name, Err: err}
+}
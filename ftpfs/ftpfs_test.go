@@ -0,0 +1,252 @@
+package ftpfs
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// waitOpen waits until the server has n control connections open
+func waitOpen(t *testing.T, s *testServer, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, open, _ := s.stats(); open == n {
+			return
+		}
+		if time.Now().After(deadline) {
+			_, open, _ := s.stats()
+			t.Fatalf("%d control connections open, want %d", open, n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// TestPool checks operations one after another share a connection, open
+// files each hold their own, and Close logs out of the idle ones
+func TestPool(t *testing.T) {
+	s, fs := newTestServer(t)
+	os.WriteFile(filepath.Join(s.root, "a.txt"), []byte("aaaa"), 0o644)
+	os.WriteFile(filepath.Join(s.root, "b.txt"), []byte("bbbb"), 0o644)
+
+	if err := fs.Mkdir("/dir/sub"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Rename("/a.txt", "/dir/a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Remove("/dir/sub"); err != nil {
+		t.Fatal(err)
+	}
+	if conns, _, _ := s.stats(); conns != 1 {
+		t.Errorf("%d connections for operations in turn, want 1", conns)
+	}
+
+	// two reads in progress hold two connections
+	a, err := fs.Open("/dir/a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := fs.Open("/b.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 1)
+	if _, err := a.Read(buf); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.Read(buf); err != nil {
+		t.Fatal(err)
+	}
+	if conns, _, _ := s.stats(); conns != 2 {
+		t.Errorf("%d connections for two reads, want 2", conns)
+	}
+	if err := a.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the idle connection logs out, the one b holds closes with b
+	if err := fs.Close(); err != nil {
+		t.Fatal(err)
+	}
+	waitOpen(t, s, 1)
+	if _, _, quits := s.stats(); quits != 1 {
+		t.Errorf("%d QUITs, want 1", quits)
+	}
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+	waitOpen(t, s, 0)
+
+	if _, err := fs.Open("/b.txt"); !errors.Is(err, ErrClosed) {
+		t.Errorf("Open after Close: %v", err)
+	}
+	if err := fs.Mkdir("/x"); !errors.Is(err, ErrClosed) {
+		t.Errorf("Mkdir after Close: %v", err)
+	}
+}
+
+// TestCreate uploads through Create, the upload completing on Close
+func TestCreate(t *testing.T) {
+	s, fs := newTestServer(t)
+	if err := fs.Mkdir("/dir"); err != nil {
+		t.Fatal(err)
+	}
+
+	content := bytes.Repeat([]byte("0123456789"), 10000)
+	f, err := fs.Create("/dir/a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for rest := content; len(rest) > 0; {
+		n := min(len(rest), 7777)
+		if _, err := f.Write(rest[:n]); err != nil {
+			t.Fatal(err)
+		}
+		rest = rest[n:]
+	}
+	if pos, err := f.Seek(0, io.SeekCurrent); err != nil || pos != int64(len(content)) {
+		t.Errorf("position while writing %d, %v", pos, err)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err == nil {
+		t.Error("a writer seeked back")
+	}
+	if _, err := f.Read(make([]byte, 1)); err == nil {
+		t.Error("a writer read")
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !s.received("STOR /dir/a.txt") {
+		t.Error("no STOR sent")
+	}
+	if b, err := os.ReadFile(filepath.Join(s.root, "dir", "a.txt")); err != nil || !bytes.Equal(b, content) {
+		t.Errorf("stored %d bytes, %v, want %d", len(b), err, len(content))
+	}
+
+	f, err = fs.Open("/dir/a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if fi, err := f.Stat(); err != nil || fi.Size() != int64(len(content)) || fi.IsDir() {
+		t.Errorf("Stat %+v, %v", fi, err)
+	}
+	if got, err := io.ReadAll(f); err != nil || !bytes.Equal(got, content) {
+		t.Errorf("read back %d bytes, %v", len(got), err)
+	}
+
+	d, err := fs.Open("/dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	if fis, err := d.Readdir(-1); err != nil || len(fis) != 1 || fis[0].Name() != "a.txt" {
+		t.Errorf("Readdir %v, %v", fis, err)
+	}
+
+	if _, err := fs.Create("/missing/a.txt"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Create in a missing directory: %v", err)
+	}
+}
+
+// TestSeek checks a Seek restarts the transfer with REST where the next
+// Read starts
+func TestSeek(t *testing.T) {
+	s, fs := newTestServer(t)
+	content := make([]byte, 1000)
+	for i := range content {
+		content[i] = byte(i)
+	}
+	os.WriteFile(filepath.Join(s.root, "a.bin"), content, 0o644)
+
+	f, err := fs.Open("/a.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	for _, tc := range []struct {
+		offset int64
+		whence int
+		want   int64
+	}{
+		{0, io.SeekCurrent, 0},
+		{500, io.SeekStart, 500},
+		{-5, io.SeekEnd, 995},
+		{-100, io.SeekCurrent, 900},
+	} {
+		pos, err := f.Seek(tc.offset, tc.whence)
+		if err != nil || pos != tc.want {
+			t.Fatalf("Seek(%d, %d) = %d, %v, want %d", tc.offset, tc.whence, pos, err, tc.want)
+		}
+		buf := make([]byte, 5)
+		if _, err := io.ReadFull(f, buf); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(buf, content[pos:pos+5]) {
+			t.Errorf("read at %d %v, want %v", pos, buf, content[pos:pos+5])
+		}
+		if pos > 0 && !s.received("REST "+strconv.FormatInt(pos, 10)) {
+			t.Errorf("no REST %d sent", pos)
+		}
+	}
+	if _, err := f.Seek(-1, io.SeekStart); err == nil {
+		t.Error("seeked before the start")
+	}
+	if _, err := f.Seek(0, io.SeekEnd); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := f.Read(make([]byte, 1)); n != 0 || err != io.EOF {
+		t.Errorf("Read at the end = %d, %v", n, err)
+	}
+}
+
+// TestNotExist checks the 550 replies to MKD, RNFR, RNTO and DELE match
+// os.ErrNotExist and still carry the reply
+func TestNotExist(t *testing.T) {
+	s, fs := newTestServer(t)
+	os.WriteFile(filepath.Join(s.root, "a.txt"), []byte("a"), 0o644)
+
+	for _, tc := range []struct {
+		name   string
+		refuse string
+		op     func() error
+	}{
+		{"RNFR of a missing file", "", func() error { return fs.Rename("/missing", "/b.txt") }},
+		{"RNTO into a missing directory", "", func() error { return fs.Rename("/a.txt", "/missing/b.txt") }},
+		{"Remove of a missing file", "", func() error { return fs.Remove("/missing") }},
+		{"Open of a missing file", "", func() error { _, err := fs.Open("/missing"); return err }},
+		{"MKD refused", "MKD", func() error { return fs.Mkdir("/dir") }},
+		{"DELE refused", "DELE", func() error { return fs.Remove("/a.txt") }},
+	} {
+		s.mu.Lock()
+		s.refuse = map[string]bool{tc.refuse: true}
+		s.mu.Unlock()
+		err := tc.op()
+		if !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("%s: %v, want os.ErrNotExist", tc.name, err)
+		}
+		var pe *os.PathError
+		if !errors.As(err, &pe) {
+			t.Errorf("%s: %v is no *os.PathError", tc.name, err)
+		}
+		var tpErr *textproto.Error
+		if tc.refuse != "" && (!errors.As(err, &tpErr) || tpErr.Code != 550) {
+			t.Errorf("%s: %v doesn't carry the 550 reply", tc.name, err)
+		}
+	}
+
+	// other failures don't
+	s.mu.Lock()
+	s.refuse = map[string]bool{}
+	s.mu.Unlock()
+	if err := fs.Mkdir("/a.txt/sub"); err == nil || errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Mkdir below a file: %v", err)
+	}
+}
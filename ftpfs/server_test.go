@@ -0,0 +1,278 @@
+package ftpfs
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// testServer is an FTP server over a directory, speaking as much of the
+// protocol as FS uses: passive transfers, MLST and MLSD, REST and the file
+// commands
+type testServer struct {
+	root string
+	ln   net.Listener
+
+	mu     sync.Mutex
+	conns  int             // control connections accepted
+	open   int             // control connections not yet closed
+	quits  int             // QUIT commands received
+	cmds   []string        // every command received
+	refuse map[string]bool // verbs answered with 550 regardless
+}
+
+// newTestServer serves a new temporary directory and returns the server
+// and an FS connected to it
+func newTestServer(t *testing.T) (*testServer, *FS) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &testServer{root: t.TempDir(), ln: ln, refuse: map[string]bool{}}
+	go s.serve()
+	t.Cleanup(func() { ln.Close() })
+
+	fs := &FS{Addr: ln.Addr().String(), User: "dav", Password: "secret"}
+	t.Cleanup(func() { fs.Close() })
+	return s, fs
+}
+
+func (s *testServer) serve() {
+	for {
+		c, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.conns++
+		s.open++
+		s.mu.Unlock()
+		go s.handle(c)
+	}
+}
+
+// stats returns the connections accepted and still open, and the QUITs
+func (s *testServer) stats() (conns, open, quits int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.conns, s.open, s.quits
+}
+
+// received reports whether a command line was received
+func (s *testServer) received(line string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, cmd := range s.cmds {
+		if cmd == line {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *testServer) local(name string) string {
+	return filepath.Join(s.root, filepath.FromSlash(name))
+}
+
+// facts returns the MLST facts of fi, up to the name
+func facts(fi os.FileInfo) string {
+	kind := "file"
+	if fi.IsDir() {
+		kind = "dir"
+	}
+	return fmt.Sprintf("type=%s;size=%d;modify=%s;", kind, fi.Size(), fi.ModTime().UTC().Format("20060102150405"))
+}
+
+func (s *testServer) handle(netc net.Conn) {
+	defer func() {
+		netc.Close()
+		s.mu.Lock()
+		s.open--
+		s.mu.Unlock()
+	}()
+	tp := textproto.NewConn(netc)
+	reply := func(code int, format string, args ...interface{}) {
+		tp.PrintfLine("%d "+format, append([]interface{}{code}, args...)...)
+	}
+
+	var (
+		pasv net.Listener
+		rest int64
+		rnfr string
+	)
+	defer func() {
+		if pasv != nil {
+			pasv.Close()
+		}
+	}()
+	// data accepts the data connection of a transfer
+	data := func() net.Conn {
+		if pasv == nil {
+			reply(425, "Use EPSV first")
+			return nil
+		}
+		c, err := pasv.Accept()
+		pasv.Close()
+		pasv = nil
+		if err != nil {
+			reply(425, "No data connection")
+			return nil
+		}
+		return c
+	}
+
+	reply(220, "test server ready")
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, arg, _ := strings.Cut(line, " ")
+		verb = strings.ToUpper(verb)
+		s.mu.Lock()
+		s.cmds = append(s.cmds, line)
+		refused := s.refuse[verb]
+		s.mu.Unlock()
+		if refused {
+			reply(550, "Refused")
+			continue
+		}
+
+		switch verb {
+		case "USER":
+			reply(331, "Password required")
+		case "PASS":
+			reply(230, "Logged in")
+		case "TYPE":
+			reply(200, "Type set")
+		case "QUIT":
+			s.mu.Lock()
+			s.quits++
+			s.mu.Unlock()
+			reply(221, "Bye")
+			return
+		case "EPSV":
+			if pasv != nil {
+				pasv.Close()
+			}
+			if pasv, err = net.Listen("tcp", "127.0.0.1:0"); err != nil {
+				reply(425, "Can't listen")
+				continue
+			}
+			reply(229, "Entering Extended Passive Mode (|||%d|)", pasv.Addr().(*net.TCPAddr).Port)
+		case "MLST":
+			fi, err := os.Stat(s.local(arg))
+			if err != nil {
+				reply(550, "No such file")
+				continue
+			}
+			tp.PrintfLine("250-Listing %s", arg)
+			tp.PrintfLine(" %s %s", facts(fi), arg)
+			reply(250, "End")
+		case "MLSD":
+			entries, err := os.ReadDir(s.local(arg))
+			if err != nil {
+				reply(550, "No such directory")
+				continue
+			}
+			c := data()
+			if c == nil {
+				continue
+			}
+			reply(150, "Listing")
+			for _, e := range entries {
+				if fi, err := e.Info(); err == nil {
+					fmt.Fprintf(c, "%s %s\r\n", facts(fi), e.Name())
+				}
+			}
+			c.Close()
+			reply(226, "Done")
+		case "REST":
+			if rest, err = strconv.ParseInt(arg, 10, 64); err != nil {
+				reply(501, "Bad offset")
+				continue
+			}
+			reply(350, "Restarting at %d", rest)
+		case "RETR":
+			f, err := os.Open(s.local(arg))
+			if err != nil {
+				reply(550, "No such file")
+				continue
+			}
+			c := data()
+			if c == nil {
+				f.Close()
+				continue
+			}
+			reply(150, "Sending")
+			f.Seek(rest, io.SeekStart)
+			rest = 0
+			_, err = io.Copy(c, f)
+			f.Close()
+			c.Close()
+			if err != nil {
+				reply(426, "Transfer aborted")
+			} else {
+				reply(226, "Done")
+			}
+		case "STOR":
+			f, err := os.Create(s.local(arg))
+			if err != nil {
+				reply(550, "Can't create")
+				continue
+			}
+			c := data()
+			if c == nil {
+				f.Close()
+				continue
+			}
+			reply(150, "Receiving")
+			_, err = io.Copy(f, bufio.NewReader(c))
+			c.Close()
+			f.Close()
+			if err != nil {
+				reply(426, "Transfer aborted")
+			} else {
+				reply(226, "Done")
+			}
+		case "MKD":
+			if err := os.Mkdir(s.local(arg), 0o755); err != nil {
+				reply(550, "Can't create directory")
+				continue
+			}
+			reply(257, "%q created", arg)
+		case "RMD", "DELE":
+			fi, err := os.Stat(s.local(arg))
+			if err != nil || fi.IsDir() != (verb == "RMD") || os.Remove(s.local(arg)) != nil {
+				reply(550, "Can't remove")
+				continue
+			}
+			reply(250, "Removed")
+		case "RNFR":
+			if _, err := os.Stat(s.local(arg)); err != nil {
+				reply(550, "No such file")
+				continue
+			}
+			rnfr = arg
+			reply(350, "Ready for RNTO")
+		case "RNTO":
+			if rnfr == "" || os.Rename(s.local(rnfr), s.local(arg)) != nil {
+				reply(550, "Can't rename")
+				continue
+			}
+			rnfr = ""
+			reply(250, "Renamed")
+		default:
+			reply(502, "Not implemented")
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package webdav
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"io"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// wellFormed reports whether s is well formed XML
+func wellFormed(s string) error {
+	d := xml.NewDecoder(strings.NewReader(s))
+	for {
+		if _, err := d.Token(); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+// addFiles adds the contents of the files matching pattern to the corpus
+// of f, with the extra arguments
+func addFiles(f *testing.F, pattern string, args ...any) {
+	names, err := filepath.Glob(pattern)
+	if err != nil || len(names) == 0 {
+		f.Fatalf("no seeds %s: %v", pattern, err)
+	}
+	for _, name := range names {
+		b, err := os.ReadFile(name)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(append([]any{string(b)}, args...)...)
+	}
+}
+
+// FuzzLock sends LOCK bodies, Timeout and If headers to a Server with
+// FakeLocks, which echoes parts of them in its XML answer. The seeds in
+// testdata/lockinfo are lockinfo bodies in the forms Windows, the macOS
+// Finder, cadaver and Office send.
+func FuzzLock(f *testing.F) {
+	addFiles(f, filepath.Join("testdata", "lockinfo", "*.xml"), "Second-3600", "")
+	f.Add("", "Infinite", "(<urn:uuid:4f2a0b7e-1c3d-4e5f-8a9b-0c1d2e3f4a5b>)")
+	f.Add("", "Second-60", "<http://example.com/f> (<opaquelocktoken:1234>)")
+	f.Add("<lockinfo xmlns='DAV:'><owner>a</owner>", "Second-<x/>", "")
+
+	s := &Server{Fs: NewMemFS(), TrimPrefix: "/", FakeLocks: true}
+	f.Fuzz(func(t *testing.T, body, timeout, ifh string) {
+		r := httptest.NewRequest("LOCK", "/f", strings.NewReader(body))
+		r.Header.Set("Timeout", timeout)
+		r.Header.Set("If", ifh)
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		if w.Code != StatusOK && w.Code != StatusCreated {
+			return
+		}
+		if err := wellFormed(w.Body.String()); err != nil {
+			t.Fatalf("LOCK answered %s: %v", w.Body, err)
+		}
+		token := strings.Trim(w.Header().Get("Lock-Token"), "<>")
+		var prop struct {
+			Token   string `xml:"DAV: lockdiscovery>activelock>locktoken>href"`
+			Timeout string `xml:"DAV: lockdiscovery>activelock>timeout"`
+		}
+		if err := xml.Unmarshal(w.Body.Bytes(), &prop); err != nil || prop.Token != token {
+			t.Fatalf("LOCK answered token %q, %v, header %q", prop.Token, err, token)
+		}
+		if prop.Timeout != "Infinite" && !strings.HasPrefix(prop.Timeout, "Second-") {
+			t.Fatalf("LOCK answered timeout %q", prop.Timeout)
+		}
+	})
+}
+
+// FuzzBatchDelete decodes batch delete bodies, and posts them to a
+// collection. A body that decodes encodes back to the same members.
+func FuzzBatchDelete(f *testing.F) {
+	f.Add(`<B:delete xmlns:B="urn:x-webdav:batch" xmlns:D="DAV:"><B:member><D:href>/d/a</D:href><D:getetag>"x"</D:getetag></B:member><B:member><D:href>b</D:href></B:member></B:delete>`, false)
+	f.Add(`{"members": [{"href": "/d/a", "etag": "\"x\""}, {"href": "/d/b"}]}`, true)
+	f.Add(`{"members": [{"href": "/elsewhere"}, {"href": "http://other/d/a"}, {"href": "/d/../d/a"}]}`, true)
+	f.Add(strings.Repeat("<a>", 100), false)
+
+	f.Fuzz(func(t *testing.T, body string, isJSON bool) {
+		var req batchDelete
+		var err error
+		if isJSON {
+			err = json.Unmarshal([]byte(body), &req)
+		} else {
+			err = newXMLDecoder(strings.NewReader(body), maxBatchTokens).Decode(&req)
+		}
+		if err == nil {
+			var again batchDelete
+			if isJSON {
+				b, _ := json.Marshal(req)
+				err = json.Unmarshal(b, &again)
+			} else {
+				b, _ := xml.Marshal(req)
+				err = xml.Unmarshal(b, &again)
+			}
+			if err != nil || !reflect.DeepEqual(req.Members, again.Members) && len(req.Members)+len(again.Members) > 0 {
+				t.Fatalf("members %q round trip to %q, %v", req.Members, again.Members, err)
+			}
+		}
+
+		m := NewMemFS()
+		m.Mkdir("/d")
+		writeMem(t, m, "/d/a", nil)
+		writeMem(t, m, "/keep", nil)
+		s := &Server{Fs: m, TrimPrefix: "/", BatchDelete: true}
+		r := httptest.NewRequest("POST", "/d", strings.NewReader(body))
+		if isJSON {
+			r.Header.Set("Content-Type", "application/json")
+		}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		if w.Code == StatusMulti {
+			if err := wellFormed(w.Body.String()); err != nil {
+				t.Fatalf("batch delete answered %s: %v", w.Body, err)
+			}
+		}
+		if _, ok := memFiles(t, m)["/keep"]; !ok {
+			t.Fatal("batch delete of /d removed /keep")
+		}
+	})
+}
+
+// FuzzIfLockToken reads If headers, in the forms Windows, the Finder and
+// cadaver send among them. The token found is always one of the header,
+// and the token of an If header FormatIf writes is found again.
+func FuzzIfLockToken(f *testing.F) {
+	f.Add("(<opaquelocktoken:e71d4fae-5dec-22d6-fea5-00a0c91e6be4>)")
+	f.Add("(<urn:uuid:181d4fae-7d8c-11d0-a765-00a0c91e6bf2>)")
+	f.Add(`<http://example.com/dav/f> (<opaquelocktoken:1> ["etag"])`)
+	f.Add(`(Not <DAV:no-lock> ["x"]) (<urn:uuid:2>)`)
+	f.Add("(<urn:uuid:")
+
+	f.Fuzz(func(t *testing.T, h string) {
+		tok := ifLockToken(h)
+		if tok != "" && !strings.Contains(h, "<"+tok+">") {
+			t.Fatalf("ifLockToken(%q) = %q, not a token of it", h, tok)
+		}
+		if strings.ContainsAny(tok, "<>") || tok != "" && !strings.HasPrefix(tok, "urn:uuid:") && !strings.HasPrefix(tok, "opaquelocktoken:") {
+			return
+		}
+		if tok == "" {
+			tok = "urn:uuid:" + strings.Map(func(r rune) rune {
+				if r < '!' || r > '~' || strings.ContainsRune("<>()[]\"", r) {
+					return -1
+				}
+				return r
+			}, h)
+		}
+		formatted := FormatIf("http://example.com/dav/f", IfList{Tokens: []string{tok}})
+		if got := ifLockToken(formatted); got != tok {
+			t.Fatalf("ifLockToken(%s) = %q, want %q", formatted, got, tok)
+		}
+	})
+}
+
+func TestXMLLimits(t *testing.T) {
+	deep := `<D:multistatus xmlns:D="DAV:"><D:response><D:href>/a</D:href><D:propstat><D:prop>` +
+		strings.Repeat("<x>", maxXMLDepth) + strings.Repeat("</x>", maxXMLDepth) +
+		`</D:prop><D:status>HTTP/1.1 200 OK</D:status></D:propstat></D:response></D:multistatus>`
+	if _, err := parseMultistatus(strings.NewReader(deep)); !errors.Is(err, errXMLLimit) {
+		t.Errorf("multistatus nested %d deep: %v, want errXMLLimit", maxXMLDepth+5, err)
+	}
+	shallow := strings.Replace(deep, strings.Repeat("<x>", maxXMLDepth), strings.Repeat("<x>", 10), 1)
+	shallow = strings.Replace(shallow, strings.Repeat("</x>", maxXMLDepth), strings.Repeat("</x>", 10), 1)
+	if _, err := parseMultistatus(strings.NewReader(shallow)); err != nil {
+		t.Errorf("multistatus nested 15 deep: %v", err)
+	}
+
+	s := &Server{Fs: NewMemFS(), TrimPrefix: "/", BatchDelete: true}
+	s.Fs.Mkdir("/d")
+	long := `<B:delete xmlns:B="urn:x-webdav:batch">` + strings.Repeat("<B:x/>", maxBatchTokens) + `</B:delete>`
+	r := httptest.NewRequest("POST", "/d", strings.NewReader(long))
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	if w.Code != StatusBadRequest {
+		t.Errorf("batch delete of %d tokens: %d, want 400", maxBatchTokens, w.Code)
+	}
+
+	// a Timeout that isn't a number of seconds isn't echoed into the XML
+	s.FakeLocks = true
+	r = httptest.NewRequest("LOCK", "/f", nil)
+	r.Header.Set("Timeout", "Second-1</D:timeout><D:x/><D:timeout>")
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	if strings.Contains(w.Body.String(), "<D:x/>") {
+		t.Errorf("LOCK echoed the Timeout header: %s", w.Body)
+	}
+}
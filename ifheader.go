@@ -0,0 +1,152 @@
+package webdav
+
+import (
+	"net/url"
+	"os"
+	"strings"
+)
+
+// ifCond is a condition of an If header list: a state token, or a quoted
+// entity tag, that must match, or with not mustn't
+type ifCond struct {
+	not   bool
+	token string
+	etag  string
+}
+
+// ifCondList is a list of an If header, resource is its tag or empty for
+// the request-URI
+type ifCondList struct {
+	resource string
+	conds    []ifCond
+}
+
+// parseIf parses an If header, RFC 4918 section 10.4. It reports false if
+// the header doesn't follow the grammar, which has no empty lists and
+// doesn't mix tagged and untagged ones.
+func parseIf(h string) ([]ifCondList, bool) {
+	var lists []ifCondList
+	resource, tagged, untagged := "", false, false
+	p := strings.TrimSpace(h)
+	for p != "" {
+		switch p[0] {
+		case '<':
+			end := strings.IndexByte(p, '>')
+			if end < 0 {
+				return nil, false
+			}
+			resource, tagged = p[1:end], true
+			if p = strings.TrimLeft(p[end+1:], " \t"); !strings.HasPrefix(p, "(") {
+				return nil, false
+			}
+		case '(':
+			l := ifCondList{resource: resource}
+			untagged = untagged || resource == ""
+			p = p[1:]
+			for {
+				if p = strings.TrimLeft(p, " \t"); p == "" {
+					return nil, false
+				}
+				if p[0] == ')' {
+					p = p[1:]
+					break
+				}
+				var c ifCond
+				if len(p) >= 3 && strings.EqualFold(p[:3], "Not") {
+					c.not, p = true, strings.TrimLeft(p[3:], " \t")
+				}
+				var end int
+				switch {
+				case strings.HasPrefix(p, "<"):
+					end = strings.IndexByte(p, '>')
+					if end > 0 {
+						c.token = p[1:end]
+					}
+				case strings.HasPrefix(p, "["):
+					end = strings.IndexByte(p, ']')
+					if end > 0 {
+						c.etag = strings.TrimSpace(p[1:end])
+					}
+				default:
+					return nil, false
+				}
+				if end <= 1 {
+					return nil, false
+				}
+				p = p[end+1:]
+				l.conds = append(l.conds, c)
+			}
+			if len(l.conds) == 0 {
+				return nil, false
+			}
+			lists = append(lists, l)
+		default:
+			return nil, false
+		}
+		p = strings.TrimLeft(p, " \t")
+	}
+	if len(lists) == 0 || tagged && untagged {
+		return nil, false
+	}
+	return lists, true
+}
+
+// checkIf evaluates the If header h of a request changing name, fi being
+// its current state or nil. It returns 0 if there is no header or one of
+// its lists holds, 412 if none does and 400 if it doesn't parse. A list
+// tagged with another resource of this Server is evaluated against it.
+//
+// There are no locks to check tokens against but those of FakeLocks,
+// which grants every lock and keeps none: with it a token of the form it
+// hands out is taken as current, without it no token is.
+func (s *Server) checkIf(h, name string, fi os.FileInfo) int {
+	if h == "" {
+		return 0
+	}
+	lists, ok := parseIf(h)
+	if !ok {
+		return StatusBadRequest
+	}
+	for _, l := range lists {
+		lname, lfi := name, fi
+		if l.resource != "" {
+			if lname, ok = s.resourceName(l.resource); !ok {
+				continue
+			}
+			if lname != name {
+				lfi, _ = s.stat(lname)
+			}
+		}
+		holds := true
+		for _, c := range l.conds {
+			var match bool
+			if c.etag != "" {
+				match = lfi != nil && !lfi.IsDir() && c.etag == s.etag(lname, lfi)
+			} else {
+				match = s.FakeLocks && isLockToken(c.token)
+			}
+			if match == c.not {
+				holds = false
+				break
+			}
+		}
+		if holds {
+			return 0
+		}
+	}
+	return StatusPreconditionFailed
+}
+
+// resourceName returns the name of the resource a tag of an If header
+// names, false if it isn't one of this Server's
+func (s *Server) resourceName(ref string) (string, bool) {
+	u, err := url.Parse(ref)
+	if err != nil || u.Path == "" {
+		return "", false
+	}
+	var ok bool
+	if u.Path, ok = s.requestPath(u); !ok || !strings.HasPrefix(u.Path, s.TrimPrefix) {
+		return "", false
+	}
+	return s.url2path(u), true
+}
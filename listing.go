@@ -0,0 +1,127 @@
+package webdav
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// listEntry is a directory entry in a JSON listing
+type listEntry struct {
+	Name    string    `json:"name"`
+	Dir     bool      `json:"dir,omitempty"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mtime"`
+	Type    string    `json:"type,omitempty"`
+}
+
+// serveListing answers GET and HEAD of the directory f with a listing,
+// HTML or JSON as the client prefers. The listing is built in full before
+// anything is sent so that HEAD gets exactly the headers of GET, Last-Modified
+// being the modification time of the directory.
+func (s *Server) serveListing(w http.ResponseWriter, r *http.Request, f File, fi os.FileInfo) {
+	var entries []listEntry
+	for {
+		fis, err := f.Readdir(walkBatch)
+		for _, e := range fis {
+			if strings.HasPrefix(e.Name(), TempPrefix) {
+				continue
+			}
+			le := listEntry{Name: e.Name(), Dir: e.IsDir(), Size: e.Size(), ModTime: e.ModTime()}
+			if !e.IsDir() {
+				le.Type = s.contentType(e.Name())
+			}
+			entries = append(entries, le)
+		}
+		if err == io.EOF || (err == nil && len(fis) == 0) {
+			break
+		}
+		if err != nil {
+			glog.Infoln("DAV:", "error listing", r.RequestURI, "error", err)
+			w.WriteHeader(StatusInternalServerError)
+			return
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+
+	var body bytes.Buffer
+	if prefersJSON(r.Header.Get("Accept")) {
+		w.Header().Set("Content-Type", "application/json")
+		if entries == nil {
+			entries = []listEntry{}
+		}
+		json.NewEncoder(&body).Encode(entries)
+	} else {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		writeHTMLListing(&body, r.URL.Path, entries, s.ZipDownloads)
+	}
+	w.Header().Add("Vary", "Accept")
+
+	// ServeContent leaves out the body for HEAD
+	http.ServeContent(w, r, "", fi.ModTime(), bytes.NewReader(body.Bytes()))
+}
+
+func writeHTMLListing(b *bytes.Buffer, dir string, entries []listEntry, zipLink bool) {
+	if !strings.HasSuffix(dir, "/") {
+		dir += "/"
+	}
+	title := html.EscapeString(dir)
+	fmt.Fprintf(b, "<!doctype html>\n<title>%s</title>\n<h1>%s</h1>\n", title, title)
+	if zipLink {
+		b.WriteString("<p><a href=\"?accept=zip\">Download as zip</a></p>\n")
+	}
+	b.WriteString("<pre>\n")
+	for _, e := range entries {
+		name := e.Name
+		if e.Dir {
+			name += "/"
+		}
+		href := (&url.URL{Path: path.Join(dir, e.Name)}).EscapedPath()
+		if e.Dir {
+			href += "/"
+		}
+		fmt.Fprintf(b, "<a href=\"%s\">%s</a>\n", html.EscapeString(href), html.EscapeString(name))
+	}
+	b.WriteString("</pre>\n")
+}
+
+// prefersJSON reports whether an Accept header ranks application/json
+// above text/html, HTML is the default
+func prefersJSON(accept string) bool {
+	var qJSON, qHTML float64 = -1, -1
+	for _, part := range strings.Split(accept, ",") {
+		fields := strings.Split(part, ";")
+		typ := strings.ToLower(strings.TrimSpace(fields[0]))
+		q := 1.0
+		for _, p := range fields[1:] {
+			if v, ok := strings.CutPrefix(strings.TrimSpace(p), "q="); ok {
+				if f, err := strconv.ParseFloat(v, 64); err == nil {
+					q = f
+				}
+			}
+		}
+		switch typ {
+		case "application/json":
+			qJSON = q
+		case "text/html":
+			qHTML = q
+		case "application/*":
+			qJSON = max(qJSON, q)
+		case "text/*", "*/*":
+			qHTML = max(qHTML, q)
+		}
+	}
+	return qJSON > 0 && qJSON > qHTML
+}
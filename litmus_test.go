@@ -0,0 +1,302 @@
+package webdav
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// litmusResult is the outcome of one litmus test: pass, FAIL or SKIPPED
+type litmusResult struct {
+	suite, test, outcome string
+}
+
+func (r litmusResult) String() string {
+	return r.suite + "." + r.test + " " + r.outcome
+}
+
+var (
+	litmusSuite = regexp.MustCompile("^-> running `([^']+)':")
+	litmusTest  = regexp.MustCompile(`^\s*\d+\.\s+([A-Za-z0-9_]+)\.*\s*(pass|FAIL|SKIPPED|WARNING)`)
+	litmusCont  = regexp.MustCompile(`^\s+\.+\s*(pass|FAIL)`)
+)
+
+// parseLitmus reads the output of litmus. A test that warned is reported
+// with the outcome of the line that follows the warning.
+func parseLitmus(r io.Reader) ([]litmusResult, error) {
+	var results []litmusResult
+	var suite string
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		line := sc.Text()
+		if m := litmusSuite.FindStringSubmatch(line); m != nil {
+			suite = m[1]
+		} else if m := litmusTest.FindStringSubmatch(line); m != nil {
+			results = append(results, litmusResult{suite, m[1], m[2]})
+		} else if m := litmusCont.FindStringSubmatch(line); m != nil && len(results) > 0 {
+			results[len(results)-1].outcome = m[1]
+		}
+	}
+	return results, sc.Err()
+}
+
+// knownList holds the known failures, suite.test or suite.* names
+type knownList map[string]bool
+
+// knownFailures reads a knownList, a name a line and # starting comments
+func knownFailures(name string) (knownList, error) {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	known := make(knownList)
+	for _, line := range strings.Split(string(b), "\n") {
+		if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+			known[line] = true
+		}
+	}
+	return known, nil
+}
+
+// has reports whether r is listed by itself or with its suite
+func (k knownList) has(r litmusResult) bool {
+	return k[r.suite+"."+r.test] || k[r.suite+".*"]
+}
+
+func TestParseLitmus(t *testing.T) {
+	f, err := os.Open(filepath.Join("testdata", "litmus", "output.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	results, err := parseLitmus(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, r := range results {
+		if r.outcome != "pass" {
+			got = append(got, r.String())
+		}
+	}
+	want := []string{
+		"basic.put_no_parent FAIL",
+		"basic.mkcol_over_plain FAIL",
+		"basic.mkcol FAIL",
+		"copymove.begin FAIL",
+		"copymove.copy_init SKIPPED",
+		"copymove.copy_simple SKIPPED",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("not passed:\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	if len(results) != 20 {
+		t.Errorf("%d results, want 20", len(results))
+	}
+
+	known, err := knownFailures(filepath.Join("testdata", "litmus", "known-failures"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range results {
+		if r.outcome == "FAIL" && !known.has(r) {
+			t.Errorf("%s isn't a known failure", r)
+		}
+	}
+	if known.has(litmusResult{"http", "expect100", "FAIL"}) {
+		t.Error("http.expect100 is a known failure")
+	}
+}
+
+// TestLitmus runs the litmus WebDAV test suite against a Server over a
+// temporary Dir, if WEBDAV_LITMUS names the litmus binary, or is 1 to use
+// the one in $PATH. It fails for failures not listed in
+// testdata/litmus/known-failures, or the file WEBDAV_LITMUS_KNOWN names.
+func TestLitmus(t *testing.T) {
+	bin := os.Getenv("WEBDAV_LITMUS")
+	if bin == "" {
+		t.Skip("set WEBDAV_LITMUS to the litmus binary, or 1 for the one in $PATH")
+	}
+	if bin == "1" {
+		var err error
+		if bin, err = exec.LookPath("litmus"); err != nil {
+			t.Skip("litmus not installed:", err)
+		}
+	}
+	knownFile := os.Getenv("WEBDAV_LITMUS_KNOWN")
+	if knownFile == "" {
+		knownFile = filepath.Join("testdata", "litmus", "known-failures")
+	}
+	known, err := knownFailures(knownFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ts := newTestServer(t, &Server{Fs: Dir(t.TempDir()), FakeLocks: true})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, bin, ts.URL+"/")
+	var out bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &out, &out
+	// litmus exits with an error when anything failed, the output tells
+	cmd.Run()
+	t.Logf("litmus output:\n%s", out.String())
+
+	results, err := parseLitmus(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) == 0 {
+		t.Fatal("no litmus results")
+	}
+	var passed, failed int
+	for _, r := range results {
+		switch {
+		case r.outcome == "pass":
+			passed++
+			if known[r.suite+"."+r.test] {
+				t.Logf("%s passes, it can be removed from %s", r, knownFile)
+			}
+		case r.outcome == "FAIL":
+			failed++
+			if !known.has(r) {
+				t.Errorf("%s, a regression", r)
+			}
+		}
+	}
+	t.Logf("litmus: %d passed, %d failed, %d skipped", passed, failed, len(results)-passed-failed)
+}
+
+// TestMiniLitmus follows the sequences of the litmus suites with what the
+// Server serves, so they run where litmus isn't installed. The props suite
+// needs PROPFIND and PROPPATCH, which the Server doesn't answer.
+func TestMiniLitmus(t *testing.T) {
+	root := t.TempDir()
+	ts := newTestServer(t, &Server{Fs: Dir(root), FakeLocks: true})
+	file := func(name string) string {
+		b, err := os.ReadFile(filepath.Join(root, filepath.FromSlash(name)))
+		if err != nil {
+			return "<" + err.Error() + ">"
+		}
+		return string(b)
+	}
+	body := "This is\na test file.\nfor litmus testing.\n"
+
+	t.Run("basic", func(t *testing.T) {
+		t.Run("options", func(t *testing.T) {
+			resp, _ := request(t, ts, "OPTIONS", "/", "")
+			wantStatus(t, resp, StatusOK)
+			if dav := resp.Header.Get("DAV"); !strings.Contains(dav, "1") {
+				t.Errorf("DAV: %q, want class 1", dav)
+			}
+		})
+		t.Run("put_get", func(t *testing.T) {
+			resp, _ := request(t, ts, "PUT", "/res", body)
+			wantStatus(t, resp, StatusCreated)
+			resp, got := request(t, ts, "GET", "/res", "")
+			wantStatus(t, resp, StatusOK)
+			if got != body {
+				t.Errorf("GET = %q, want %q", got, body)
+			}
+		})
+		t.Run("put_get_utf8_segment", func(t *testing.T) {
+			resp, _ := request(t, ts, "PUT", "/res-%e2%82%ac", body)
+			wantStatus(t, resp, StatusCreated)
+			if got := file("res-\u20ac"); got != body {
+				t.Errorf("stored %q", got)
+			}
+			resp, got := request(t, ts, "GET", "/res-%e2%82%ac", "")
+			wantStatus(t, resp, StatusOK)
+			if got != body {
+				t.Errorf("GET = %q", got)
+			}
+		})
+		t.Run("delete", func(t *testing.T) {
+			resp, _ := request(t, ts, "DELETE", "/res", "")
+			wantStatus(t, resp, StatusNoContent)
+			resp, _ = request(t, ts, "GET", "/res", "")
+			wantStatus(t, resp, StatusNotFound)
+		})
+		t.Run("delete_null", func(t *testing.T) {
+			resp, _ := request(t, ts, "DELETE", "/404me", "")
+			wantStatus(t, resp, StatusNotFound)
+		})
+	})
+
+	t.Run("copymove", func(t *testing.T) {
+		t.Run("copy_init", func(t *testing.T) {
+			resp, _ := request(t, ts, "PUT", "/copysrc", body)
+			wantStatus(t, resp, StatusCreated)
+		})
+		t.Run("copy_simple", func(t *testing.T) {
+			resp, _ := request(t, ts, "COPY", "/copysrc", "", "Destination", ts.URL+"/copydest")
+			wantStatus(t, resp, StatusCreated)
+			if got := file("copydest"); got != body {
+				t.Errorf("copy = %q", got)
+			}
+		})
+		t.Run("copy_overwrite", func(t *testing.T) {
+			resp, _ := request(t, ts, "COPY", "/copysrc", "", "Destination", ts.URL+"/copydest", "Overwrite", "F")
+			wantStatus(t, resp, StatusPreconditionFailed)
+			resp, _ = request(t, ts, "COPY", "/copysrc", "", "Destination", ts.URL+"/copydest", "Overwrite", "T")
+			wantStatus(t, resp, StatusNoContent)
+		})
+		t.Run("copy_cleanup", func(t *testing.T) {
+			for _, name := range []string{"/copysrc", "/copydest"} {
+				resp, _ := request(t, ts, "DELETE", name, "")
+				wantStatus(t, resp, StatusNoContent)
+			}
+		})
+	})
+
+	t.Run("props", func(t *testing.T) {
+		t.Skip("the Server doesn't answer PROPFIND or PROPPATCH")
+	})
+
+	t.Run("locks", func(t *testing.T) {
+		const lockBody = `<?xml version="1.0" encoding="utf-8"?>
+<lockinfo xmlns="DAV:"><lockscope><exclusive/></lockscope><locktype><write/></locktype>
+<owner>litmus test suite</owner></lockinfo>`
+		var token string
+		t.Run("lock_excl", func(t *testing.T) {
+			resp, _ := request(t, ts, "PUT", "/lockme", body)
+			wantStatus(t, resp, StatusCreated)
+			resp, got := request(t, ts, "LOCK", "/lockme", lockBody, "Depth", "0", "Timeout", "Second-30")
+			wantStatus(t, resp, StatusOK)
+			token = strings.Trim(resp.Header.Get("Lock-Token"), "<>")
+			if token == "" || !strings.Contains(got, token) {
+				t.Fatalf("Lock-Token %q, body %s", token, got)
+			}
+		})
+		t.Run("owner_modify", func(t *testing.T) {
+			resp, _ := request(t, ts, "PUT", "/lockme", "changed", "If", "(<"+token+">)")
+			wantStatus(t, resp, StatusNoContent)
+		})
+		t.Run("refresh", func(t *testing.T) {
+			resp, got := request(t, ts, "LOCK", "/lockme", "", "If", "(<"+token+">)", "Timeout", "Second-60")
+			wantStatus(t, resp, StatusOK)
+			if !strings.Contains(got, token) {
+				t.Errorf("refresh answered %s, without the token", got)
+			}
+		})
+		t.Run("unlock", func(t *testing.T) {
+			resp, _ := request(t, ts, "UNLOCK", "/lockme", "", "Lock-Token", "<"+token+">")
+			wantStatus(t, resp, StatusNoContent)
+		})
+		t.Run("lock_unmapped", func(t *testing.T) {
+			resp, _ := request(t, ts, "LOCK", "/unmapped", lockBody)
+			wantStatus(t, resp, StatusCreated)
+			if got := file("unmapped"); got != "" {
+				t.Errorf("locked unmapped URL holds %q, want an empty file", got)
+			}
+		})
+	})
+}
@@ -0,0 +1,346 @@
+package webdav
+
+import (
+	"os"
+	"path"
+	"sort"
+	"sync"
+	"time"
+)
+
+// LockedFS serializes mutations of a path: a file opened with Create, or
+// for writing with OpenFile, is locked until it is closed, and Remove,
+// Rename and CopyFile lock the paths they change, so two writers of the
+// same path can't interleave. Different paths proceed in parallel, and
+// reads are never blocked.
+//
+// Files from CreateTemp are not locked, their names are private. When the
+// inner FileSystem is a TempFiler a PUT only takes the lock for the Rename
+// that puts the upload in place: concurrent PUTs of a path each replace it
+// whole, the last to finish wins, and a DELETE during a PUT removes the
+// previous file without waiting for the upload.
+//
+// Every optional interface is passed to the inner FileSystem, returning
+// ErrNotImplemented where it has none, with Rename and CopyFile taking the
+// locks first. The files it locks pass Sync, Truncate and Preallocate
+// through to the inner file in the same way.
+type LockedFS struct {
+	fs    FileSystem
+	locks pathLocks
+}
+
+// NewLockedFS wraps fs in a LockedFS
+func NewLockedFS(fs FileSystem) *LockedFS {
+	return &LockedFS{fs: fs}
+}
+
+// Unwrap returns the inner FileSystem
+func (l *LockedFS) Unwrap() FileSystem {
+	return l.fs
+}
+
+// pathLocks hands out one mutex per path, entries only live while someone
+// holds or waits for them
+type pathLocks struct {
+	mu    sync.Mutex
+	locks map[string]*pathLock
+}
+
+type pathLock struct {
+	sync.Mutex
+	refs int
+}
+
+// lock locks the given paths, in a fixed order so callers locking several
+// paths can't deadlock, and returns the function that unlocks them
+func (l *pathLocks) lock(names ...string) func() {
+	for i := range names {
+		names[i] = path.Clean("/" + names[i])
+	}
+	sort.Strings(names)
+
+	var held []string
+	for i, name := range names {
+		if i > 0 && name == names[i-1] {
+			continue
+		}
+
+		l.mu.Lock()
+		if l.locks == nil {
+			l.locks = make(map[string]*pathLock)
+		}
+		pl, ok := l.locks[name]
+		if !ok {
+			pl = &pathLock{}
+			l.locks[name] = pl
+		}
+		pl.refs++
+		l.mu.Unlock()
+
+		pl.Lock()
+		held = append(held, name)
+	}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.mu.Lock()
+			defer l.mu.Unlock()
+
+			for _, name := range held {
+				pl := l.locks[name]
+				pl.Unlock()
+				if pl.refs--; pl.refs == 0 {
+					delete(l.locks, name)
+				}
+			}
+		})
+	}
+}
+
+// Open opens a file for reading without locking
+func (l *LockedFS) Open(name string) (File, error) {
+	return l.fs.Open(name)
+}
+
+// Create locks name until the returned file is closed
+func (l *LockedFS) Create(name string) (File, error) {
+	unlock := l.locks.lock(name)
+	f, err := l.fs.Create(name)
+	if err != nil {
+		unlock()
+		return nil, err
+	}
+	return &lockedFile{File: f, unlock: unlock}, nil
+}
+
+// OpenFile holds the lock of name until the file is closed when opening
+// it for writing
+func (l *LockedFS) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	o, ok := l.fs.(OpenFiler)
+	if !ok {
+		return nil, ErrNotImplemented
+	}
+	if flag&(os.O_WRONLY|os.O_RDWR) == 0 {
+		return o.OpenFile(name, flag, perm)
+	}
+
+	unlock := l.locks.lock(name)
+	f, err := o.OpenFile(name, flag, perm)
+	if err != nil {
+		unlock()
+		return nil, err
+	}
+	return &lockedFile{File: f, unlock: unlock}, nil
+}
+
+// Mkdir calls the inner Mkdir
+func (l *LockedFS) Mkdir(name string) error {
+	return l.fs.Mkdir(name)
+}
+
+// Remove removes name while holding its lock
+func (l *LockedFS) Remove(name string) error {
+	defer l.locks.lock(name)()
+	return l.fs.Remove(name)
+}
+
+// removePartial forwards to the inner partialRemover, locking name
+func (l *LockedFS) removePartial(name string) error {
+	r, ok := l.fs.(partialRemover)
+	if !ok {
+		return ErrNotImplemented
+	}
+	defer l.locks.lock(name)()
+	return r.removePartial(name)
+}
+
+// Rename holds the locks of both paths
+func (l *LockedFS) Rename(oldname, newname string) error {
+	r, ok := l.fs.(Renamer)
+	if !ok {
+		return ErrNotImplemented
+	}
+
+	defer l.locks.lock(oldname, newname)()
+	return r.Rename(oldname, newname)
+}
+
+// CreateTemp does not lock, the temporary name is private until it is
+// renamed into place
+func (l *LockedFS) CreateTemp(dir, pattern string) (File, string, error) {
+	t, ok := l.fs.(TempFiler)
+	if !ok {
+		return nil, "", ErrNotImplemented
+	}
+	return t.CreateTemp(dir, pattern)
+}
+
+// CopyFile holds the lock of dst
+func (l *LockedFS) CopyFile(src, dst string) error {
+	c, ok := l.fs.(Copier)
+	if !ok {
+		return ErrNotImplemented
+	}
+
+	defer l.locks.lock(dst)()
+	return c.CopyFile(src, dst)
+}
+
+// ETag forwards to the inner ETagger
+func (l *LockedFS) ETag(name string) (string, error) {
+	if e, ok := l.fs.(ETagger); ok {
+		return e.ETag(name)
+	}
+	return "", ErrNotImplemented
+}
+
+// Chtimes forwards to the inner Chtimer, holding the lock of name
+func (l *LockedFS) Chtimes(name string, atime, mtime time.Time) error {
+	c, ok := l.fs.(Chtimer)
+	if !ok {
+		return ErrNotImplemented
+	}
+
+	defer l.locks.lock(name)()
+	return c.Chtimes(name, atime, mtime)
+}
+
+// Chmod forwards to the inner Chmoder, holding the lock of name
+func (l *LockedFS) Chmod(name string, mode os.FileMode) error {
+	c, ok := l.fs.(Chmoder)
+	if !ok {
+		return ErrNotImplemented
+	}
+
+	defer l.locks.lock(name)()
+	return c.Chmod(name, mode)
+}
+
+// Checksum forwards to the inner ChecksumFS
+func (l *LockedFS) Checksum(name, algo string) (string, error) {
+	if c, ok := l.fs.(ChecksumFS); ok {
+		return c.Checksum(name, algo)
+	}
+	return "", ErrNotImplemented
+}
+
+// CachedChecksum forwards to the inner ChecksumCache
+func (l *LockedFS) CachedChecksum(name, algo string) (string, bool) {
+	if c, ok := l.fs.(ChecksumCache); ok {
+		return c.CachedChecksum(name, algo)
+	}
+	return "", false
+}
+
+// CacheChecksum forwards to the inner ChecksumCache
+func (l *LockedFS) CacheChecksum(name, algo, sum string, size int64) {
+	if c, ok := l.fs.(ChecksumCache); ok {
+		c.CacheChecksum(name, algo, sum, size)
+	}
+}
+
+// Owner forwards to the inner OwnerFS
+func (l *LockedFS) Owner(name string) (FileOwner, error) {
+	if o, ok := l.fs.(OwnerFS); ok {
+		return o.Owner(name)
+	}
+	return FileOwner{}, ErrNotImplemented
+}
+
+// Watch forwards to the inner Watcher
+func (l *LockedFS) Watch(prefix string) (<-chan Event, func(), error) {
+	if w, ok := l.fs.(Watcher); ok {
+		return w.Watch(prefix)
+	}
+	return nil, nil, ErrNotImplemented
+}
+
+// Trash forwards to the inner Trasher
+func (l *LockedFS) Trash(n int) ([]TrashEntry, error) {
+	if t, ok := l.fs.(Trasher); ok {
+		return t.Trash(n)
+	}
+	return nil, ErrNotImplemented
+}
+
+// TrashEntry forwards to the inner Trasher
+func (l *LockedFS) TrashEntry(id string) (TrashEntry, error) {
+	if t, ok := l.fs.(Trasher); ok {
+		return t.TrashEntry(id)
+	}
+	return TrashEntry{}, ErrNotImplemented
+}
+
+// Restore forwards to the inner Trasher, holding the lock of name
+func (l *LockedFS) Restore(id, name string) error {
+	t, ok := l.fs.(Trasher)
+	if !ok {
+		return ErrNotImplemented
+	}
+
+	defer l.locks.lock(name)()
+	return t.Restore(id, name)
+}
+
+// Purge forwards to the inner Trasher
+func (l *LockedFS) Purge(id string) error {
+	if t, ok := l.fs.(Trasher); ok {
+		return t.Purge(id)
+	}
+	return ErrNotImplemented
+}
+
+// Close closes the inner FileSystem if it is a FileSystemCloser
+func (l *LockedFS) Close() error {
+	if c, ok := l.fs.(FileSystemCloser); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// lockedFile releases its path lock on Close. It keeps the optional
+// methods of the inner file the server looks for.
+type lockedFile struct {
+	File
+	unlock func()
+}
+
+// Sync commits the content to storage if the inner file can
+func (f *lockedFile) Sync() error {
+	if s, ok := f.File.(interface{ Sync() error }); ok {
+		return s.Sync()
+	}
+	return nil
+}
+
+// Truncate forwards to the inner file
+func (f *lockedFile) Truncate(size int64) error {
+	if t, ok := f.File.(interface{ Truncate(int64) error }); ok {
+		return t.Truncate(size)
+	}
+	return ErrNotImplemented
+}
+
+// Preallocate forwards to the inner file, see Preallocator
+func (f *lockedFile) Preallocate(size int64) error {
+	switch inner := f.File.(type) {
+	case Preallocator:
+		return inner.Preallocate(size)
+	case *os.File:
+		return preallocate(inner, size)
+	}
+	return ErrNotImplemented
+}
+
+// abort forwards to the inner file, which streams to a remote server
+func (f *lockedFile) abort() {
+	if a, ok := f.File.(interface{ abort() }); ok {
+		a.abort()
+	}
+}
+
+func (f *lockedFile) Close() error {
+	defer f.unlock()
+	return f.File.Close()
+}
@@ -0,0 +1,137 @@
+package webdav
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLockedFSWriterBlocksRemove(t *testing.T) {
+	l := NewLockedFS(NewMemFS())
+	f, err := l.Create("/f")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte("content"))
+
+	removed := make(chan error, 1)
+	go func() { removed <- l.Remove("/f") }()
+	select {
+	case err := <-removed:
+		t.Fatalf("Remove returned %v while the file was open for writing", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-removed; err != nil {
+		t.Errorf("Remove after Close: %v", err)
+	}
+}
+
+func TestLockedFileKeepsFileMethods(t *testing.T) {
+	l := NewLockedFS(Dir(t.TempDir()))
+	f, err := l.Create("/f")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if err := f.(Preallocator).Preallocate(1 << 20); err != nil {
+		t.Logf("Preallocate: %v", err)
+	}
+	f.Write([]byte("abc"))
+	if err := f.(interface{ Truncate(int64) error }).Truncate(3); err != nil {
+		t.Errorf("Truncate: %v", err)
+	}
+	if err := f.(interface{ Sync() error }).Sync(); err != nil {
+		t.Errorf("Sync: %v", err)
+	}
+	fi, err := f.Stat()
+	if err != nil || fi.Size() != 3 {
+		t.Errorf("size after Truncate = %v, %v", fi, err)
+	}
+
+	// a file without them reports so instead of failing
+	m, err := NewLockedFS(NewMemFS()).Create("/f")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	if err := m.(interface{ Truncate(int64) error }).Truncate(0); err != ErrNotImplemented {
+		t.Errorf("Truncate of a MemFS file = %v, want ErrNotImplemented", err)
+	}
+	if err := m.(interface{ Sync() error }).Sync(); err != nil {
+		t.Errorf("Sync of a MemFS file = %v", err)
+	}
+}
+
+// TestLockedFSConcurrentPutGet has PUTs of one path race each other and
+// GETs of it: every GET must see one upload complete, never a mix or a
+// truncated file
+func TestLockedFSConcurrentPutGet(t *testing.T) {
+	for name, fsys := range map[string]FileSystem{
+		"dir":   NewLockedFS(Dir(t.TempDir())),
+		"memfs": NewLockedFS(NewMemFS()),
+	} {
+		t.Run(name, func(t *testing.T) {
+			ts := newTestServer(t, &Server{Fs: fsys})
+			const size = 64 << 10
+			bodies := make([]string, 8)
+			for i := range bodies {
+				bodies[i] = strings.Repeat(string(rune('a'+i)), size)
+			}
+			resp, _ := request(t, ts, "PUT", "/f", bodies[0])
+			wantStatus(t, resp, StatusCreated)
+
+			var wg sync.WaitGroup
+			errs := make(chan error, 100)
+			for w := 0; w < 4; w++ {
+				wg.Add(2)
+				go func(w int) {
+					defer wg.Done()
+					for i := 0; i < 20; i++ {
+						req, _ := http.NewRequest("PUT", ts.URL+"/f", strings.NewReader(bodies[(w+i)%len(bodies)]))
+						resp, err := ts.Client().Do(req)
+						if err != nil {
+							errs <- err
+							return
+						}
+						resp.Body.Close()
+						if resp.StatusCode != StatusNoContent && resp.StatusCode != StatusCreated {
+							errs <- fmt.Errorf("PUT: status %d", resp.StatusCode)
+						}
+					}
+				}(w)
+				go func() {
+					defer wg.Done()
+					var buf bytes.Buffer
+					for i := 0; i < 40; i++ {
+						resp, err := ts.Client().Get(ts.URL + "/f")
+						if err != nil {
+							errs <- err
+							return
+						}
+						buf.Reset()
+						buf.ReadFrom(resp.Body)
+						resp.Body.Close()
+						b := buf.Bytes()
+						if resp.StatusCode != StatusOK || len(b) != size || bytes.Count(b, b[:1]) != size {
+							errs <- fmt.Errorf("GET: status %d, %d bytes, not one upload", resp.StatusCode, len(b))
+							return
+						}
+					}
+				}()
+			}
+			wg.Wait()
+			close(errs)
+			for err := range errs {
+				t.Error(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,534 @@
+package webdav
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemFS is a FileSystem kept entirely in memory, for tests and ephemeral
+// servers. File contents are never modified in place: a file opened for
+// writing replaces the content when it is closed, so open readers and
+// snapshots keep seeing a consistent version.
+type MemFS struct {
+	mu     sync.RWMutex
+	root   *memNode
+	events notifier
+	closed bool
+}
+
+type memNode struct {
+	name     string
+	dir      bool
+	mode     os.FileMode
+	modTime  time.Time
+	data     []byte
+	children map[string]*memNode
+}
+
+// ErrClosed is returned by a MemFS after Close
+var ErrClosed = errors.New("file system closed")
+
+var (
+	errIsDir    = errors.New("is a directory")
+	errNotDir   = errors.New("not a directory")
+	errNotEmpty = errors.New("directory not empty")
+)
+
+// NewMemFS returns an empty MemFS
+func NewMemFS() *MemFS {
+	return &MemFS{root: newMemDir("/")}
+}
+
+func newMemDir(name string) *memNode {
+	return &memNode{
+		name:     name,
+		dir:      true,
+		mode:     os.ModeDir | 0755,
+		modTime:  time.Now(),
+		children: make(map[string]*memNode),
+	}
+}
+
+// split cleans name into its path elements
+func split(name string) []string {
+	name = strings.Trim(path.Clean("/"+name), "/")
+	if name == "" {
+		return nil
+	}
+	return strings.Split(name, "/")
+}
+
+// lookup finds the node for name, the caller holds m.mu
+func (m *MemFS) lookup(name string) (*memNode, error) {
+	if m.closed {
+		return nil, ErrClosed
+	}
+
+	n := m.root
+	for _, elem := range split(name) {
+		if !n.dir {
+			return nil, errNotDir
+		}
+		child, ok := n.children[elem]
+		if !ok {
+			return nil, os.ErrNotExist
+		}
+		n = child
+	}
+	return n, nil
+}
+
+// parent finds the directory that holds name, the caller holds m.mu
+func (m *MemFS) parent(name string) (*memNode, string, error) {
+	elems := split(name)
+	if len(elems) == 0 {
+		return nil, "", os.ErrInvalid
+	}
+
+	dir, err := m.lookup(strings.Join(elems[:len(elems)-1], "/"))
+	if err != nil {
+		return nil, "", err
+	}
+	if !dir.dir {
+		return nil, "", errNotDir
+	}
+	return dir, elems[len(elems)-1], nil
+}
+
+func (m *MemFS) publish(op Op, name string) {
+	m.events.publish(Event{Op: op, Path: path.Clean("/" + name), Time: time.Now()})
+}
+
+// Open opens a file or directory for reading
+func (m *MemFS) Open(name string) (File, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	n, err := m.lookup(name)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: name, Err: err}
+	}
+	return &memFile{fs: m, path: path.Clean("/" + name), node: n, data: n.data}, nil
+}
+
+// Create creates a file, or truncates it once the returned file is closed,
+// its parent must exist
+func (m *MemFS) Create(name string) (File, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.create(name); err != nil {
+		return nil, &os.PathError{Op: "create", Path: name, Err: err}
+	}
+
+	n, _ := m.lookup(name)
+	return &memFile{fs: m, path: path.Clean("/" + name), node: n, writer: true}, nil
+}
+
+// OpenFile opens name with os.OpenFile flags, see OpenFiler. A file opened
+// for writing has its content replaced on Close, as by Create, and O_EXCL
+// with O_CREATE fails if name exists. O_APPEND is not supported.
+func (m *MemFS) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR) == 0 {
+		return m.Open(name)
+	}
+	if flag&os.O_APPEND != 0 {
+		return nil, ErrNotImplemented
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, err := m.lookup(name); err == nil {
+		if flag&(os.O_CREATE|os.O_EXCL) == os.O_CREATE|os.O_EXCL {
+			return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrExist}
+		}
+	} else if flag&os.O_CREATE == 0 {
+		return nil, &os.PathError{Op: "open", Path: name, Err: err}
+	}
+	if err := m.create(name); err != nil {
+		return nil, &os.PathError{Op: "open", Path: name, Err: err}
+	}
+
+	n, _ := m.lookup(name)
+	return &memFile{fs: m, path: path.Clean("/" + name), node: n, writer: true}, nil
+}
+
+// create makes sure name is a file, an existing one keeps its content
+// until a writer replaces it, the caller holds m.mu
+func (m *MemFS) create(name string) error {
+	if m.closed {
+		return ErrClosed
+	}
+	dir, base, err := m.parent(name)
+	if err != nil {
+		return err
+	}
+
+	n, ok := dir.children[base]
+	if ok && n.dir {
+		return errIsDir
+	}
+	if !ok {
+		n = &memNode{name: base, mode: 0644, modTime: time.Now()}
+		dir.children[base] = n
+		m.publish(OpCreate, name)
+	}
+	return nil
+}
+
+// CreateTemp creates a new file in dir, see TempFiler
+func (m *MemFS) CreateTemp(dir, pattern string) (File, string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for {
+		base := pattern + randomSuffix()
+		if j := strings.LastIndex(pattern, "*"); j >= 0 {
+			base = pattern[:j] + randomSuffix() + pattern[j+1:]
+		}
+		name := path.Join("/", dir, base)
+
+		if _, err := m.lookup(name); err == nil {
+			continue
+		}
+		if err := m.create(name); err != nil {
+			return nil, "", &os.PathError{Op: "createtemp", Path: name, Err: err}
+		}
+
+		n, _ := m.lookup(name)
+		return &memFile{fs: m, path: name, node: n, writer: true}, name, nil
+	}
+}
+
+// Mkdir creates a directory and any missing parents
+func (m *MemFS) Mkdir(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return ErrClosed
+	}
+
+	n := m.root
+	p := "/"
+	for _, elem := range split(name) {
+		p = path.Join(p, elem)
+		child, ok := n.children[elem]
+		if !ok {
+			child = newMemDir(elem)
+			n.children[elem] = child
+			m.publish(OpCreate, p)
+		} else if !child.dir {
+			return &os.PathError{Op: "mkdir", Path: name, Err: errNotDir}
+		}
+		n = child
+	}
+	return nil
+}
+
+// Remove removes a file or an empty directory
+func (m *MemFS) Remove(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return ErrClosed
+	}
+	dir, base, err := m.parent(name)
+	if err != nil {
+		return &os.PathError{Op: "remove", Path: name, Err: err}
+	}
+	n, ok := dir.children[base]
+	if !ok {
+		return &os.PathError{Op: "remove", Path: name, Err: os.ErrNotExist}
+	}
+	if n.dir && len(n.children) > 0 {
+		return &os.PathError{Op: "remove", Path: name, Err: errNotEmpty}
+	}
+
+	delete(dir.children, base)
+	m.publish(OpRemove, name)
+	return nil
+}
+
+// Rename moves a file or directory, replacing a file at newname
+func (m *MemFS) Rename(oldname, newname string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return ErrClosed
+	}
+	oldname, newname = path.Clean("/"+oldname), path.Clean("/"+newname)
+	if strings.HasPrefix(newname, oldname+"/") {
+		return &os.PathError{Op: "rename", Path: oldname, Err: os.ErrInvalid}
+	}
+
+	odir, obase, err := m.parent(oldname)
+	if err != nil {
+		return &os.PathError{Op: "rename", Path: oldname, Err: err}
+	}
+	n, ok := odir.children[obase]
+	if !ok {
+		return &os.PathError{Op: "rename", Path: oldname, Err: os.ErrNotExist}
+	}
+	ndir, nbase, err := m.parent(newname)
+	if err != nil {
+		return &os.PathError{Op: "rename", Path: newname, Err: err}
+	}
+	if target, ok := ndir.children[nbase]; ok && target.dir && target != n {
+		return &os.PathError{Op: "rename", Path: newname, Err: errIsDir}
+	}
+
+	delete(odir.children, obase)
+	n.name = nbase
+	ndir.children[nbase] = n
+	m.publish(OpRename, oldname)
+	m.publish(OpCreate, newname)
+	return nil
+}
+
+// CopyFile shares the content of src with dst, see Copier
+func (m *MemFS) CopyFile(src, dst string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	n, err := m.lookup(src)
+	if err != nil {
+		return &os.PathError{Op: "copy", Path: src, Err: err}
+	}
+	if n.dir {
+		return &os.PathError{Op: "copy", Path: src, Err: errIsDir}
+	}
+	if err := m.create(dst); err != nil {
+		return &os.PathError{Op: "copy", Path: dst, Err: err}
+	}
+
+	d, _ := m.lookup(dst)
+	d.data = n.data
+	m.publish(OpWrite, dst)
+	return nil
+}
+
+// Chtimes sets the modification time of name, MemFS keeps no access times
+func (m *MemFS) Chtimes(name string, atime, mtime time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return ErrClosed
+	}
+	n, err := m.lookup(name)
+	if err != nil {
+		return &os.PathError{Op: "chtimes", Path: name, Err: err}
+	}
+	n.modTime = mtime
+	return nil
+}
+
+// Chmod sets the permission bits of name
+func (m *MemFS) Chmod(name string, mode os.FileMode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return ErrClosed
+	}
+	n, err := m.lookup(name)
+	if err != nil {
+		return &os.PathError{Op: "chmod", Path: name, Err: err}
+	}
+	n.mode = n.mode&^os.ModePerm | mode.Perm()
+	return nil
+}
+
+func randomSuffix() string {
+	var b [8]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// Watch reports changes below prefix, see Watcher
+func (m *MemFS) Watch(prefix string) (<-chan Event, func(), error) {
+	return m.events.subscribe(prefix)
+}
+
+// Close makes every further operation fail with ErrClosed
+func (m *MemFS) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.closed = true
+	return nil
+}
+
+// memFile reads a fixed version of a node's content, or collects written
+// content and stores it in the node on Close
+type memFile struct {
+	fs     *MemFS
+	path   string
+	node   *memNode
+	data   []byte
+	off    int64
+	writer bool
+	names  []string
+	listed bool
+	closed bool
+}
+
+func (f *memFile) Stat() (os.FileInfo, error) {
+	if f.closed {
+		return nil, os.ErrClosed
+	}
+
+	f.fs.mu.RLock()
+	defer f.fs.mu.RUnlock()
+
+	fi := f.node.info()
+	if f.writer {
+		fi.size = int64(len(f.data))
+	}
+	return fi, nil
+}
+
+func (f *memFile) Readdir(count int) ([]os.FileInfo, error) {
+	if f.closed {
+		return nil, os.ErrClosed
+	}
+	if !f.node.dir {
+		return nil, &os.PathError{Op: "readdir", Path: f.path, Err: errNotDir}
+	}
+
+	f.fs.mu.RLock()
+	defer f.fs.mu.RUnlock()
+
+	if !f.listed {
+		for name := range f.node.children {
+			f.names = append(f.names, name)
+		}
+		sort.Strings(f.names)
+		f.listed = true
+	}
+
+	var fis []os.FileInfo
+	for len(f.names) > 0 && (count <= 0 || len(fis) < count) {
+		if child, ok := f.node.children[f.names[0]]; ok {
+			fis = append(fis, child.info())
+		}
+		f.names = f.names[1:]
+	}
+
+	if count > 0 && len(fis) == 0 {
+		return nil, io.EOF
+	}
+	return fis, nil
+}
+
+func (f *memFile) Read(p []byte) (int, error) {
+	if f.closed {
+		return 0, os.ErrClosed
+	}
+	if f.node.dir {
+		return 0, &os.PathError{Op: "read", Path: f.path, Err: errIsDir}
+	}
+	if f.off >= int64(len(f.data)) {
+		return 0, io.EOF
+	}
+
+	n := copy(p, f.data[f.off:])
+	f.off += int64(n)
+	return n, nil
+}
+
+func (f *memFile) Write(p []byte) (int, error) {
+	if f.closed {
+		return 0, os.ErrClosed
+	}
+	if !f.writer {
+		return 0, &os.PathError{Op: "write", Path: f.path, Err: os.ErrPermission}
+	}
+
+	end := f.off + int64(len(p))
+	if end > int64(len(f.data)) {
+		data := make([]byte, end, end+end/4)
+		copy(data, f.data)
+		f.data = data
+	}
+	copy(f.data[f.off:], p)
+	f.off = end
+	return len(p), nil
+}
+
+func (f *memFile) Seek(offset int64, whence int) (int64, error) {
+	if f.closed {
+		return 0, os.ErrClosed
+	}
+
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.off
+	case io.SeekEnd:
+		offset += int64(len(f.data))
+	default:
+		return 0, &os.PathError{Op: "seek", Path: f.path, Err: os.ErrInvalid}
+	}
+	if offset < 0 {
+		return 0, &os.PathError{Op: "seek", Path: f.path, Err: os.ErrInvalid}
+	}
+	f.off = offset
+	return offset, nil
+}
+
+// Close stores the written content
+func (f *memFile) Close() error {
+	if f.closed {
+		return os.ErrClosed
+	}
+	f.closed = true
+	if !f.writer {
+		return nil
+	}
+
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+
+	if f.fs.closed {
+		return ErrClosed
+	}
+	f.node.data = f.data[:len(f.data):len(f.data)]
+	f.node.modTime = time.Now()
+	f.fs.publish(OpWrite, f.path)
+	return nil
+}
+
+func (n *memNode) info() *memFileInfo {
+	return &memFileInfo{
+		name:    n.name,
+		size:    int64(len(n.data)),
+		mode:    n.mode,
+		modTime: n.modTime,
+	}
+}
+
+type memFileInfo struct {
+	name    string
+	size    int64
+	mode    os.FileMode
+	modTime time.Time
+}
+
+func (fi *memFileInfo) Name() string       { return fi.name }
+func (fi *memFileInfo) Size() int64        { return fi.size }
+func (fi *memFileInfo) Mode() os.FileMode  { return fi.mode }
+func (fi *memFileInfo) ModTime() time.Time { return fi.modTime }
+func (fi *memFileInfo) IsDir() bool        { return fi.mode.IsDir() }
+func (fi *memFileInfo) Sys() interface{}   { return nil }
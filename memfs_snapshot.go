@@ -0,0 +1,194 @@
+package webdav
+
+import (
+	"archive/tar"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// WriteTo writes a tar archive of the whole file system to w, recording
+// paths, modes, modification times and contents. The tree is captured under
+// the lock and written afterwards; since contents are never modified in
+// place the archive is a consistent snapshot even while requests keep
+// changing the file system.
+func (m *MemFS) WriteTo(w io.Writer) (int64, error) {
+	type entry struct {
+		name string
+		node memNode
+	}
+
+	m.mu.RLock()
+	if m.closed {
+		m.mu.RUnlock()
+		return 0, ErrClosed
+	}
+	var entries []entry
+	var walk func(dir *memNode, name string)
+	walk = func(dir *memNode, name string) {
+		for base, n := range dir.children {
+			p := path.Join(name, base)
+			entries = append(entries, entry{p, *n})
+			if n.dir {
+				walk(n, p)
+			}
+		}
+	}
+	walk(m.root, "")
+	m.mu.RUnlock()
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].name < entries[j].name })
+
+	cw := &countingWriter{w: w}
+	tw := tar.NewWriter(cw)
+	for _, e := range entries {
+		hdr := &tar.Header{
+			Name:    e.name,
+			Mode:    int64(e.node.mode.Perm()),
+			ModTime: e.node.modTime,
+			Format:  tar.FormatPAX, // keeps sub-second mtimes
+		}
+		if e.node.dir {
+			hdr.Typeflag = tar.TypeDir
+			hdr.Name += "/"
+		} else {
+			hdr.Typeflag = tar.TypeReg
+			hdr.Size = int64(len(e.node.data))
+		}
+
+		if err := tw.WriteHeader(hdr); err != nil {
+			return cw.n, err
+		}
+		if _, err := tw.Write(e.node.data); err != nil {
+			return cw.n, err
+		}
+	}
+
+	err := tw.Close()
+	return cw.n, err
+}
+
+// ReadFrom adds the directories and regular files of the tar archive read
+// from r, replacing files that already exist. Other entry types are
+// skipped.
+func (m *MemFS) ReadFrom(r io.Reader) (int64, error) {
+	cr := &countingReader{r: r}
+	tr := tar.NewReader(cr)
+
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return cr.n, nil
+		}
+		if err != nil {
+			return cr.n, err
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			err = m.restore(hdr.Name, true, os.FileMode(hdr.Mode).Perm(), hdr.ModTime, nil)
+		case tar.TypeReg:
+			var data []byte
+			if data, err = io.ReadAll(tr); err == nil {
+				err = m.restore(hdr.Name, false, os.FileMode(hdr.Mode).Perm(), hdr.ModTime, data)
+			}
+		}
+		if err != nil {
+			return cr.n, err
+		}
+	}
+}
+
+// LoadDir copies the directories and regular files below dir on the native
+// file system into m
+func (m *MemFS) LoadDir(dir string) error {
+	return filepath.Walk(dir, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil || rel == "." {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+
+		switch {
+		case fi.IsDir():
+			return m.restore(name, true, fi.Mode().Perm(), fi.ModTime(), nil)
+		case fi.Mode().IsRegular():
+			data, err := os.ReadFile(p)
+			if err != nil {
+				return err
+			}
+			return m.restore(name, false, fi.Mode().Perm(), fi.ModTime(), data)
+		}
+		return nil
+	})
+}
+
+// restore creates or replaces a node with the given attributes
+func (m *MemFS) restore(name string, dir bool, perm os.FileMode, modTime time.Time, data []byte) error {
+	name = strings.TrimSuffix(name, "/")
+	if len(split(name)) == 0 {
+		return nil
+	}
+
+	if err := m.Mkdir(path.Dir(path.Clean("/" + name))); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if dir {
+		parent, base, err := m.parent(name)
+		if err != nil {
+			return err
+		}
+		n, ok := parent.children[base]
+		if !ok {
+			n = newMemDir(base)
+			parent.children[base] = n
+		} else if !n.dir {
+			return &os.PathError{Op: "mkdir", Path: name, Err: errNotDir}
+		}
+		n.mode = os.ModeDir | perm
+		n.modTime = modTime
+		return nil
+	}
+
+	if err := m.create(name); err != nil {
+		return &os.PathError{Op: "create", Path: name, Err: err}
+	}
+	n, _ := m.lookup(name)
+	n.mode = perm
+	n.modTime = modTime
+	n.data = data
+	return nil
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,166 @@
+package webdav
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// memFiles returns the content and modification time of every file of m
+func memFiles(t testing.TB, m *MemFS) map[string]memNode {
+	t.Helper()
+	files := make(map[string]memNode)
+	err := Walk(m, "/", func(name string, fi os.FileInfo, err error) error {
+		if err != nil || name == "/" {
+			return err
+		}
+		n := memNode{dir: fi.IsDir(), mode: fi.Mode(), modTime: fi.ModTime()}
+		if !n.dir {
+			f, err := m.Open(name)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			if n.data, err = io.ReadAll(f); err != nil {
+				return err
+			}
+		}
+		files[name] = n
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func sameFiles(t testing.TB, got, want map[string]memNode) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Errorf("%d entries, want %d", len(got), len(want))
+	}
+	for name, w := range want {
+		g, ok := got[name]
+		switch {
+		case !ok:
+			t.Errorf("%s missing", name)
+		case !bytes.Equal(g.data, w.data):
+			t.Errorf("%s has %q, want %q", name, g.data, w.data)
+		case g.mode != w.mode:
+			t.Errorf("%s has mode %v, want %v", name, g.mode, w.mode)
+		case !g.modTime.Equal(w.modTime):
+			t.Errorf("%s modified %v, want %v", name, g.modTime, w.modTime)
+		}
+	}
+}
+
+func writeMem(t testing.TB, m *MemFS, name string, data []byte) {
+	t.Helper()
+	f, err := m.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMemFSSnapshotRoundTrip(t *testing.T) {
+	m := NewMemFS()
+	if err := m.Mkdir("/dir/sub"); err != nil {
+		t.Fatal(err)
+	}
+	binary := make([]byte, 1<<16)
+	for i := range binary {
+		binary[i] = byte(i * 7)
+	}
+	writeMem(t, m, "/dir/sub/binary", binary)
+	writeMem(t, m, "/dir/empty", nil)
+	writeMem(t, m, "/top.txt", []byte("top"))
+	mtime := time.Date(2001, 2, 3, 4, 5, 6, 789000000, time.UTC)
+	for _, name := range []string{"/dir/sub/binary", "/dir", "/top.txt"} {
+		if err := m.Chtimes(name, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := m.Chmod("/top.txt", 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	n, err := m.WriteTo(&buf)
+	if err != nil || n != int64(buf.Len()) {
+		t.Fatalf("WriteTo = %d, %v, wrote %d", n, err, buf.Len())
+	}
+	restored := NewMemFS()
+	if n, err := restored.ReadFrom(bytes.NewReader(buf.Bytes())); err != nil || n != int64(buf.Len()) {
+		t.Fatalf("ReadFrom = %d, %v of %d", n, err, buf.Len())
+	}
+	sameFiles(t, memFiles(t, restored), memFiles(t, m))
+}
+
+func TestMemFSLoadDir(t *testing.T) {
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "a", "b"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "a", "b", "c.txt"), []byte("content"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Date(2010, 1, 1, 0, 0, 0, 0, time.UTC)
+	if err := os.Chtimes(filepath.Join(root, "a", "b", "c.txt"), mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+
+	m := NewMemFS()
+	if err := m.LoadDir(root); err != nil {
+		t.Fatal(err)
+	}
+	got := memFiles(t, m)
+	c, ok := got["/a/b/c.txt"]
+	if !ok || string(c.data) != "content" || c.mode != 0640 || !c.modTime.Equal(mtime) {
+		t.Errorf("/a/b/c.txt = %+v", c)
+	}
+	if d := got["/a/b"]; !d.dir {
+		t.Error("/a/b is not a directory")
+	}
+}
+
+func TestMemFSSnapshotDuringWrite(t *testing.T) {
+	m := NewMemFS()
+	writeMem(t, m, "/f", []byte("before"))
+
+	w, err := m.Create("/f")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("after, but not closed"))
+
+	// neither readers nor a snapshot see the unfinished write
+	var buf bytes.Buffer
+	if _, err := m.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	restored := NewMemFS()
+	if _, err := restored.ReadFrom(&buf); err != nil {
+		t.Fatal(err)
+	}
+	for _, fsys := range []*MemFS{m, restored} {
+		if got := memFiles(t, fsys)["/f"]; string(got.data) != "before" {
+			t.Errorf("/f = %q before the writer is closed", got.data)
+		}
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got := memFiles(t, m)["/f"]; string(got.data) != "after, but not closed" {
+		t.Errorf("/f = %q after the writer is closed", got.data)
+	}
+}
@@ -0,0 +1,37 @@
+package webdav
+
+import (
+	"mime"
+	"path"
+	"strings"
+)
+
+// overrideType returns the type Server.MimeTypes gives name, by its
+// extension matched case-insensitively, "" standing for no extension
+func (s *Server) overrideType(name string) (string, bool) {
+	if len(s.MimeTypes) == 0 {
+		return "", false
+	}
+	ext := path.Ext(name)
+	if t, ok := s.MimeTypes[ext]; ok {
+		return t, true
+	}
+	if t, ok := s.MimeTypes[strings.ToLower(ext)]; ok {
+		return t, true
+	}
+	for e, t := range s.MimeTypes {
+		if strings.EqualFold(e, ext) {
+			return t, true
+		}
+	}
+	return "", false
+}
+
+// contentType returns the type of the file name from Server.MimeTypes,
+// else from the standard library's table, "" if neither knows it
+func (s *Server) contentType(name string) string {
+	if t, ok := s.overrideType(name); ok {
+		return t
+	}
+	return mime.TypeByExtension(path.Ext(name))
+}
@@ -0,0 +1,84 @@
+package webdav
+
+import (
+	"encoding/json"
+	"mime"
+	"strings"
+	"testing"
+)
+
+func TestMimeTypes(t *testing.T) {
+	types := map[string]string{
+		".html": "text/plain",          // beats the standard library
+		".md":   "text/markdown",       // which doesn't know it everywhere
+		"gpx":   "application/gpx+xml", // without the dot it never matches
+		".GPX":  "application/gpx+xml",
+		"":      "application/octet-stream",
+	}
+	if mime.TypeByExtension(".html") == types[".html"] {
+		t.Fatal("the standard library agrees with the override, nothing is tested")
+	}
+
+	m := NewMemFS()
+	m.Mkdir("/dir")
+	want := map[string]string{
+		"page.html":  "text/plain",
+		"PAGE.HTML":  "text/plain",
+		"notes.md":   "text/markdown",
+		"NOTES.Md":   "text/markdown",
+		"track.gpx":  "application/gpx+xml",
+		"Makefile":   "application/octet-stream",
+		"image.png":  "image/png",
+		"backup.tgz": mime.TypeByExtension(".tgz"),
+	}
+	for name := range want {
+		writeMem(t, m, "/dir/"+name, []byte("<html>"))
+	}
+	s := &Server{Fs: m, Listings: true, SyncCollection: true, MimeTypes: types}
+	defer s.Close()
+	ts := newTestServer(t, s)
+
+	// GET and HEAD
+	for name, typ := range want {
+		for _, method := range []string{"GET", "HEAD"} {
+			resp, _ := request(t, ts, method, "/dir/"+name, "")
+			wantStatus(t, resp, StatusOK)
+			if got := resp.Header.Get("Content-Type"); typ != "" && got != typ {
+				t.Errorf("%s %s: Content-Type %q, want %q", method, name, got, typ)
+			}
+		}
+	}
+
+	// the JSON listing
+	resp, body := request(t, ts, "GET", "/dir", "", "Accept", "application/json")
+	wantStatus(t, resp, StatusOK)
+	var entries []listEntry
+	if err := json.Unmarshal([]byte(body), &entries); err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if e.Type != want[e.Name] {
+			t.Errorf("listing %s: type %q, want %q", e.Name, e.Type, want[e.Name])
+		}
+	}
+
+	// getcontenttype, there being no PROPFIND
+	_, body = request(t, ts, "REPORT", "/dir/", `<D:sync-collection xmlns:D="DAV:"><D:sync-token/>`+
+		`<D:sync-level>1</D:sync-level><D:prop><D:getcontenttype/></D:prop></D:sync-collection>`)
+	for name, typ := range want {
+		if typ == "" {
+			continue
+		}
+		if !strings.Contains(body, "<D:href>/dir/"+name+"</D:href><D:propstat><D:prop><D:getcontenttype>"+typ+"<") {
+			t.Errorf("REPORT: no getcontenttype %s for %s in %s", typ, name, body)
+		}
+	}
+
+	// without the table the standard library decides
+	s2 := &Server{Fs: m}
+	ts2 := newTestServer(t, s2)
+	resp, _ = request(t, ts2, "GET", "/dir/page.html", "")
+	if got := resp.Header.Get("Content-Type"); !strings.HasPrefix(got, "text/html") {
+		t.Errorf("without MimeTypes: Content-Type %q", got)
+	}
+}
@@ -0,0 +1,118 @@
+package webdav
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestHeadMatchesGet(t *testing.T) {
+	root := t.TempDir()
+	if err := os.Mkdir(filepath.Join(root, "dir"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "dir", "f.txt"), []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	os.Chtimes(filepath.Join(root, "dir"), mtime, mtime)
+	ts := newTestServer(t, &Server{Fs: Dir(root), Listings: true})
+
+	for _, tc := range []struct {
+		name, accept string
+		status       int
+	}{
+		{"/dir/f.txt", "", StatusOK},
+		{"/dir", "", StatusOK},
+		{"/dir", "application/json", StatusOK},
+		{"/missing", "", StatusNotFound},
+	} {
+		get, _ := request(t, ts, "GET", tc.name, "", "Accept", tc.accept)
+		head, body := request(t, ts, "HEAD", tc.name, "", "Accept", tc.accept)
+		wantStatus(t, get, tc.status)
+		wantStatus(t, head, tc.status)
+		if body != "" {
+			t.Errorf("HEAD %s sent a body: %q", tc.name, body)
+		}
+		for _, h := range []string{"Content-Type", "Content-Length", "Last-Modified", "Vary"} {
+			if g, h2 := get.Header.Get(h), head.Header.Get(h); g != h2 {
+				t.Errorf("%s %s: GET %q, HEAD %q", tc.name, h, g, h2)
+			}
+		}
+	}
+
+	resp, _ := request(t, ts, "HEAD", "/dir", "", "Accept", "application/json")
+	if ct := resp.Header.Get("Content-Type"); ct != "application/json" {
+		t.Errorf("HEAD of a collection asking for JSON: Content-Type %q", ct)
+	}
+	if lm := resp.Header.Get("Last-Modified"); lm != mtime.Format(http.TimeFormat) {
+		t.Errorf("HEAD of a collection: Last-Modified %q, want %q", lm, mtime.Format(http.TimeFormat))
+	}
+}
+
+func TestOptionsAllow(t *testing.T) {
+	m := NewMemFS()
+	m.Mkdir("/dir")
+	writeMem(t, m, "/f.txt", []byte("x"))
+
+	for _, tc := range []struct {
+		name               string
+		s                  *Server
+		file, dir, missing string
+	}{
+		{
+			"default", &Server{},
+			"OPTIONS, GET, HEAD, PUT, COPY, DELETE",
+			"OPTIONS, DELETE",
+			"OPTIONS, PUT",
+		},
+		{
+			"listings and locks", &Server{Listings: true, FakeLocks: true},
+			"OPTIONS, GET, HEAD, PUT, COPY, DELETE, LOCK, UNLOCK",
+			"OPTIONS, GET, HEAD, DELETE, LOCK, UNLOCK",
+			"OPTIONS, PUT, LOCK, UNLOCK",
+		},
+		{
+			"read-only", &Server{ReadOnly: true, Listings: true, FakeLocks: true},
+			"OPTIONS, GET, HEAD",
+			"OPTIONS, GET, HEAD",
+			"OPTIONS",
+		},
+		{
+			"deletes disabled", &Server{DeletesDisabled: true},
+			"OPTIONS, GET, HEAD, PUT, COPY",
+			"OPTIONS",
+			"OPTIONS, PUT",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s := tc.s
+			s.Fs = m
+			ts := newTestServer(t, s)
+			for name, want := range map[string]string{"/f.txt": tc.file, "/dir": tc.dir, "/missing": tc.missing} {
+				resp, _ := request(t, ts, "OPTIONS", name, "")
+				wantStatus(t, resp, StatusOK)
+				if got := resp.Header.Get("Allow"); got != want {
+					t.Errorf("OPTIONS %s: Allow %q, want %q", name, got, want)
+				}
+			}
+
+			// a 405 lists the same methods
+			resp, _ := request(t, ts, "PUT", "/dir", "x")
+			if resp.StatusCode == StatusMethodNotAllowed {
+				if got := resp.Header.Get("Allow"); got != tc.dir {
+					t.Errorf("405 for PUT onto a collection: Allow %q, want %q", got, tc.dir)
+				}
+			}
+			if !s.Listings {
+				resp, _ := request(t, ts, "GET", "/dir", "")
+				wantStatus(t, resp, StatusMethodNotAllowed)
+				if got := resp.Header.Get("Allow"); got != tc.dir {
+					t.Errorf("405 for GET of a collection: Allow %q, want %q", got, tc.dir)
+				}
+			}
+		})
+	}
+}
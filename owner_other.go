@@ -0,0 +1,10 @@
+//go:build !unix
+
+package webdav
+
+import "os"
+
+// files have no unix owners on this platform
+func fileOwner(fi os.FileInfo) (FileOwner, bool) {
+	return FileOwner{}, false
+}
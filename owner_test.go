@@ -0,0 +1,115 @@
+package webdav
+
+import (
+	"os"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// TestDirOwner reads the owner, group and mode of a file from its stat
+func TestDirOwner(t *testing.T) {
+	if runtime.GOOS != "linux" && runtime.GOOS != "darwin" {
+		t.Skip("no unix owners on", runtime.GOOS)
+	}
+	root := t.TempDir()
+	name := filepath.Join(root, "f.txt")
+	if err := os.WriteFile(name, []byte("data"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(name, 0o640); err != nil {
+		t.Fatal(err)
+	}
+
+	o, err := Dir(root).Owner("/f.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o.UID != os.Getuid() || o.Mode != 0o640 {
+		t.Errorf("owner %+v, want uid %d and mode 0640", o, os.Getuid())
+	}
+	// the group may be the directory's, so only its name is checked
+	if u, err := user.LookupId(strconv.Itoa(o.UID)); err == nil && o.User != u.Username {
+		t.Errorf("user %q, want %q", o.User, u.Username)
+	}
+	if g, err := user.LookupGroupId(strconv.Itoa(o.GID)); err == nil && o.Group != g.Name {
+		t.Errorf("group %q, want %q", o.Group, g.Name)
+	}
+
+	if _, err := Dir(root).Owner("/missing"); !os.IsNotExist(err) {
+		t.Errorf("owner of a missing file: %v", err)
+	}
+}
+
+// TestUnixProperties checks the REPORT answers the unix properties only
+// with Server.UnixProperties, only when asked for by name, and through
+// wrappers of the FileSystem
+func TestUnixProperties(t *testing.T) {
+	if runtime.GOOS != "linux" && runtime.GOOS != "darwin" {
+		t.Skip("no unix owners on", runtime.GOOS)
+	}
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "f.txt"), []byte("data"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(filepath.Join(root, "f.txt"), 0o640); err != nil {
+		t.Fatal(err)
+	}
+	o, err := Dir(root).Owner("/f.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	owner, _ := unixProp(&o, "owner")
+	group, _ := unixProp(&o, "group")
+
+	asked := `<?xml version="1.0" encoding="utf-8"?>
+<D:sync-collection xmlns:D="DAV:" xmlns:u="` + UnixNamespace + `"><D:sync-token/><D:sync-level>1</D:sync-level>
+<D:prop><D:displayname/><u:owner/><u:group/><u:unix-mode/></D:prop></D:sync-collection>`
+	report := func(s *Server, body string) string {
+		t.Helper()
+		ts := newTestServer(t, s)
+		resp, got := request(t, ts, "REPORT", "/", body)
+		wantStatus(t, resp, StatusMulti)
+		return got
+	}
+
+	for name, fsys := range map[string]FileSystem{
+		"dir":       Dir(root),
+		"lockedfs":  NewLockedFS(Dir(root)),
+		"readahead": NewReadAheadFS(Dir(root), 0, 0),
+	} {
+		body := report(&Server{Fs: fsys, SyncCollection: true, UnixProperties: true}, asked)
+		for _, want := range []string{
+			`<u:owner xmlns:u="` + UnixNamespace + `">` + owner + `</u:owner>`,
+			`<u:group xmlns:u="` + UnixNamespace + `">` + group + `</u:group>`,
+			`<u:unix-mode xmlns:u="` + UnixNamespace + `">0640</u:unix-mode>`,
+		} {
+			if !strings.Contains(body, want) {
+				t.Errorf("%s: REPORT %s, want %s", name, body, want)
+			}
+		}
+
+		// never unasked
+		body = report(&Server{Fs: fsys, SyncCollection: true, UnixProperties: true}, syncBody("", "1"))
+		if strings.Contains(body, UnixNamespace) {
+			t.Errorf("%s: unasked REPORT %s", name, body)
+		}
+	}
+
+	// without the option, or an OwnerFS, they are missing
+	for name, s := range map[string]*Server{
+		"disabled": {Fs: Dir(root), SyncCollection: true},
+		"memfs":    {Fs: NewMemFS(), SyncCollection: true, UnixProperties: true},
+	} {
+		if name == "memfs" {
+			writeMem(t, s.Fs.(*MemFS), "/f.txt", []byte("data"))
+		}
+		body := report(s, asked)
+		if strings.Contains(body, "0640") || !strings.Contains(body, `<x:unix-mode xmlns:x="`+UnixNamespace+`"/>`) {
+			t.Errorf("%s: REPORT %s, want unix-mode missing", name, body)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+//go:build unix
+
+package webdav
+
+import (
+	"os"
+	"os/user"
+	"strconv"
+	"sync"
+	"syscall"
+)
+
+// userNames and groupNames cache the lookups of ids, "" for unknown ones
+var userNames, groupNames sync.Map
+
+// fileOwner returns the owner of fi from its syscall.Stat_t
+func fileOwner(fi os.FileInfo) (FileOwner, bool) {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return FileOwner{}, false
+	}
+	o := FileOwner{UID: int(st.Uid), GID: int(st.Gid), Mode: fi.Mode().Perm()}
+	o.User = lookupName(&userNames, o.UID, func(id string) (string, error) {
+		u, err := user.LookupId(id)
+		if err != nil {
+			return "", err
+		}
+		return u.Username, nil
+	})
+	o.Group = lookupName(&groupNames, o.GID, func(id string) (string, error) {
+		g, err := user.LookupGroupId(id)
+		if err != nil {
+			return "", err
+		}
+		return g.Name, nil
+	})
+	return o, true
+}
+
+// lookupName returns the name of id from cache, looking it up on a miss
+func lookupName(cache *sync.Map, id int, lookup func(string) (string, error)) string {
+	if name, ok := cache.Load(id); ok {
+		return name.(string)
+	}
+	name, err := lookup(strconv.Itoa(id))
+	if err != nil {
+		name = ""
+	}
+	cache.Store(id, name)
+	return name
+}
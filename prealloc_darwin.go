@@ -0,0 +1,30 @@
+//go:build darwin
+
+package webdav
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// preallocate reserves size bytes for f with F_PREALLOCATE, trying for a
+// contiguous allocation first. The file size is not changed.
+func preallocate(f *os.File, size int64) error {
+	fst := syscall.Fstore_t{
+		Flags:   syscall.F_ALLOCATECONTIG | syscall.F_ALLOCATEALL,
+		Posmode: syscall.F_PEOFPOSMODE,
+		Length:  size,
+	}
+	_, _, errno := syscall.Syscall(syscall.SYS_FCNTL, f.Fd(), syscall.F_PREALLOCATE, uintptr(unsafe.Pointer(&fst)))
+	if errno == 0 {
+		return nil
+	}
+
+	fst.Flags = syscall.F_ALLOCATEALL
+	_, _, errno = syscall.Syscall(syscall.SYS_FCNTL, f.Fd(), syscall.F_PREALLOCATE, uintptr(unsafe.Pointer(&fst)))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
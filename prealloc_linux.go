@@ -0,0 +1,22 @@
+//go:build linux
+
+package webdav
+
+import (
+	"os"
+	"syscall"
+)
+
+// FALLOC_FL_KEEP_SIZE from linux/falloc.h
+const fallocKeepSize = 0x1
+
+// preallocate reserves size bytes for f without changing its size, so a
+// short upload needs no truncation afterwards
+func preallocate(f *os.File, size int64) error {
+	for {
+		err := syscall.Fallocate(int(f.Fd()), fallocKeepSize, 0, size)
+		if err != syscall.EINTR {
+			return err
+		}
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !linux && !darwin && !windows
+
+package webdav
+
+import "os"
+
+// preallocate is not available on this platform
+func preallocate(f *os.File, size int64) error {
+	return ErrNotImplemented
+}
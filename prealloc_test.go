@@ -0,0 +1,136 @@
+package webdav
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+// preallocFile is a File that preallocates by extending itself, as
+// posix_fallocate does, or fails with err
+type preallocFile struct {
+	*os.File
+	err    error
+	writes int
+}
+
+func (f *preallocFile) Preallocate(size int64) error {
+	if f.err != nil {
+		return f.err
+	}
+	return f.File.Truncate(size)
+}
+
+func (f *preallocFile) Write(b []byte) (int, error) {
+	f.writes++
+	return f.File.Write(b)
+}
+
+// preallocFS is a Dir whose files are preallocFiles. It hides the
+// optional interfaces of Dir, so PUT writes the destination directly.
+type preallocFS struct {
+	FileSystem
+	err   error
+	files []*preallocFile
+}
+
+func (p *preallocFS) Create(name string) (File, error) {
+	f, err := p.FileSystem.Create(name)
+	if err != nil {
+		return nil, err
+	}
+	pf := &preallocFile{File: f.(*os.File), err: p.err}
+	p.files = append(p.files, pf)
+	return pf, nil
+}
+
+// readWatcher records whether a body was read
+type readWatcher struct {
+	r    *strings.Reader
+	read bool
+}
+
+func (r *readWatcher) Read(b []byte) (int, error) {
+	r.read = true
+	return r.r.Read(b)
+}
+
+// TestPutPreallocNoSpace checks a PUT whose preallocation runs out of space
+// is answered with 507 before its body is read, and that other failures
+// to preallocate are ignored
+func TestPutPreallocNoSpace(t *testing.T) {
+	for _, tc := range []struct {
+		err    error
+		status int
+	}{
+		{syscall.ENOSPC, StatusInsufficientStorage},
+		{&os.PathError{Op: "fallocate", Path: "a.txt", Err: syscall.EDQUOT}, StatusInsufficientStorage},
+		{syscall.EOPNOTSUPP, StatusCreated},
+		{ErrNotImplemented, StatusCreated},
+	} {
+		root := t.TempDir()
+		fsys := &preallocFS{FileSystem: Dir(root), err: tc.err}
+		s := &Server{Fs: fsys, TrimPrefix: "/"}
+
+		body := &readWatcher{r: strings.NewReader("0123456789")}
+		r := httptest.NewRequest("PUT", "/a.txt", body)
+		r.ContentLength = 10
+		r.Header.Set("Expect", "100-continue")
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		if w.Code != tc.status {
+			t.Errorf("%v: PUT %d, want %d", tc.err, w.Code, tc.status)
+		}
+		if len(fsys.files) != 1 {
+			t.Fatalf("%v: %d files created", tc.err, len(fsys.files))
+		}
+		if tc.status != StatusInsufficientStorage {
+			continue
+		}
+		if body.read || fsys.files[0].writes > 0 {
+			t.Errorf("%v: the body was read", tc.err)
+		}
+		if _, err := os.Stat(filepath.Join(root, "a.txt")); !os.IsNotExist(err) {
+			t.Errorf("%v: a.txt left behind: %v", tc.err, err)
+		}
+	}
+}
+
+// TestPreallocTruncate checks commit cuts a file that preallocation
+// extended back to what was written, and leaves one written in full
+func TestPreallocTruncate(t *testing.T) {
+	root := t.TempDir()
+	fsys := &preallocFS{FileSystem: Dir(root)}
+	for _, tc := range []struct {
+		name     string
+		prealloc int64
+		content  string
+	}{
+		{"/short.txt", 100, "forty bytes of a hundred preallocated..."},
+		{"/full.txt", 10, "0123456789"},
+	} {
+		f, err := fsys.Create(tc.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p := &pendingFile{File: f, fs: fsys, name: tc.name}
+		if err := p.preallocate(tc.prealloc); err != nil {
+			t.Fatal(err)
+		}
+		if fi, err := os.Stat(filepath.Join(root, tc.name)); err != nil || fi.Size() != tc.prealloc {
+			t.Fatalf("%s preallocated to %v, %v", tc.name, fi, err)
+		}
+		if _, err := p.Write([]byte(tc.content)); err != nil {
+			t.Fatal(err)
+		}
+		if err := p.commit(); err != nil {
+			t.Fatal(err)
+		}
+		if b, err := os.ReadFile(filepath.Join(root, tc.name)); err != nil || string(b) != tc.content {
+			t.Errorf("%s holds %q, %v, want %q", tc.name, b, err, tc.content)
+		}
+	}
+}
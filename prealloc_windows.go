@@ -0,0 +1,11 @@
+//go:build windows
+
+package webdav
+
+import "os"
+
+// preallocate extends f to size, which makes NTFS allocate the space. The
+// server truncates the file to the bytes actually received when done.
+func preallocate(f *os.File, size int64) error {
+	return f.Truncate(size)
+}
@@ -0,0 +1,649 @@
+package webdav
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+// renameOnlyDir is a Dir without CreateTemp, so PUT takes the path that
+// sets the previous file aside
+type renameOnlyDir struct {
+	FileSystem
+	Renamer
+	Chmoder
+}
+
+// pendingBackends make the FileSystems over a directory that exercise
+// both ways a PUT is written
+var pendingBackends = map[string]func(root string) FileSystem{
+	"tempfile": func(root string) FileSystem { return Dir(root) },
+	"rename":   func(root string) FileSystem { return renameOnlyDir{Dir(root), Dir(root), Dir(root)} },
+}
+
+// putChunked sends a PUT with a chunked body, whose size the server only
+// learns by reading it
+func putChunked(t testing.TB, url string, body string) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest("PUT", url, io.MultiReader(strings.NewReader(body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	return resp
+}
+
+// newFileMode returns the mode os.Create gives a new file in dir
+func newFileMode(t testing.TB, dir string) os.FileMode {
+	t.Helper()
+	f, err := os.Create(filepath.Join(dir, "mode-probe"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+	fi, err := os.Stat(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fi.Mode().Perm()
+}
+
+func TestPutPromotesOnSuccess(t *testing.T) {
+	for name, mk := range pendingBackends {
+		t.Run(name, func(t *testing.T) {
+			root := t.TempDir()
+			ts := newTestServer(t, &Server{Fs: mk(root)})
+
+			resp, _ := request(t, ts, "PUT", "/new.txt", "fresh")
+			wantStatus(t, resp, StatusCreated)
+			fi, err := os.Stat(filepath.Join(root, "new.txt"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := newFileMode(t, root); fi.Mode().Perm() != want {
+				t.Errorf("new file has mode %v, want %v like os.Create", fi.Mode().Perm(), want)
+			}
+
+			old := filepath.Join(root, "old.txt")
+			if err := os.WriteFile(old, []byte("previous"), 0640); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Chmod(old, 0640); err != nil {
+				t.Fatal(err)
+			}
+			resp, _ = request(t, ts, "PUT", "/old.txt", "replaced")
+			wantStatus(t, resp, StatusNoContent)
+			b, err := os.ReadFile(old)
+			if err != nil || string(b) != "replaced" {
+				t.Fatalf("old.txt = %q, %v", b, err)
+			}
+			fi, err = os.Stat(old)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fi.Mode().Perm() != 0640 {
+				t.Errorf("replaced file has mode %v, want 0640", fi.Mode().Perm())
+			}
+			if tmp := tempEntries(t, root); len(tmp) > 0 {
+				t.Errorf("temporary files left: %v", tmp)
+			}
+		})
+	}
+}
+
+func TestPutCleansUpOnFailure(t *testing.T) {
+	for name, mk := range pendingBackends {
+		t.Run(name, func(t *testing.T) {
+			root := t.TempDir()
+			ts := newTestServer(t, &Server{Fs: mk(root), MaxUploadSize: 8})
+			if err := os.WriteFile(filepath.Join(root, "old.txt"), []byte("previous"), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			// the limit is only found out while the body is written
+			resp := putChunked(t, ts.URL+"/old.txt", "far more than eight bytes")
+			wantStatus(t, resp, StatusRequestTooLarge)
+			resp = putChunked(t, ts.URL+"/new.txt", "far more than eight bytes")
+			wantStatus(t, resp, StatusRequestTooLarge)
+
+			b, err := os.ReadFile(filepath.Join(root, "old.txt"))
+			if err != nil || string(b) != "previous" {
+				t.Errorf("old.txt = %q, %v after a failed PUT", b, err)
+			}
+			if _, err := os.Stat(filepath.Join(root, "new.txt")); !os.IsNotExist(err) {
+				t.Errorf("failed PUT of a new file left it: %v", err)
+			}
+			if tmp := tempEntries(t, root); len(tmp) > 0 {
+				t.Errorf("temporary files left: %v", tmp)
+			}
+		})
+	}
+}
+
+// putSevered sends a PUT announcing size bytes to the server behind h,
+// closes the connection after sending only part of it, and waits for h to
+// return
+func putSevered(t *testing.T, h http.Handler, name string, size int) {
+	t.Helper()
+	done := make(chan struct{}, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() { done <- struct{}{} }()
+		h.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+
+	conn, err := net.Dial("tcp", ts.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprintf(conn, "PUT %s HTTP/1.1\r\nHost: x\r\nContent-Length: %d\r\n\r\n", name, size)
+	conn.Write(bytes.Repeat([]byte("x"), size/3))
+	time.Sleep(50 * time.Millisecond)
+	conn.Close()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the handler didn't return after the client went away")
+	}
+}
+
+func TestPutSeveredKeepsPrevious(t *testing.T) {
+	for name, mk := range pendingBackends {
+		t.Run(name, func(t *testing.T) {
+			root := t.TempDir()
+			s := &Server{Fs: mk(root), TrimPrefix: "/"}
+			old := filepath.Join(root, "old.txt")
+			if err := os.WriteFile(old, []byte("previous"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			mtime := time.Now().Add(-time.Hour).Truncate(time.Second)
+			if err := os.Chtimes(old, mtime, mtime); err != nil {
+				t.Fatal(err)
+			}
+
+			putSevered(t, s, "/old.txt", 1<<20)
+			putSevered(t, s, "/new.txt", 1<<20)
+
+			b, err := os.ReadFile(old)
+			if err != nil || string(b) != "previous" {
+				t.Errorf("old.txt = %q, %v after a severed PUT", b, err)
+			}
+			if fi, err := os.Stat(old); err != nil || !fi.ModTime().Equal(mtime) {
+				t.Errorf("old.txt modified %v, want %v", fi.ModTime(), mtime)
+			}
+			if _, err := os.Stat(filepath.Join(root, "new.txt")); !os.IsNotExist(err) {
+				t.Errorf("severed PUT of a new file left it: %v", err)
+			}
+			if tmp := tempEntries(t, root); len(tmp) > 0 {
+				t.Errorf("temporary files left: %v", tmp)
+			}
+		})
+	}
+}
+
+func TestPutSeveredMemFS(t *testing.T) {
+	m := NewMemFS()
+	writeMem(t, m, "/old.txt", []byte("previous"))
+	s := &Server{Fs: m, TrimPrefix: "/"}
+
+	putSevered(t, s, "/old.txt", 1<<20)
+	putSevered(t, s, "/new.txt", 1<<20)
+
+	files := memFiles(t, m)
+	if got := string(files["/old.txt"].data); got != "previous" {
+		t.Errorf("old.txt = %q after a severed PUT", got)
+	}
+	if len(files) != 1 {
+		t.Errorf("%d files after severed PUTs, want 1", len(files))
+	}
+}
+
+func TestPutReportsCloseErrors(t *testing.T) {
+	for name := range pendingBackends {
+		for _, tc := range []struct {
+			fault  string
+			status int
+		}{
+			{"close-enospc", StatusInsufficientStorage},
+			{"close", StatusInternalServerError},
+		} {
+			t.Run(name+"/"+tc.fault, func(t *testing.T) {
+				root := t.TempDir()
+				ts := newTestServer(t, &Server{Fs: faultyBackend(name, faultyFS{Dir: Dir(root), fault: tc.fault})})
+				if err := os.WriteFile(filepath.Join(root, "old.txt"), []byte("previous"), 0644); err != nil {
+					t.Fatal(err)
+				}
+
+				resp, _ := request(t, ts, "PUT", "/old.txt", "replaced")
+				wantStatus(t, resp, tc.status)
+				resp, _ = request(t, ts, "PUT", "/new.txt", "fresh")
+				wantStatus(t, resp, tc.status)
+
+				if b, err := os.ReadFile(filepath.Join(root, "old.txt")); err != nil || string(b) != "previous" {
+					t.Errorf("old.txt = %q, %v after a failed Close", b, err)
+				}
+				if _, err := os.Stat(filepath.Join(root, "new.txt")); !os.IsNotExist(err) {
+					t.Errorf("a new file whose Close failed was kept: %v", err)
+				}
+				if tmp := tempEntries(t, root); len(tmp) > 0 {
+					t.Errorf("temporary files left: %v", tmp)
+				}
+			})
+		}
+	}
+}
+
+func TestPutSyncOnPut(t *testing.T) {
+	for name := range pendingBackends {
+		t.Run(name, func(t *testing.T) {
+			root := t.TempDir()
+			var syncs atomic.Int32
+			s := &Server{Fs: faultyBackend(name, faultyFS{Dir: Dir(root), syncs: &syncs})}
+			ts := newTestServer(t, s)
+
+			resp, _ := request(t, ts, "PUT", "/a.txt", "unsynced")
+			wantStatus(t, resp, StatusCreated)
+			if n := syncs.Load(); n != 0 {
+				t.Errorf("%d Syncs without SyncOnPut", n)
+			}
+
+			s.SyncOnPut = true
+			resp, _ = request(t, ts, "PUT", "/a.txt", "synced")
+			wantStatus(t, resp, StatusNoContent)
+			if n := syncs.Load(); n != 1 {
+				t.Errorf("%d Syncs with SyncOnPut, want 1", n)
+			}
+
+			s.Fs = faultyBackend(name, faultyFS{Dir: Dir(root), fault: "sync", syncs: &syncs})
+			resp, _ = request(t, ts, "PUT", "/a.txt", "sync fails")
+			wantStatus(t, resp, StatusInternalServerError)
+			if b, err := os.ReadFile(filepath.Join(root, "a.txt")); err != nil || string(b) != "synced" {
+				t.Errorf("a.txt = %q, %v after a failed Sync", b, err)
+			}
+			if tmp := tempEntries(t, root); len(tmp) > 0 {
+				t.Errorf("temporary files left: %v", tmp)
+			}
+		})
+	}
+}
+
+// faultyBackend is the pendingBackends entry name over fsys
+func faultyBackend(name string, fsys faultyFS) FileSystem {
+	if name == "rename" {
+		return renameOnlyDir{fsys, fsys, fsys}
+	}
+	return fsys
+}
+
+// maxReader records the largest read it was asked for
+type maxReader struct {
+	io.Reader
+	max int
+}
+
+func (r *maxReader) Read(p []byte) (int, error) {
+	if len(p) > r.max {
+		r.max = len(p)
+	}
+	return r.Reader.Read(p)
+}
+
+func TestCopyBuffer(t *testing.T) {
+	data := bytes.Repeat([]byte("x"), 1<<20)
+	for _, size := range []int{0, 16 << 10, 1 << 20} {
+		s := &Server{CopyBufferSize: size}
+		want := size
+		if want == 0 {
+			want = DefaultCopyBufferSize
+		}
+		r := &maxReader{Reader: bytes.NewReader(data)}
+		var dst bytes.Buffer
+		if n, err := s.copy(struct{ io.Writer }{&dst}, r); err != nil || n != int64(len(data)) {
+			t.Fatalf("copy = %d, %v", n, err)
+		}
+		if r.max != want {
+			t.Errorf("CopyBufferSize %d: read with %d bytes, want %d", size, r.max, want)
+		}
+	}
+
+	// once the pool holds a buffer copying allocates none
+	s := &Server{}
+	src := bytes.NewReader(data)
+	var dst, r = io.Writer(struct{ io.Writer }{io.Discard}), io.Reader(struct{ io.Reader }{src})
+	allocs := testing.AllocsPerRun(100, func() {
+		src.Reset(data)
+		s.copy(dst, r)
+	})
+	if allocs > 0 {
+		t.Errorf("copy allocates %v times, want none", allocs)
+	}
+}
+
+// BenchmarkPutConcurrent uploads files of 1 MiB to a MemFS from many
+// goroutines; compare its allocations with BenchmarkCopyBuffer/unpooled
+func BenchmarkPutConcurrent(b *testing.B) {
+	const size = 1 << 20
+	data := bytes.Repeat([]byte("0123456789abcdef"), size/16)
+	s := &Server{Fs: NewMemFS(), TrimPrefix: "/"}
+	var seq atomic.Int64
+	b.SetBytes(size)
+	b.ReportAllocs()
+	b.SetParallelism(8)
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			// a few names per goroutine, so MemFS doesn't grow without end
+			name := fmt.Sprintf("/f%d", seq.Add(1)%64)
+			r := httptest.NewRequest("PUT", name, struct{ io.Reader }{bytes.NewReader(data)})
+			w := httptest.NewRecorder()
+			s.ServeHTTP(w, r)
+			if w.Code != StatusCreated && w.Code != StatusNoContent {
+				b.Errorf("PUT %s: %d", name, w.Code)
+				return
+			}
+		}
+	})
+}
+
+// BenchmarkCopyBuffer copies 1 MiB bodies concurrently through Server.copy
+// and through io.Copy, which allocates a buffer every time
+func BenchmarkCopyBuffer(b *testing.B) {
+	const size = 1 << 20
+	data := bytes.Repeat([]byte("x"), size)
+	s := &Server{}
+	for name, copy := range map[string]func(io.Writer, io.Reader) (int64, error){
+		"pooled":   s.copy,
+		"unpooled": io.Copy,
+	} {
+		b.Run(name, func(b *testing.B) {
+			b.SetBytes(size)
+			b.ReportAllocs()
+			b.SetParallelism(8)
+			b.RunParallel(func(pb *testing.PB) {
+				src := bytes.NewReader(data)
+				for pb.Next() {
+					src.Reset(data)
+					if _, err := copy(struct{ io.Writer }{io.Discard}, struct{ io.Reader }{src}); err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			})
+		})
+	}
+}
+
+// putShort hands h a PUT of name whose body ends after 400 of the 1000
+// bytes its Content-Length declares, as a proxy or a broken client might
+func putShort(t *testing.T, h http.Handler, name string) {
+	t.Helper()
+	r := httptest.NewRequest("PUT", name, strings.NewReader(strings.Repeat("x", 400)))
+	r.ContentLength = 1000
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != StatusBadRequest || !strings.Contains(w.Body.String(), "400 of 1000") {
+		t.Errorf("PUT %s of a short body: %d %q, want 400 naming the sizes", name, w.Code, w.Body)
+	}
+}
+
+func TestPutShortBody(t *testing.T) {
+	for name, mk := range pendingBackends {
+		t.Run(name, func(t *testing.T) {
+			root := t.TempDir()
+			s := &Server{Fs: mk(root), TrimPrefix: "/"}
+			if err := os.WriteFile(filepath.Join(root, "old.txt"), []byte("previous"), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			putShort(t, s, "/old.txt")
+			putShort(t, s, "/new.txt")
+
+			if b, err := os.ReadFile(filepath.Join(root, "old.txt")); err != nil || string(b) != "previous" {
+				t.Errorf("old.txt = %q, %v after a short PUT", b, err)
+			}
+			if _, err := os.Stat(filepath.Join(root, "new.txt")); !os.IsNotExist(err) {
+				t.Errorf("short PUT of a new file left it: %v", err)
+			}
+			if tmp := tempEntries(t, root); len(tmp) > 0 {
+				t.Errorf("temporary files left: %v", tmp)
+			}
+		})
+	}
+
+	t.Run("memfs", func(t *testing.T) {
+		m := NewMemFS()
+		writeMem(t, m, "/old.txt", []byte("previous"))
+		s := &Server{Fs: m, TrimPrefix: "/"}
+
+		putShort(t, s, "/old.txt")
+		putShort(t, s, "/new.txt")
+
+		files := memFiles(t, m)
+		if got := string(files["/old.txt"].data); got != "previous" || len(files) != 1 {
+			t.Errorf("after short PUTs old.txt = %q and %d files, want 1", got, len(files))
+		}
+	})
+
+	t.Run("chunked", func(t *testing.T) {
+		// without a Content-Length whatever arrives is the file
+		root := t.TempDir()
+		ts := newTestServer(t, &Server{Fs: Dir(root)})
+		resp := putChunked(t, ts.URL+"/chunked.txt", "all of it")
+		wantStatus(t, resp, StatusCreated)
+		if b, err := os.ReadFile(filepath.Join(root, "chunked.txt")); err != nil || string(b) != "all of it" {
+			t.Errorf("chunked.txt = %q, %v", b, err)
+		}
+
+		// unless it ends before its last chunk, as when a proxy in front
+		// gives up on the upload
+		r := httptest.NewRequest("PUT", "/cut.txt", io.MultiReader(strings.NewReader("part of it"), iotest.ErrReader(io.ErrUnexpectedEOF)))
+		r.ContentLength = -1
+		w := httptest.NewRecorder()
+		(&Server{Fs: Dir(root), TrimPrefix: "/"}).ServeHTTP(w, r)
+		if w.Code != StatusBadRequest || !strings.Contains(w.Body.String(), "last chunk") {
+			t.Errorf("PUT of a cut chunked body: %d %q", w.Code, w.Body)
+		}
+		if _, err := os.Stat(filepath.Join(root, "cut.txt")); !os.IsNotExist(err) {
+			t.Errorf("cut chunked PUT left the file: %v", err)
+		}
+	})
+}
+
+// exclBarrierFS holds an O_EXCL open until a second one comes, so two
+// PUTs reach the create together after both found the file missing
+type exclBarrierFS struct {
+	FileSystem
+	mu      *sync.Mutex
+	waiting *chan struct{}
+}
+
+func (b exclBarrierFS) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	if flag&os.O_EXCL != 0 {
+		b.mu.Lock()
+		if ch := *b.waiting; ch != nil {
+			close(ch)
+			*b.waiting = nil
+			b.mu.Unlock()
+		} else {
+			ch = make(chan struct{})
+			*b.waiting = ch
+			b.mu.Unlock()
+			select {
+			case <-ch:
+			case <-time.After(time.Second):
+			}
+		}
+	}
+	return b.FileSystem.(OpenFiler).OpenFile(name, flag, perm)
+}
+
+func TestPutCreateOnlyRace(t *testing.T) {
+	for name, mk := range map[string]func(root string) FileSystem{
+		"dir":      func(root string) FileSystem { return Dir(root) },
+		"memfs":    func(string) FileSystem { return NewMemFS() },
+		"lockedfs": func(root string) FileSystem { return NewLockedFS(Dir(root)) },
+	} {
+		t.Run(name, func(t *testing.T) {
+			fsys := mk(t.TempDir())
+			ts := newTestServer(t, &Server{Fs: exclBarrierFS{fsys, new(sync.Mutex), new(chan struct{})}})
+			for i := 0; i < 20; i++ {
+				target := fmt.Sprintf("/new-%d", i)
+				statuses := make(chan int, 2)
+				for _, body := range []string{"first", "second"} {
+					go func(body string) {
+						req, _ := http.NewRequest("PUT", ts.URL+target, strings.NewReader(body))
+						req.Header.Set("If-None-Match", "*")
+						resp, err := ts.Client().Do(req)
+						if err != nil {
+							t.Error(err)
+							statuses <- 0
+							return
+						}
+						resp.Body.Close()
+						if resp.StatusCode == StatusCreated {
+							statuses <- len(body)
+						} else {
+							statuses <- -resp.StatusCode
+						}
+					}(body)
+				}
+				a, b := <-statuses, <-statuses
+				if a < 0 {
+					a, b = b, a
+				}
+				if a <= 0 || b != -StatusPreconditionFailed {
+					t.Fatalf("%s: statuses %d and %d, want one 201 and one 412", target, a, b)
+				}
+				if got := readAll(t, fsys, target); len(got) != a {
+					t.Errorf("%s holds %q, not the content of the PUT that created it", target, got)
+				}
+			}
+		})
+	}
+}
+
+// TestPutCreateOnlyExpect sends a create-only PUT of an existing file that
+// waits for 100 Continue: it gets 412 without being asked for its body
+func TestPutCreateOnlyExpect(t *testing.T) {
+	m := NewMemFS()
+	writeMem(t, m, "/taken", []byte("original"))
+	ts := newTestServer(t, &Server{Fs: m})
+
+	conn, err := net.Dial("tcp", ts.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	fmt.Fprint(conn, "PUT /taken HTTP/1.1\r\nHost: x\r\nIf-None-Match: *\r\nExpect: 100-continue\r\nContent-Length: 1000\r\n\r\n")
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil || !strings.HasPrefix(line, "HTTP/1.1 412 ") {
+		t.Errorf("first answer %q, %v, want 412 before any 100 Continue", line, err)
+	}
+	if got := readAll(t, m, "/taken"); string(got) != "original" {
+		t.Errorf("taken holds %q", got)
+	}
+}
+
+// TestPutOCMTime sends PUTs with X-OC-MTime and checks the time is stored
+// and served as Last-Modified, and that bad values only lose the time
+func TestPutOCMTime(t *testing.T) {
+	mtime := time.Unix(1600000000, 500000000)
+	for name, mk := range map[string]func(root string) FileSystem{
+		"dir":       func(root string) FileSystem { return Dir(root) },
+		"memfs":     func(string) FileSystem { return NewMemFS() },
+		"lockedfs":  func(root string) FileSystem { return NewLockedFS(Dir(root)) },
+		"readahead": func(root string) FileSystem { return NewReadAheadFS(Dir(root), 0, 0) },
+	} {
+		t.Run(name, func(t *testing.T) {
+			fsys := mk(t.TempDir())
+			ts := newTestServer(t, &Server{Fs: fsys, OCMTime: true, SyncCollection: true})
+			put := func(status int, value string) *http.Response {
+				t.Helper()
+				resp, _ := request(t, ts, "PUT", "/f.txt", "data", "X-OC-MTime", value)
+				wantStatus(t, resp, status)
+				return resp
+			}
+			stored := func() time.Time {
+				t.Helper()
+				fi, err := (&Server{Fs: fsys}).stat("/f.txt")
+				if err != nil {
+					t.Fatal(err)
+				}
+				return fi.ModTime()
+			}
+
+			if resp := put(StatusCreated, "1600000000.5"); resp.Header.Get("X-OC-MTime") != "accepted" {
+				t.Errorf("X-OC-MTime: %q, want accepted", resp.Header.Get("X-OC-MTime"))
+			}
+			if got := stored(); !got.Equal(mtime) {
+				t.Errorf("stored mtime %v, want %v", got, mtime)
+			}
+			resp, _ := request(t, ts, "GET", "/f.txt", "")
+			if lm := resp.Header.Get("Last-Modified"); lm != mtime.UTC().Format(http.TimeFormat) {
+				t.Errorf("Last-Modified %q, want %v", lm, mtime)
+			}
+			// and as getlastmodified, there being no PROPFIND
+			_, body := request(t, ts, "REPORT", "/", `<D:sync-collection xmlns:D="DAV:"><D:sync-token/>`+
+				`<D:sync-level>1</D:sync-level><D:prop><D:getlastmodified/></D:prop></D:sync-collection>`)
+			if want := "<D:getlastmodified>" + mtime.UTC().Format(http.TimeFormat); !strings.Contains(body, want) {
+				t.Errorf("REPORT %s, want %s", body, want)
+			}
+
+			// a replacement gets the time too
+			if resp := put(StatusNoContent, "1500000000"); resp.Header.Get("X-OC-MTime") != "accepted" {
+				t.Errorf("replacing: X-OC-MTime %q", resp.Header.Get("X-OC-MTime"))
+			}
+			if got := stored(); !got.Equal(time.Unix(1500000000, 0)) {
+				t.Errorf("replacing stored mtime %v", got)
+			}
+
+			// bad values still store the upload, with the current time
+			for _, bad := range []string{"yesterday", "-5", "0", "1600000000.x"} {
+				before := time.Now().Add(-time.Second)
+				if resp := put(StatusNoContent, bad); resp.Header.Get("X-OC-MTime") != "" {
+					t.Errorf("%q: X-OC-MTime %q", bad, resp.Header.Get("X-OC-MTime"))
+				}
+				if got := stored(); got.Before(before) {
+					t.Errorf("%q: stored mtime %v, want the time of the PUT", bad, got)
+				}
+			}
+		})
+	}
+
+	// without the option, or a FileSystem that can set times, the header
+	// is ignored
+	for name, s := range map[string]*Server{
+		"off":       {Fs: Dir(t.TempDir())},
+		"no chtime": {Fs: struct{ FileSystem }{Dir(t.TempDir())}, OCMTime: true},
+	} {
+		ts := newTestServer(t, s)
+		resp, _ := request(t, ts, "PUT", "/f.txt", "data", "X-OC-MTime", "1600000000")
+		wantStatus(t, resp, StatusCreated)
+		fi, err := s.stat("/f.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.Header.Get("X-OC-MTime") != "" || fi.ModTime().Equal(mtime.Truncate(time.Second)) {
+			t.Errorf("%s: X-OC-MTime %q, stored mtime %v", name, resp.Header.Get("X-OC-MTime"), fi.ModTime())
+		}
+	}
+}
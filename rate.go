@@ -0,0 +1,75 @@
+package webdav
+
+import (
+	"io"
+	"net/http"
+	"time"
+)
+
+// DefaultMinRateGrace is how long a transfer may stay below MinUploadRate
+// or MinDownloadRate, unless the Server sets its own
+const DefaultMinRateGrace = 30 * time.Second
+
+// rateFloor holds a transfer to a minimum rate through the read or write
+// deadline of its connection: every window of grace has to move rate*grace
+// bytes, a new window starting when it has, or the read or write that is
+// waiting fails with os.ErrDeadlineExceeded. A client that stalls, or
+// trickles, is cut off after at most grace.
+type rateFloor struct {
+	deadline func(time.Time) error
+	grace    time.Duration
+	need     int64 // bytes per window
+	moved    int64 // in the current window
+}
+
+// newRateFloor starts holding a transfer to rate bytes per second, it
+// returns nil if the connection has no deadlines, as under HTTP/1 hijacked
+// or test recorders
+func newRateFloor(deadline func(time.Time) error, rate int64, grace time.Duration) *rateFloor {
+	if grace <= 0 {
+		grace = DefaultMinRateGrace
+	}
+	f := &rateFloor{deadline: deadline, grace: grace, need: max(1, int64(float64(rate)*grace.Seconds()))}
+	if err := deadline(time.Now().Add(grace)); err != nil {
+		return nil
+	}
+	return f
+}
+
+func (f *rateFloor) progress(n int) {
+	f.moved += int64(n)
+	if f.moved >= f.need {
+		f.moved = 0
+		f.deadline(time.Now().Add(f.grace))
+	}
+}
+
+// stop lifts the deadline
+func (f *rateFloor) stop() {
+	f.deadline(time.Time{})
+}
+
+// slowReader reads a request body under a rateFloor
+type slowReader struct {
+	r io.Reader
+	f *rateFloor
+}
+
+func (s slowReader) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	s.f.progress(n)
+	return n, err
+}
+
+// slowWriter writes a response under a rateFloor. It hides io.ReaderFrom,
+// sendfile would write a whole file in one call the floor can't follow.
+type slowWriter struct {
+	http.ResponseWriter
+	f *rateFloor
+}
+
+func (s slowWriter) Write(p []byte) (int, error) {
+	n, err := s.ResponseWriter.Write(p)
+	s.f.progress(n)
+	return n, err
+}
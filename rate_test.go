@@ -0,0 +1,126 @@
+package webdav
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// throttled sends size bytes of body over conn in chunks of chunk bytes,
+// one every interval, until it is done or the server hangs up
+func throttled(conn net.Conn, size, chunk int, interval time.Duration) {
+	for sent := 0; sent < size; sent += chunk {
+		if _, err := conn.Write(bytes.Repeat([]byte("x"), min(chunk, size-sent))); err != nil {
+			return
+		}
+		time.Sleep(interval)
+	}
+}
+
+// TestMinUploadRate sends bodies below, and just above, a minimum rate of
+// 1000 bytes a second over a grace of half a second
+func TestMinUploadRate(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		chunk, size int
+		interval    time.Duration
+		status      int
+	}{
+		{"stalled", 10, 1000, time.Hour, StatusRequestTimeout},
+		{"trickling at 625 B/s", 50, 3000, 80 * time.Millisecond, StatusRequestTimeout},
+		{"just above at 1250 B/s", 50, 3000, 40 * time.Millisecond, StatusNoContent},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			root := t.TempDir()
+			os.WriteFile(filepath.Join(root, "f.txt"), []byte("previous"), 0o644)
+			ts := newTestServer(t, &Server{Fs: Dir(root), MinUploadRate: 1000, MinRateGrace: 500 * time.Millisecond})
+
+			conn, err := net.Dial("tcp", ts.Listener.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			fmt.Fprintf(conn, "PUT /f.txt HTTP/1.1\r\nHost: x\r\nContent-Length: %d\r\n\r\n", tc.size)
+			start := time.Now()
+			go throttled(conn, tc.size, tc.chunk, tc.interval)
+
+			conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+			resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tc.status {
+				t.Fatalf("status %d after %v, want %d", resp.StatusCode, time.Since(start), tc.status)
+			}
+
+			b, _ := os.ReadFile(filepath.Join(root, "f.txt"))
+			if tc.status == StatusRequestTimeout {
+				if elapsed := time.Since(start); elapsed > 2*time.Second {
+					t.Errorf("cut off after %v", elapsed)
+				}
+				if !resp.Close {
+					t.Error("408 without Connection: close")
+				}
+				if string(b) != "previous" {
+					t.Errorf("f.txt holds %q after the cutoff", b)
+				}
+				entries, _ := os.ReadDir(root)
+				if len(entries) != 1 {
+					t.Errorf("files left behind: %v", entries)
+				}
+			} else if string(b) != strings.Repeat("x", tc.size) {
+				t.Errorf("f.txt holds %d bytes, want %d", len(b), tc.size)
+			}
+		})
+	}
+}
+
+// TestMinDownloadRate reads a download slower than MinDownloadRate, it is
+// cut off, while one read at full speed is not. The floor is high so the
+// socket buffers, which the floor can't see into, drain quickly.
+func TestMinDownloadRate(t *testing.T) {
+	root := t.TempDir()
+	const size = 128 << 20
+	os.WriteFile(filepath.Join(root, "big"), make([]byte, size), 0o644)
+	ts := newTestServer(t, &Server{Fs: Dir(root), MinDownloadRate: 32 << 20, MinRateGrace: 300 * time.Millisecond})
+
+	resp, err := ts.Client().Get(ts.URL + "/big")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := io.Copy(io.Discard, resp.Body); n != size || err != nil {
+		t.Fatalf("read %d bytes at full speed, %v", n, err)
+	}
+	resp.Body.Close()
+
+	resp, err = ts.Client().Get(ts.URL + "/big")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	start := time.Now()
+	var n int64
+	for time.Since(start) < 20*time.Second {
+		m, err := io.CopyN(io.Discard, resp.Body, 64<<10)
+		n += m
+		if err != nil {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if n >= size {
+		t.Errorf("a reader at under 13 MiB/s got all of the download")
+	}
+	if elapsed := time.Since(start); elapsed >= 20*time.Second {
+		t.Errorf("a reader at under 13 MiB/s still reading after %v, %d bytes", elapsed, n)
+	}
+}
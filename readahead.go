@@ -0,0 +1,453 @@
+package webdav
+
+import (
+	"context"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// the defaults of NewReadAheadFS
+const (
+	DefaultReadAheadWindow = 4 << 20
+	DefaultReadAheadChunk  = 256 << 10
+)
+
+// ReadAheadFS speeds up sequential reads from a FileSystem whose reads are
+// slow to start, like one reached over the network. A file read from start
+// to end has the next window bytes fetched in chunks while the reader
+// consumes the current one, several at a time, with the ReadAt of the file
+// the inner FileSystem opens.
+//
+// Reads that don't continue the previous one go straight to the inner
+// file, so random access costs no more than without ReadAheadFS. Files
+// that don't implement io.ReaderAt, directories and files no larger than
+// one chunk are returned unwrapped. A Seek out of the window, and Close,
+// cancel the fetches no longer needed if the file is a ContextReaderAt.
+//
+// Every optional interface, from Renamer to Trasher and FileSystemCloser,
+// is passed to the inner FileSystem, returning ErrNotImplemented where it
+// has none. Files opened for writing are returned as the inner FileSystem
+// opens them, so PUT still preallocates and syncs them.
+type ReadAheadFS struct {
+	fs     FileSystem
+	window int
+	chunk  int
+}
+
+// NewReadAheadFS wraps fs in a ReadAheadFS fetching up to window bytes
+// ahead in reads of chunk bytes, zero for the defaults
+func NewReadAheadFS(fs FileSystem, window, chunk int) *ReadAheadFS {
+	if chunk <= 0 {
+		chunk = DefaultReadAheadChunk
+	}
+	if window <= 0 {
+		window = DefaultReadAheadWindow
+	}
+	if window < chunk {
+		window = chunk
+	}
+	return &ReadAheadFS{fs: fs, window: window, chunk: chunk}
+}
+
+// Unwrap returns the inner FileSystem
+func (ra *ReadAheadFS) Unwrap() FileSystem {
+	return ra.fs
+}
+
+// Open opens name with the inner FileSystem and reads ahead of its reader
+func (ra *ReadAheadFS) Open(name string) (File, error) {
+	f, err := ra.fs.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return ra.wrap(f), nil
+}
+
+// wrap returns f reading ahead if it is worth it
+func (ra *ReadAheadFS) wrap(f File) File {
+	at, ok := f.(io.ReaderAt)
+	if !ok {
+		return f
+	}
+	fi, err := f.Stat()
+	if err != nil || fi.IsDir() || fi.Size() <= int64(ra.chunk) {
+		return f
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cat, _ := f.(ContextReaderAt)
+	return &readAheadFile{
+		File:   f,
+		at:     at,
+		cat:    cat,
+		size:   fi.Size(),
+		chunk:  ra.chunk,
+		ahead:  ra.window / ra.chunk,
+		ctx:    ctx,
+		cancel: cancel,
+	}
+}
+
+// Create calls the inner Create
+func (ra *ReadAheadFS) Create(name string) (File, error) {
+	return ra.fs.Create(name)
+}
+
+// OpenFile forwards to the inner OpenFiler, reading ahead of files opened
+// read-only
+func (ra *ReadAheadFS) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	o, ok := ra.fs.(OpenFiler)
+	if !ok {
+		return nil, ErrNotImplemented
+	}
+	f, err := o.OpenFile(name, flag, perm)
+	if err != nil || flag&(os.O_WRONLY|os.O_RDWR) != 0 {
+		return f, err
+	}
+	return ra.wrap(f), nil
+}
+
+// Mkdir calls the inner Mkdir
+func (ra *ReadAheadFS) Mkdir(name string) error {
+	return ra.fs.Mkdir(name)
+}
+
+// Remove calls the inner Remove
+func (ra *ReadAheadFS) Remove(name string) error {
+	return ra.fs.Remove(name)
+}
+
+// removePartial forwards to the inner partialRemover
+func (ra *ReadAheadFS) removePartial(name string) error {
+	if r, ok := ra.fs.(partialRemover); ok {
+		return r.removePartial(name)
+	}
+	return ErrNotImplemented
+}
+
+// Rename forwards to the inner Renamer
+func (ra *ReadAheadFS) Rename(oldname, newname string) error {
+	if r, ok := ra.fs.(Renamer); ok {
+		return r.Rename(oldname, newname)
+	}
+	return ErrNotImplemented
+}
+
+// CreateTemp forwards to the inner TempFiler
+func (ra *ReadAheadFS) CreateTemp(dir, pattern string) (File, string, error) {
+	if t, ok := ra.fs.(TempFiler); ok {
+		return t.CreateTemp(dir, pattern)
+	}
+	return nil, "", ErrNotImplemented
+}
+
+// CopyFile forwards to the inner Copier
+func (ra *ReadAheadFS) CopyFile(src, dst string) error {
+	if c, ok := ra.fs.(Copier); ok {
+		return c.CopyFile(src, dst)
+	}
+	return ErrNotImplemented
+}
+
+// ETag forwards to the inner ETagger
+func (ra *ReadAheadFS) ETag(name string) (string, error) {
+	if e, ok := ra.fs.(ETagger); ok {
+		return e.ETag(name)
+	}
+	return "", ErrNotImplemented
+}
+
+// Chtimes forwards to the inner Chtimer
+func (ra *ReadAheadFS) Chtimes(name string, atime, mtime time.Time) error {
+	if c, ok := ra.fs.(Chtimer); ok {
+		return c.Chtimes(name, atime, mtime)
+	}
+	return ErrNotImplemented
+}
+
+// Chmod forwards to the inner Chmoder
+func (ra *ReadAheadFS) Chmod(name string, mode os.FileMode) error {
+	if c, ok := ra.fs.(Chmoder); ok {
+		return c.Chmod(name, mode)
+	}
+	return ErrNotImplemented
+}
+
+// Checksum forwards to the inner ChecksumFS
+func (ra *ReadAheadFS) Checksum(name, algo string) (string, error) {
+	if c, ok := ra.fs.(ChecksumFS); ok {
+		return c.Checksum(name, algo)
+	}
+	return "", ErrNotImplemented
+}
+
+// CachedChecksum forwards to the inner ChecksumCache
+func (ra *ReadAheadFS) CachedChecksum(name, algo string) (string, bool) {
+	if c, ok := ra.fs.(ChecksumCache); ok {
+		return c.CachedChecksum(name, algo)
+	}
+	return "", false
+}
+
+// CacheChecksum forwards to the inner ChecksumCache
+func (ra *ReadAheadFS) CacheChecksum(name, algo, sum string, size int64) {
+	if c, ok := ra.fs.(ChecksumCache); ok {
+		c.CacheChecksum(name, algo, sum, size)
+	}
+}
+
+// Owner forwards to the inner OwnerFS
+func (ra *ReadAheadFS) Owner(name string) (FileOwner, error) {
+	if o, ok := ra.fs.(OwnerFS); ok {
+		return o.Owner(name)
+	}
+	return FileOwner{}, ErrNotImplemented
+}
+
+// Watch forwards to the inner Watcher
+func (ra *ReadAheadFS) Watch(prefix string) (<-chan Event, func(), error) {
+	if w, ok := ra.fs.(Watcher); ok {
+		return w.Watch(prefix)
+	}
+	return nil, nil, ErrNotImplemented
+}
+
+// Trash forwards to the inner Trasher
+func (ra *ReadAheadFS) Trash(n int) ([]TrashEntry, error) {
+	if t, ok := ra.fs.(Trasher); ok {
+		return t.Trash(n)
+	}
+	return nil, ErrNotImplemented
+}
+
+// TrashEntry forwards to the inner Trasher
+func (ra *ReadAheadFS) TrashEntry(id string) (TrashEntry, error) {
+	if t, ok := ra.fs.(Trasher); ok {
+		return t.TrashEntry(id)
+	}
+	return TrashEntry{}, ErrNotImplemented
+}
+
+// Restore forwards to the inner Trasher
+func (ra *ReadAheadFS) Restore(id, name string) error {
+	if t, ok := ra.fs.(Trasher); ok {
+		return t.Restore(id, name)
+	}
+	return ErrNotImplemented
+}
+
+// Purge forwards to the inner Trasher
+func (ra *ReadAheadFS) Purge(id string) error {
+	if t, ok := ra.fs.(Trasher); ok {
+		return t.Purge(id)
+	}
+	return ErrNotImplemented
+}
+
+// Close closes the inner FileSystem if it is a FileSystemCloser
+func (ra *ReadAheadFS) Close() error {
+	if c, ok := ra.fs.(FileSystemCloser); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// readAheadFile is a file opened by ReadAheadFS. Its chunks are the reads
+// in order from the current offset on, each fetched by its own goroutine.
+type readAheadFile struct {
+	File
+	at    io.ReaderAt
+	cat   ContextReaderAt // nil if fetches can't be canceled
+	size  int64           // at Open, nothing is fetched ahead beyond it
+	chunk int
+	ahead int // most chunks fetched at once
+
+	// the parent of the fetches' contexts, canceled by Close
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	inflight atomic.Int32 // fetches running, including abandoned ones
+	wg       sync.WaitGroup
+
+	mu      sync.Mutex
+	off     int64
+	lastEnd int64 // where the previous Read ended
+	chunks  []*readAheadChunk
+	free    [][]byte
+	closed  bool
+}
+
+// readAheadChunk is a ReadAt of buf at off, done is closed once n and err
+// are set. cancel gives up on it.
+type readAheadChunk struct {
+	off    int64
+	buf    []byte
+	n      int
+	err    error
+	done   chan struct{}
+	cancel context.CancelFunc
+}
+
+// fill starts fetching the chunks following the last one, or the offset
+// if there are none, until ahead are on their way. The caller holds f.mu.
+func (f *readAheadFile) fill() {
+	next := f.off
+	if len(f.chunks) > 0 {
+		last := f.chunks[len(f.chunks)-1]
+		next = last.off + int64(len(last.buf))
+	}
+	for len(f.chunks) < f.ahead && int(f.inflight.Load()) < f.ahead && next < f.size {
+		n := int64(f.chunk)
+		if rest := f.size - next; rest < n {
+			n = rest
+		}
+		var buf []byte
+		if k := len(f.free); k > 0 && n == int64(f.chunk) {
+			buf, f.free = f.free[k-1], f.free[:k-1]
+		} else {
+			buf = make([]byte, n)
+		}
+
+		ctx, cancel := context.WithCancel(f.ctx)
+		c := &readAheadChunk{off: next, buf: buf, done: make(chan struct{}), cancel: cancel}
+		f.inflight.Add(1)
+		f.wg.Add(1)
+		go func() {
+			defer f.wg.Done()
+			if f.cat != nil {
+				c.n, c.err = f.cat.ReadAtContext(ctx, c.buf, c.off)
+			} else {
+				c.n, c.err = f.at.ReadAt(c.buf, c.off)
+			}
+			cancel()
+			f.inflight.Add(-1)
+			close(c.done)
+		}()
+		f.chunks = append(f.chunks, c)
+		next += n
+	}
+}
+
+// drop abandons the chunks before off, or all of them if off lies beyond
+// the last. Fetches still running are canceled, or if they can't be their
+// results are dropped when they finish. The caller holds f.mu.
+func (f *readAheadFile) drop() {
+	for len(f.chunks) > 0 {
+		c := f.chunks[0]
+		if f.off < c.off+int64(len(c.buf)) {
+			if f.off < c.off {
+				f.dropAll()
+			}
+			return
+		}
+		c.cancel()
+		f.chunks = f.chunks[1:]
+	}
+}
+
+// dropAll abandons every chunk, see drop. The caller holds f.mu.
+func (f *readAheadFile) dropAll() {
+	for _, c := range f.chunks {
+		c.cancel()
+	}
+	f.chunks = nil
+}
+
+func (f *readAheadFile) Read(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		return 0, os.ErrClosed
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	sequential := f.off == f.lastEnd
+
+	for {
+		f.drop()
+		if sequential {
+			f.fill()
+		}
+		if len(f.chunks) == 0 {
+			break
+		}
+
+		c := f.chunks[0]
+		<-c.done
+		i := int(f.off - c.off)
+		if i < c.n {
+			n := copy(p, c.buf[i:c.n])
+			f.off += int64(n)
+			f.lastEnd = f.off
+			return n, nil
+		}
+		if c.n < len(c.buf) {
+			// ReadAt only returns less with an error
+			f.dropAll()
+			return 0, c.err
+		}
+		// used up, its buffer is free for another chunk
+		f.chunks = f.chunks[1:]
+		if len(c.buf) == f.chunk {
+			f.free = append(f.free, c.buf)
+		}
+	}
+
+	// not read ahead: a random read, or beyond the size at Open
+	n, err := f.at.ReadAt(p, f.off)
+	if n > 0 && err == io.EOF {
+		err = nil
+	}
+	f.off += int64(n)
+	f.lastEnd = f.off
+	return n, err
+}
+
+// ReadAt reads from the inner file, without reading ahead
+func (f *readAheadFile) ReadAt(p []byte, off int64) (int, error) {
+	return f.at.ReadAt(p, off)
+}
+
+// Seek keeps the chunks from the new offset on, so skipping ahead within
+// the window doesn't fetch them again
+func (f *readAheadFile) Seek(offset int64, whence int) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		return 0, os.ErrClosed
+	}
+	if whence == io.SeekCurrent {
+		offset, whence = f.off+offset, io.SeekStart
+	}
+	// the inner file checks and resolves the offset, reads use ReadAt so
+	// its own offset doesn't matter
+	off, err := f.File.Seek(offset, whence)
+	if err != nil {
+		return f.off, err
+	}
+	f.off = off
+	f.drop()
+	return off, nil
+}
+
+// Close cancels the fetches running and waits for them, so the inner file
+// isn't closed under them. Those that can't be canceled run to the end.
+func (f *readAheadFile) Close() error {
+	f.mu.Lock()
+	if f.closed {
+		f.mu.Unlock()
+		return os.ErrClosed
+	}
+	f.closed = true
+	f.chunks, f.free = nil, nil
+	f.cancel()
+	f.mu.Unlock()
+
+	f.wg.Wait()
+	return f.File.Close()
+}
@@ -0,0 +1,257 @@
+package webdav
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"io"
+	"math/rand"
+	randv2 "math/rand/v2"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// slowFS is a FileSystem whose reads take a while to start, like one
+// reached over the network. Every Read and ReadAt waits for delay of its
+// offset; with cancelable its files are ContextReaderAts, whose reads stop
+// waiting when their context is done, counted in canceled.
+type slowFS struct {
+	FileSystem
+	delay      func(off int64) time.Duration
+	cancelable bool
+	canceled   atomic.Int32
+}
+
+type slowFile struct {
+	File
+	fs *slowFS
+}
+
+// cancelableFile is a slowFile that is a ContextReaderAt
+type cancelableFile struct {
+	*slowFile
+}
+
+func (s *slowFS) Open(name string) (File, error) {
+	f, err := s.FileSystem.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	sf := &slowFile{f, s}
+	if s.cancelable {
+		return cancelableFile{sf}, nil
+	}
+	return sf, nil
+}
+
+func (f *slowFile) Read(p []byte) (int, error) {
+	off, _ := f.File.Seek(0, io.SeekCurrent)
+	time.Sleep(f.fs.delay(off))
+	return f.File.Read(p)
+}
+
+func (f *slowFile) ReadAt(p []byte, off int64) (int, error) {
+	time.Sleep(f.fs.delay(off))
+	return f.File.(io.ReaderAt).ReadAt(p, off)
+}
+
+func (f cancelableFile) ReadAtContext(ctx context.Context, p []byte, off int64) (int, error) {
+	t := time.NewTimer(f.fs.delay(off))
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return f.File.(io.ReaderAt).ReadAt(p, off)
+	case <-ctx.Done():
+		f.fs.canceled.Add(1)
+		return 0, ctx.Err()
+	}
+}
+
+// writeSource writes size random bytes to the file name below root
+func writeSource(t testing.TB, root, name string, size int) []byte {
+	src := make([]byte, size)
+	rand.New(rand.NewSource(int64(size))).Read(src)
+	if err := os.WriteFile(filepath.Join(root, name), src, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return src
+}
+
+// TestReadAheadHash streams a file through ReadAheadFS with random mixes
+// of seeks and reads, from a backend whose reads complete out of order,
+// and hashes what was read against the source
+func TestReadAheadHash(t *testing.T) {
+	root := t.TempDir()
+	const size = 1<<20 + 123
+	src := writeSource(t, root, "f", size)
+	jitter := func(int64) time.Duration { return time.Duration(randv2.N(300)) * time.Microsecond }
+
+	for _, cancelable := range []bool{false, true} {
+		fsys := NewReadAheadFS(&slowFS{FileSystem: Dir(root), delay: jitter, cancelable: cancelable}, 64<<10, 4<<10)
+
+		// straight through
+		f, err := fsys.Open("/f")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := f.(*readAheadFile); !ok {
+			t.Fatalf("opened %T, not reading ahead", f)
+		}
+		h := sha256.New()
+		if n, err := io.CopyBuffer(h, struct{ io.Reader }{f}, make([]byte, 3000)); n != size || err != nil {
+			t.Fatalf("cancelable %v: copied %d bytes, %v", cancelable, n, err)
+		}
+		if !bytes.Equal(h.Sum(nil), sha256Sum(src)) {
+			t.Errorf("cancelable %v: sequential read differs from the source", cancelable)
+		}
+		f.Close()
+
+		for seed := int64(1); seed <= 20; seed++ {
+			rnd := rand.New(rand.NewSource(seed))
+			f, err := fsys.Open("/f")
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, want := sha256.New(), sha256.New()
+			var off int64
+			for op := 0; op < 300; op++ {
+				switch k := rnd.Intn(10); {
+				case k == 0: // anywhere
+					off, err = f.Seek(rnd.Int63n(size+10), io.SeekStart)
+				case k == 1: // ahead within the window
+					off, err = f.Seek(rnd.Int63n(32<<10), io.SeekCurrent)
+				case k == 2: // back a little
+					off, err = f.Seek(-rnd.Int63n(8<<10), io.SeekCurrent)
+					if err != nil {
+						// before the start
+						off, err = f.Seek(0, io.SeekCurrent)
+					}
+				case k == 3:
+					off, err = f.Seek(-rnd.Int63n(size), io.SeekEnd)
+				default:
+					p := make([]byte, 1+rnd.Intn(20000))
+					n, err := f.Read(p)
+					if err != nil && err != io.EOF {
+						t.Fatalf("seed %d: read at %d: %v", seed, off, err)
+					}
+					if n > 0 && off+int64(n) > size || n == 0 && off < size {
+						t.Fatalf("seed %d: read %d bytes at %d", seed, n, off)
+					}
+					if n > 0 {
+						got.Write(p[:n])
+						want.Write(src[off : off+int64(n)])
+					}
+					off += int64(n)
+					continue
+				}
+				if err != nil {
+					t.Fatalf("seed %d: seek: %v", seed, err)
+				}
+			}
+			if !bytes.Equal(got.Sum(nil), want.Sum(nil)) {
+				t.Errorf("cancelable %v, seed %d: what was read differs from the source", cancelable, seed)
+			}
+			if err := f.Close(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+func sha256Sum(b []byte) []byte {
+	h := sha256.Sum256(b)
+	return h[:]
+}
+
+// TestReadAheadCancel checks the fetches ahead are canceled by a Seek out
+// of the window and by Close, and kept by a Seek within it but for those
+// it skips
+func TestReadAheadCancel(t *testing.T) {
+	root := t.TempDir()
+	const chunk, window, size = 4 << 10, 32 << 10, 1 << 20
+	writeSource(t, root, "f", size)
+	// only the first chunk arrives, the others wait until canceled
+	slow := &slowFS{FileSystem: Dir(root), cancelable: true, delay: func(off int64) time.Duration {
+		if off < chunk {
+			return 0
+		}
+		return time.Hour
+	}}
+	fsys := NewReadAheadFS(slow, window, chunk)
+	waitCanceled := func(want int32) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for slow.canceled.Load() < want && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+		if got := slow.canceled.Load(); got != want {
+			t.Fatalf("%d fetches canceled, want %d", got, want)
+		}
+	}
+
+	f, err := fsys.Open("/f")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := f.Read(make([]byte, 100)); n != 100 || err != nil {
+		t.Fatalf("read %d, %v", n, err)
+	}
+	if _, err := f.Seek(2*chunk, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	// only the chunk skipped is canceled
+	time.Sleep(20 * time.Millisecond)
+	waitCanceled(1)
+	if _, err := f.Seek(size/2, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	waitCanceled(window/chunk - 1)
+	f.Close()
+
+	slow.canceled.Store(0)
+	f, err = fsys.Open("/f")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Read(make([]byte, 100))
+	start := time.Now()
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Close took %v", elapsed)
+	}
+	waitCanceled(window/chunk - 1)
+}
+
+// BenchmarkReadAhead reads a file sequentially from a backend whose reads
+// each take a millisecond to start, directly and through ReadAheadFS
+func BenchmarkReadAhead(b *testing.B) {
+	root := b.TempDir()
+	const size = 4 << 20
+	writeSource(b, root, "f", size)
+	slow := &slowFS{FileSystem: Dir(root), delay: func(int64) time.Duration { return time.Millisecond }}
+
+	for name, fsys := range map[string]FileSystem{
+		"direct":    slow,
+		"readahead": NewReadAheadFS(slow, 1<<20, 128<<10),
+	} {
+		b.Run(name, func(b *testing.B) {
+			b.SetBytes(size)
+			buf := make([]byte, 32<<10)
+			for i := 0; i < b.N; i++ {
+				f, err := fsys.Open("/f")
+				if err != nil {
+					b.Fatal(err)
+				}
+				if n, err := io.CopyBuffer(io.Discard, struct{ io.Reader }{f}, buf); n != size || err != nil {
+					b.Fatalf("copied %d bytes, %v", n, err)
+				}
+				f.Close()
+			}
+		})
+	}
+}
@@ -0,0 +1,398 @@
+package webdav
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RemoteFS is a FileSystem backed by another WebDAV server, so a Server
+// can re-export a remote endpoint with its own access rules or wrappers
+// layered on top.
+//
+// Open issues a PROPFIND and reads the content on demand with ranged GETs,
+// guarded by the ETag seen by Open, so a resource that changes while it
+// is read fails the read instead of mixing two versions. Create streams a
+// PUT that completes when the file is closed. Mkdir, Remove, Rename and
+// CopyFile map to MKCOL, DELETE, MOVE and COPY.
+type RemoteFS struct {
+	c *Client
+}
+
+// NewRemoteFS returns a FileSystem for the server c talks to
+func NewRemoteFS(c *Client) *RemoteFS {
+	return &RemoteFS{c: c}
+}
+
+// the FileSystem interface has no context, requests made for it are only
+// canceled by closing the Client
+var bg = context.Background()
+
+var errUploadAborted = errors.New("upload aborted")
+
+// remoteError turns the status errors the server code can't interpret into
+// the os errors it checks for
+func remoteError(op, name string, err error) error {
+	switch {
+	case errors.Is(err, ErrNotFound):
+		return &os.PathError{Op: op, Path: name, Err: os.ErrNotExist}
+	case errors.Is(err, ErrForbidden):
+		return &os.PathError{Op: op, Path: name, Err: os.ErrPermission}
+	}
+	return err
+}
+
+// Open returns the remote resource, its content is not fetched until the
+// first Read
+func (r *RemoteFS) Open(name string) (File, error) {
+	fi, err := r.c.Stat(bg, name)
+	if err != nil {
+		return nil, remoteError("open", name, err)
+	}
+	rfi := fi.(*RemoteFileInfo)
+	return &remoteFile{c: r.c, name: name, fi: rfi, etag: strongETag(rfi.ETag())}, nil
+}
+
+// Create starts a PUT of name fed by the writes to the returned file, the
+// upload finishes and reports its error when the file is closed
+func (r *RemoteFS) Create(name string) (File, error) {
+	pr, pw := io.Pipe()
+	f := &remoteWriter{name: name, pw: pw, done: make(chan error, 1)}
+	go func() {
+		err := r.c.Put(bg, name, pr)
+		pr.CloseWithError(err)
+		f.done <- err
+	}()
+	return f, nil
+}
+
+// Mkdir creates the collection name and any missing parents
+func (r *RemoteFS) Mkdir(name string) error {
+	return remoteError("mkdir", name, r.c.mkcolAll(bg, name))
+}
+
+// Remove deletes name
+func (r *RemoteFS) Remove(name string) error {
+	return remoteError("remove", name, r.c.Delete(bg, name))
+}
+
+// Rename moves oldname to newname, see Renamer
+func (r *RemoteFS) Rename(oldname, newname string) error {
+	return remoteError("rename", oldname, r.c.Move(bg, oldname, newname, true))
+}
+
+// CopyFile has the remote server copy src to dst, see Copier
+func (r *RemoteFS) CopyFile(src, dst string) error {
+	return remoteError("copy", src, r.c.Copy(bg, src, dst, true))
+}
+
+// ETag returns the strong entity tag the remote server reports, see ETagger
+func (r *RemoteFS) ETag(name string) (string, error) {
+	fi, err := r.c.Stat(bg, name)
+	if err != nil {
+		return "", remoteError("etag", name, err)
+	}
+	tag := fi.(*RemoteFileInfo).ETag()
+	if tag == "" || strings.HasPrefix(tag, "W/") {
+		return "", ErrNotImplemented
+	}
+	return strings.Trim(tag, `"`), nil
+}
+
+// Close closes the Client, see FileSystemCloser
+func (r *RemoteFS) Close() error {
+	return r.c.Close()
+}
+
+// the window of a remote file fetched by one GET, it starts at
+// remoteReadAhead and doubles with every sequential read up to
+// remoteMaxReadAhead
+const (
+	remoteReadAhead    = 64 << 10
+	remoteMaxReadAhead = 8 << 20
+)
+
+// fetch reads the content of f from off into p with one ranged GET,
+// returning io.EOF at the end of the resource
+func (f *remoteFile) fetch(ctx context.Context, p []byte, off int64) (int, error) {
+	size := f.fi.Size()
+	if off >= size {
+		return 0, io.EOF
+	}
+	if rest := size - off; int64(len(p)) > rest {
+		p = p[:rest]
+	}
+
+	f.ifMu.Lock()
+	ifRange := f.ifRange
+	f.ifMu.Unlock()
+	resp, err := f.c.getRange(ctx, f.name, off, off+int64(len(p))-1, ifRange)
+	if err != nil {
+		return 0, remoteError("read", f.name, err)
+	}
+	defer resp.Body.Close()
+
+	changed := &os.PathError{Op: "read", Path: f.name, Err: errChanged}
+	if tag := resp.Header.Get("ETag"); tag != "" && f.etag != "" {
+		if tag != f.etag {
+			return 0, changed
+		}
+		// the server sends the ETag with GET, so it can check If-Range
+		f.ifMu.Lock()
+		f.ifRange = f.etag
+		f.ifMu.Unlock()
+	}
+
+	switch {
+	case resp.StatusCode == StatusPartialContent:
+		start, _, total, ok := parseContentRange(resp.Header.Get("Content-Range"))
+		if !ok || start != off {
+			return 0, fmt.Errorf("webdav: GET %s: unexpected Content-Range %q", f.name, resp.Header.Get("Content-Range"))
+		}
+		if total >= 0 && total != size {
+			return 0, changed
+		}
+	case ifRange != "":
+		// If-Range didn't match
+		return 0, changed
+	default:
+		// the server doesn't do ranges, skip to off ourselves
+		if _, err := io.CopyN(io.Discard, resp.Body, off); err != nil {
+			return 0, remoteError("read", f.name, err)
+		}
+	}
+
+	n, err := io.ReadFull(resp.Body, p)
+	if err == io.ErrUnexpectedEOF || err == io.EOF {
+		// shorter than the PROPFIND said
+		err = changed
+	}
+	return n, err
+}
+
+// remoteFile is a resource opened by RemoteFS.Open
+type remoteFile struct {
+	c    *Client
+	name string
+	fi   *RemoteFileInfo
+
+	mu      sync.Mutex
+	etag    string // strong ETag from Open
+	ifMu    sync.Mutex
+	ifRange string // etag once the server showed it sends it with GET, under ifMu
+	off     int64
+	buf     []byte // the content from bufOff on, read ahead
+	bufOff  int64
+	window  int
+
+	dir    []os.FileInfo
+	dirOff int
+}
+
+func (f *remoteFile) Stat() (os.FileInfo, error) {
+	return f.fi, nil
+}
+
+func (f *remoteFile) Readdir(count int) ([]os.FileInfo, error) {
+	if !f.fi.IsDir() {
+		return nil, &os.PathError{Op: "readdir", Path: f.name, Err: errNotDir}
+	}
+	if f.dir == nil {
+		fis, err := f.c.ReadDir(bg, f.name)
+		if err != nil {
+			return nil, remoteError("readdir", f.name, err)
+		}
+		f.dir = append([]os.FileInfo{}, fis...)
+	}
+
+	rest := f.dir[f.dirOff:]
+	if count <= 0 {
+		f.dirOff = len(f.dir)
+		return rest, nil
+	}
+	if len(rest) == 0 {
+		return nil, io.EOF
+	}
+	if count > len(rest) {
+		count = len(rest)
+	}
+	f.dirOff += count
+	return rest[:count], nil
+}
+
+func (f *remoteFile) Read(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	n, err := f.readAt(p, f.off, true)
+	f.off += int64(n)
+	return n, err
+}
+
+// ReadAt reads without moving the offset, it is safe for concurrent use
+func (f *remoteFile) ReadAt(p []byte, off int64) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	n := 0
+	for n < len(p) {
+		m, err := f.readAt(p[n:], off+int64(n), false)
+		n += m
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// ReadAtContext reads like ReadAt, without the read ahead buffer or its
+// lock so reads run concurrently, and gives up when ctx is done, see
+// ContextReaderAt
+func (f *remoteFile) ReadAtContext(ctx context.Context, p []byte, off int64) (int, error) {
+	if f.fi.IsDir() {
+		return 0, &os.PathError{Op: "read", Path: f.name, Err: errIsDir}
+	}
+	n := 0
+	for n < len(p) {
+		m, err := f.fetch(ctx, p[n:], off+int64(n))
+		n += m
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// readAt serves p from the read ahead buffer, refilling it on a miss.
+// Sequential reads grow the window, the caller holds f.mu.
+func (f *remoteFile) readAt(p []byte, off int64, sequential bool) (int, error) {
+	if f.fi.IsDir() {
+		return 0, &os.PathError{Op: "read", Path: f.name, Err: errIsDir}
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if off >= f.bufOff && off < f.bufOff+int64(len(f.buf)) {
+		return copy(p, f.buf[off-f.bufOff:]), nil
+	}
+
+	continued := off == f.bufOff+int64(len(f.buf))
+	switch {
+	case f.window == 0 || !sequential || !continued:
+		f.window = remoteReadAhead
+	case f.window < remoteMaxReadAhead:
+		f.window *= 2
+	}
+	if len(p) >= f.window {
+		// no need to buffer
+		f.buf = f.buf[:0]
+		return f.fetch(bg, p, off)
+	}
+
+	if cap(f.buf) < f.window {
+		f.buf = make([]byte, f.window)
+	}
+	n, err := f.fetch(bg, f.buf[:f.window], off)
+	f.buf, f.bufOff = f.buf[:n], off
+	if n == 0 {
+		return 0, err
+	}
+	return copy(p, f.buf), nil
+}
+
+func (f *remoteFile) Write(p []byte) (int, error) {
+	return 0, &os.PathError{Op: "write", Path: f.name, Err: os.ErrPermission}
+}
+
+// Seek only moves the offset, the next Read fetches from there
+func (f *remoteFile) Seek(offset int64, whence int) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.off
+	case io.SeekEnd:
+		offset += f.fi.Size()
+	default:
+		return 0, fmt.Errorf("webdav: seek %s: invalid whence %d", f.name, whence)
+	}
+	if offset < 0 {
+		return 0, fmt.Errorf("webdav: seek %s: negative position", f.name)
+	}
+
+	f.off = offset
+	return offset, nil
+}
+
+func (f *remoteFile) Close() error {
+	f.mu.Lock()
+	f.buf = nil
+	f.mu.Unlock()
+	return nil
+}
+
+// remoteWriter is a file returned by RemoteFS.Create, its writes feed a
+// running PUT
+type remoteWriter struct {
+	name string
+	pw   *io.PipeWriter
+	done chan error
+	size int64
+
+	closed bool
+	err    error
+}
+
+func (f *remoteWriter) Stat() (os.FileInfo, error) {
+	return &memFileInfo{name: path.Base(f.name), size: f.size, mode: 0644, modTime: time.Now()}, nil
+}
+
+func (f *remoteWriter) Readdir(count int) ([]os.FileInfo, error) {
+	return nil, &os.PathError{Op: "readdir", Path: f.name, Err: errNotDir}
+}
+
+func (f *remoteWriter) Read(p []byte) (int, error) {
+	return 0, &os.PathError{Op: "read", Path: f.name, Err: os.ErrPermission}
+}
+
+func (f *remoteWriter) Write(p []byte) (int, error) {
+	n, err := f.pw.Write(p)
+	f.size += int64(n)
+	if err != nil {
+		return n, remoteError("write", f.name, err)
+	}
+	return n, nil
+}
+
+// Seek only reports the current offset, the upload can't go back
+func (f *remoteWriter) Seek(offset int64, whence int) (int64, error) {
+	if offset == 0 && whence == io.SeekCurrent {
+		return f.size, nil
+	}
+	return 0, &os.PathError{Op: "seek", Path: f.name, Err: ErrNotImplemented}
+}
+
+// abort makes the running PUT fail instead of completing on Close
+func (f *remoteWriter) abort() {
+	f.pw.CloseWithError(errUploadAborted)
+}
+
+// Close ends the upload and waits for the server's answer
+func (f *remoteWriter) Close() error {
+	if f.closed {
+		return f.err
+	}
+	f.closed = true
+
+	f.pw.Close()
+	f.err = remoteError("close", f.name, <-f.done)
+	return f.err
+}
@@ -0,0 +1,269 @@
+package webdav
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestRemoteFSLoop serves a RemoteFS pointing at a second Server over MemFS,
+// every request to the outer Server goes through the Client to the inner
+func TestRemoteFSLoop(t *testing.T) {
+	inner := NewMemFS()
+	innerBusy := &busyHandler{h: &davServer{Server: &Server{Fs: inner, TrimPrefix: "/"}}}
+	its := httptest.NewServer(innerBusy)
+	t.Cleanup(its.Close)
+	c, err := NewClient(its.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	outerBusy := &busyHandler{h: &Server{Fs: NewRemoteFS(c), TrimPrefix: "/", MaxUploadSize: 1 << 20}}
+	outer := httptest.NewServer(outerBusy)
+	t.Cleanup(outer.Close)
+
+	if err := inner.Mkdir("/dir"); err != nil {
+		t.Fatal(err)
+	}
+	resp, _ := request(t, outer, "PUT", "/dir/a.txt", "through both servers")
+	wantStatus(t, resp, StatusCreated)
+	if got := string(readAll(t, inner, "/dir/a.txt")); got != "through both servers" {
+		t.Fatalf("inner has %q", got)
+	}
+
+	resp, body := request(t, outer, "GET", "/dir/a.txt", "")
+	wantStatus(t, resp, StatusOK)
+	if body != "through both servers" {
+		t.Errorf("GET = %q", body)
+	}
+	resp, body = request(t, outer, "GET", "/dir/a.txt", "", "Range", "bytes=8-11")
+	wantStatus(t, resp, StatusPartialContent)
+	if body != "both" {
+		t.Errorf("ranged GET = %q", body)
+	}
+
+	resp, _ = request(t, outer, "PUT", "/dir/a.txt", "replaced")
+	wantStatus(t, resp, StatusNoContent)
+	resp, _ = request(t, outer, "COPY", "/dir/a.txt", "", "Destination", outer.URL+"/dir/b.txt")
+	wantStatus(t, resp, StatusCreated)
+	if got := string(readAll(t, inner, "/dir/b.txt")); got != "replaced" {
+		t.Errorf("inner copy has %q", got)
+	}
+
+	// an upload refused by the outer server never completes on the inner.
+	// The outer answers before it has put the previous file back, and the
+	// inner only learns of the abort from the dropped connection, so both
+	// are waited for.
+	resp = putChunked(t, outer.URL+"/dir/a.txt", strings.Repeat("x", 2<<20))
+	wantStatus(t, resp, StatusRequestTooLarge)
+	outerBusy.wait(t)
+	innerBusy.wait(t)
+	if got := string(readAll(t, inner, "/dir/a.txt")); got != "replaced" {
+		t.Errorf("inner has %q after a refused upload", got)
+	}
+
+	resp, _ = request(t, outer, "DELETE", "/dir/b.txt", "")
+	wantStatus(t, resp, StatusNoContent)
+	if _, err := inner.Open("/dir/b.txt"); !os.IsNotExist(err) {
+		t.Errorf("inner still has b.txt: %v", err)
+	}
+	resp, _ = request(t, outer, "GET", "/dir/missing", "")
+	wantStatus(t, resp, StatusNotFound)
+}
+
+// busyHandler counts the requests h is serving, so a test can wait for
+// the work a server does after it has answered
+type busyHandler struct {
+	h       http.Handler
+	running atomic.Int64
+}
+
+func (b *busyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	b.running.Add(1)
+	defer b.running.Add(-1)
+	b.h.ServeHTTP(w, r)
+}
+
+// wait returns once no request is being served
+func (b *busyHandler) wait(t *testing.T) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for b.running.Load() > 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("requests still running")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// getCounter counts the GET requests and the response bytes they got
+type getCounter struct {
+	h        http.Handler
+	ranges   bool // pass Range headers on
+	mu       sync.Mutex
+	requests int
+	bytes    int64
+}
+
+type getBodyWriter struct {
+	http.ResponseWriter
+	g *getCounter
+}
+
+func (w getBodyWriter) Write(p []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(p)
+	w.g.mu.Lock()
+	w.g.bytes += int64(n)
+	w.g.mu.Unlock()
+	return n, err
+}
+
+func (g *getCounter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		g.h.ServeHTTP(w, r)
+		return
+	}
+	g.mu.Lock()
+	g.requests++
+	g.mu.Unlock()
+	if !g.ranges {
+		r.Header.Del("Range")
+	}
+	g.h.ServeHTTP(getBodyWriter{w, g}, r)
+}
+
+// take returns the counts and resets them
+func (g *getCounter) take() (requests int, bytes int64) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	requests, bytes = g.requests, g.bytes
+	g.requests, g.bytes = 0, 0
+	return requests, bytes
+}
+
+func newRemoteFile(t *testing.T, content []byte, ranges bool) (*MemFS, *getCounter, File) {
+	t.Helper()
+	m := NewMemFS()
+	writeMem(t, m, "/obj", content)
+	g := &getCounter{h: &davServer{Server: &Server{Fs: m, TrimPrefix: "/"}}, ranges: ranges}
+	ts := httptest.NewServer(g)
+	t.Cleanup(ts.Close)
+	c, err := NewClient(ts.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+
+	f, err := NewRemoteFS(c).Open("/obj")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.Close() })
+	if n, _ := g.take(); n != 0 {
+		t.Fatalf("Open made %d GETs", n)
+	}
+	return m, g, f
+}
+
+func TestRemoteFileReadsSlice(t *testing.T) {
+	content := randomBytes(t, 10<<20)
+	_, g, f := newRemoteFile(t, content, true)
+
+	const off = 5<<20 + 123
+	if _, err := f.Seek(off, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	p := make([]byte, 4<<10)
+	if _, err := io.ReadFull(f, p); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(p, content[off:off+len(p)]) {
+		t.Error("read the wrong bytes")
+	}
+	requests, n := g.take()
+	if requests != 1 || n > int64(len(p))+remoteReadAhead {
+		t.Errorf("a 4 KB read took %d GETs transferring %d bytes", requests, n)
+	}
+
+	// the next slice comes from the read ahead
+	if _, err := io.ReadFull(f, p); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(p, content[off+len(p):off+2*len(p)]) {
+		t.Error("read the wrong bytes from the read ahead")
+	}
+	if requests, _ := g.take(); requests != 0 {
+		t.Errorf("a read within the read ahead made %d GETs", requests)
+	}
+
+	// ReadAt anywhere
+	for _, at := range []int64{0, 9<<20 + 7, int64(len(content)) - 100} {
+		q := make([]byte, 100)
+		if _, err := f.(io.ReaderAt).ReadAt(q, at); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(q, content[at:at+100]) {
+			t.Errorf("ReadAt(%d) read the wrong bytes", at)
+		}
+	}
+	if _, n := g.take(); n > 3*remoteReadAhead {
+		t.Errorf("three small ReadAts transferred %d bytes", n)
+	}
+}
+
+func TestRemoteFileReadsWhole(t *testing.T) {
+	content := randomBytes(t, 10<<20)
+	_, g, f := newRemoteFile(t, content, true)
+
+	b, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, content) {
+		t.Error("read the wrong bytes")
+	}
+	// the window doubles from 64 KB to 8 MB
+	requests, n := g.take()
+	if requests > 10 || n != int64(len(content)) {
+		t.Errorf("reading 10 MB took %d GETs transferring %d bytes", requests, n)
+	}
+}
+
+func TestRemoteFileWithoutRanges(t *testing.T) {
+	content := randomBytes(t, 1<<20)
+	_, _, f := newRemoteFile(t, content, false)
+
+	const off = 700 << 10
+	p := make([]byte, 4<<10)
+	if _, err := f.(io.ReaderAt).ReadAt(p, off); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(p, content[off:off+len(p)]) {
+		t.Error("read the wrong bytes from a server without ranges")
+	}
+}
+
+func TestRemoteFileDetectsChange(t *testing.T) {
+	content := randomBytes(t, 1<<20)
+	m, _, f := newRemoteFile(t, content, true)
+
+	p := make([]byte, 4<<10)
+	if _, err := io.ReadFull(f, p); err != nil {
+		t.Fatal(err)
+	}
+	writeMem(t, m, "/obj", randomBytes(t, 2<<20))
+	if _, err := f.Seek(900<<10, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadFull(f, p); !errors.Is(err, errChanged) {
+		t.Errorf("read after the resource changed = %v, want errChanged", err)
+	}
+}
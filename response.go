@@ -0,0 +1,181 @@
+package webdav
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+)
+
+// responseWriter records the status and size of a response for the log
+type responseWriter struct {
+	http.ResponseWriter
+	status  int
+	written int64
+	before  func() // called before the status is sent
+}
+
+// setStatus records the status of the response when it is first sent
+func (w *responseWriter) setStatus(code int) {
+	if w.status != 0 {
+		return
+	}
+	w.status = code
+	if w.before != nil {
+		w.before()
+	}
+}
+
+func (w *responseWriter) WriteHeader(code int) {
+	w.setStatus(code)
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *responseWriter) Write(p []byte) (int, error) {
+	w.setStatus(StatusOK)
+	n, err := w.ResponseWriter.Write(p)
+	w.written += int64(n)
+	return n, err
+}
+
+// Unwrap gives http.ResponseController the wrapped writer
+func (w *responseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// the optional interfaces of the wrapped writer, passed through
+type (
+	readerFrom struct{ w *responseWriter }
+	flusher    struct{ w *responseWriter }
+	hijacker   struct{ w *responseWriter }
+)
+
+// ReadFrom keeps the sendfile(2) path net/http takes for file bodies
+func (rf readerFrom) ReadFrom(r io.Reader) (int64, error) {
+	rf.w.setStatus(StatusOK)
+	n, err := rf.w.ResponseWriter.(io.ReaderFrom).ReadFrom(r)
+	rf.w.written += n
+	return n, err
+}
+
+func (f flusher) Flush() {
+	f.w.ResponseWriter.(http.Flusher).Flush()
+}
+
+func (h hijacker) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return h.w.ResponseWriter.(http.Hijacker).Hijack()
+}
+
+// DefaultDrainLimit is the largest unread request body that is discarded
+// before an answer, unless the Server sets its own
+const DefaultDrainLimit = 64 << 10
+
+// countingBody counts the bytes read from a request body
+type countingBody struct {
+	io.ReadCloser
+	n   int64
+	eof bool
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	atomic.AddInt64(&b.n, int64(n))
+	if err == io.EOF {
+		b.eof = true
+	}
+	return n, err
+}
+
+// countBody returns a shallow copy of r reading its body through a
+// countingBody
+func countBody(r *http.Request) (*http.Request, *countingBody) {
+	body := &countingBody{ReadCloser: r.Body}
+	if r.Body == nil {
+		body.ReadCloser = http.NoBody
+	}
+	r2 := *r
+	r2.Body = body
+	return &r2, body
+}
+
+// drainBody readies the connection for an answer sent before the request
+// body was read to the end, as every early error is. A client that asked
+// for 100 Continue and got none has sent nothing, net/http takes care of
+// it. A body of up to limit bytes is read and thrown away, so the
+// connection can be reused; for a larger one the answer says Connection:
+// close, which the client reads instead of a reset in the middle of its
+// upload.
+func drainBody(w http.ResponseWriter, r *http.Request, body *countingBody, limit int64) {
+	if body.eof || body.ReadCloser == http.NoBody || r.ContentLength == 0 {
+		return
+	}
+	read := atomic.LoadInt64(&body.n)
+	if read == 0 && strings.EqualFold(r.Header.Get("Expect"), "100-continue") {
+		return
+	}
+	if r.ContentLength > 0 && r.ContentLength-read > limit {
+		w.Header().Set("Connection", "close")
+		return
+	}
+	if n, _ := io.CopyN(io.Discard, body, limit+1); n > limit {
+		w.Header().Set("Connection", "close")
+	}
+}
+
+// wrapResponse wraps w in a responseWriter. The http.ResponseWriter it
+// returns implements exactly the optional interfaces w does, a wrapper
+// type per combination, so code checking for them behaves as without the
+// wrapper.
+func wrapResponse(w http.ResponseWriter) (*responseWriter, http.ResponseWriter) {
+	rw := &responseWriter{ResponseWriter: w}
+	_, isRF := w.(io.ReaderFrom)
+	_, isF := w.(http.Flusher)
+	_, isH := w.(http.Hijacker)
+	rf, f, h := readerFrom{rw}, flusher{rw}, hijacker{rw}
+
+	switch {
+	case isRF && isF && isH:
+		return rw, struct {
+			*responseWriter
+			readerFrom
+			flusher
+			hijacker
+		}{rw, rf, f, h}
+	case isRF && isF:
+		return rw, struct {
+			*responseWriter
+			readerFrom
+			flusher
+		}{rw, rf, f}
+	case isRF && isH:
+		return rw, struct {
+			*responseWriter
+			readerFrom
+			hijacker
+		}{rw, rf, h}
+	case isF && isH:
+		return rw, struct {
+			*responseWriter
+			flusher
+			hijacker
+		}{rw, f, h}
+	case isRF:
+		return rw, struct {
+			*responseWriter
+			readerFrom
+		}{rw, rf}
+	case isF:
+		return rw, struct {
+			*responseWriter
+			flusher
+		}{rw, f}
+	case isH:
+		return rw, struct {
+			*responseWriter
+			hijacker
+		}{rw, h}
+	}
+	return rw, rw
+}
@@ -0,0 +1,244 @@
+package webdav
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeWriter is a ResponseWriter with just the optional interfaces its
+// fields turn on
+type fakeWriter struct{ http.ResponseWriter }
+
+type (
+	fakeReaderFrom struct{}
+	fakeFlusher    struct{}
+	fakeHijacker   struct{}
+)
+
+func (fakeReaderFrom) ReadFrom(io.Reader) (int64, error) { return 0, nil }
+func (fakeFlusher) Flush()                               {}
+func (fakeHijacker) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return nil, nil, nil
+}
+
+func TestWrapResponse(t *testing.T) {
+	base := httptest.NewRecorder()
+	for _, tc := range []struct {
+		name      string
+		w         http.ResponseWriter
+		rf, f, hj bool
+	}{
+		{"none", struct{ http.ResponseWriter }{fakeWriter{base}}, false, false, false},
+		{"all", struct {
+			http.ResponseWriter
+			fakeReaderFrom
+			fakeFlusher
+			fakeHijacker
+		}{fakeWriter{base}, fakeReaderFrom{}, fakeFlusher{}, fakeHijacker{}}, true, true, true},
+		{"readerfrom", struct {
+			http.ResponseWriter
+			fakeReaderFrom
+		}{fakeWriter{base}, fakeReaderFrom{}}, true, false, false},
+		{"flusher hijacker", struct {
+			http.ResponseWriter
+			fakeFlusher
+			fakeHijacker
+		}{fakeWriter{base}, fakeFlusher{}, fakeHijacker{}}, false, true, true},
+		{"readerfrom flusher", struct {
+			http.ResponseWriter
+			fakeReaderFrom
+			fakeFlusher
+		}{fakeWriter{base}, fakeReaderFrom{}, fakeFlusher{}}, true, true, false},
+	} {
+		_, w := wrapResponse(tc.w)
+		_, rf := w.(io.ReaderFrom)
+		_, f := w.(http.Flusher)
+		_, hj := w.(http.Hijacker)
+		if rf != tc.rf || f != tc.f || hj != tc.hj {
+			t.Errorf("%s: wrapper is ReaderFrom %v, Flusher %v, Hijacker %v", tc.name, rf, f, hj)
+		}
+	}
+}
+
+// readFromCounter counts the bytes the ResponseWriter beneath a Server is
+// given through ReadFrom
+type readFromCounter struct {
+	h http.Handler
+	n atomic.Int64
+}
+
+type countingReaderFrom struct {
+	http.ResponseWriter
+	c *readFromCounter
+}
+
+func (w countingReaderFrom) ReadFrom(r io.Reader) (int64, error) {
+	n, err := w.ResponseWriter.(io.ReaderFrom).ReadFrom(r)
+	w.c.n.Add(n)
+	return n, err
+}
+
+func (c *readFromCounter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.h.ServeHTTP(countingReaderFrom{w, c}, r)
+}
+
+// sparseFile creates a sparse file of size bytes in dir
+func sparseFile(t testing.TB, dir string, size int64) {
+	f, err := os.Create(filepath.Join(dir, "sparse"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := f.Truncate(size); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// getSparse GETs the sparse file and returns the bytes sent through ReadFrom
+func getSparse(t testing.TB, ts *httptest.Server, c *readFromCounter, size int64) int64 {
+	resp, err := http.Get(ts.URL + "/sparse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if err != nil || n != size {
+		t.Fatalf("GET read %d bytes, %v, want %d", n, err, size)
+	}
+	return c.n.Swap(0)
+}
+
+func TestGetKeepsReaderFrom(t *testing.T) {
+	root := t.TempDir()
+	const size = 64 << 20
+	sparseFile(t, root, size)
+	c := &readFromCounter{h: &Server{Fs: Dir(root), TrimPrefix: "/"}}
+	ts := httptest.NewServer(c)
+	defer ts.Close()
+
+	if n := getSparse(t, ts, c, size); n != size {
+		t.Errorf("%d of %d bytes sent through ReadFrom", n, size)
+	}
+}
+
+// BenchmarkGetSparse serves a sparse file of 4 GiB from a Dir through the
+// logging ResponseWriter, which has to leave the sendfile(2) path of
+// net/http in place
+func BenchmarkGetSparse(b *testing.B) {
+	root := b.TempDir()
+	const size = 4 << 30
+	sparseFile(b, root, size)
+	c := &readFromCounter{h: &Server{Fs: Dir(root), TrimPrefix: "/"}}
+	ts := httptest.NewServer(c)
+	defer ts.Close()
+
+	b.SetBytes(size)
+	for i := 0; i < b.N; i++ {
+		if n := getSparse(b, ts, c, size); n != size {
+			b.Fatalf("%d of %d bytes sent through ReadFrom", n, size)
+		}
+	}
+}
+
+// TestEarlyErrorsDrainBody sends bodies to requests that are refused
+// before they are read: the client gets the status, not a reset, and a
+// small body leaves the connection usable
+func TestEarlyErrorsDrainBody(t *testing.T) {
+	m := NewMemFS()
+	m.Mkdir("/dir")
+	writeMem(t, m, "/f.txt", []byte("x"))
+
+	const large = 64 << 20
+	for _, tc := range []struct {
+		name         string
+		s            *Server
+		method, path string
+		size         int
+		expect       bool
+		status       int
+		keepAlive    bool
+	}{
+		{"read-only, small", &Server{ReadOnly: true}, "PUT", "/f.txt", 1 << 10, false, StatusForbidden, true},
+		{"read-only, large", &Server{ReadOnly: true}, "PUT", "/f.txt", large, false, StatusForbidden, false},
+		{"read-only, 100-continue", &Server{ReadOnly: true}, "PUT", "/f.txt", large, true, StatusForbidden, false},
+		{"drain limit", &Server{ReadOnly: true, DrainLimit: 1 << 20}, "PUT", "/f.txt", 512 << 10, false, StatusForbidden, true},
+		{"PUT onto a collection", &Server{}, "PUT", "/dir", large, false, StatusMethodNotAllowed, false},
+		{"unknown method", &Server{}, "PROPPATCH", "/f.txt", 1 << 10, false, StatusMethodNotAllowed, true},
+		{"too large", &Server{MaxUploadSize: 1 << 20}, "PUT", "/new", large, false, StatusRequestTooLarge, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.s.Fs = m
+			ts := newTestServer(t, tc.s)
+			conn, err := net.Dial("tcp", ts.Listener.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+			head := fmt.Sprintf("%s %s HTTP/1.1\r\nHost: x\r\nContent-Length: %d\r\n", tc.method, tc.path, tc.size)
+			if tc.expect {
+				head += "Expect: 100-continue\r\n"
+			}
+			if _, err := io.WriteString(conn, head+"\r\n"); err != nil {
+				t.Fatal(err)
+			}
+			// the body is sent meanwhile, until the server hangs up
+			sent := make(chan int64, 1)
+			go func() {
+				if tc.expect {
+					sent <- 0
+					return
+				}
+				n, _ := io.Copy(conn, io.LimitReader(zeros{}, int64(tc.size)))
+				sent <- n
+			}()
+
+			br := bufio.NewReader(conn)
+			resp, err := http.ReadResponse(br, nil)
+			if err != nil {
+				t.Fatalf("no answer, the client would see: %v", err)
+			}
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+			if resp.StatusCode != tc.status {
+				t.Fatalf("status %d, want %d", resp.StatusCode, tc.status)
+			}
+			if resp.Close == tc.keepAlive {
+				t.Errorf("Connection: close is %v, want %v", resp.Close, !tc.keepAlive)
+			}
+			if !tc.keepAlive {
+				return
+			}
+			if n := <-sent; n != int64(tc.size) {
+				t.Fatalf("sent %d bytes of %d", n, tc.size)
+			}
+			io.WriteString(conn, "GET /f.txt HTTP/1.1\r\nHost: x\r\n\r\n")
+			resp, err = http.ReadResponse(br, nil)
+			if err != nil {
+				t.Fatalf("connection not reusable: %v", err)
+			}
+			b, _ := io.ReadAll(resp.Body)
+			if resp.StatusCode != StatusOK || string(b) != "x" {
+				t.Errorf("next request: %d %q", resp.StatusCode, b)
+			}
+		})
+	}
+}
+
+// zeros reads as an endless run of zero bytes
+type zeros struct{}
+
+func (zeros) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
@@ -1,14 +1,26 @@
 package webdav
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"hash"
 	"io"
+	"io/fs"
 	"math/rand"
 	"net/http"
 	"net/url"
+	"os"
 	"path"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
+	"unicode/utf8"
 
 	"github.com/golang/glog"
 )
@@ -42,8 +54,154 @@ type Server struct {
 	// generate directory listings?
 	Listings bool
 
+	// answer GET of a directory with ?accept=zip, or Accept:
+	// application/zip, with a zip archive of it, leaving out dot files.
+	// Larger directories than the limits, zero for DefaultZipMaxEntries
+	// and DefaultZipMaxBytes, are refused with 413.
+	ZipDownloads  bool
+	ZipMaxEntries int
+	ZipMaxBytes   int64
+
+	// treat backslashes in request paths as separators, as some Windows
+	// clients send them. Otherwise a path with a backslash is refused
+	// with 400 Bad Request, on every platform.
+	BackslashSeparators bool
+
+	// answer LOCK and UNLOCK, and advertise DAV class 2, without locking
+	// anything. Every LOCK is granted with a new token and nothing is
+	// enforced, it only lets clients that refuse to write to a server
+	// without locks, like Microsoft Office and the macOS Finder, save
+	// files. The Server has no real locking this would conflict with.
+	FakeLocks bool
+
+	// flush PUT bodies to stable storage before answering, for files with
+	// a Sync method like *os.File
+	SyncOnPut bool
+
+	// size of the pooled buffers PUT and COPY copy with, zero for
+	// DefaultCopyBufferSize. Larger buffers help links with a high
+	// bandwidth-delay product.
+	CopyBufferSize int
+
+	// set the modification time of a PUT from its X-OC-MTime header, Unix
+	// seconds as ownCloud and Nextcloud clients and rclone send, where Fs
+	// is a Chtimer. The response carries X-OC-MTime: accepted then.
+	OCMTime bool
+
+	// slowest PUT body and GET response accepted, in bytes per second, zero
+	// for no limit. A transfer that stays slower for MinRateGrace, zero for
+	// DefaultMinRateGrace, is cut off: an upload with 408 Request Timeout
+	// and nothing stored. Downloads held to a rate don't use sendfile.
+	MinUploadRate   int64
+	MinDownloadRate int64
+	MinRateGrace    time.Duration
+
+	// largest PUT body accepted, zero for no limit. Larger bodies are
+	// answered with 413 Request Entity Too Large.
+	MaxUploadSize int64
+
+	// serve the changes below every directory at <directory>/.changes,
+	// as JSON or server-sent events. The feed URL lies inside the
+	// directory, so access control on the directory's path covers it.
+	ChangeFeed bool
+
+	// called with the Usage of every request once it is done, for
+	// per-user traffic and storage accounting, see Ledger
+	Accounting func(Usage)
+
+	// returns who made a request for Accounting, nil for the user name
+	// of basic auth. Authentication itself is left to a wrapping handler.
+	Principal func(*http.Request) string
+
+	// answer the sync-collection REPORT of RFC 6578 on directories, so
+	// clients fetch only what changed since their last sync. The changes
+	// are those of the change feed, see ChangeFeed.
+	SyncCollection bool
+
+	// serve the Capabilities of the Server as JSON at /.capabilities,
+	// for JavaScript clients. The path is inside the root, so access
+	// control on the root covers it, and a file of that name is hidden.
+	ServeCapabilities bool
+
+	// largest unread request body discarded before an early answer, so
+	// the connection can be reused, zero for DefaultDrainLimit. A larger
+	// body gets the answer with Connection: close.
+	DrainLimit int64
+
+	// content types by file extension, like ".md": "text/markdown",
+	// consulted before the standard library's table without changing it.
+	// Extensions match case-insensitively, "" is for files without one.
+	MimeTypes map[string]string
+
+	// accept a list of members to delete in the body of a POST, or of a
+	// DELETE, to a collection, answered with a 207 of their statuses
+	BatchDelete bool
+
+	// called with every member of a batch delete, a path like /dir/a.txt,
+	// which is refused with 403 when it returns false. Nil allows every
+	// member of the collection, as access control on its path covers them.
+	BatchAuthorize func(r *http.Request, name string) bool
+
+	// answer the owner, group and unix-mode properties of UnixNamespace
+	// when a REPORT asks for them by name, from an OwnerFS. They are never
+	// sent unasked.
+	UnixProperties bool
+
+	// serve the trash of a Trasher, like TrashFS, as the collection
+	// /.trash: PROPFIND lists it, MOVE of an entry restores it to the
+	// Destination and DELETE of one purges it. The path is inside the
+	// root, so access control on the root covers it.
+	ServeTrash bool
+
+	// most responses in the multistatus of a PROPFIND of the trash or a
+	// sync-collection REPORT, zero for DefaultMaxPropfindChildren. A
+	// larger one isn't built, it is answered with 507 and the
+	// DAV:number-of-matches-within-limits precondition, as is a REPORT
+	// over the client's own DAV:limit.
+	MaxPropfindChildren int
+
 	// access to a collection of named files
 	Fs FileSystem
+
+	events notifier
+	feed   feed
+
+	watchMu sync.Mutex
+	watches map[*byte]func() // the stop functions of running watches
+
+	doneOnce  sync.Once
+	doneCh    chan struct{}
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// errServerClosed is returned for what is asked of a Server after Close
+var errServerClosed = errors.New("webdav: server closed")
+
+// done returns a channel Close closes, long-running handlers return on it
+func (s *Server) done() <-chan struct{} {
+	s.doneOnce.Do(func() { s.doneCh = make(chan struct{}) })
+	return s.doneCh
+}
+
+// Close releases the resources held by the Server: it stops the change
+// feed and the watches of Server.Watch, ends running event streams, and
+// closes Fs if it implements FileSystemCloser. Requests that come later are
+// answered with 503. Calling Close more than once, or from several
+// goroutines, is safe, every call returns the result of the first one once
+// it is done.
+func (s *Server) Close() error {
+	s.closeOnce.Do(func() {
+		s.done()
+		close(s.doneCh)
+		s.feed.close()
+		s.stopWatches()
+		s.events.close()
+		if c, ok := s.Fs.(FileSystemCloser); ok {
+			s.closeErr = c.Close()
+		}
+	})
+	return s.closeErr
 }
 
 func generateToken() string {
@@ -64,6 +222,54 @@ func NewServer(dir, prefix string, listDir bool) *Server {
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	// XXX disable this in production
 	glog.Infoln("DAV:", r.RemoteAddr, r.Method, r.URL)
+	rw, w := wrapResponse(w)
+	defer func() {
+		glog.Infoln("DAV:", r.RemoteAddr, r.Method, r.URL, "status", rw.status, "bytes", rw.written)
+	}()
+
+	select {
+	case <-s.done():
+		http.Error(w, "server closed", StatusServiceUnavailable)
+		return
+	default:
+	}
+
+	// the body is counted for Accounting, and what the handler leaves of
+	// it is dealt with before the answer
+	r, body := countBody(r)
+	rw.before = func() {
+		limit := s.DrainLimit
+		if limit <= 0 {
+			limit = DefaultDrainLimit
+		}
+		drainBody(w, r, body, limit)
+	}
+
+	if p, ok := s.requestPath(r.URL); !ok {
+		glog.Infoln("DAV:", "refused path", r.URL)
+		http.Error(w, "path not allowed", StatusBadRequest)
+		return
+	} else if p != r.URL.Path {
+		r = r.Clone(r.Context())
+		r.URL.Path, r.URL.RawPath = p, ""
+	}
+
+	if s.Accounting != nil {
+		u := &Usage{Principal: s.principal(r), Op: r.Method, Path: r.URL.Path}
+		r = withUsage(r, u)
+		defer func() {
+			u.Status, u.BytesIn, u.BytesOut = rw.status, atomic.LoadInt64(&body.n), rw.written
+			if u.Status == 0 {
+				u.Status = StatusOK
+			}
+			s.Accounting(*u)
+		}()
+	}
+
+	if t, id, ok := s.trashRequest(r); ok {
+		s.serveTrash(w, r, t, id)
+		return
+	}
 
 	switch r.Method {
 	case "GET":
@@ -72,20 +278,139 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		s.doHead(w, r)
 	case "DELETE":
 		s.doDelete(w, r)
+	case "POST":
+		if s.BatchDelete {
+			s.doBatchDelete(w, r)
+		} else {
+			fi, _ := s.stat(s.url2path(r.URL))
+			s.methodNotAllowed(w, fi)
+		}
 	case "PUT":
 		s.doPut(w, r)
+	case "COPY":
+		s.doCopy(w, r)
+	case "OPTIONS":
+		s.doOptions(w, r)
+	case "REPORT":
+		s.doReport(w, r)
+	case "LOCK", "UNLOCK":
+		if !s.FakeLocks {
+			fi, _ := s.stat(s.url2path(r.URL))
+			s.methodNotAllowed(w, fi)
+		} else if r.Method == "LOCK" {
+			s.doFakeLock(w, r)
+		} else {
+			s.doFakeUnlock(w, r)
+		}
 
 	default:
 		glog.Infoln("DAV:", "unknown method", r.Method)
-		w.WriteHeader(StatusBadRequest)
+		fi, _ := s.stat(s.url2path(r.URL))
+		s.methodNotAllowed(w, fi)
+	}
+}
+
+// allow returns the methods valid on a resource for its Allow header, fi
+// is the resource or nil if it doesn't exist. OPTIONS and every 405 answer
+// use it, so they agree.
+func (s *Server) allow(fi os.FileInfo) string {
+	methods := []string{"OPTIONS"}
+	switch {
+	case fi == nil:
+		if !s.ReadOnly {
+			methods = append(methods, "PUT")
+			if s.FakeLocks {
+				methods = append(methods, "LOCK", "UNLOCK")
+			}
+		}
+		return strings.Join(methods, ", ")
+	case fi.IsDir():
+		if s.Listings {
+			methods = append(methods, "GET", "HEAD")
+		}
+		if s.SyncCollection {
+			methods = append(methods, "REPORT")
+		}
+		if s.BatchDelete && !s.ReadOnly && !s.DeletesDisabled {
+			methods = append(methods, "POST")
+		}
+	default:
+		methods = append(methods, "GET", "HEAD")
+		if !s.ReadOnly {
+			methods = append(methods, "PUT", "COPY")
+		}
+	}
+	if !s.ReadOnly && !s.DeletesDisabled {
+		methods = append(methods, "DELETE")
+	}
+	if !s.ReadOnly && s.FakeLocks {
+		methods = append(methods, "LOCK", "UNLOCK")
+	}
+	return strings.Join(methods, ", ")
+}
+
+// methodNotAllowed answers 405 with the methods that are allowed
+func (s *Server) methodNotAllowed(w http.ResponseWriter, fi os.FileInfo) {
+	w.Header().Set("Allow", s.allow(fi))
+	w.WriteHeader(StatusMethodNotAllowed)
+}
+
+// http://www.webdav.org/specs/rfc4918.html#HEADER_Allow
+func (s *Server) doOptions(w http.ResponseWriter, r *http.Request) {
+	fi, err := s.stat(s.url2path(r.URL))
+	if err != nil {
+		fi = nil
+	}
+	w.Header().Set("Allow", s.allow(fi))
+	if classes := s.davClasses(); len(classes) > 0 {
+		w.Header().Set("DAV", strings.Join(classes, ", "))
+		// Office only tries WebDAV with this
+		w.Header().Set("MS-Author-Via", "DAV")
+	}
+	w.WriteHeader(StatusOK)
+}
+
+// requestPath returns the path of a request or Destination URL, it reports
+// false for a path to refuse. The same rules hold for both, so clients that
+// encode names differently reach the same file:
+//
+//   - the path is decoded exactly once, by net/url: %2525 names a file
+//     with %25 in its name
+//   - + is a plus, never a space, spaces are %20 or raw
+//   - raw UTF-8 is taken as it is, bytes that aren't UTF-8 are refused
+//   - %2F is refused, it would name a file in another directory than the
+//     path shows, and so is a NUL
+//   - backslashes are as slashes says
+func (s *Server) requestPath(u *url.URL) (string, bool) {
+	if strings.Contains(strings.ToLower(u.RawPath), "%2f") {
+		return "", false
+	}
+	if strings.IndexByte(u.Path, 0) >= 0 || !utf8.ValidString(u.Path) {
+		return "", false
+	}
+	return s.slashes(u.Path)
+}
+
+// slashes turns the backslashes of a request path into slashes if
+// BackslashSeparators is set, it reports false for a path to refuse
+func (s *Server) slashes(p string) (string, bool) {
+	if !strings.Contains(p, `\`) {
+		return p, true
+	}
+	if !s.BackslashSeparators {
+		return "", false
 	}
+	return strings.ReplaceAll(p, `\`, "/"), true
 }
 
-// convert request url to path
+// convert request url to path, an empty TrimPrefix serves from the root
 func (s *Server) url2path(u *url.URL) string {
 	if u.Path == "" {
 		return "/"
 	}
+	if s.TrimPrefix == "" {
+		return strings.Trim(u.Path, "/")
+	}
 
 	if p := strings.TrimPrefix(u.Path, s.TrimPrefix); len(p) < len(u.Path) {
 		return strings.Trim(p, "/")
@@ -94,41 +419,41 @@ func (s *Server) url2path(u *url.URL) string {
 	return "/"
 }
 
-// TODO: this is really silly
-func (s *Server) pathExists(path string) bool {
-	f, err := s.Fs.Open(path)
+// stat returns the FileInfo of name with a single Open, Stat and Close,
+// handlers resolve the state of a resource once and pass it on
+func (s *Server) stat(name string) (os.FileInfo, error) {
+	f, err := s.Fs.Open(name)
 	if err != nil {
-		// TODO: error logging?
-		return false
+		return nil, err
 	}
-	f.Close()
-
-	return true
+	defer f.Close()
+	return f.Stat()
 }
 
-// TODO: this is also pretty silly
-func (s *Server) pathIsDirectory(path string) bool {
-	f, err := s.Fs.Open(path)
-	if err != nil {
-		// TODO: error logging?
-		return false
-	}
-
-	fi, err := f.Stat()
-	if err != nil {
-		// TODO: error logging?
-		f.Close()
-		return false
+// etag returns the quoted entity tag of the file name, fi being its
+// FileInfo: the FileSystem's if it is an ETagger, else one of the size and
+// modification time, which every FileSystem has
+func (s *Server) etag(name string, fi os.FileInfo) string {
+	if et, ok := capability[ETagger](s.Fs); ok {
+		if tag, err := et.ETag(name); err == nil {
+			return `"` + tag + `"`
+		}
 	}
-
-	x := fi.IsDir()
-	f.Close()
-	return x
+	return fmt.Sprintf(`"%x-%x"`, fi.ModTime().UnixNano(), fi.Size())
 }
 
 // http://www.webdav.org/specs/rfc4918.html#rfc.section.9.4
 func (s *Server) doGet(w http.ResponseWriter, r *http.Request) {
 	glog.Infoln("DAV", "GET", r.RequestURI)
+	name := s.url2path(r.URL)
+	if s.ChangeFeed && path.Base(name) == changesName {
+		s.serveChanges(w, r, path.Dir(name))
+		return
+	}
+	if s.ServeCapabilities && name == capabilitiesName {
+		s.serveCapabilities(w, r)
+		return
+	}
 	s.serveResource(w, r, true)
 }
 
@@ -150,8 +475,6 @@ func (s *Server) serveResource(w http.ResponseWriter, r *http.Request, serveCont
 	}
 	defer f.Close()
 
-	// TODO: what if path is collection?
-
 	fi, err := f.Stat()
 	if err != nil {
 		// TODO: log locally also, configurably
@@ -159,14 +482,44 @@ func (s *Server) serveResource(w http.ResponseWriter, r *http.Request, serveCont
 		http.Error(w, r.RequestURI, StatusNotFound)
 		return
 	}
+
+	if fi.IsDir() {
+		if s.ZipDownloads && wantsZip(r) {
+			s.serveZip(w, r, path)
+			return
+		}
+		if !s.Listings {
+			glog.Infoln("DAV:", "listing disabled", r.RequestURI)
+			s.methodNotAllowed(w, fi)
+			return
+		}
+		s.serveListing(w, r, f, fi)
+		return
+	}
 	modTime := fi.ModTime()
 
-	if serveContent {
-		http.ServeContent(w, r, path, modTime, f)
-	} else {
-		// TODO: better way to send only head
-		http.ServeContent(w, r, path, modTime, emptyFile{})
+	w.Header().Set("ETag", s.etag(path, fi))
+	if t, ok := s.overrideType(path); ok {
+		// ServeContent keeps a type that is set
+		w.Header().Set("Content-Type", t)
+	}
+	// hashing a large file first would hold up the answer
+	if cc, ok := capability[ChecksumCache](s.Fs); ok {
+		if sum, ok := cc.CachedChecksum(path, "SHA-256"); ok {
+			w.Header().Set("X-Checksum-SHA256", sum)
+		}
+	}
+
+	if serveContent && s.MinDownloadRate > 0 {
+		rc := http.NewResponseController(w)
+		if floor := newRateFloor(rc.SetWriteDeadline, s.MinDownloadRate, s.MinRateGrace); floor != nil {
+			defer floor.stop()
+			w = slowWriter{w, floor}
+		}
 	}
+	// ServeContent sends no body for HEAD, but the Content-Length and
+	// Content-Type of the file, as GET does
+	http.ServeContent(w, r, path, modTime, f)
 }
 
 // http://www.webdav.org/specs/rfc4918.html#METHOD_DELETE
@@ -183,37 +536,84 @@ func (s *Server) doDelete(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if s.deleteResource(s.url2path(r.URL), w, r, true) {
-		glog.Infoln("DAV:", "DELETE successful", r.URL)
-	} else {
-		glog.Infoln("DAV:", "DELETE unsuccessful", r.URL)
+	if s.BatchDelete && hasBody(r) {
+		s.doBatchDelete(w, r)
+		return
 	}
 
+	name := s.url2path(r.URL)
+	if status := s.deleteResource(r, name, r.Header); status != StatusNoContent {
+		glog.Infoln("DAV:", "DELETE unsuccessful", r.URL, "status", status)
+		w.WriteHeader(status)
+		return
+	}
+	glog.Infoln("DAV:", "DELETE successful", r.URL)
+	w.WriteHeader(StatusNoContent)
 }
 
-// TODO(rbastic): audit this code
-func (s *Server) deleteResource(path string, w http.ResponseWriter, r *http.Request, setStatus bool) bool {
+// deleteResource removes name for r, h holding the preconditions, and
+// returns the status of the outcome, 204 on success. Collections are
+// refused with 403, there is no recursive delete.
+func (s *Server) deleteResource(r *http.Request, name string, h http.Header) int {
+	fi, err := s.stat(name)
+	if err != nil {
+		return StatusNotFound
+	}
+	if status := s.preconditions(h, name, fi); status != 0 {
+		glog.Infoln("DAV:", "DELETE precondition failed", name)
+		return status
+	}
+	if fi.IsDir() {
+		glog.Infoln("DAV:", "DELETE of a collection refused", name)
+		return StatusForbidden
+	}
 
-	if !s.pathExists(path) {
-		glog.Infoln("404", r.RequestURI)
-		w.WriteHeader(StatusNotFound)
-		return false
+	if err := s.Fs.Remove(name); err != nil {
+		glog.Infoln("DAV:", "DELETE error removing", name, "error", err)
+		return StatusInternalServerError
 	}
+	s.publish(OpRemove, name)
+	s.stored(r, -fi.Size())
+	return StatusNoContent
+}
 
-	if !s.pathIsDirectory(path) {
-		if err := s.Fs.Remove(path); err != nil {
-			// TODO: log locally
-			w.WriteHeader(StatusInternalServerError)
-			return false
-		}
-	} else {
-		// XXX: Deleting entire paths is completely disabled.
+// preconditions evaluates the If-Match, If-None-Match and If headers h of
+// a request changing name, fi being its current state or nil if it doesn't
+// exist. It returns 0 if the request may go ahead, else the status to
+// answer.
+//
+// Tags are compared strongly against the ETag GET sends, see etag, RFC
+// 9110 section 13.1.
+func (s *Server) preconditions(h http.Header, name string, fi os.FileInfo) int {
+	current := ""
+	if fi != nil && !fi.IsDir() {
+		current = s.etag(name, fi)
+	}
+	if m := h.Get("If-Match"); m != "" && !matchETag(m, fi != nil, current) {
+		return StatusPreconditionFailed
+	}
+	if m := h.Get("If-None-Match"); m != "" && matchETag(m, fi != nil, current) {
+		return StatusPreconditionFailed
 	}
+	return s.checkIf(h.Get("If"), name, fi)
+}
 
-	if setStatus {
-		w.WriteHeader(StatusNoContent)
+// matchETag reports whether a list of entity tags in an If-Match or
+// If-None-Match header matches a resource, exists telling if there is one
+// and current being its quoted ETag or empty. Weak tags never match.
+func matchETag(list string, exists bool, current string) bool {
+	if strings.TrimSpace(list) == "*" {
+		return exists
 	}
-	return true
+	if current == "" {
+		return false
+	}
+	for _, tag := range strings.Split(list, ",") {
+		if strings.TrimSpace(tag) == current {
+			return true
+		}
+	}
+	return false
 }
 
 func (s *Server) doPut(w http.ResponseWriter, r *http.Request) {
@@ -224,48 +624,620 @@ func (s *Server) doPut(w http.ResponseWriter, r *http.Request) {
 	}
 	myPath := s.url2path(r.URL)
 
-	/*
-		 * TODO: do something about this.
-		if s.pathIsDirectory(myPath) {
-			// use MKCOL instead
-			glog.Infoln("DAV:", "use mkcol instead perhaps, path", myPath)
-			w.WriteHeader(StatusMethodNotAllowed)
+	if s.MaxUploadSize > 0 {
+		if r.ContentLength > s.MaxUploadSize {
+			glog.Infoln("DAV:", "PUT too large", myPath, "size", r.ContentLength)
+			w.WriteHeader(StatusRequestTooLarge)
 			return
 		}
-	*/
+		// chunked bodies are cut off once they pass the limit
+		r.Body = http.MaxBytesReader(w, r.Body, s.MaxUploadSize)
+	}
 
-	// TODO: only Mkdir() if path.Dir() doesn't exist
-	err := s.Fs.Mkdir(path.Dir(myPath))
+	fi, err := s.stat(myPath)
+	if err == nil && fi.IsDir() {
+		// use MKCOL instead
+		glog.Infoln("DAV:", "PUT onto a collection", myPath)
+		s.methodNotAllowed(w, fi)
+		return
+	}
 	if err != nil {
-		glog.Infoln("DAV:", "PUT error %+v making directory %+v  ", err, path.Dir(myPath))
+		fi = nil
 	}
 
-	// truncate file if it exists already ???
-	exists := s.pathExists(myPath)
+	// If-None-Match: * only creates, If-Match: * only replaces. They are
+	// answered before the body is read, so a client waiting for 100
+	// Continue sends none of it.
+	if status := s.preconditions(r.Header, myPath, fi); status != 0 {
+		glog.Infoln("DAV:", "PUT precondition failed", myPath)
+		w.WriteHeader(status)
+		return
+	}
+	createOnly := r.Header.Get("If-None-Match") == "*"
 
-	file, err := s.Fs.Create(myPath)
+	if fi == nil {
+		// only a new file can be missing its parent
+		if err := s.Fs.Mkdir(path.Dir(myPath)); err != nil {
+			glog.Infoln("DAV:", "PUT error making directory", path.Dir(myPath), "error", err)
+		}
+	}
+
+	var file *pendingFile
+	if createOnly {
+		file, err = s.createExclusive(myPath)
+		if errors.Is(err, fs.ErrExist) {
+			glog.Infoln("DAV:", "PUT precondition failed, created meanwhile", myPath)
+			w.WriteHeader(StatusPreconditionFailed)
+			return
+		}
+	}
+	if !createOnly || err == ErrNotImplemented {
+		file, err = s.createPending(myPath, fi)
+	}
 	if err != nil {
 		// TODO: having stupid problems?
 		glog.Infoln("DAV:", "PUT error with create path", myPath, "error", err)
 		w.WriteHeader(StatusConflict)
 		return
 	}
+	// a PUT that doesn't reach commit leaves nothing behind
+	defer file.abort()
+	file.sync = s.SyncOnPut
+
+	if r.ContentLength > 0 {
+		if err := file.preallocate(r.ContentLength); err != nil {
+			glog.Infoln("DAV:", "PUT no space for", myPath, "size", r.ContentLength, "error", err)
+			w.WriteHeader(StatusInsufficientStorage)
+			return
+		}
+	}
+
+	// the checksum of the upload is computed on the way through, for a
+	// FileSystem that has them
+	var body io.Reader = ctxReader{r.Context(), r.Body}
+	var sum hash.Hash
+	if supports[ChecksumFS](s.Fs) {
+		sum = sha256.New()
+		body = io.TeeReader(body, sum)
+	}
 
-	// XXX: investigate how io.Copy() is implemented, is it thread-safe or do
-	// we need to change this implementation to work more like how nginx's does,
-	// using temporary filenames and then atomic rename's ?
+	if s.MinUploadRate > 0 {
+		rc := http.NewResponseController(w)
+		if floor := newRateFloor(rc.SetReadDeadline, s.MinUploadRate, s.MinRateGrace); floor != nil {
+			defer floor.stop()
+			body = slowReader{body, floor}
+		}
+	}
 
-	if _, err := io.Copy(file, r.Body); err != nil {
-		glog.Infoln("DAV:", "PUT error with ioCopy", file, "error", err)
+	n, err := s.copy(file, body)
+	cut := err == io.ErrUnexpectedEOF
+	if cut {
+		// net/http's report of a body shorter than declared, or of a
+		// chunked one that ended before its last chunk, see below
+		err = nil
+	}
+	if err != nil {
+		// a failed read cancels the request context, so this comes first
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			glog.Infoln("DAV:", "PUT too slow", myPath, "received", n, "bytes, minimum rate", s.MinUploadRate)
+			w.Header().Set("Connection", "close")
+			w.WriteHeader(StatusRequestTimeout)
+			return
+		}
+		if r.Context().Err() != nil {
+			// nobody is left to read a status
+			glog.Infoln("DAV:", "PUT aborted, client disconnected", myPath, "error", err)
+			return
+		}
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			glog.Infoln("DAV:", "PUT too large", myPath, "limit", tooLarge.Limit)
+			w.WriteHeader(StatusRequestTooLarge)
+			return
+		}
+		glog.Infoln("DAV:", "PUT error with ioCopy", myPath, "error", err)
 		w.WriteHeader(StatusConflict)
+		return
+	}
+
+	// a body that ends early is an interrupted upload, not a smaller file,
+	// and is thrown away; chunked bodies have no length to check, only
+	// their last chunk
+	if r.ContentLength >= 0 && n < r.ContentLength {
+		glog.Infoln("DAV:", "PUT body incomplete", myPath, "received", n, "of", r.ContentLength)
+		http.Error(w, fmt.Sprintf("request body ended after %d of %d bytes", n, r.ContentLength), StatusBadRequest)
+		return
+	}
+	if cut {
+		glog.Infoln("DAV:", "PUT chunked body incomplete", myPath, "received", n)
+		http.Error(w, fmt.Sprintf("request body ended after %d bytes, before its last chunk", n), StatusBadRequest)
+		return
+	}
+
+	// only a file that was closed, and synced if asked, is reported as
+	// stored: network filesystems report write errors that late
+	if err := file.commit(); err != nil {
+		glog.Infoln("DAV:", "PUT error committing", myPath, "error", err)
+		w.WriteHeader(storageErrorStatus(err))
+		return
+	}
+
+	if s.OCMTime && r.Header.Get("X-OC-MTime") != "" {
+		s.setMTime(w, myPath, r.Header.Get("X-OC-MTime"))
+	}
+	if sum != nil {
+		hexSum := hex.EncodeToString(sum.Sum(nil))
+		w.Header().Set("X-Checksum-SHA256", hexSum)
+		if cc, ok := capability[ChecksumCache](s.Fs); ok {
+			cc.CacheChecksum(myPath, "SHA-256", hexSum, n)
+		}
+	}
+	if fi != nil {
+		n -= fi.Size()
+	}
+	s.stored(r, n)
+	if file.existed {
+		s.publish(OpWrite, myPath)
+		glog.Infoln("DAV:", "PUT status-no-content", myPath)
+		w.WriteHeader(StatusNoContent)
 	} else {
-		if exists {
-			glog.Infoln("DAV:", "PUT status-no-content", file, "error", err)
-			w.WriteHeader(StatusNoContent)
+		s.publish(OpCreate, myPath)
+		glog.Infoln("DAV:", "PUT created", myPath)
+		w.WriteHeader(StatusCreated)
+	}
+}
+
+// setMTime sets the modification time of a stored PUT from the value of an
+// X-OC-MTime header. A bad value or a FileSystem that can't is only logged,
+// the upload has succeeded regardless.
+func (s *Server) setMTime(w http.ResponseWriter, name, value string) {
+	mtime, ok := parseUnixTime(value)
+	if !ok {
+		glog.Infoln("DAV:", "PUT ignoring bad X-OC-MTime", value, name)
+		return
+	}
+	c, ok := capability[Chtimer](s.Fs)
+	if !ok {
+		glog.Infoln("DAV:", "PUT ignoring X-OC-MTime, FileSystem can't set times", name)
+		return
+	}
+	if err := c.Chtimes(name, mtime, mtime); err != nil {
+		glog.Infoln("DAV:", "PUT error setting mtime", name, "error", err)
+		return
+	}
+	w.Header().Set("X-OC-MTime", "accepted")
+}
+
+// parseUnixTime parses Unix seconds, with an optional fraction
+func parseUnixTime(v string) (time.Time, bool) {
+	sec, frac, _ := strings.Cut(strings.TrimSpace(v), ".")
+	n, err := strconv.ParseInt(sec, 10, 64)
+	if err != nil || n <= 0 {
+		return time.Time{}, false
+	}
+	var nsec int64
+	if frac != "" {
+		if len(frac) > 9 {
+			frac = frac[:9]
+		}
+		f, err := strconv.ParseUint(frac, 10, 32)
+		if err != nil {
+			return time.Time{}, false
+		}
+		nsec = int64(f)
+		for i := len(frac); i < 9; i++ {
+			nsec *= 10
+		}
+	}
+	return time.Unix(n, nsec), true
+}
+
+// http://www.webdav.org/specs/rfc4918.html#METHOD_COPY
+//
+// Only non-collection resources can be copied.
+func (s *Server) doCopy(w http.ResponseWriter, r *http.Request) {
+	if s.ReadOnly {
+		w.WriteHeader(StatusForbidden)
+		glog.Infoln("DAV:", "COPY Forbidden: server is ReadOnly")
+		return
+	}
+
+	src := s.url2path(r.URL)
+	dest, err := url.Parse(r.Header.Get("Destination"))
+	ok := err == nil && dest.Path != ""
+	if ok {
+		dest.Path, ok = s.requestPath(dest)
+	}
+	if !ok {
+		glog.Infoln("DAV:", "COPY bad destination", r.Header.Get("Destination"))
+		w.WriteHeader(StatusBadRequest)
+		return
+	}
+	dst := s.url2path(dest)
+
+	if src == dst {
+		w.WriteHeader(StatusForbidden)
+		return
+	}
+
+	fi, err := s.stat(src)
+	if err != nil {
+		glog.Infoln("404", r.RequestURI)
+		w.WriteHeader(StatusNotFound)
+		return
+	}
+
+	if fi.IsDir() {
+		// XXX: copying entire paths is not supported, same as DELETE
+		glog.Infoln("DAV:", "COPY of collection refused", src)
+		w.WriteHeader(StatusForbidden)
+		return
+	}
+
+	dfi, err := s.stat(dst)
+	exists := err == nil
+	if !exists {
+		dfi = nil
+	}
+	if exists && r.Header.Get("Overwrite") == "F" {
+		w.WriteHeader(StatusPreconditionFailed)
+		return
+	}
+
+	if err := s.copyFile(src, dst, dfi); err != nil {
+		glog.Infoln("DAV:", "COPY error", src, "to", dst, "error", err)
+		if isNoSpace(err) {
+			w.WriteHeader(StatusInsufficientStorage)
 		} else {
-			glog.Infoln("DAV:", "PUT created", file, "error", err)
-			w.WriteHeader(StatusCreated)
+			w.WriteHeader(StatusConflict)
+		}
+		return
+	}
+
+	if exists {
+		s.stored(r, fi.Size()-dfi.Size())
+		s.publish(OpWrite, dst)
+		w.WriteHeader(StatusNoContent)
+	} else {
+		s.stored(r, fi.Size())
+		s.publish(OpCreate, dst)
+		w.WriteHeader(StatusCreated)
+	}
+}
+
+// copyFile copies a single file through a pendingFile like PUT, so the
+// destination is only replaced by a complete copy. A Copier copies natively
+// into the place of the pending file. fi is the destination, nil if there
+// is none.
+func (s *Server) copyFile(src, dst string, fi os.FileInfo) error {
+	out, err := s.createPending(dst, fi)
+	if err != nil {
+		return err
+	}
+	defer out.abort()
+
+	if c, ok := capability[Copier](s.Fs); ok {
+		if err := out.copyNative(c, src); err != ErrNotImplemented {
+			if err != nil {
+				return err
+			}
+			return out.commit()
+		}
+	}
+
+	in, err := s.Fs.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	if _, err := s.copy(out, in); err != nil {
+		return err
+	}
+	return out.commit()
+}
+
+// DefaultCopyBufferSize is the size of the buffers PUT and COPY copy with
+const DefaultCopyBufferSize = 128 << 10
+
+// copyBuffers holds the buffers of Server.copy, of whatever size the
+// Servers using them are configured with
+var copyBuffers sync.Pool
+
+// copy copies src to dst with a pooled buffer, unless one of them can copy
+// by itself, like a file to file copy with copy_file_range(2)
+func (s *Server) copy(dst io.Writer, src io.Reader) (int64, error) {
+	if _, ok := dst.(io.ReaderFrom); ok {
+		return io.Copy(dst, src)
+	}
+	if _, ok := src.(io.WriterTo); ok {
+		return io.Copy(dst, src)
+	}
+
+	size := s.CopyBufferSize
+	if size <= 0 {
+		size = DefaultCopyBufferSize
+	}
+	bp, _ := copyBuffers.Get().(*[]byte)
+	if bp == nil || cap(*bp) < size {
+		b := make([]byte, size)
+		bp = &b
+	}
+	defer copyBuffers.Put(bp)
+	return io.CopyBuffer(dst, src, (*bp)[:size])
+}
+
+// ctxReader fails once ctx is done, so a request that was canceled stops
+// copying its body even if more of it arrives
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c ctxReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
+
+// pendingFile is a file being written by PUT or COPY. When the FileSystem
+// is a TempFiler the content goes to a temporary file which only replaces
+// name on commit. Otherwise name is written directly, after setting the
+// previous file aside if the FileSystem is a Renamer, and a failed write
+// removes it again and restores the previous file.
+type pendingFile struct {
+	File
+	fs      FileSystem
+	name    string
+	tmp     string
+	backup  string      // the previous file set aside, without tmp
+	existed bool        // name was replaced rather than created
+	mode    os.FileMode // of the replaced file, kept by the new one
+
+	written  int64
+	prealloc int64
+	sync     bool // Sync before Close
+	done     bool // committed or aborted
+	closed   bool // File closed early by copyNative
+}
+
+// createPending starts writing name, fi is its FileInfo or nil if it didn't
+// exist. Where the FileSystem is an OpenFiler a new file is opened with
+// O_EXCL, so one created meanwhile is noticed and reported as replaced.
+func (s *Server) createPending(name string, fi os.FileInfo) (*pendingFile, error) {
+	existed := fi != nil
+	var mode os.FileMode
+	if existed {
+		mode = fi.Mode().Perm()
+	}
+	if t, ok := s.Fs.(TempFiler); ok {
+		f, tmp, err := t.CreateTemp(path.Dir(name), TempPrefix+"*")
+		if err == nil {
+			return &pendingFile{File: f, fs: s.Fs, name: name, tmp: tmp, existed: existed, mode: mode}, nil
+		}
+		if err != ErrNotImplemented {
+			return nil, err
+		}
+	}
+
+	if o, ok := s.Fs.(OpenFiler); ok && !existed {
+		f, err := o.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		switch {
+		case err == nil:
+			return &pendingFile{File: f, fs: s.Fs, name: name}, nil
+		case errors.Is(err, fs.ErrExist):
+			// created since the Stat, truncated by Create below
+			existed = true
+		case err != ErrNotImplemented:
+			return nil, err
+		}
+	}
+
+	var backup string
+	if r, ok := s.Fs.(Renamer); ok && fi != nil && !fi.IsDir() {
+		b := path.Join(path.Dir(name), TempPrefix+strconv.FormatInt(rand.Int63(), 36))
+		if err := r.Rename(name, b); err == nil {
+			backup = b
+		}
+	}
+
+	f, err := s.Fs.Create(name)
+	if err != nil {
+		if backup != "" {
+			s.Fs.(Renamer).Rename(backup, name)
+		}
+		return nil, err
+	}
+	return &pendingFile{File: f, fs: s.Fs, name: name, backup: backup, existed: existed, mode: mode}, nil
+}
+
+// createExclusive starts writing name only if it doesn't exist, checking
+// and creating it with one O_EXCL open. The file is written in place, so
+// it can be seen while it is written, and a failed write removes it. It
+// returns ErrNotImplemented if the FileSystem is not an OpenFiler.
+func (s *Server) createExclusive(name string) (*pendingFile, error) {
+	o, ok := s.Fs.(OpenFiler)
+	if !ok {
+		return nil, ErrNotImplemented
+	}
+	f, err := o.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &pendingFile{File: f, fs: s.Fs, name: name}, nil
+}
+
+func (p *pendingFile) Write(b []byte) (int, error) {
+	n, err := p.File.Write(b)
+	p.written += int64(n)
+	return n, err
+}
+
+// copyNative closes the file and lets c copy src to where it was being
+// written. If c returns ErrNotImplemented the file is created again, to be
+// written as usual.
+func (p *pendingFile) copyNative(c Copier, src string) error {
+	// a file streaming to a remote server must not complete the upload
+	if a, ok := p.File.(interface{ abort() }); ok {
+		a.abort()
+	}
+	p.File.Close()
+	p.closed = true
+
+	target := p.name
+	if p.tmp != "" {
+		target = p.tmp
+	}
+	if err := c.CopyFile(src, target); err != ErrNotImplemented {
+		return err
+	}
+
+	f, err := p.fs.Create(target)
+	if err != nil {
+		return err
+	}
+	p.File, p.closed = f, false
+	return ErrNotImplemented
+}
+
+// preallocate reserves space for the expected size. Only running out of
+// space is reported, files that can't preallocate are written as usual.
+func (p *pendingFile) preallocate(size int64) error {
+	var err error
+	switch f := p.File.(type) {
+	case Preallocator:
+		err = f.Preallocate(size)
+	case *os.File:
+		err = preallocate(f, size)
+	default:
+		return nil
+	}
+
+	if err == nil {
+		p.prealloc = size
+		return nil
+	}
+	if isNoSpace(err) {
+		return err
+	}
+	return nil
+}
+
+// isNoSpace reports whether err means the storage is full
+func isNoSpace(err error) bool {
+	return errors.Is(err, syscall.ENOSPC) || errors.Is(err, syscall.EDQUOT)
+}
+
+// storageErrorStatus is the status for a file that could not be stored
+func storageErrorStatus(err error) int {
+	if isNoSpace(err) {
+		return StatusInsufficientStorage
+	}
+	return StatusInternalServerError
+}
+
+// commit closes the file and moves it into place
+func (p *pendingFile) commit() error {
+	if p.prealloc > 0 && p.written != p.prealloc {
+		// preallocation may have extended the file past what was received
+		if t, ok := p.File.(interface{ Truncate(int64) error }); ok {
+			if err := t.Truncate(p.written); err != nil && err != ErrNotImplemented {
+				p.abort()
+				return err
+			}
+		}
+	}
+	p.done = true
+
+	if s, ok := p.File.(interface{ Sync() error }); ok && p.sync && !p.closed {
+		if err := s.Sync(); err != nil {
+			p.File.Close()
+			p.discard()
+			return err
+		}
+	}
+	if !p.closed {
+		if err := p.File.Close(); err != nil {
+			p.discard()
+			return err
+		}
+	}
+	if p.mode != 0 {
+		p.keepMode()
+	}
+	if p.tmp != "" {
+		if err := p.fs.(TempFiler).Rename(p.tmp, p.name); err != nil {
+			p.discard()
+			return err
+		}
+		return nil
+	}
+
+	if p.backup != "" {
+		if err := p.fs.Remove(p.backup); err != nil {
+			glog.Infoln("DAV:", "error removing previous file", p.backup, "error", err)
+		}
+	}
+	return nil
+}
+
+// keepMode gives the new file the mode of the one it replaces, where the
+// FileSystem can set modes. A failure is only logged, the content is
+// stored regardless.
+func (p *pendingFile) keepMode() {
+	c, ok := capability[Chmoder](p.fs)
+	if !ok {
+		return
+	}
+	name := p.name
+	if p.tmp != "" {
+		name = p.tmp
+	}
+	if err := c.Chmod(name, p.mode); err != nil {
+		glog.Infoln("DAV:", "error keeping the mode of", p.name, "error", err)
+	}
+}
+
+// abort closes the file and throws away what was written, it does nothing
+// after commit
+func (p *pendingFile) abort() {
+	if p.done {
+		return
+	}
+	p.done = true
+
+	if !p.closed {
+		// a file streaming to a remote server must not complete the upload
+		if a, ok := p.File.(interface{ abort() }); ok {
+			a.abort()
+		}
+		p.File.Close()
+	}
+	p.discard()
+}
+
+// discard removes the temporary file, or the partly written destination,
+// putting the previous file back
+func (p *pendingFile) discard() {
+	if p.tmp != "" {
+		if err := p.fs.Remove(p.tmp); err != nil {
+			glog.Infoln("DAV:", "error removing temporary file", p.tmp, "error", err)
+		}
+		return
+	}
+
+	remove := p.fs.Remove
+	if r, ok := capability[partialRemover](p.fs); ok {
+		// not into a trash, it was never a file anyone stored
+		remove = r.removePartial
+	}
+	if err := remove(p.name); err != nil && !os.IsNotExist(err) {
+		glog.Infoln("DAV:", "error removing partial file", p.name, "error", err)
+	}
+	if p.backup != "" {
+		if err := p.fs.(Renamer).Rename(p.backup, p.name); err != nil {
+			glog.Infoln("DAV:", "error restoring", p.name, "from", p.backup, "error", err)
 		}
 	}
-	file.Close()
 }
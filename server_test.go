@@ -0,0 +1,428 @@
+package webdav
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestServer serves s over HTTP until the test ends, its TrimPrefix is
+// "/" unless set
+func newTestServer(t testing.TB, s *Server) *httptest.Server {
+	t.Helper()
+	if s.TrimPrefix == "" {
+		s.TrimPrefix = "/"
+	}
+	ts := httptest.NewServer(s)
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+// request sends method to the path name of ts with body and header, given
+// as name and value pairs, and returns the response and its body
+func request(t testing.TB, ts *httptest.Server, method, name, body string, header ...string) (*http.Response, string) {
+	t.Helper()
+	var r io.Reader
+	if body != "" {
+		r = strings.NewReader(body)
+	}
+	req, err := http.NewRequest(method, ts.URL+name, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	resp, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, string(b)
+}
+
+// wantStatus fails the test if resp doesn't have status code
+func wantStatus(t testing.TB, resp *http.Response, code int) {
+	t.Helper()
+	if resp.StatusCode != code {
+		t.Fatalf("%s %s: status %d, want %d", resp.Request.Method, resp.Request.URL.Path, resp.StatusCode, code)
+	}
+}
+
+// closeCountFS counts the calls of Close
+type closeCountFS struct {
+	FileSystem
+	closed atomic.Int32
+}
+
+func (c *closeCountFS) Close() error {
+	c.closed.Add(1)
+	return nil
+}
+
+func TestServerCloseClosesFileSystemOnce(t *testing.T) {
+	fsys := &closeCountFS{FileSystem: NewMemFS()}
+	s := &Server{Fs: fsys}
+	ts := newTestServer(t, s)
+
+	resp, _ := request(t, ts, "PUT", "/a.txt", "hello")
+	wantStatus(t, resp, StatusCreated)
+
+	for i := 0; i < 3; i++ {
+		if err := s.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := fsys.closed.Load(); n != 1 {
+		t.Errorf("FileSystem closed %d times, want 1", n)
+	}
+
+	resp, _ = request(t, ts, "GET", "/a.txt", "")
+	wantStatus(t, resp, StatusServiceUnavailable)
+}
+
+// TestServerCloseLeaksNothing creates, serves and closes Servers with a
+// change feed, an event stream and an embedder's watch, and counts the
+// goroutines left behind
+func TestServerCloseLeaksNothing(t *testing.T) {
+	for name, mk := range map[string]func(root string) FileSystem{
+		"dir":   func(root string) FileSystem { return Dir(root) },
+		"memfs": func(string) FileSystem { return NewMemFS() },
+	} {
+		t.Run(name, func(t *testing.T) {
+			before := runtime.NumGoroutine()
+			for i := 0; i < 20; i++ {
+				s := &Server{Fs: mk(t.TempDir()), TrimPrefix: "/", ChangeFeed: true, SyncCollection: true}
+				ts := httptest.NewServer(s)
+
+				events, stop, err := s.Watch("/")
+				if err != nil {
+					t.Fatal(err)
+				}
+				req, _ := http.NewRequest("GET", ts.URL+"/"+changesName, nil)
+				req.Header.Set("Accept", "text/event-stream")
+				stream, err := ts.Client().Do(req)
+				if err != nil {
+					t.Fatal(err)
+				}
+				resp, _ := request(t, ts, "PUT", "/f.txt", "x")
+				wantStatus(t, resp, StatusCreated)
+				<-events
+
+				var wg sync.WaitGroup
+				for j := 0; j < 3; j++ {
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						s.Close()
+					}()
+				}
+				wg.Wait()
+				// the stream ends, the watch is closed, and stopping it
+				// again is harmless
+				io.Copy(io.Discard, stream.Body)
+				stream.Body.Close()
+				for range events {
+				}
+				stop()
+				if _, _, err := s.Watch("/"); err == nil {
+					t.Error("Watch after Close succeeded")
+				}
+				ts.Close()
+			}
+			http.DefaultTransport.(*http.Transport).CloseIdleConnections()
+			waitGoroutines(t, before)
+		})
+	}
+}
+
+// callCountFS is a Dir that counts the calls of its methods and of the
+// Stat and Readdir of its files
+type callCountFS struct {
+	Dir
+	mu    sync.Mutex
+	calls map[string]int
+}
+
+func (c *callCountFS) count(method string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.calls[method]++
+}
+
+// take returns the calls counted so far, as "Method:n" sorted by method,
+// and starts counting anew
+func (c *callCountFS) take() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var s []string
+	for m, n := range c.calls {
+		s = append(s, fmt.Sprintf("%s:%d", m, n))
+	}
+	sort.Strings(s)
+	c.calls = make(map[string]int)
+	return strings.Join(s, " ")
+}
+
+type callCountFile struct {
+	File
+	c *callCountFS
+}
+
+func (f callCountFile) Stat() (os.FileInfo, error) {
+	f.c.count("Stat")
+	return f.File.Stat()
+}
+
+func (f callCountFile) Readdir(n int) ([]os.FileInfo, error) {
+	f.c.count("Readdir")
+	return f.File.Readdir(n)
+}
+
+func (c *callCountFS) file(f File, err error) (File, error) {
+	if err != nil {
+		return nil, err
+	}
+	return callCountFile{f, c}, nil
+}
+
+func (c *callCountFS) Open(name string) (File, error) {
+	c.count("Open")
+	return c.file(c.Dir.Open(name))
+}
+
+func (c *callCountFS) Create(name string) (File, error) {
+	c.count("Create")
+	return c.file(c.Dir.Create(name))
+}
+
+func (c *callCountFS) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	c.count("OpenFile")
+	return c.file(c.Dir.OpenFile(name, flag, perm))
+}
+
+func (c *callCountFS) CreateTemp(dir, pattern string) (File, string, error) {
+	c.count("CreateTemp")
+	f, name, err := c.Dir.CreateTemp(dir, pattern)
+	f, err = c.file(f, err)
+	return f, name, err
+}
+
+func (c *callCountFS) Mkdir(name string) error {
+	c.count("Mkdir")
+	return c.Dir.Mkdir(name)
+}
+
+func (c *callCountFS) Remove(name string) error {
+	c.count("Remove")
+	return c.Dir.Remove(name)
+}
+
+func (c *callCountFS) Rename(oldname, newname string) error {
+	c.count("Rename")
+	return c.Dir.Rename(oldname, newname)
+}
+
+func (c *callCountFS) CopyFile(src, dst string) error {
+	c.count("CopyFile")
+	return c.Dir.CopyFile(src, dst)
+}
+
+func (c *callCountFS) Chmod(name string, mode os.FileMode) error {
+	c.count("Chmod")
+	return c.Dir.Chmod(name, mode)
+}
+
+func (c *callCountFS) Chtimes(name string, atime, mtime time.Time) error {
+	c.count("Chtimes")
+	return c.Dir.Chtimes(name, atime, mtime)
+}
+
+func (c *callCountFS) Checksum(name, algo string) (string, error) {
+	c.count("Checksum")
+	return c.Dir.Checksum(name, algo)
+}
+
+func (c *callCountFS) CachedChecksum(name, algo string) (string, bool) {
+	c.count("CachedChecksum")
+	return c.Dir.CachedChecksum(name, algo)
+}
+
+func (c *callCountFS) CacheChecksum(name, algo, sum string, size int64) {
+	c.count("CacheChecksum")
+	c.Dir.CacheChecksum(name, algo, sum, size)
+}
+
+func TestServerBackendCalls(t *testing.T) {
+	root := t.TempDir()
+	fsys := &callCountFS{Dir: Dir(root), calls: make(map[string]int)}
+	ts := newTestServer(t, &Server{Fs: fsys})
+	if err := os.Mkdir(filepath.Join(root, "dir"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	// a new file is found missing with one Open, an existing one takes a
+	// Stat more; COPY does the same for its source and destination
+	for _, tc := range []struct {
+		method, name string
+		header       []string
+		status       int
+		calls        string
+	}{
+		{"PUT", "/dir/new", nil, StatusCreated, "CacheChecksum:1 CreateTemp:1 Mkdir:1 Open:1 Rename:1"},
+		{"PUT", "/dir/new", nil, StatusNoContent, "CacheChecksum:1 Chmod:1 CreateTemp:1 Open:1 Rename:1 Stat:1"},
+		{"PUT", "/a/b/new", nil, StatusCreated, "CacheChecksum:1 CreateTemp:1 Mkdir:1 Open:1 Rename:1"},
+		{"PUT", "/dir", nil, StatusMethodNotAllowed, "Open:1 Stat:1"},
+		{"GET", "/dir/new", nil, StatusOK, "CachedChecksum:1 Open:1 Stat:1"},
+		{"HEAD", "/dir/new", nil, StatusOK, "CachedChecksum:1 Open:1 Stat:1"},
+		{"GET", "/missing", nil, StatusNotFound, "Open:1"},
+		{"COPY", "/dir/new", []string{"Destination", ts.URL + "/dir/copy"}, StatusCreated, "CopyFile:1 CreateTemp:1 Open:2 Rename:1 Stat:1"},
+		{"COPY", "/dir/new", []string{"Destination", ts.URL + "/dir/copy"}, StatusNoContent, "Chmod:1 CopyFile:1 CreateTemp:1 Open:2 Rename:1 Stat:2"},
+		{"DELETE", "/dir/copy", nil, StatusNoContent, "Open:1 Remove:1 Stat:1"},
+		{"DELETE", "/missing", nil, StatusNotFound, "Open:1"},
+	} {
+		resp, _ := request(t, ts, tc.method, tc.name, "data", tc.header...)
+		wantStatus(t, resp, tc.status)
+		if got := fsys.take(); got != tc.calls {
+			t.Errorf("%s %s: calls %s\nwant %s", tc.method, tc.name, got, tc.calls)
+		}
+	}
+}
+
+// TestBackslashes sends the same paths with backslashes to a strict Server
+// and to one with BackslashSeparators. Strict refuses each with 400 on
+// every platform, lenient takes them as slashes and stays inside the root.
+func TestBackslashes(t *testing.T) {
+	for _, lenient := range []bool{false, true} {
+		t.Run(fmt.Sprintf("BackslashSeparators=%v", lenient), func(t *testing.T) {
+			top := t.TempDir()
+			root := filepath.Join(top, "root")
+			os.MkdirAll(filepath.Join(root, "dir"), 0o755)
+			os.WriteFile(filepath.Join(root, "dir", "f.txt"), []byte("inside"), 0o644)
+			os.WriteFile(filepath.Join(top, "secret"), []byte("outside"), 0o644)
+			ts := newTestServer(t, &Server{Fs: Dir(root), BackslashSeparators: lenient})
+
+			for _, tc := range []struct {
+				method, name, body string
+				header             []string
+				lenient            int    // status with BackslashSeparators
+				want               string // body of a lenient GET
+			}{
+				{"GET", "/plain", "", nil, StatusNotFound, ""},
+				{"GET", `/dir\f.txt`, "", nil, StatusOK, "inside"},
+				{"GET", "/dir%5Cf.txt", "", nil, StatusOK, "inside"},
+				{"GET", `/a\..\..\secret`, "", nil, StatusNotFound, ""},
+				{"GET", `/dir\..\..\..\secret`, "", nil, StatusNotFound, ""},
+				{"GET", `/..\secret`, "", nil, StatusNotFound, ""},
+				{"PUT", `/a\..\..\secret`, "x", nil, StatusCreated, ""},
+				{"DELETE", `/dir\..\..\secret`, "", nil, StatusNoContent, ""},
+				{"COPY", `/dir\f.txt`, "", []string{"Destination", ts.URL + `/dir\copy.txt`}, StatusCreated, ""},
+			} {
+				want := tc.lenient
+				if !lenient && strings.ContainsAny(tc.name, `\%`) {
+					want = StatusBadRequest
+				}
+				resp, body := request(t, ts, tc.method, tc.name, tc.body, tc.header...)
+				if resp.StatusCode != want {
+					t.Errorf("%s %s: %d, want %d", tc.method, tc.name, resp.StatusCode, want)
+				} else if lenient && tc.want != "" && body != tc.want {
+					t.Errorf("%s %s = %q, want %q", tc.method, tc.name, body, tc.want)
+				}
+			}
+
+			// the secret beside the root is untouched: the lenient PUT and
+			// DELETE went to root/secret, the COPY inside the root too
+			if b, err := os.ReadFile(filepath.Join(top, "secret")); string(b) != "outside" {
+				t.Errorf("secret outside the root holds %q, %v", b, err)
+			}
+			if _, err := os.Stat(filepath.Join(root, "dir", "copy.txt")); lenient != (err == nil) {
+				t.Errorf("root/dir/copy.txt: %v", err)
+			}
+		})
+	}
+}
+
+// TestRequestLines replays the request lines of testdata/requestlines, in
+// the encodings of Windows, the Finder, cadaver and rclone, and checks each
+// reaches the file it names, the Destination of a COPY too
+func TestRequestLines(t *testing.T) {
+	files, err := filepath.Glob("testdata/requestlines/*.txt")
+	if err != nil || len(files) == 0 {
+		t.Fatal("no fixtures", err)
+	}
+	for _, file := range files {
+		t.Run(filepath.Base(file), func(t *testing.T) {
+			b, err := os.ReadFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, line := range strings.Split(string(b), "\n") {
+				if line == "" || strings.HasPrefix(line, "#") {
+					continue
+				}
+				f := strings.Split(line, "\t")
+				reqLine, want := f[0], f[1]
+
+				m := NewMemFS()
+				s := &Server{Fs: m, TrimPrefix: "/"}
+				if want != "400" {
+					mkdirAll(m, path.Dir(want))
+					writeMem(t, m, want, []byte(want))
+				}
+				head := reqLine + "\r\nHost: files.example.com\r\n"
+				if len(f) == 4 {
+					head += "Destination: " + f[2] + "\r\n"
+				}
+				r, err := http.ReadRequest(bufio.NewReader(strings.NewReader(head + "\r\n")))
+				if err != nil {
+					t.Errorf("%s: %v", reqLine, err)
+					continue
+				}
+				w := httptest.NewRecorder()
+				s.ServeHTTP(w, r)
+
+				switch {
+				case want == "400" || len(f) == 4 && f[3] == "400":
+					if w.Code != StatusBadRequest {
+						t.Errorf("%s: %d, want 400", line, w.Code)
+					}
+				case len(f) == 4:
+					if w.Code != StatusCreated {
+						t.Errorf("%s: %d, want 201", line, w.Code)
+					} else if got := string(readAll(t, m, f[3])); got != want {
+						t.Errorf("%s: %s holds %q", line, f[3], got)
+					}
+				default:
+					if w.Code != StatusOK || w.Body.String() != want {
+						t.Errorf("%s: %d %q, want %q", reqLine, w.Code, w.Body, want)
+					}
+				}
+			}
+		})
+	}
+}
+
+// mkdirAll makes dir and its parents in m
+func mkdirAll(m *MemFS, dir string) {
+	if dir == "/" {
+		return
+	}
+	mkdirAll(m, path.Dir(dir))
+	m.Mkdir(dir)
+}
@@ -0,0 +1,382 @@
+package sqlfs
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeDB is an in-memory database that knows the statements FS sends and
+// nothing else, for a database/sql driver without cgo or a server.
+// Statements in a transaction work on a copy of the tables, which commit
+// puts in place.
+type fakeDB struct {
+	mu     sync.Mutex
+	tables *fakeTables
+
+	// a statement containing failOn fails, to break a transaction midway
+	failOn string
+	// every statement run, normalized
+	log []string
+}
+
+type fakeTables struct {
+	ddl    []string // the CREATE statements run
+	schema []int64  // the versions in webdav_schema
+	files  map[string]fakeRow
+	chunks map[fakeChunkKey][]byte
+}
+
+type fakeRow struct {
+	parent  string
+	isDir   int64
+	size    int64
+	modTime int64
+	hash    string
+}
+
+type fakeChunkKey struct {
+	path string
+	seq  int64
+}
+
+func (t *fakeTables) clone() *fakeTables {
+	c := &fakeTables{
+		ddl:    append([]string(nil), t.ddl...),
+		schema: append([]int64(nil), t.schema...),
+		files:  make(map[string]fakeRow, len(t.files)),
+		chunks: make(map[fakeChunkKey][]byte, len(t.chunks)),
+	}
+	for k, v := range t.files {
+		c.files[k] = v
+	}
+	for k, v := range t.chunks {
+		c.chunks[k] = v
+	}
+	return c
+}
+
+// newFakeFS returns an FS over a new fakeDB, migrated
+func newFakeFS(t testing.TB, postgres bool, chunkSize int) (*FS, *fakeDB) {
+	t.Helper()
+	fdb := &fakeDB{tables: &fakeTables{files: map[string]fakeRow{}, chunks: map[fakeChunkKey][]byte{}}}
+	db := sql.OpenDB(fdb)
+	t.Cleanup(func() { db.Close() })
+	fs := &FS{DB: db, Postgres: postgres, ChunkSize: chunkSize}
+	if err := fs.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	return fs, fdb
+}
+
+func (db *fakeDB) Connect(context.Context) (driver.Conn, error) { return &fakeConn{db: db}, nil }
+func (db *fakeDB) Driver() driver.Driver                        { return fakeDriver{} }
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(string) (driver.Conn, error) { return nil, errors.New("use sql.OpenDB") }
+
+type fakeConn struct {
+	db *fakeDB
+	tx *fakeTables // the working copy of a running transaction
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) { return &fakeStmt{c, query}, nil }
+func (c *fakeConn) Close() error                              { return nil }
+
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	c.db.mu.Lock()
+	c.tx = c.db.tables.clone()
+	c.db.mu.Unlock()
+	return c, nil
+}
+
+func (c *fakeConn) Commit() error {
+	c.db.mu.Lock()
+	c.db.tables, c.tx = c.tx, nil
+	c.db.mu.Unlock()
+	return nil
+}
+
+func (c *fakeConn) Rollback() error {
+	c.tx = nil
+	return nil
+}
+
+type fakeStmt struct {
+	c     *fakeConn
+	query string
+}
+
+func (s *fakeStmt) Close() error  { return nil }
+func (s *fakeStmt) NumInput() int { return -1 }
+
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	_, _, err := s.run(args)
+	return driver.RowsAffected(0), err
+}
+
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	cols, rows, err := s.run(args)
+	if err != nil {
+		return nil, err
+	}
+	return &fakeRows{cols: cols, rows: rows}, nil
+}
+
+var (
+	spaces       = regexp.MustCompile(`\s+`)
+	placeholders = regexp.MustCompile(`\$\d+`)
+)
+
+// run runs the statement on the tables of the transaction, or on the
+// database outside one
+func (s *fakeStmt) run(args []driver.Value) ([]string, [][]driver.Value, error) {
+	db := s.c.db
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	query := strings.TrimSpace(spaces.ReplaceAllString(s.query, " "))
+	if strings.Contains(query, "$") {
+		// Postgres placeholders are numbered in order
+		query = placeholders.ReplaceAllString(query, "?")
+	}
+	db.log = append(db.log, query)
+	if db.failOn != "" && strings.Contains(query, db.failOn) {
+		return nil, nil, fmt.Errorf("fake: %s failed", query)
+	}
+	t := s.c.tx
+	if t == nil {
+		t = db.tables
+	}
+
+	if strings.HasPrefix(query, "CREATE ") {
+		t.ddl = append(t.ddl, query)
+		return nil, nil, nil
+	}
+	st, ok := fakeStatements[query]
+	if !ok {
+		return nil, nil, fmt.Errorf("fake: unknown statement %q", query)
+	}
+	rows, err := st.run(t, args)
+	return st.cols, rows, err
+}
+
+// substr is SQL substr, counting characters from 1
+func substr(s string, start, n int64) string {
+	r := []rune(s)
+	if start < 1 {
+		start = 1
+	}
+	if start > int64(len(r)) {
+		return ""
+	}
+	r = r[start-1:]
+	if n >= 0 && n < int64(len(r)) {
+		r = r[:n]
+	}
+	return string(r)
+}
+
+type fakeStatement struct {
+	cols []string
+	run  func(t *fakeTables, a []driver.Value) ([][]driver.Value, error)
+}
+
+// fakeStatements are the statements FS sends, with '?' placeholders and
+// single spaces
+var fakeStatements = map[string]fakeStatement{
+	`SELECT COALESCE(MAX(version), 0) FROM webdav_schema`: {[]string{"version"}, func(t *fakeTables, a []driver.Value) ([][]driver.Value, error) {
+		var v int64
+		for _, s := range t.schema {
+			if s > v {
+				v = s
+			}
+		}
+		return [][]driver.Value{{v}}, nil
+	}},
+	`INSERT INTO webdav_schema (version) VALUES (?)`: {nil, func(t *fakeTables, a []driver.Value) ([][]driver.Value, error) {
+		t.schema = append(t.schema, a[0].(int64))
+		return nil, nil
+	}},
+	`SELECT is_dir, size, mod_time, hash FROM webdav_files WHERE path = ?`: {[]string{"is_dir", "size", "mod_time", "hash"}, func(t *fakeTables, a []driver.Value) ([][]driver.Value, error) {
+		r, ok := t.files[a[0].(string)]
+		if !ok {
+			return nil, nil
+		}
+		return [][]driver.Value{{r.isDir, r.size, r.modTime, r.hash}}, nil
+	}},
+	`SELECT COUNT(*) FROM webdav_files WHERE parent = ?`: {[]string{"count"}, func(t *fakeTables, a []driver.Value) ([][]driver.Value, error) {
+		n := int64(0)
+		for _, r := range t.files {
+			if r.parent == a[0].(string) {
+				n++
+			}
+		}
+		return [][]driver.Value{{n}}, nil
+	}},
+	`SELECT path, is_dir, size, mod_time, hash FROM webdav_files WHERE parent = ? AND path > ? ORDER BY path`: {[]string{"path", "is_dir", "size", "mod_time", "hash"}, func(t *fakeTables, a []driver.Value) ([][]driver.Value, error) {
+		return t.children(a[0].(string), a[1].(string), -1), nil
+	}},
+	`SELECT path, is_dir, size, mod_time, hash FROM webdav_files WHERE parent = ? AND path > ? ORDER BY path LIMIT ?`: {[]string{"path", "is_dir", "size", "mod_time", "hash"}, func(t *fakeTables, a []driver.Value) ([][]driver.Value, error) {
+		return t.children(a[0].(string), a[1].(string), a[2].(int64)), nil
+	}},
+	`INSERT INTO webdav_files (path, parent, is_dir, size, mod_time, hash) VALUES (?, ?, 0, 0, ?, ?) ON CONFLICT (path) DO UPDATE SET size = 0, mod_time = excluded.mod_time, hash = excluded.hash`: {nil, func(t *fakeTables, a []driver.Value) ([][]driver.Value, error) {
+		r, ok := t.files[a[0].(string)]
+		if !ok {
+			r = fakeRow{parent: a[1].(string)}
+		}
+		r.size, r.modTime, r.hash = 0, a[2].(int64), a[3].(string)
+		t.files[a[0].(string)] = r
+		return nil, nil
+	}},
+	`INSERT INTO webdav_files (path, parent, is_dir, size, mod_time, hash) VALUES (?, ?, 1, 0, ?, '')`: {nil, func(t *fakeTables, a []driver.Value) ([][]driver.Value, error) {
+		if _, ok := t.files[a[0].(string)]; ok {
+			return nil, errors.New("fake: duplicate key")
+		}
+		t.files[a[0].(string)] = fakeRow{parent: a[1].(string), isDir: 1, modTime: a[2].(int64)}
+		return nil, nil
+	}},
+	`UPDATE webdav_files SET size = ?, mod_time = ?, hash = ? WHERE path = ?`: {nil, func(t *fakeTables, a []driver.Value) ([][]driver.Value, error) {
+		if r, ok := t.files[a[3].(string)]; ok {
+			r.size, r.modTime, r.hash = a[0].(int64), a[1].(int64), a[2].(string)
+			t.files[a[3].(string)] = r
+		}
+		return nil, nil
+	}},
+	`UPDATE webdav_files SET path = ?, parent = ? WHERE path = ?`: {nil, func(t *fakeTables, a []driver.Value) ([][]driver.Value, error) {
+		if r, ok := t.files[a[2].(string)]; ok {
+			delete(t.files, a[2].(string))
+			r.parent = a[1].(string)
+			t.files[a[0].(string)] = r
+		}
+		return nil, nil
+	}},
+	`UPDATE webdav_files SET path = ? || substr(path, ?), parent = ? || substr(parent, ?) WHERE substr(path, 1, ?) = ?`: {nil, func(t *fakeTables, a []driver.Value) ([][]driver.Value, error) {
+		moved := map[string]fakeRow{}
+		for p, r := range t.files {
+			if substr(p, 1, a[4].(int64)) == a[5].(string) {
+				delete(t.files, p)
+				r.parent = a[2].(string) + substr(r.parent, a[3].(int64), -1)
+				moved[a[0].(string)+substr(p, a[1].(int64), -1)] = r
+			}
+		}
+		for p, r := range moved {
+			t.files[p] = r
+		}
+		return nil, nil
+	}},
+	`DELETE FROM webdav_files WHERE path = ?`: {nil, func(t *fakeTables, a []driver.Value) ([][]driver.Value, error) {
+		delete(t.files, a[0].(string))
+		return nil, nil
+	}},
+	`DELETE FROM webdav_files WHERE path = ? OR substr(path, 1, ?) = ?`: {nil, func(t *fakeTables, a []driver.Value) ([][]driver.Value, error) {
+		for p := range t.files {
+			if p == a[0].(string) || substr(p, 1, a[1].(int64)) == a[2].(string) {
+				delete(t.files, p)
+			}
+		}
+		return nil, nil
+	}},
+	`SELECT data FROM webdav_chunks WHERE path = ? AND seq = ?`: {[]string{"data"}, func(t *fakeTables, a []driver.Value) ([][]driver.Value, error) {
+		data, ok := t.chunks[fakeChunkKey{a[0].(string), a[1].(int64)}]
+		if !ok {
+			return nil, nil
+		}
+		return [][]driver.Value{{data}}, nil
+	}},
+	`INSERT INTO webdav_chunks (path, seq, data) VALUES (?, ?, ?)`: {nil, func(t *fakeTables, a []driver.Value) ([][]driver.Value, error) {
+		k := fakeChunkKey{a[0].(string), a[1].(int64)}
+		if _, ok := t.chunks[k]; ok {
+			return nil, errors.New("fake: duplicate key")
+		}
+		t.chunks[k] = append([]byte(nil), a[2].([]byte)...)
+		return nil, nil
+	}},
+	`UPDATE webdav_chunks SET path = ? WHERE path = ?`: {nil, func(t *fakeTables, a []driver.Value) ([][]driver.Value, error) {
+		t.moveChunks(func(p string) (string, bool) { return a[0].(string), p == a[1].(string) })
+		return nil, nil
+	}},
+	`UPDATE webdav_chunks SET path = ? || substr(path, ?) WHERE substr(path, 1, ?) = ?`: {nil, func(t *fakeTables, a []driver.Value) ([][]driver.Value, error) {
+		t.moveChunks(func(p string) (string, bool) {
+			return a[0].(string) + substr(p, a[1].(int64), -1), substr(p, 1, a[2].(int64)) == a[3].(string)
+		})
+		return nil, nil
+	}},
+	`DELETE FROM webdav_chunks WHERE path = ?`: {nil, func(t *fakeTables, a []driver.Value) ([][]driver.Value, error) {
+		for k := range t.chunks {
+			if k.path == a[0].(string) {
+				delete(t.chunks, k)
+			}
+		}
+		return nil, nil
+	}},
+	`DELETE FROM webdav_chunks WHERE path = ? OR substr(path, 1, ?) = ?`: {nil, func(t *fakeTables, a []driver.Value) ([][]driver.Value, error) {
+		for k := range t.chunks {
+			if k.path == a[0].(string) || substr(k.path, 1, a[1].(int64)) == a[2].(string) {
+				delete(t.chunks, k)
+			}
+		}
+		return nil, nil
+	}},
+}
+
+// children returns the rows of the files in parent after the path after,
+// in order, at most limit of them unless it is negative
+func (t *fakeTables) children(parent, after string, limit int64) [][]driver.Value {
+	var paths []string
+	for p, r := range t.files {
+		if r.parent == parent && p > after {
+			paths = append(paths, p)
+		}
+	}
+	sort.Strings(paths)
+	if limit >= 0 && int64(len(paths)) > limit {
+		paths = paths[:limit]
+	}
+	rows := make([][]driver.Value, len(paths))
+	for i, p := range paths {
+		r := t.files[p]
+		rows[i] = []driver.Value{p, r.isDir, r.size, r.modTime, r.hash}
+	}
+	return rows
+}
+
+// moveChunks gives the chunks of every path rename accepts its new path
+func (t *fakeTables) moveChunks(rename func(string) (string, bool)) {
+	moved := map[fakeChunkKey][]byte{}
+	for k, data := range t.chunks {
+		if p, ok := rename(k.path); ok {
+			delete(t.chunks, k)
+			moved[fakeChunkKey{p, k.seq}] = data
+		}
+	}
+	for k, data := range moved {
+		t.chunks[k] = data
+	}
+}
+
+type fakeRows struct {
+	cols []string
+	rows [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string { return r.cols }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
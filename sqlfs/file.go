@@ -0,0 +1,257 @@
+package sqlfs
+
+import (
+	"encoding/hex"
+	"hash"
+	"io"
+	"os"
+	"path"
+	"time"
+)
+
+// file is a handle returned by Open (read-only) or Create (write-only,
+// sequential). Content is read one chunk at a time and written in chunks
+// of FS.ChunkSize, so neither direction holds the whole file in memory.
+type file struct {
+	fs   *FS
+	name string
+	info *fileInfo
+
+	// reading
+	off     int64
+	chunk   []byte
+	seq     int64
+	dirLast string
+
+	// writing
+	writer bool
+	buf    []byte
+	next   int64
+	hash   hash.Hash
+
+	closed bool
+}
+
+func (f *file) Stat() (os.FileInfo, error) {
+	if f.closed {
+		return nil, os.ErrClosed
+	}
+	return f.info, nil
+}
+
+func (f *file) Readdir(count int) ([]os.FileInfo, error) {
+	if f.closed {
+		return nil, os.ErrClosed
+	}
+	if !f.info.IsDir() {
+		return nil, &os.PathError{Op: "readdir", Path: f.name, Err: errNotDir}
+	}
+
+	// page by the last name returned, cheaper than OFFSET on big directories
+	query := `SELECT path, is_dir, size, mod_time, hash FROM webdav_files
+		WHERE parent = ? AND path > ? ORDER BY path`
+	args := []interface{}{f.name, f.dirLast}
+	if count > 0 {
+		query += ` LIMIT ?`
+		args = append(args, count)
+	}
+
+	rows, err := f.fs.DB.Query(f.fs.q(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var fis []os.FileInfo
+	for rows.Next() {
+		var (
+			p       string
+			isDir   int
+			size    int64
+			modTime int64
+			hash    string
+		)
+		if err := rows.Scan(&p, &isDir, &size, &modTime, &hash); err != nil {
+			return fis, err
+		}
+		f.dirLast = p
+		fis = append(fis, &fileInfo{
+			name:    path.Base(p),
+			size:    size,
+			modTime: time.Unix(0, modTime),
+			isDir:   isDir != 0,
+			hash:    hash,
+		})
+	}
+	if err := rows.Err(); err != nil {
+		return fis, err
+	}
+
+	if count > 0 && len(fis) == 0 {
+		return nil, io.EOF
+	}
+	return fis, nil
+}
+
+func (f *file) Read(p []byte) (int, error) {
+	if f.closed {
+		return 0, os.ErrClosed
+	}
+	if f.writer {
+		return 0, &os.PathError{Op: "read", Path: f.name, Err: errWriteOnly}
+	}
+	if f.info.IsDir() {
+		return 0, &os.PathError{Op: "read", Path: f.name, Err: errIsDir}
+	}
+	if f.off >= f.info.size {
+		return 0, io.EOF
+	}
+
+	n := 0
+	for n < len(p) && f.off < f.info.size {
+		if err := f.loadChunk(); err != nil {
+			return n, err
+		}
+		start := f.off - f.seq*int64(f.fs.chunkSize())
+		if start >= int64(len(f.chunk)) {
+			// chunk shorter than its slot, content was changed underneath us
+			return n, io.ErrUnexpectedEOF
+		}
+		c := copy(p[n:], f.chunk[start:])
+		n += c
+		f.off += int64(c)
+	}
+	return n, nil
+}
+
+// loadChunk makes sure f.chunk holds the chunk containing f.off
+func (f *file) loadChunk() error {
+	seq := f.off / int64(f.fs.chunkSize())
+	if f.chunk != nil && seq == f.seq {
+		return nil
+	}
+
+	var data []byte
+	err := f.fs.DB.QueryRow(f.fs.q(`SELECT data FROM webdav_chunks WHERE path = ? AND seq = ?`), f.name, seq).
+		Scan(&data)
+	if err != nil {
+		return err
+	}
+	f.chunk, f.seq = data, seq
+	return nil
+}
+
+func (f *file) Write(p []byte) (int, error) {
+	if f.closed {
+		return 0, os.ErrClosed
+	}
+	if !f.writer {
+		return 0, &os.PathError{Op: "write", Path: f.name, Err: errReadOnly}
+	}
+
+	size := f.fs.chunkSize()
+	n := 0
+	for n < len(p) {
+		c := size - len(f.buf)
+		if c > len(p)-n {
+			c = len(p) - n
+		}
+		f.buf = append(f.buf, p[n:n+c]...)
+		n += c
+
+		if len(f.buf) == size {
+			if err := f.flush(); err != nil {
+				return n, err
+			}
+		}
+	}
+	return n, nil
+}
+
+// flush stores the buffered bytes as the next chunk
+func (f *file) flush() error {
+	if len(f.buf) == 0 {
+		return nil
+	}
+
+	if _, err := f.fs.DB.Exec(f.fs.q(`INSERT INTO webdav_chunks (path, seq, data) VALUES (?, ?, ?)`),
+		f.name, f.next, f.buf); err != nil {
+		return err
+	}
+	f.hash.Write(f.buf)
+	f.info.size += int64(len(f.buf))
+	f.next++
+	f.buf = f.buf[:0]
+	return nil
+}
+
+func (f *file) Seek(offset int64, whence int) (int64, error) {
+	if f.closed {
+		return 0, os.ErrClosed
+	}
+	if f.writer {
+		// writes are strictly sequential, only allow asking for the position
+		if offset == 0 && whence == io.SeekCurrent {
+			return f.info.size + int64(len(f.buf)), nil
+		}
+		return 0, &os.PathError{Op: "seek", Path: f.name, Err: errSequential}
+	}
+
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.off
+	case io.SeekEnd:
+		offset += f.info.size
+	default:
+		return 0, &os.PathError{Op: "seek", Path: f.name, Err: os.ErrInvalid}
+	}
+	if offset < 0 {
+		return 0, &os.PathError{Op: "seek", Path: f.name, Err: os.ErrInvalid}
+	}
+	f.off = offset
+	return offset, nil
+}
+
+// Close writes the remaining buffer and the final size and hash of a file
+// opened by Create
+func (f *file) Close() error {
+	if f.closed {
+		return os.ErrClosed
+	}
+	f.closed = true
+	if !f.writer {
+		return nil
+	}
+
+	if err := f.flush(); err != nil {
+		return err
+	}
+	f.info.hash = hex.EncodeToString(f.hash.Sum(nil))
+	f.info.modTime = time.Now()
+
+	_, err := f.fs.DB.Exec(f.fs.q(`UPDATE webdav_files SET size = ?, mod_time = ?, hash = ? WHERE path = ?`),
+		f.info.size, f.info.modTime.UnixNano(), f.info.hash, f.name)
+	return err
+}
+
+type fileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	isDir   bool
+	hash    string
+}
+
+func (fi *fileInfo) Name() string       { return fi.name }
+func (fi *fileInfo) Size() int64        { return fi.size }
+func (fi *fileInfo) ModTime() time.Time { return fi.modTime }
+func (fi *fileInfo) IsDir() bool        { return fi.isDir }
+func (fi *fileInfo) Sys() interface{}   { return nil }
+
+func (fi *fileInfo) Mode() os.FileMode {
+	if fi.isDir {
+		return os.ModeDir | 0755
+	}
+	return 0644
+}
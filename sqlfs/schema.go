@@ -0,0 +1,66 @@
+package sqlfs
+
+import "strings"
+
+// migrations are applied in order, the index+1 being the schema version
+// recorded in webdav_schema. Never edit a released migration, append one.
+var migrations = []string{
+	`CREATE TABLE IF NOT EXISTS webdav_files (
+		path TEXT PRIMARY KEY,
+		parent TEXT NOT NULL,
+		is_dir INTEGER NOT NULL,
+		size BIGINT NOT NULL,
+		mod_time BIGINT NOT NULL,
+		hash TEXT NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS webdav_files_parent ON webdav_files (parent, path);
+	CREATE TABLE IF NOT EXISTS webdav_chunks (
+		path TEXT NOT NULL,
+		seq BIGINT NOT NULL,
+		data BLOB NOT NULL,
+		PRIMARY KEY (path, seq)
+	)`,
+}
+
+// SchemaVersion is the version Migrate brings the database to
+var SchemaVersion = len(migrations)
+
+// Migrate creates the tables used by FS, or upgrades them from an older
+// version of this package. It is safe to call on every start.
+func (fs *FS) Migrate() error {
+	if _, err := fs.DB.Exec(`CREATE TABLE IF NOT EXISTS webdav_schema (version INTEGER NOT NULL)`); err != nil {
+		return err
+	}
+
+	tx, err := fs.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	version := 0
+	if err := tx.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM webdav_schema`).Scan(&version); err != nil {
+		return err
+	}
+
+	for ; version < len(migrations); version++ {
+		for _, stmt := range strings.Split(migrations[version], ";") {
+			if _, err := tx.Exec(fs.dialect(stmt)); err != nil {
+				return err
+			}
+		}
+		if _, err := tx.Exec(fs.q(`INSERT INTO webdav_schema (version) VALUES (?)`), version+1); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// dialect adjusts DDL for the configured database
+func (fs *FS) dialect(stmt string) string {
+	if fs.Postgres {
+		stmt = strings.Replace(stmt, " BLOB ", " BYTEA ", -1)
+	}
+	return stmt
+}
@@ -0,0 +1,371 @@
+/*
+Package sqlfs implements webdav.FileSystem on top of a database/sql database.
+
+Every file and directory is a row in webdav_files, keyed by its cleaned
+absolute path and indexed by its parent, and file content is stored in
+fixed-size chunks in webdav_chunks so large files are streamed rather than
+held in memory. Any driver works; set Postgres for drivers that use $1
+style placeholders and BYTEA, the default is '?' and BLOB as used by SQLite.
+
+	fs := &sqlfs.FS{DB: db, Postgres: true}
+	if err := fs.Migrate(); err != nil {
+		log.Fatal(err)
+	}
+	http.Handle("/dav/", &webdav.Server{Fs: fs, TrimPrefix: "/dav/"})
+*/
+package sqlfs
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/rbastic/webdav"
+)
+
+// DefaultChunkSize is used when FS.ChunkSize is zero
+const DefaultChunkSize = 256 << 10
+
+// FS is a webdav.FileSystem stored in a SQL database
+type FS struct {
+	DB *sql.DB
+
+	// use $1 placeholders and BYTEA
+	Postgres bool
+
+	// size of the content chunks written by Create
+	ChunkSize int
+}
+
+func (fs *FS) chunkSize() int {
+	if fs.ChunkSize <= 0 {
+		return DefaultChunkSize
+	}
+	return fs.ChunkSize
+}
+
+// q rewrites '?' placeholders for the configured driver
+func (fs *FS) q(query string) string {
+	if !fs.Postgres {
+		return query
+	}
+
+	var b strings.Builder
+	n := 0
+	for _, c := range query {
+		if c == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// clean converts a FileSystem name to the key used in the database
+func clean(name string) string {
+	return path.Clean("/" + name)
+}
+
+// Open opens the named file or directory for reading
+func (fs *FS) Open(name string) (webdav.File, error) {
+	name = clean(name)
+
+	fi, err := fs.stat(fs.DB, name)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: name, Err: err}
+	}
+	return &file{fs: fs, name: name, info: fi}, nil
+}
+
+// Create creates or truncates the named file, its parent must exist
+func (fs *FS) Create(name string) (webdav.File, error) {
+	name = clean(name)
+	if name == "/" {
+		return nil, &os.PathError{Op: "create", Path: name, Err: os.ErrExist}
+	}
+
+	tx, err := fs.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	parent, err := fs.stat(tx, path.Dir(name))
+	if err != nil {
+		return nil, &os.PathError{Op: "create", Path: name, Err: err}
+	}
+	if !parent.IsDir() {
+		return nil, &os.PathError{Op: "create", Path: name, Err: errNotDir}
+	}
+	if fi, err := fs.stat(tx, name); err == nil && fi.IsDir() {
+		return nil, &os.PathError{Op: "create", Path: name, Err: errIsDir}
+	}
+
+	now := time.Now()
+	if _, err := tx.Exec(fs.q(`DELETE FROM webdav_chunks WHERE path = ?`), name); err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(fs.q(`INSERT INTO webdav_files (path, parent, is_dir, size, mod_time, hash)
+		VALUES (?, ?, 0, 0, ?, ?)
+		ON CONFLICT (path) DO UPDATE SET size = 0, mod_time = excluded.mod_time, hash = excluded.hash`),
+		name, path.Dir(name), now.UnixNano(), emptyHash); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return &file{
+		fs:     fs,
+		name:   name,
+		info:   &fileInfo{name: path.Base(name), modTime: now},
+		writer: true,
+		hash:   sha256.New(),
+	}, nil
+}
+
+// CreateTemp creates a new file in dir whose name is pattern with the last
+// "*" replaced by a random string
+func (fs *FS) CreateTemp(dir, pattern string) (webdav.File, string, error) {
+	var rnd [8]byte
+	if _, err := rand.Read(rnd[:]); err != nil {
+		return nil, "", err
+	}
+
+	base := pattern + hex.EncodeToString(rnd[:])
+	if i := strings.LastIndex(pattern, "*"); i >= 0 {
+		base = pattern[:i] + hex.EncodeToString(rnd[:]) + pattern[i+1:]
+	}
+
+	name := path.Join(clean(dir), base)
+	f, err := fs.Create(name)
+	if err != nil {
+		return nil, "", err
+	}
+	return f, name, nil
+}
+
+// Mkdir creates the named directory along with any missing parents
+func (fs *FS) Mkdir(name string) error {
+	name = clean(name)
+
+	tx, err := fs.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UnixNano()
+	for p := name; p != "/"; p = path.Dir(p) {
+		fi, err := fs.stat(tx, p)
+		if err == nil {
+			if !fi.IsDir() {
+				return &os.PathError{Op: "mkdir", Path: p, Err: errNotDir}
+			}
+			break
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+
+		if _, err := tx.Exec(fs.q(`INSERT INTO webdav_files (path, parent, is_dir, size, mod_time, hash)
+			VALUES (?, ?, 1, 0, ?, '')`), p, path.Dir(p), now); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Remove removes a file or an empty directory
+func (fs *FS) Remove(name string) error {
+	name = clean(name)
+	if name == "/" {
+		return &os.PathError{Op: "remove", Path: name, Err: os.ErrPermission}
+	}
+
+	tx, err := fs.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := fs.stat(tx, name); err != nil {
+		return &os.PathError{Op: "remove", Path: name, Err: err}
+	}
+
+	var children int
+	if err := tx.QueryRow(fs.q(`SELECT COUNT(*) FROM webdav_files WHERE parent = ?`), name).Scan(&children); err != nil {
+		return err
+	}
+	if children > 0 {
+		return &os.PathError{Op: "remove", Path: name, Err: errNotEmpty}
+	}
+
+	if _, err := tx.Exec(fs.q(`DELETE FROM webdav_chunks WHERE path = ?`), name); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(fs.q(`DELETE FROM webdav_files WHERE path = ?`), name); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// RemoveAll removes name and everything below it in one transaction
+func (fs *FS) RemoveAll(name string) error {
+	name = clean(name)
+	if name == "/" {
+		return &os.PathError{Op: "remove", Path: name, Err: os.ErrPermission}
+	}
+	prefix := name + "/"
+
+	tx, err := fs.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(fs.q(`DELETE FROM webdav_chunks WHERE path = ? OR substr(path, 1, ?) = ?`),
+		name, utf8.RuneCountInString(prefix), prefix); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(fs.q(`DELETE FROM webdav_files WHERE path = ? OR substr(path, 1, ?) = ?`),
+		name, utf8.RuneCountInString(prefix), prefix); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Rename moves a file or a directory tree in one transaction, replacing
+// newname if it is a file
+func (fs *FS) Rename(oldname, newname string) error {
+	oldname, newname = clean(oldname), clean(newname)
+	if oldname == "/" || newname == "/" || strings.HasPrefix(newname, oldname+"/") {
+		return &os.PathError{Op: "rename", Path: oldname, Err: os.ErrInvalid}
+	}
+	if oldname == newname {
+		return nil
+	}
+
+	tx, err := fs.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	fi, err := fs.stat(tx, oldname)
+	if err != nil {
+		return &os.PathError{Op: "rename", Path: oldname, Err: err}
+	}
+	parent, err := fs.stat(tx, path.Dir(newname))
+	if err != nil || !parent.IsDir() {
+		return &os.PathError{Op: "rename", Path: newname, Err: os.ErrNotExist}
+	}
+	if target, err := fs.stat(tx, newname); err == nil {
+		if target.IsDir() {
+			return &os.PathError{Op: "rename", Path: newname, Err: errIsDir}
+		}
+		if _, err := tx.Exec(fs.q(`DELETE FROM webdav_chunks WHERE path = ?`), newname); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(fs.q(`DELETE FROM webdav_files WHERE path = ?`), newname); err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.Exec(fs.q(`UPDATE webdav_files SET path = ?, parent = ? WHERE path = ?`),
+		newname, path.Dir(newname), oldname); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(fs.q(`UPDATE webdav_chunks SET path = ? WHERE path = ?`), newname, oldname); err != nil {
+		return err
+	}
+
+	if fi.IsDir() {
+		// substr counts characters, not bytes
+		prefix := oldname + "/"
+		n := utf8.RuneCountInString(prefix)
+		if _, err := tx.Exec(fs.q(`UPDATE webdav_files
+			SET path = ? || substr(path, ?), parent = ? || substr(parent, ?)
+			WHERE substr(path, 1, ?) = ?`),
+			newname+"/", n+1, newname, n, n, prefix); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(fs.q(`UPDATE webdav_chunks SET path = ? || substr(path, ?)
+			WHERE substr(path, 1, ?) = ?`),
+			newname+"/", n+1, n, prefix); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// ETag returns the hex encoded SHA-256 of the file content
+func (fs *FS) ETag(name string) (string, error) {
+	fi, err := fs.stat(fs.DB, clean(name))
+	if err != nil {
+		return "", err
+	}
+	if fi.IsDir() {
+		return "", errIsDir
+	}
+	return fi.hash, nil
+}
+
+type queryer interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+func (fs *FS) stat(db queryer, name string) (*fileInfo, error) {
+	if name == "/" {
+		return &fileInfo{name: "/", isDir: true}, nil
+	}
+
+	var (
+		isDir   int
+		size    int64
+		modTime int64
+		hash    string
+	)
+	err := db.QueryRow(fs.q(`SELECT is_dir, size, mod_time, hash FROM webdav_files WHERE path = ?`), name).
+		Scan(&isDir, &size, &modTime, &hash)
+	if err == sql.ErrNoRows {
+		return nil, os.ErrNotExist
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &fileInfo{
+		name:    path.Base(name),
+		size:    size,
+		modTime: time.Unix(0, modTime),
+		isDir:   isDir != 0,
+		hash:    hash,
+	}, nil
+}
+
+var (
+	errIsDir      = errors.New("is a directory")
+	errNotDir     = errors.New("not a directory")
+	errNotEmpty   = errors.New("directory not empty")
+	errReadOnly   = errors.New("file not opened for writing")
+	errWriteOnly  = errors.New("file not opened for reading")
+	errSequential = errors.New("files are written sequentially")
+)
+
+var emptyHash = func() string {
+	sum := sha256.Sum256(nil)
+	return hex.EncodeToString(sum[:])
+}()
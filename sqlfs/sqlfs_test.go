@@ -0,0 +1,342 @@
+package sqlfs
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/rbastic/webdav"
+)
+
+func writeFile(t *testing.T, fs *FS, name string, data []byte) {
+	t.Helper()
+	f, err := fs.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readFile(t *testing.T, fs *FS, name string) []byte {
+	t.Helper()
+	f, err := fs.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	b, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func readdirNames(t *testing.T, fs *FS, name string) []string {
+	t.Helper()
+	d, err := fs.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	fis, err := d.Readdir(-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, fi := range fis {
+		names = append(names, fi.Name())
+	}
+	return names
+}
+
+// TestChunks writes a file across several chunks in uneven writes, and
+// reads it back in uneven reads and after seeks
+func TestChunks(t *testing.T) {
+	for _, postgres := range []bool{false, true} {
+		fs, db := newFakeFS(t, postgres, 4)
+		content := []byte("0123456789abcdefghij-")
+
+		f, err := fs.Create("/a.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, n := range []int{3, 1, 7, 10} {
+			if _, err := f.Write(content[:n]); err != nil {
+				t.Fatal(err)
+			}
+			content = content[n:]
+		}
+		if pos, err := f.Seek(0, io.SeekCurrent); err != nil || pos != 21 {
+			t.Errorf("position while writing %d, %v", pos, err)
+		}
+		if _, err := f.Seek(0, io.SeekStart); err == nil {
+			t.Error("a writer seeked back")
+		}
+		if _, err := f.Read(make([]byte, 1)); err == nil {
+			t.Error("a writer read")
+		}
+		if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+		content = []byte("0123456789abcdefghij-")
+		if n := len(db.tables.chunks); n != 6 {
+			t.Errorf("%d chunks stored, want 6", n)
+		}
+		sum := sha256.Sum256(content)
+		if tag, err := fs.ETag("/a.txt"); err != nil || tag != hex.EncodeToString(sum[:]) {
+			t.Errorf("ETag %q, %v", tag, err)
+		}
+
+		f, err = fs.Open("/a.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fi, err := f.Stat(); err != nil || fi.Size() != 21 || fi.IsDir() {
+			t.Errorf("Stat %+v, %v", fi, err)
+		}
+		var got []byte
+		buf := make([]byte, 3)
+		for {
+			n, err := f.Read(buf)
+			got = append(got, buf[:n]...)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		if !bytes.Equal(got, content) {
+			t.Errorf("read %q", got)
+		}
+
+		for _, tc := range []struct {
+			offset int64
+			whence int
+			want   string
+		}{
+			{6, io.SeekStart, "6789a"},
+			{-4, io.SeekEnd, "hij-"},
+			{-9, io.SeekCurrent, "cdefg"},
+			{30, io.SeekStart, ""},
+		} {
+			if _, err := f.Seek(tc.offset, tc.whence); err != nil {
+				t.Fatal(err)
+			}
+			b := make([]byte, 5)
+			n, _ := io.ReadFull(f, b)
+			if string(b[:n]) != tc.want {
+				t.Errorf("after Seek(%d, %d) read %q, want %q", tc.offset, tc.whence, b[:n], tc.want)
+			}
+		}
+		if _, err := f.Seek(-1, io.SeekStart); err == nil {
+			t.Error("seeked before the start")
+		}
+		if _, err := f.Write([]byte("x")); err == nil {
+			t.Error("a reader wrote")
+		}
+		f.Close()
+		if _, err := f.Read(buf); !errors.Is(err, os.ErrClosed) {
+			t.Errorf("Read after Close: %v", err)
+		}
+
+		// Create truncates
+		writeFile(t, fs, "/a.txt", []byte("ab"))
+		if got := readFile(t, fs, "/a.txt"); string(got) != "ab" {
+			t.Errorf("rewritten file holds %q", got)
+		}
+		if n := len(db.tables.chunks); n != 1 {
+			t.Errorf("%d chunks stored after the rewrite, want 1", n)
+		}
+	}
+}
+
+// TestReaddirPaging reads a directory in pages, which continue after the
+// last name returned
+func TestReaddirPaging(t *testing.T) {
+	fs, _ := newFakeFS(t, false, 0)
+	if err := fs.Mkdir("/d/sub"); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"a", "b", "c", "d", "e", "f", "sub"}
+	for _, name := range want[:6] {
+		writeFile(t, fs, "/d/"+name, []byte(name))
+	}
+	writeFile(t, fs, "/d/sub/deeper", nil)
+	writeFile(t, fs, "/d-sibling", nil)
+
+	d, err := fs.Open("/d")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	var pages []int
+	for {
+		fis, err := d.Readdir(3)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		pages = append(pages, len(fis))
+		for _, fi := range fis {
+			got = append(got, fi.Name())
+			if fi.IsDir() != (fi.Name() == "sub") {
+				t.Errorf("%s IsDir %v", fi.Name(), fi.IsDir())
+			}
+		}
+	}
+	if strings.Join(got, " ") != strings.Join(want, " ") || len(pages) != 3 || pages[2] != 1 {
+		t.Errorf("pages %v of %q, want %q", pages, got, want)
+	}
+	d.Close()
+
+	if got := readdirNames(t, fs, "/d"); strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("Readdir(-1) = %q", got)
+	}
+	if got := readdirNames(t, fs, "/"); strings.Join(got, " ") != "d d-sibling" {
+		t.Errorf("root lists %q", got)
+	}
+	f, _ := fs.Open("/d/a")
+	if _, err := f.Readdir(-1); err == nil {
+		t.Error("Readdir of a file")
+	}
+}
+
+// TestRenameTree moves a directory with everything below it, and checks a
+// Rename that fails midway leaves nothing half moved
+func TestRenameTree(t *testing.T) {
+	fs, db := newFakeFS(t, false, 4)
+	if err := fs.Mkdir("/a/b"); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, fs, "/a/b/c.txt", []byte("deep content"))
+	writeFile(t, fs, "/a/x.txt", []byte("x"))
+	writeFile(t, fs, "/ab.txt", []byte("not below /a"))
+
+	if err := fs.Rename("/a", "/z"); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, fs, "/z/b/c.txt"); string(got) != "deep content" {
+		t.Errorf("/z/b/c.txt holds %q", got)
+	}
+	if got := readdirNames(t, fs, "/z"); strings.Join(got, " ") != "b x.txt" {
+		t.Errorf("/z lists %q", got)
+	}
+	if got := readdirNames(t, fs, "/z/b"); strings.Join(got, " ") != "c.txt" {
+		t.Errorf("/z/b lists %q", got)
+	}
+	if got := readdirNames(t, fs, "/"); strings.Join(got, " ") != "ab.txt z" {
+		t.Errorf("root lists %q", got)
+	}
+	if got := readFile(t, fs, "/ab.txt"); string(got) != "not below /a" {
+		t.Errorf("/ab.txt holds %q", got)
+	}
+	if _, err := fs.Open("/a/x.txt"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("/a/x.txt still opens: %v", err)
+	}
+
+	db.failOn = "UPDATE webdav_chunks SET path = ? || substr"
+	if err := fs.Rename("/z", "/y"); err == nil {
+		t.Fatal("Rename succeeded with its last statement failing")
+	}
+	db.failOn = ""
+	if got := readFile(t, fs, "/z/b/c.txt"); string(got) != "deep content" {
+		t.Errorf("/z/b/c.txt holds %q after a failed Rename", got)
+	}
+	if _, err := fs.Open("/y"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("/y exists after a failed Rename: %v", err)
+	}
+
+	for _, tc := range []struct{ from, to string }{
+		{"/z", "/z/b/inside"},
+		{"/ab.txt", "/z/b"},
+		{"/missing", "/m"},
+		{"/ab.txt", "/missing/ab.txt"},
+	} {
+		if err := fs.Rename(tc.from, tc.to); err == nil {
+			t.Errorf("Rename(%s, %s) succeeded", tc.from, tc.to)
+		}
+	}
+
+	// a file replaces a file
+	if err := fs.Rename("/ab.txt", "/z/x.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, fs, "/z/x.txt"); string(got) != "not below /a" {
+		t.Errorf("replaced file holds %q", got)
+	}
+}
+
+// TestMigrateTwice checks a second Migrate, as on every start, changes
+// nothing, and that the DDL follows the dialect
+func TestMigrateTwice(t *testing.T) {
+	for _, postgres := range []bool{false, true} {
+		fs, db := newFakeFS(t, postgres, 0)
+		ddl := len(db.tables.ddl)
+		if err := fs.Migrate(); err != nil {
+			t.Fatal(err)
+		}
+		if len(db.tables.schema) != 1 || db.tables.schema[0] != int64(SchemaVersion) {
+			t.Errorf("schema versions %v, want [%d]", db.tables.schema, SchemaVersion)
+		}
+		if len(db.tables.ddl) != ddl+1 {
+			// only the CREATE TABLE IF NOT EXISTS of webdav_schema
+			t.Errorf("the second Migrate ran %q", db.tables.ddl[ddl:])
+		}
+		blob := "BLOB"
+		if postgres {
+			blob = "BYTEA"
+		}
+		if all := strings.Join(db.tables.ddl, "\n"); !strings.Contains(all, "data "+blob+" NOT NULL") {
+			t.Errorf("DDL for postgres %v:\n%s", postgres, all)
+		}
+	}
+}
+
+// TestServer serves an FS, whose PUTs go through CreateTemp and Rename
+func TestServer(t *testing.T) {
+	fs, _ := newFakeFS(t, false, 8)
+	ts := httptest.NewServer(&webdav.Server{Fs: fs, TrimPrefix: "/"})
+	defer ts.Close()
+
+	do := func(method, name, body string) (int, string) {
+		t.Helper()
+		req, _ := http.NewRequest(method, ts.URL+name, strings.NewReader(body))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(b)
+	}
+	if status, _ := do("PUT", "/dir/a.txt", "stored in chunks of eight"); status != webdav.StatusCreated {
+		t.Fatalf("PUT %d", status)
+	}
+	if status, body := do("GET", "/dir/a.txt", ""); status != webdav.StatusOK || body != "stored in chunks of eight" {
+		t.Errorf("GET %d %q", status, body)
+	}
+	if got := readdirNames(t, fs, "/dir"); strings.Join(got, " ") != "a.txt" {
+		t.Errorf("/dir lists %q", got)
+	}
+	if status, _ := do("DELETE", "/dir/a.txt", ""); status != webdav.StatusNoContent {
+		t.Errorf("DELETE %d", status)
+	}
+	if status, _ := do("GET", "/dir/a.txt", ""); status != webdav.StatusNotFound {
+		t.Errorf("GET after DELETE %d", status)
+	}
+}
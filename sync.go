@@ -0,0 +1,330 @@
+package webdav
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/golang/glog"
+)
+
+// syncTokenPrefix starts the sync tokens of Server.SyncCollection, the
+// rest is the epoch of the change feed and a cursor in it
+const syncTokenPrefix = "urn:x-webdav-sync:"
+
+// DefaultMaxPropfindChildren is the most responses of a multistatus unless
+// the Server sets Server.MaxPropfindChildren
+const DefaultMaxPropfindChildren = 10000
+
+// errTooManyChildren stops a listing over Server.MaxPropfindChildren
+var errTooManyChildren = errors.New("too many responses")
+
+// UnixNamespace is the XML namespace of the owner, group and unix-mode
+// properties of Server.UnixProperties
+const UnixNamespace = "urn:x-webdav:unix"
+
+// syncCollection is the body of a sync-collection REPORT, RFC 6578
+type syncCollection struct {
+	XMLName   xml.Name `xml:"DAV: sync-collection"`
+	SyncToken string   `xml:"DAV: sync-token"`
+	SyncLevel string   `xml:"DAV: sync-level"`
+	Limit     int      `xml:"DAV: limit>nresults"`
+	Prop      struct {
+		Names []struct {
+			XMLName xml.Name
+		} `xml:",any"`
+	} `xml:"DAV: prop"`
+}
+
+// syncToken returns the sync token of cursor
+func (f *feed) syncToken(cursor uint64) string {
+	return syncTokenPrefix + f.cursor(cursor)
+}
+
+// maxPropfindChildren returns Server.MaxPropfindChildren or its default
+func (s *Server) maxPropfindChildren() int {
+	if s.MaxPropfindChildren <= 0 {
+		return DefaultMaxPropfindChildren
+	}
+	return s.MaxPropfindChildren
+}
+
+// parseSyncToken returns the cursor of a sync token of this feed
+func (f *feed) parseSyncToken(token string) (uint64, bool) {
+	rest, ok := strings.CutPrefix(token, syncTokenPrefix)
+	if !ok {
+		return 0, false
+	}
+	return f.parseCursor(rest)
+}
+
+// doReport answers REPORT, of which only sync-collection is supported, and
+// only with Server.SyncCollection.
+//
+// An empty sync token gets every member of the collection and a token;
+// a later token only the members changed since, removed ones with 404.
+// The changes come from the change feed, which only lives in memory: a
+// token of a previous process, one older than the feedSize changes the
+// feed keeps, or one from before the feed's watch lost events, is refused
+// with the DAV:valid-sync-token precondition and the client starts over
+// with an empty token. More members than
+// Server.MaxPropfindChildren, or the DAV:limit of the request, are refused
+// with 507, the listing of an empty token stopping once it has too many.
+func (s *Server) doReport(w http.ResponseWriter, r *http.Request) {
+	name := s.url2path(r.URL)
+	fi, err := s.stat(name)
+	if err != nil {
+		glog.Infoln("DAV:", "404, REPORT of missing", r.RequestURI)
+		http.Error(w, r.RequestURI, StatusNotFound)
+		return
+	}
+	if !s.SyncCollection || !fi.IsDir() {
+		s.methodNotAllowed(w, fi)
+		return
+	}
+	if d := r.Header.Get("Depth"); d != "" && d != "0" {
+		http.Error(w, "sync-collection requires Depth: 0", StatusBadRequest)
+		return
+	}
+
+	var req syncCollection
+	if err := newXMLDecoder(io.LimitReader(r.Body, 64<<10), 1000).Decode(&req); err != nil {
+		glog.Infoln("DAV:", "REPORT unsupported or bad body", r.URL, "error", err)
+		davError(w, StatusForbidden, "supported-report")
+		return
+	}
+	infinite := false
+	switch strings.TrimSpace(req.SyncLevel) {
+	case "1":
+	case "infinite":
+		infinite = true
+	default:
+		http.Error(w, "bad sync-level", StatusBadRequest)
+		return
+	}
+
+	if err := s.feed.start(s); err != nil {
+		http.Error(w, "change feed unavailable", StatusNotImplemented)
+		return
+	}
+	prefix := path.Clean("/" + name)
+	member := func(p string) bool {
+		rel := strings.TrimPrefix(p, prefix)
+		if prefix == "/" {
+			rel = p
+		}
+		return p != prefix && (infinite || strings.Count(rel, "/") == 1)
+	}
+
+	limit := s.maxPropfindChildren()
+	if req.Limit > 0 && req.Limit < limit {
+		limit = req.Limit
+	}
+
+	// members in the order found, true for those that were removed
+	var names []string
+	removed := map[string]bool{}
+	var next uint64
+	if token := strings.TrimSpace(req.SyncToken); token == "" {
+		// the cursor is taken before listing, changes made meanwhile are
+		// reported again next time
+		_, next, _, _ = s.feed.since(0, prefix)
+		err := Walk(s.Fs, name, func(p string, fi os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			p = path.Clean("/" + p)
+			if p == prefix {
+				return nil
+			}
+			if strings.HasPrefix(fi.Name(), TempPrefix) {
+				return nil
+			}
+			if names = append(names, p); len(names) > limit {
+				return errTooManyChildren
+			}
+			if fi.IsDir() && !infinite {
+				return fs.SkipDir
+			}
+			return nil
+		})
+		if err != nil && err != errTooManyChildren {
+			glog.Infoln("DAV:", "REPORT error listing", name, "error", err)
+			w.WriteHeader(StatusInternalServerError)
+			return
+		}
+	} else {
+		cursor, ok := s.feed.parseSyncToken(token)
+		var changes []change
+		if ok {
+			changes, next, _, ok = s.feed.since(cursor, prefix)
+		}
+		if !ok {
+			glog.Infoln("DAV:", "REPORT sync token expired", token)
+			davError(w, StatusForbidden, "valid-sync-token")
+			return
+		}
+		for _, c := range changes {
+			if !member(c.Path) {
+				continue
+			}
+			if _, seen := removed[c.Path]; !seen {
+				names = append(names, c.Path)
+			}
+			// the latest change of a path decides
+			removed[c.Path] = c.Op == OpRemove.String() || c.Op == OpRename.String()
+		}
+	}
+
+	if len(names) > limit {
+		glog.Infoln("DAV:", "REPORT of more than", limit, "members", r.URL)
+		davError(w, StatusInsufficientStorage, "number-of-matches-within-limits")
+		return
+	}
+
+	props := req.Prop.Names
+	if len(props) == 0 {
+		props = append(props, struct{ XMLName xml.Name }{xml.Name{Space: "DAV:", Local: "getetag"}})
+	}
+	base := strings.TrimSuffix(r.URL.Path, "/")
+
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="utf-8"?>` + "\n" + `<D:multistatus xmlns:D="DAV:">`)
+	for _, p := range names {
+		fi, err := s.stat(p)
+		href := (&url.URL{Path: base + strings.TrimPrefix(p, strings.TrimSuffix(prefix, "/"))}).EscapedPath()
+		if removed[p] || err != nil {
+			b.WriteString(`<D:response><D:href>`)
+			xml.EscapeText(&b, []byte(href))
+			b.WriteString(`</D:href><D:status>HTTP/1.1 404 Not Found</D:status></D:response>`)
+			continue
+		}
+		if fi.IsDir() {
+			href += "/"
+		}
+
+		var found, missing strings.Builder
+		var owner *FileOwner
+		for _, prop := range props {
+			n := prop.XMLName
+			v, ok := "", n.Space == "DAV:"
+			if n.Space == UnixNamespace && s.UnixProperties {
+				if owner == nil {
+					owner = &FileOwner{UID: -1}
+					if o, ok := capability[OwnerFS](s.Fs); ok {
+						if fo, err := o.Owner(p); err == nil {
+							owner = &fo
+						}
+					}
+				}
+				if v, ok = unixProp(owner, n.Local); ok {
+					fmt.Fprintf(&found, `<u:%s xmlns:u="%s">`, n.Local, UnixNamespace)
+					xml.EscapeText(&found, []byte(v))
+					fmt.Fprintf(&found, `</u:%s>`, n.Local)
+					continue
+				}
+			}
+			if ok {
+				switch n.Local {
+				case "getetag":
+					if ok = !fi.IsDir(); ok {
+						v = s.etag(p, fi)
+					}
+				case "getcontentlength":
+					v, ok = strconv.FormatInt(fi.Size(), 10), !fi.IsDir()
+				case "getlastmodified":
+					v = fi.ModTime().UTC().Format(http.TimeFormat)
+				case "getcontenttype":
+					if !fi.IsDir() {
+						v = s.contentType(p)
+					}
+					ok = v != ""
+				case "displayname":
+					v = fi.Name()
+				case "resourcetype":
+					if fi.IsDir() {
+						found.WriteString(`<D:resourcetype><D:collection/></D:resourcetype>`)
+					} else {
+						found.WriteString(`<D:resourcetype/>`)
+					}
+					continue
+				default:
+					ok = false
+				}
+			}
+			if !ok {
+				if n.Space == "" {
+					fmt.Fprintf(&missing, `<%s xmlns=""/>`, n.Local)
+				} else {
+					fmt.Fprintf(&missing, `<x:%s xmlns:x="%s"/>`, n.Local, xmlAttr(n.Space))
+				}
+				continue
+			}
+			fmt.Fprintf(&found, `<D:%s>`, n.Local)
+			xml.EscapeText(&found, []byte(v))
+			fmt.Fprintf(&found, `</D:%s>`, n.Local)
+		}
+
+		b.WriteString(`<D:response><D:href>`)
+		xml.EscapeText(&b, []byte(href))
+		b.WriteString(`</D:href>`)
+		if found.Len() > 0 {
+			b.WriteString(`<D:propstat><D:prop>` + found.String() + `</D:prop><D:status>HTTP/1.1 200 OK</D:status></D:propstat>`)
+		}
+		if missing.Len() > 0 {
+			b.WriteString(`<D:propstat><D:prop>` + missing.String() + `</D:prop><D:status>HTTP/1.1 404 Not Found</D:status></D:propstat>`)
+		}
+		b.WriteString(`</D:response>`)
+	}
+	b.WriteString(`<D:sync-token>` + s.feed.syncToken(next) + `</D:sync-token></D:multistatus>`)
+
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(StatusMulti)
+	io.WriteString(w, b.String())
+}
+
+// unixProp returns the value of a property in UnixNamespace, false if o
+// is unknown, UID -1, or the property isn't one of them
+func unixProp(o *FileOwner, local string) (string, bool) {
+	if o.UID < 0 {
+		return "", false
+	}
+	switch local {
+	case "owner":
+		if o.User != "" {
+			return o.User, true
+		}
+		return strconv.Itoa(o.UID), true
+	case "group":
+		if o.Group != "" {
+			return o.Group, true
+		}
+		return strconv.Itoa(o.GID), true
+	case "unix-mode":
+		return fmt.Sprintf("%04o", uint32(o.Mode.Perm())), true
+	}
+	return "", false
+}
+
+// davError answers with a DAV:error body naming a failed precondition,
+// RFC 4918 section 16
+func davError(w http.ResponseWriter, status int, condition string) {
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(status)
+	io.WriteString(w, `<?xml version="1.0" encoding="utf-8"?>`+"\n"+`<D:error xmlns:D="DAV:"><D:`+condition+`/></D:error>`)
+}
+
+// xmlAttr escapes s for an XML attribute value
+func xmlAttr(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
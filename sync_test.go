@@ -0,0 +1,302 @@
+package webdav
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// syncReport is the multistatus of a sync-collection REPORT, hrefs with
+// their status, 404 for removed members
+type syncReport struct {
+	Responses []struct {
+		Href     string `xml:"DAV: href"`
+		Status   string `xml:"DAV: status"`
+		Propstat []struct {
+			Status string `xml:"DAV: status"`
+		} `xml:"DAV: propstat"`
+	} `xml:"DAV: response"`
+	SyncToken string `xml:"DAV: sync-token"`
+}
+
+// members returns "href status" of each response, sorted
+func (r syncReport) members() string {
+	var m []string
+	for _, resp := range r.Responses {
+		status := resp.Status
+		if len(resp.Propstat) > 0 {
+			status = resp.Propstat[0].Status
+		}
+		m = append(m, resp.Href+" "+strings.TrimPrefix(status, "HTTP/1.1 "))
+	}
+	sort.Strings(m)
+	return strings.Join(m, ", ")
+}
+
+func syncBody(token, level string) string {
+	return fmt.Sprintf(`<?xml version="1.0" encoding="utf-8"?>
+<D:sync-collection xmlns:D="DAV:"><D:sync-token>%s</D:sync-token><D:sync-level>%s</D:sync-level>
+<D:prop><D:displayname/><D:getcontentlength/></D:prop></D:sync-collection>`, token, level)
+}
+
+func TestSyncCollection(t *testing.T) {
+	m := NewMemFS()
+	m.Mkdir("/dir")
+	m.Mkdir("/dir/sub")
+	writeMem(t, m, "/dir/a", []byte("a"))
+	writeMem(t, m, "/dir/sub/b", []byte("b"))
+	s := &Server{Fs: m, SyncCollection: true}
+	defer s.Close()
+	ts := newTestServer(t, s)
+
+	report := func(token, level string) syncReport {
+		t.Helper()
+		resp, body := request(t, ts, "REPORT", "/dir/", syncBody(token, level))
+		wantStatus(t, resp, StatusMulti)
+		var r syncReport
+		if err := xml.Unmarshal([]byte(body), &r); err != nil {
+			t.Fatalf("%v: %s", err, body)
+		}
+		if !strings.HasPrefix(r.SyncToken, syncTokenPrefix) {
+			t.Fatalf("sync token %q", r.SyncToken)
+		}
+		return r
+	}
+
+	// an empty token lists everything
+	r := report("", "1")
+	if got := r.members(); got != "/dir/a 200 OK, /dir/sub/ 200 OK" {
+		t.Errorf("initial depth 1: %s", got)
+	}
+	if got := report("", "infinite").members(); got != "/dir/a 200 OK, /dir/sub/ 200 OK, /dir/sub/b 200 OK" {
+		t.Errorf("initial infinite: %s", got)
+	}
+
+	// then only what changed, removed members with 404; the feed is
+	// fed asynchronously, so ask until both changes are in
+	request(t, ts, "PUT", "/dir/c", "c")
+	request(t, ts, "DELETE", "/dir/a", "")
+	const want = "/dir/a 404 Not Found, /dir/c 200 OK"
+	var next syncReport
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if next = report(r.SyncToken, "1"); next.members() == want {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("changes since the initial token: %s, want %s", next.members(), want)
+		}
+	}
+	if got := report(next.SyncToken, "1").members(); got != "" {
+		t.Errorf("nothing changed, yet got %s", got)
+	}
+}
+
+// TestSyncTokenInvalid simulates each way the server loses the history of
+// a token: a restart, a resync of the watch, more changes than the feed
+// keeps. Each is refused with DAV:valid-sync-token, after which the
+// client starts over with an empty token.
+func TestSyncTokenInvalid(t *testing.T) {
+	m := NewMemFS()
+	m.Mkdir("/dir")
+	writeMem(t, m, "/dir/a", []byte("a"))
+	s := &Server{Fs: m, SyncCollection: true}
+	defer s.Close()
+	ts := newTestServer(t, s)
+
+	token := func() string {
+		t.Helper()
+		resp, body := request(t, ts, "REPORT", "/dir/", syncBody("", "1"))
+		wantStatus(t, resp, StatusMulti)
+		var r syncReport
+		xml.Unmarshal([]byte(body), &r)
+		return r.SyncToken
+	}
+	refused := func(what, old string) {
+		t.Helper()
+		resp, body := request(t, ts, "REPORT", "/dir/", syncBody(old, "1"))
+		if resp.StatusCode != StatusForbidden || !strings.Contains(body, "<D:valid-sync-token/>") {
+			t.Errorf("%s: %d %s, want 403 with DAV:valid-sync-token", what, resp.StatusCode, body)
+		}
+		// the fallback to a full sync works
+		if token() == "" {
+			t.Errorf("%s: no new token", what)
+		}
+	}
+
+	restarted := &Server{Fs: m, SyncCollection: true}
+	defer restarted.Close()
+	if err := restarted.feed.start(restarted); err != nil {
+		t.Fatal(err)
+	}
+	old := restarted.feed.syncToken(0)
+	refused("token of a previous process", old)
+
+	cur := token()
+	refused("malformed token", "urn:x-other:1")
+	refused("token of a cursor not reached yet", s.feed.syncToken(99999))
+
+	s.feed.add(change{Op: OpResync.String(), Path: "/"})
+	refused("token from before a resync", cur)
+
+	cur = token()
+	for i := 0; i <= feedSize; i++ {
+		s.feed.add(change{Op: OpWrite.String(), Path: "/dir/a"})
+	}
+	refused("token older than the feed keeps", cur)
+
+	// a token just inside the feed is still good
+	cur = token()
+	for i := 0; i < feedSize; i++ {
+		s.feed.add(change{Op: OpWrite.String(), Path: "/dir/a"})
+	}
+	resp, body := request(t, ts, "REPORT", "/dir/", syncBody(cur, "1"))
+	if resp.StatusCode != StatusMulti || !strings.Contains(body, "/dir/a") {
+		t.Errorf("token %d changes behind: %d %s", feedSize, resp.StatusCode, body)
+	}
+}
+
+// TestSyncCollectionLimit asks for fewer results than there are members,
+// which is refused with DAV:number-of-matches-within-limits
+func TestSyncCollectionLimit(t *testing.T) {
+	m := NewMemFS()
+	m.Mkdir("/dir")
+	for i := 0; i < 5; i++ {
+		writeMem(t, m, fmt.Sprintf("/dir/f%d", i), []byte("x"))
+	}
+	s := &Server{Fs: m, SyncCollection: true}
+	defer s.Close()
+	ts := newTestServer(t, s)
+
+	limited := func(n int) string {
+		return strings.Replace(syncBody("", "1"), "<D:prop>", fmt.Sprintf("<D:limit><D:nresults>%d</D:nresults></D:limit><D:prop>", n), 1)
+	}
+	resp, body := request(t, ts, "REPORT", "/dir/", limited(5))
+	if resp.StatusCode != StatusMulti || strings.Count(body, "<D:response>") != 5 {
+		t.Errorf("limit 5 of 5: %d %s", resp.StatusCode, body)
+	}
+	resp, body = request(t, ts, "REPORT", "/dir/", limited(4))
+	if resp.StatusCode != StatusInsufficientStorage || !strings.Contains(body, "<D:number-of-matches-within-limits/>") {
+		t.Errorf("limit 4 of 5: %d %s", resp.StatusCode, body)
+	}
+}
+
+// TestSyncCollectionCeiling checks the Server's own MaxPropfindChildren
+// bounds a REPORT whatever DAV:limit the client sends, or without one, for
+// a full listing and for the changes since a token
+func TestSyncCollectionCeiling(t *testing.T) {
+	m := NewMemFS()
+	m.Mkdir("/dir")
+	m.Mkdir("/dir/sub")
+	for i := 0; i < 2; i++ {
+		writeMem(t, m, fmt.Sprintf("/dir/sub/f%d", i), []byte("x"))
+	}
+	s := &Server{Fs: m, SyncCollection: true, MaxPropfindChildren: 3}
+	defer s.Close()
+	ts := newTestServer(t, s)
+
+	// unrefused returns the status and body of a REPORT unless it is
+	// refused with DAV:number-of-matches-within-limits, 0 if it is
+	unrefused := func(body string) (int, string) {
+		t.Helper()
+		resp, got := request(t, ts, "REPORT", "/dir/", body)
+		if resp.StatusCode != StatusInsufficientStorage || !strings.Contains(got, "<D:number-of-matches-within-limits/>") {
+			return resp.StatusCode, got
+		}
+		return 0, ""
+	}
+
+	// depth 1 has a single member, infinity three
+	resp, _ := request(t, ts, "REPORT", "/dir/", syncBody("", "1"))
+	wantStatus(t, resp, StatusMulti)
+	resp, body := request(t, ts, "REPORT", "/dir/", syncBody("", "infinite"))
+	wantStatus(t, resp, StatusMulti)
+	var r syncReport
+	if err := xml.Unmarshal([]byte(body), &r); err != nil {
+		t.Fatalf("%v: %s", err, body)
+	}
+
+	writeMem(t, m, "/dir/sub/f2", []byte("x"))
+	if status, got := unrefused(syncBody("", "infinite")); status != 0 {
+		t.Errorf("an infinite listing of 4: %d %s", status, got)
+	}
+	limited := strings.Replace(syncBody("", "infinite"), "<D:prop>", "<D:limit><D:nresults>100</D:nresults></D:limit><D:prop>", 1)
+	if status, got := unrefused(limited); status != 0 {
+		t.Errorf("a DAV:limit over the ceiling: %d %s", status, got)
+	}
+
+	// the feed is fed asynchronously, so ask until the changes are in
+	for i := 3; i < 6; i++ {
+		writeMem(t, m, fmt.Sprintf("/dir/sub/f%d", i), []byte("x"))
+	}
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		status, got := unrefused(syncBody(r.SyncToken, "infinite"))
+		if status == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("4 changes: %d %s", status, got)
+		}
+	}
+}
+
+// TestSyncTokenLostEvents stores files in parallel faster than the change
+// feed may keep up with: a token from before is either answered with
+// every one of them, or refused with DAV:valid-sync-token if events were
+// lost, never with members missing
+func TestSyncTokenLostEvents(t *testing.T) {
+	m := NewMemFS()
+	m.Mkdir("/dir")
+	s := &Server{Fs: m, SyncCollection: true}
+	defer s.Close()
+	ts := newTestServer(t, s)
+
+	resp, body := request(t, ts, "REPORT", "/dir/", syncBody("", "1"))
+	wantStatus(t, resp, StatusMulti)
+	var start syncReport
+	if err := xml.Unmarshal([]byte(body), &start); err != nil {
+		t.Fatal(err)
+	}
+
+	const n = 400
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, 50)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			req, _ := http.NewRequest("PUT", fmt.Sprintf("%s/dir/f%03d", ts.URL, i), strings.NewReader("x"))
+			if resp, err := ts.Client().Do(req); err == nil {
+				resp.Body.Close()
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	// the feed is fed asynchronously, so ask until it has caught up
+	for deadline := time.Now().Add(10 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		resp, body := request(t, ts, "REPORT", "/dir/", syncBody(start.SyncToken, "1"))
+		if resp.StatusCode == StatusForbidden && strings.Contains(body, "<D:valid-sync-token/>") {
+			return
+		}
+		if resp.StatusCode != StatusMulti {
+			t.Fatalf("REPORT %d %s", resp.StatusCode, body)
+		}
+		var r syncReport
+		if err := xml.Unmarshal([]byte(body), &r); err != nil {
+			t.Fatal(err)
+		}
+		if len(r.Responses) == n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d of %d members reported, and the token is still valid", len(r.Responses), n)
+		}
+	}
+}
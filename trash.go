@@ -0,0 +1,211 @@
+package webdav
+
+import (
+	"encoding/xml"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/golang/glog"
+)
+
+// TrashNamespace is the XML namespace of the properties of trash entries
+const TrashNamespace = "urn:x-webdav:trash"
+
+// trashRequest returns the Trasher of the Server and the entry ID named by
+// r, "" for the trash collection itself, if r is for Server.ServeTrash
+func (s *Server) trashRequest(r *http.Request) (Trasher, string, bool) {
+	if !s.ServeTrash {
+		return nil, "", false
+	}
+	name := s.url2path(r.URL)
+	if name != trashName && !strings.HasPrefix(name, trashName+"/") {
+		return nil, "", false
+	}
+	t, ok := capability[Trasher](s.Fs)
+	return t, strings.Trim(strings.TrimPrefix(name, trashName), "/"), ok
+}
+
+// allowTrash returns the methods valid on the trash collection, id "", or
+// on one of its entries
+func (s *Server) allowTrash(id string) string {
+	methods := []string{"OPTIONS", "PROPFIND"}
+	if id != "" && !s.ReadOnly {
+		methods = append(methods, "MOVE")
+		if !s.DeletesDisabled {
+			methods = append(methods, "DELETE")
+		}
+	}
+	return strings.Join(methods, ", ")
+}
+
+// serveTrash answers a request to /.trash for Server.ServeTrash: PROPFIND
+// lists the trash, MOVE of an entry restores it to the Destination, and
+// DELETE of one purges it
+func (s *Server) serveTrash(w http.ResponseWriter, r *http.Request, t Trasher, id string) {
+	var entry *TrashEntry
+	if id != "" {
+		e, err := t.TrashEntry(id)
+		if err != nil {
+			glog.Infoln("DAV:", "404, no trash entry", r.RequestURI, "error", err)
+			http.Error(w, r.RequestURI, StatusNotFound)
+			return
+		}
+		entry = &e
+	}
+
+	switch r.Method {
+	case "OPTIONS":
+		w.Header().Set("Allow", s.allowTrash(id))
+		w.WriteHeader(StatusOK)
+	case "PROPFIND":
+		s.propfindTrash(w, r, t, entry)
+	case "MOVE":
+		if entry == nil || s.ReadOnly {
+			glog.Infoln("DAV:", "MOVE of the trash refused", r.URL)
+			w.WriteHeader(StatusForbidden)
+			return
+		}
+		s.restoreTrash(w, r, t, *entry)
+	case "DELETE":
+		if entry == nil || s.ReadOnly || s.DeletesDisabled {
+			glog.Infoln("DAV:", "DELETE in the trash refused", r.URL)
+			w.WriteHeader(StatusForbidden)
+			return
+		}
+		if err := t.Purge(id); err != nil {
+			glog.Infoln("DAV:", "trash purge error", id, "error", err)
+			w.WriteHeader(StatusInternalServerError)
+			return
+		}
+		glog.Infoln("DAV:", "trash purged", entry.Path, "id", id)
+		w.WriteHeader(StatusNoContent)
+	default:
+		w.Header().Set("Allow", s.allowTrash(id))
+		w.WriteHeader(StatusMethodNotAllowed)
+	}
+}
+
+// propfindTrash answers PROPFIND of the trash, entry nil, or of an entry.
+// Every property is sent whatever the body asks for: displayname, the
+// resourcetype, getcontentlength, and from TrashNamespace original-path,
+// the path the entry was removed from, and deletion-time. A trash of more
+// than Server.MaxPropfindChildren entries isn't listed, it is answered
+// with 507 and the DAV:number-of-matches-within-limits precondition.
+func (s *Server) propfindTrash(w http.ResponseWriter, r *http.Request, t Trasher, entry *TrashEntry) {
+	base := strings.TrimSuffix(r.URL.Path, "/")
+	var entries []TrashEntry
+	if entry != nil {
+		base = strings.TrimSuffix(base, "/"+entry.ID)
+		entries = []TrashEntry{*entry}
+	} else if r.Header.Get("Depth") != "0" {
+		var err error
+		limit := s.maxPropfindChildren()
+		if entries, err = t.Trash(limit + 1); err != nil {
+			glog.Infoln("DAV:", "trash listing error", err)
+			w.WriteHeader(StatusInternalServerError)
+			return
+		}
+		if len(entries) > limit {
+			glog.Infoln("DAV:", "trash too large to list", r.URL)
+			davError(w, StatusInsufficientStorage, "number-of-matches-within-limits")
+			return
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="utf-8"?>` + "\n" + `<D:multistatus xmlns:D="DAV:" xmlns:T="` + TrashNamespace + `">`)
+	if entry == nil {
+		b.WriteString(`<D:response><D:href>`)
+		xml.EscapeText(&b, []byte((&url.URL{Path: base + "/"}).EscapedPath()))
+		b.WriteString(`</D:href><D:propstat><D:prop><D:displayname>` + trashName + `</D:displayname>` +
+			`<D:resourcetype><D:collection/></D:resourcetype></D:prop><D:status>HTTP/1.1 200 OK</D:status></D:propstat></D:response>`)
+	}
+	for _, e := range entries {
+		b.WriteString(`<D:response><D:href>`)
+		xml.EscapeText(&b, []byte((&url.URL{Path: base + "/" + e.ID}).EscapedPath()))
+		b.WriteString(`</D:href><D:propstat><D:prop><D:displayname>`)
+		xml.EscapeText(&b, []byte(path.Base(e.Path)))
+		b.WriteString(`</D:displayname><D:resourcetype/><D:getcontentlength>` + strconv.FormatInt(e.Size, 10) + `</D:getcontentlength>`)
+		b.WriteString(`<T:original-path>`)
+		xml.EscapeText(&b, []byte(e.Path))
+		b.WriteString(`</T:original-path><T:deletion-time>` + e.Deleted.UTC().Format(http.TimeFormat) + `</T:deletion-time>`)
+		b.WriteString(`</D:prop><D:status>HTTP/1.1 200 OK</D:status></D:propstat></D:response>`)
+	}
+	b.WriteString(`</D:multistatus>`)
+
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(StatusMulti)
+	io.WriteString(w, b.String())
+}
+
+// restoreTrash answers MOVE of a trash entry, restoring it to the
+// Destination as a PUT there would store it: the parent must exist, an
+// existing file is only replaced without Overwrite: F, and the If header
+// is evaluated against it
+func (s *Server) restoreTrash(w http.ResponseWriter, r *http.Request, t Trasher, e TrashEntry) {
+	dest, err := url.Parse(r.Header.Get("Destination"))
+	ok := err == nil && dest.Path != ""
+	if ok {
+		dest.Path, ok = s.requestPath(dest)
+	}
+	if !ok {
+		glog.Infoln("DAV:", "MOVE bad destination", r.Header.Get("Destination"))
+		w.WriteHeader(StatusBadRequest)
+		return
+	}
+	dst := s.url2path(dest)
+	if isTrashPath(dst) || path.Clean("/"+dst) == "/" {
+		w.WriteHeader(StatusForbidden)
+		return
+	}
+
+	if pfi, err := s.stat(path.Dir(path.Clean("/" + dst))); err != nil || !pfi.IsDir() {
+		glog.Infoln("DAV:", "MOVE destination has no parent", dst)
+		w.WriteHeader(StatusConflict)
+		return
+	}
+	dfi, err := s.stat(dst)
+	exists := err == nil
+	if !exists {
+		dfi = nil
+	}
+	if exists && dfi.IsDir() {
+		glog.Infoln("DAV:", "MOVE onto a collection refused", dst)
+		w.WriteHeader(StatusForbidden)
+		return
+	}
+	if exists && r.Header.Get("Overwrite") == "F" {
+		w.WriteHeader(StatusPreconditionFailed)
+		return
+	}
+	if status := s.checkIf(r.Header.Get("If"), dst, dfi); status != 0 {
+		w.WriteHeader(status)
+		return
+	}
+
+	if err := t.Restore(e.ID, dst); err != nil {
+		glog.Infoln("DAV:", "trash restore error", e.ID, "to", dst, "error", err)
+		if errors.Is(err, os.ErrNotExist) {
+			w.WriteHeader(StatusNotFound)
+		} else {
+			w.WriteHeader(StatusInternalServerError)
+		}
+		return
+	}
+	glog.Infoln("DAV:", "trash restored", e.Path, "to", dst)
+	if exists {
+		s.stored(r, e.Size-dfi.Size())
+		s.publish(OpWrite, dst)
+		w.WriteHeader(StatusNoContent)
+	} else {
+		s.stored(r, e.Size)
+		s.publish(OpCreate, dst)
+		w.WriteHeader(StatusCreated)
+	}
+}
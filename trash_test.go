@@ -0,0 +1,455 @@
+package webdav
+
+import (
+	"encoding/xml"
+	"errors"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// trashListing is a PROPFIND answer of the trash
+type trashListing struct {
+	Responses []struct {
+		Href         string `xml:"DAV: href"`
+		Length       string `xml:"propstat>prop>getcontentlength"`
+		OriginalPath string `xml:"propstat>prop>original-path"`
+		DeletionTime string `xml:"propstat>prop>deletion-time"`
+	} `xml:"DAV: response"`
+}
+
+// TestTrashOverHTTP deletes files, lists the trash, restores and purges
+// them, all through HTTP
+func TestTrashOverHTTP(t *testing.T) {
+	for name, inner := range map[string]func(t *testing.T) FileSystem{
+		"dir": func(t *testing.T) FileSystem {
+			root := t.TempDir()
+			os.Mkdir(filepath.Join(root, "dir"), 0o755)
+			return Dir(root)
+		},
+		"memfs": func(t *testing.T) FileSystem {
+			m := NewMemFS()
+			m.Mkdir("/dir")
+			return m
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			tfs, err := NewTrashFS(NewLockedFS(inner(t)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			s := &Server{Fs: tfs, ServeTrash: true, Listings: true}
+			ts := newTestServer(t, s)
+			list := func(depth string) trashListing {
+				t.Helper()
+				resp, body := request(t, ts, "PROPFIND", "/.trash", "", "Depth", depth)
+				wantStatus(t, resp, StatusMulti)
+				var l trashListing
+				if err := xml.Unmarshal([]byte(body), &l); err != nil {
+					t.Fatal(err)
+				}
+				return l
+			}
+
+			// delete
+			request(t, ts, "PUT", "/dir/a.txt", "the original")
+			before := time.Now().Add(-time.Second)
+			resp, _ := request(t, ts, "DELETE", "/dir/a.txt", "")
+			wantStatus(t, resp, StatusNoContent)
+			resp, _ = request(t, ts, "GET", "/dir/a.txt", "")
+			wantStatus(t, resp, StatusNotFound)
+
+			// the trash isn't part of the tree
+			resp, body := request(t, ts, "GET", "/", "", "Accept", "application/json")
+			wantStatus(t, resp, StatusOK)
+			if strings.Contains(body, trashName) {
+				t.Errorf("the root lists the trash: %s", body)
+			}
+
+			// list
+			l := list("1")
+			if len(l.Responses) != 2 || l.Responses[0].Href != "/.trash/" {
+				t.Fatalf("trash listing %+v", l.Responses)
+			}
+			e := l.Responses[1]
+			if e.OriginalPath != "/dir/a.txt" || e.Length != "12" || !strings.HasPrefix(e.Href, "/.trash/") {
+				t.Errorf("trash entry %+v", e)
+			}
+			if deleted, err := time.Parse(time.RFC1123, e.DeletionTime); err != nil || deleted.Before(before) {
+				t.Errorf("deletion-time %q: %v", e.DeletionTime, err)
+			}
+			if l := list("0"); len(l.Responses) != 1 {
+				t.Errorf("Depth: 0 answered %+v", l.Responses)
+			}
+			resp, _ = request(t, ts, "PROPFIND", e.Href, "")
+			wantStatus(t, resp, StatusMulti)
+
+			// restore, under the rules of a PUT to the destination
+			request(t, ts, "PUT", "/dir/a.txt", "a newer file")
+			for _, tc := range []struct {
+				dest      string
+				overwrite string
+				status    int
+			}{
+				{"/dir/a.txt", "F", StatusPreconditionFailed},
+				{"/missing/a.txt", "", StatusConflict},
+				{"/dir", "", StatusForbidden},
+				{"/.trash/a.txt", "", StatusForbidden},
+			} {
+				h := []string{"Destination", ts.URL + tc.dest}
+				if tc.overwrite != "" {
+					h = append(h, "Overwrite", tc.overwrite)
+				}
+				resp, _ := request(t, ts, "MOVE", e.Href, "", h...)
+				if resp.StatusCode != tc.status {
+					t.Errorf("MOVE to %s, Overwrite %q: %d, want %d", tc.dest, tc.overwrite, resp.StatusCode, tc.status)
+				}
+			}
+			resp, _ = request(t, ts, "MOVE", e.Href, "", "Destination", ts.URL+"/dir/a.txt")
+			wantStatus(t, resp, StatusNoContent)
+			if _, body := request(t, ts, "GET", "/dir/a.txt", ""); body != "the original" {
+				t.Errorf("restored file holds %q", body)
+			}
+			resp, _ = request(t, ts, "PROPFIND", e.Href, "")
+			wantStatus(t, resp, StatusNotFound)
+
+			// the newer file was replaced as by a PUT, restore the original
+			// again elsewhere
+			if l := list("1"); len(l.Responses) != 1 {
+				t.Fatalf("trash listing after the restore %+v", l.Responses)
+			}
+			request(t, ts, "DELETE", "/dir/a.txt", "")
+			l = list("1")
+			if len(l.Responses) != 2 || l.Responses[1].OriginalPath != "/dir/a.txt" {
+				t.Fatalf("trash listing %+v", l.Responses)
+			}
+			resp, _ = request(t, ts, "MOVE", l.Responses[1].Href, "", "Destination", ts.URL+"/dir/b.txt")
+			wantStatus(t, resp, StatusCreated)
+			if _, body := request(t, ts, "GET", "/dir/b.txt", ""); body != "the original" {
+				t.Errorf("restored file holds %q", body)
+			}
+
+			// purge
+			request(t, ts, "DELETE", "/dir/b.txt", "")
+			l = list("1")
+			if len(l.Responses) != 2 {
+				t.Fatalf("trash listing %+v", l.Responses)
+			}
+			resp, _ = request(t, ts, "DELETE", l.Responses[1].Href, "")
+			wantStatus(t, resp, StatusNoContent)
+			resp, _ = request(t, ts, "DELETE", l.Responses[1].Href, "")
+			wantStatus(t, resp, StatusNotFound)
+			if l := list("1"); len(l.Responses) != 1 {
+				t.Errorf("trash listing after the purge %+v", l.Responses)
+			}
+			resp, _ = request(t, ts, "DELETE", "/.trash", "")
+			wantStatus(t, resp, StatusForbidden)
+		})
+	}
+}
+
+// TestTrashOptions checks the trash follows ReadOnly and DeletesDisabled,
+// and is only served with ServeTrash over a Trasher
+func TestTrashOptions(t *testing.T) {
+	m := NewMemFS()
+	writeMem(t, m, "/a.txt", []byte("a"))
+	tfs, err := NewTrashFS(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tfs.Remove("/a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := tfs.Trash(0)
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("trash %+v, %v", entries, err)
+	}
+	href := "/.trash/" + entries[0].ID
+
+	for _, tc := range []struct {
+		name         string
+		s            *Server
+		move, delete int
+	}{
+		{"ReadOnly", &Server{Fs: tfs, ServeTrash: true, ReadOnly: true}, StatusForbidden, StatusForbidden},
+		{"DeletesDisabled", &Server{Fs: tfs, ServeTrash: true, DeletesDisabled: true}, StatusCreated, StatusForbidden},
+		{"without ServeTrash", &Server{Fs: tfs}, StatusMethodNotAllowed, StatusNotFound},
+		{"without a Trasher", &Server{Fs: m, ServeTrash: true}, StatusMethodNotAllowed, StatusNotFound},
+	} {
+		ts := newTestServer(t, tc.s)
+		resp, _ := request(t, ts, "DELETE", href, "")
+		if resp.StatusCode != tc.delete {
+			t.Errorf("%s: DELETE %d, want %d", tc.name, resp.StatusCode, tc.delete)
+		}
+		resp, _ = request(t, ts, "MOVE", href, "", "Destination", ts.URL+"/b.txt")
+		if resp.StatusCode != tc.move {
+			t.Errorf("%s: MOVE %d, want %d", tc.name, resp.StatusCode, tc.move)
+		}
+		if resp.StatusCode == StatusCreated {
+			tfs.Remove("/b.txt")
+			entries, _ := tfs.Trash(0)
+			href = "/.trash/" + entries[0].ID
+		}
+		if c := tc.s.Capabilities(); c.Trash != (tc.s.ServeTrash && tc.s.Fs == FileSystem(tfs)) {
+			t.Errorf("%s: capabilities %+v", tc.name, c)
+		}
+	}
+
+	ts := newTestServer(t, &Server{Fs: tfs, ServeTrash: true})
+	for _, method := range []string{"GET", "PUT"} {
+		resp, _ := request(t, ts, method, href, "")
+		wantStatus(t, resp, StatusMethodNotAllowed)
+		if allow := resp.Header.Get("Allow"); allow != "OPTIONS, PROPFIND, MOVE, DELETE" {
+			t.Errorf("%s of an entry: Allow %q", method, allow)
+		}
+	}
+	resp, _ := request(t, ts, "OPTIONS", "/.trash", "")
+	if allow := resp.Header.Get("Allow"); allow != "OPTIONS, PROPFIND" {
+		t.Errorf("OPTIONS of the trash: Allow %q", allow)
+	}
+}
+
+// TestTrashFSLayout checks NewTrashFS refuses a /.trash it didn't make,
+// reopens its own, and keeps the trash out of reach through itself
+func TestTrashFSLayout(t *testing.T) {
+	root := t.TempDir()
+	os.Mkdir(filepath.Join(root, trashName), 0o755)
+	if _, err := NewTrashFS(Dir(root)); !errors.Is(err, errNotTrash) {
+		t.Errorf("NewTrashFS over a directory .trash: %v", err)
+	}
+	m := NewMemFS()
+	writeMem(t, m, "/"+trashName, []byte("a file"))
+	if _, err := NewTrashFS(m); !errors.Is(err, errNotTrash) {
+		t.Errorf("NewTrashFS over a file .trash: %v", err)
+	}
+	if _, err := NewTrashFS(struct{ FileSystem }{NewMemFS()}); err == nil {
+		t.Error("NewTrashFS over a FileSystem that can't rename")
+	}
+
+	root = t.TempDir()
+	os.WriteFile(filepath.Join(root, "a.txt"), []byte("a"), 0o644)
+	tfs, err := NewTrashFS(Dir(root))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tfs.Remove("/a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	again, err := NewTrashFS(Dir(root))
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := again.Trash(0)
+	if err != nil || len(entries) != 1 || entries[0].Path != "/a.txt" {
+		t.Fatalf("trash reopened %+v, %v", entries, err)
+	}
+
+	entry := path.Join(trashFiles, entries[0].ID)
+	for name, err := range map[string]error{
+		"open":     func() error { _, err := tfs.Open("/.trash/info"); return err }(),
+		"create":   func() error { _, err := tfs.Create("/.trash/x"); return err }(),
+		"mkdir":    tfs.Mkdir("/.trash/x"),
+		"remove":   tfs.Remove("/.trash/files"),
+		"rename":   tfs.Rename("/.trash/files", "/x"),
+		"chtimes":  tfs.Chtimes(entry, time.Now(), time.Unix(0, 0)),
+		"chmod":    tfs.Chmod(entry, 0o777),
+		"checksum": func() error { _, err := tfs.Checksum(entry, "SHA-256"); return err }(),
+		"owner":    func() error { _, err := tfs.Owner(entry); return err }(),
+		"etag":     func() error { _, err := tfs.ETag(entry); return err }(),
+	} {
+		if name == "etag" && err == ErrNotImplemented {
+			continue // Dir has no ETags of its own
+		}
+		if !errors.Is(err, os.ErrNotExist) && !errors.Is(err, os.ErrPermission) {
+			t.Errorf("%s in the trash: %v", name, err)
+		}
+	}
+	tfs.CacheChecksum(entry, "SHA-256", "abc", 1)
+	if sum, ok := tfs.CachedChecksum(entry, "SHA-256"); ok {
+		t.Errorf("cached checksum %s of a trash entry", sum)
+	}
+	if fi, err := os.Stat(filepath.Join(root, filepath.FromSlash(entry))); err != nil || fi.ModTime().Unix() == 0 || fi.Mode().Perm() == 0o777 {
+		t.Errorf("trash entry changed: %v, %v", fi, err)
+	}
+	if _, err := Dir(root).Open("/.trash/info"); err != nil {
+		t.Errorf("the trash isn't where it is expected: %v", err)
+	}
+}
+
+// TestTrashFSWatch checks Watch leaves out the trash, and stops even with
+// events nobody receives
+func TestTrashFSWatch(t *testing.T) {
+	m := NewMemFS()
+	writeMem(t, m, "/a.txt", []byte("a"))
+	tfs, err := NewTrashFS(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := runtime.NumGoroutine()
+	events, stop, err := tfs.Watch("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tfs.Remove("/a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	writeMem(t, m, "/b.txt", []byte("b"))
+
+	var seen []string
+	timeout := time.After(5 * time.Second)
+	for len(seen) == 0 || seen[len(seen)-1] != "/b.txt" {
+		select {
+		case ev := <-events:
+			seen = append(seen, ev.Path)
+		case <-timeout:
+			t.Fatalf("events %q", seen)
+		}
+	}
+	for _, p := range seen {
+		if isTrashPath(p) {
+			t.Errorf("event of the trash %s in %q", p, seen)
+		}
+	}
+
+	// an event left unreceived doesn't hold up the stop
+	tfs.Remove("/b.txt")
+	time.Sleep(50 * time.Millisecond)
+	stop()
+	waitGoroutines(t, before)
+}
+
+// TestTrashFSAbortedUpload checks the temporary file of an upload cut
+// short is removed for good, not kept in the trash
+func TestTrashFSAbortedUpload(t *testing.T) {
+	root := t.TempDir()
+	tfs, err := NewTrashFS(Dir(root))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{Fs: tfs, ServeTrash: true}
+	putSevered(t, s, "/a.txt", 100)
+
+	if entries, err := tfs.Trash(0); err != nil || len(entries) != 0 {
+		t.Errorf("trash after an aborted upload %+v, %v", entries, err)
+	}
+	if tmp := tempEntries(t, root); len(tmp) > 0 {
+		t.Errorf("temporary files left: %v", tmp)
+	}
+}
+
+// TestTrashFSFailedExclusiveUpload checks a PUT with If-None-Match: *,
+// written in place rather than to a temporary file, that fails leaves
+// nothing in the trash
+func TestTrashFSFailedExclusiveUpload(t *testing.T) {
+	for name, wrap := range map[string]func(FileSystem) FileSystem{
+		"trashfs":           func(fs FileSystem) FileSystem { return fs },
+		"lockedfs over it":  func(fs FileSystem) FileSystem { return NewLockedFS(fs) },
+		"readahead over it": func(fs FileSystem) FileSystem { return NewReadAheadFS(fs, 0, 0) },
+	} {
+		t.Run(name, func(t *testing.T) {
+			tfs, err := NewTrashFS(NewMemFS())
+			if err != nil {
+				t.Fatal(err)
+			}
+			s := &Server{Fs: wrap(tfs), TrimPrefix: "/"}
+			r := httptest.NewRequest("PUT", "/a.txt", strings.NewReader("hello"))
+			r.ContentLength = 10
+			r.Header.Set("If-None-Match", "*")
+			w := httptest.NewRecorder()
+			s.ServeHTTP(w, r)
+			if w.Code != StatusBadRequest {
+				t.Errorf("PUT of a short body: %d %q", w.Code, w.Body)
+			}
+
+			if entries, err := tfs.Trash(0); err != nil || len(entries) != 0 {
+				t.Errorf("trash after a failed upload %+v, %v", entries, err)
+			}
+			if _, err := statName(tfs, "/a.txt"); !errors.Is(err, os.ErrNotExist) {
+				t.Errorf("/a.txt after a failed upload: %v", err)
+			}
+			// a file stored before still goes to the trash
+			writeMem(t, tfs.Unwrap().(*MemFS), "/b.txt", []byte("b"))
+			if err := s.Fs.Remove("/b.txt"); err != nil {
+				t.Fatal(err)
+			}
+			if entries, _ := tfs.Trash(0); len(entries) != 1 || entries[0].Path != "/b.txt" {
+				t.Errorf("trash %+v, want /b.txt", entries)
+			}
+		})
+	}
+}
+
+// TestTrashFSEntries checks entries are found by ID, and that Trash lists
+// the oldest first and as many as asked
+func TestTrashFSEntries(t *testing.T) {
+	m := NewMemFS()
+	tfs, err := NewTrashFS(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"/a.txt", "/b.txt", "/c.txt"} {
+		writeMem(t, m, name, []byte(name))
+		if err := tfs.Remove(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	all, err := tfs.Trash(0)
+	if err != nil || len(all) != 3 {
+		t.Fatalf("trash %+v, %v", all, err)
+	}
+	for i, name := range []string{"/a.txt", "/b.txt", "/c.txt"} {
+		if all[i].Path != name {
+			t.Errorf("entry %d is %s, want %s", i, all[i].Path, name)
+		}
+	}
+	if two, err := tfs.Trash(2); err != nil || len(two) != 2 || two[1] != all[1] {
+		t.Errorf("Trash(2) = %+v, %v", two, err)
+	}
+
+	if e, err := tfs.TrashEntry(all[2].ID); err != nil || e != all[2] {
+		t.Errorf("TrashEntry(%s) = %+v, %v", all[2].ID, e, err)
+	}
+	for _, id := range []string{"", "..", "missing", "../files/" + all[0].ID} {
+		if _, err := tfs.TrashEntry(id); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("TrashEntry(%q): %v", id, err)
+		}
+	}
+}
+
+// TestTrashListingLimit checks a trash of more entries than
+// MaxPropfindChildren is refused with 507 rather than listed, while its
+// entries can still be asked for one by one
+func TestTrashListingLimit(t *testing.T) {
+	m := NewMemFS()
+	tfs, err := NewTrashFS(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{Fs: tfs, ServeTrash: true, MaxPropfindChildren: 2}
+	ts := newTestServer(t, s)
+	for _, name := range []string{"/a.txt", "/b.txt", "/c.txt"} {
+		// up to the ceiling, the trash is listed
+		resp, _ := request(t, ts, "PROPFIND", "/.trash", "", "Depth", "1")
+		wantStatus(t, resp, StatusMulti)
+		writeMem(t, m, name, []byte(name))
+		if err := tfs.Remove(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	resp, body := request(t, ts, "PROPFIND", "/.trash", "", "Depth", "1")
+	if resp.StatusCode != StatusInsufficientStorage || !strings.Contains(body, "<D:number-of-matches-within-limits/>") {
+		t.Errorf("listing 3 entries of at most 2: %d %s", resp.StatusCode, body)
+	}
+	resp, _ = request(t, ts, "PROPFIND", "/.trash", "", "Depth", "0")
+	wantStatus(t, resp, StatusMulti)
+	entries, _ := tfs.Trash(1)
+	resp, _ = request(t, ts, "PROPFIND", "/.trash/"+entries[0].ID, "", "Depth", "0")
+	wantStatus(t, resp, StatusMulti)
+}
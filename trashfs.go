@@ -0,0 +1,473 @@
+package webdav
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// trashName is the directory at the root of the inner FileSystem a TrashFS
+// keeps the trash in, and the collection Server.ServeTrash serves it as
+const trashName = ".trash"
+
+// the layout of the trash directory: the removed files by ID, and next to
+// them what is known of each
+const (
+	trashFiles = "/" + trashName + "/files"
+	trashInfo  = "/" + trashName + "/info"
+)
+
+// errNotTrash is returned by NewTrashFS for a /.trash it didn't make
+var errNotTrash = errors.New("webdav: /" + trashName + " exists and is not a trash")
+
+// TrashFS keeps the files removed from a FileSystem in a trash, the
+// directory /.trash of it, where they can be listed, restored and purged
+// as a Trasher. Remove renames a file there, so the inner FileSystem must
+// be a Renamer. Empty directories, all that Remove removes of them, and
+// temporary files, whose names start with TempPrefix, are removed without
+// going to the trash, as is what a failed upload to a Server wrote.
+//
+// The trash directory is hidden: it isn't listed, can't be opened, and
+// names in it can't be created, renamed or removed. Events of it are left
+// out of Watch.
+//
+// TrashFS is a Trasher by itself, whatever the inner FileSystem is. Its
+// other optional interfaces are passed to the inner FileSystem, returning
+// ErrNotImplemented where it has none, after refusing names in the trash.
+type TrashFS struct {
+	fs FileSystem
+	rn Renamer
+}
+
+// trashInfoFile is what the trash keeps of a removed file
+type trashInfoFile struct {
+	Path    string    `json:"path"`
+	Deleted time.Time `json:"deleted"`
+}
+
+// NewTrashFS wraps fs in a TrashFS, creating its trash directory. It fails
+// if fs is not a Renamer or already has a /.trash that isn't a trash, which
+// would be hidden.
+func NewTrashFS(fs FileSystem) (*TrashFS, error) {
+	rn, ok := capability[Renamer](fs)
+	if !ok {
+		return nil, errors.New("webdav: TrashFS needs a FileSystem that can rename")
+	}
+	if f, err := fs.Open("/" + trashName); err == nil {
+		f.Close()
+		if fi, err := statName(fs, trashInfo); err != nil || !fi.IsDir() {
+			return nil, errNotTrash
+		}
+	}
+	for _, dir := range []string{trashFiles, trashInfo} {
+		if err := fs.Mkdir(dir); err != nil {
+			return nil, err
+		}
+	}
+	return &TrashFS{fs: fs, rn: rn}, nil
+}
+
+// statName returns the FileInfo of name in fs
+func statName(fs FileSystem, name string) (os.FileInfo, error) {
+	f, err := fs.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return f.Stat()
+}
+
+// isTrashPath reports whether name is the trash directory or in it
+func isTrashPath(name string) bool {
+	name = path.Clean("/" + name)
+	return name == "/"+trashName || strings.HasPrefix(name, "/"+trashName+"/")
+}
+
+// hidden returns the error for an operation op on name in the trash
+func hidden(op, name string, err error) error {
+	return &os.PathError{Op: op, Path: name, Err: err}
+}
+
+// Unwrap returns the inner FileSystem
+func (t *TrashFS) Unwrap() FileSystem {
+	return t.fs
+}
+
+// provides tells supports that TrashFS is a Trasher and a partialRemover
+// by itself, not by forwarding to the inner FileSystem
+func (t *TrashFS) provides(iface any) bool {
+	switch iface.(type) {
+	case *Trasher, *partialRemover:
+		return true
+	}
+	return false
+}
+
+// partialRemover is a FileSystem whose Remove keeps what it removes, like
+// TrashFS, with a way around that for the partly written destination of
+// a failed upload, which never held a file worth keeping
+type partialRemover interface {
+	removePartial(name string) error
+}
+
+// removePartial removes name with the inner FileSystem, for good
+func (t *TrashFS) removePartial(name string) error {
+	if isTrashPath(name) {
+		return hidden("remove", name, os.ErrPermission)
+	}
+	return t.fs.Remove(name)
+}
+
+// Open opens name with the inner FileSystem, leaving the trash out of the
+// root directory
+func (t *TrashFS) Open(name string) (File, error) {
+	if isTrashPath(name) {
+		return nil, hidden("open", name, os.ErrNotExist)
+	}
+	f, err := t.fs.Open(name)
+	if err != nil || path.Clean("/"+name) != "/" {
+		return f, err
+	}
+	return trashRoot{f}, nil
+}
+
+// trashRoot is the root directory of a TrashFS, which doesn't list the
+// trash
+type trashRoot struct {
+	File
+}
+
+func (f trashRoot) Readdir(count int) ([]os.FileInfo, error) {
+	for {
+		fis, err := f.File.Readdir(count)
+		kept := fis[:0]
+		for _, fi := range fis {
+			if fi.Name() != trashName {
+				kept = append(kept, fi)
+			}
+		}
+		// a batch of only the trash mustn't read as the end
+		if len(kept) > 0 || err != nil || count <= 0 {
+			return kept, err
+		}
+	}
+}
+
+// Create calls the inner Create
+func (t *TrashFS) Create(name string) (File, error) {
+	if isTrashPath(name) {
+		return nil, hidden("create", name, os.ErrPermission)
+	}
+	return t.fs.Create(name)
+}
+
+// OpenFile forwards to the inner OpenFiler
+func (t *TrashFS) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	o, ok := t.fs.(OpenFiler)
+	if !ok {
+		return nil, ErrNotImplemented
+	}
+	if isTrashPath(name) {
+		return nil, hidden("open", name, os.ErrNotExist)
+	}
+	return o.OpenFile(name, flag, perm)
+}
+
+// Mkdir calls the inner Mkdir
+func (t *TrashFS) Mkdir(name string) error {
+	if isTrashPath(name) {
+		return hidden("mkdir", name, os.ErrPermission)
+	}
+	return t.fs.Mkdir(name)
+}
+
+// Remove moves the file name to the trash, and removes an empty directory
+// or a temporary file, one whose name starts with TempPrefix, for good
+func (t *TrashFS) Remove(name string) error {
+	if isTrashPath(name) {
+		return hidden("remove", name, os.ErrPermission)
+	}
+	if strings.HasPrefix(path.Base(name), TempPrefix) {
+		return t.fs.Remove(name)
+	}
+	fi, err := statName(t.fs, name)
+	if err != nil {
+		return err
+	}
+	if fi.IsDir() {
+		return t.fs.Remove(name)
+	}
+
+	id, err := newTrashID()
+	if err != nil {
+		return err
+	}
+	b, _ := json.Marshal(trashInfoFile{Path: path.Clean("/" + name), Deleted: time.Now().UTC()})
+	if err := writeFile(t.fs, path.Join(trashInfo, id), b); err != nil {
+		return err
+	}
+	if err := t.rn.Rename(name, path.Join(trashFiles, id)); err != nil {
+		t.fs.Remove(path.Join(trashInfo, id))
+		return err
+	}
+	return nil
+}
+
+// newTrashID returns the ID of a new trash entry, which sorts by the time
+// it was made
+func newTrashID() (string, error) {
+	var b [4]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%016x-%s", time.Now().UnixNano(), hex.EncodeToString(b[:])), nil
+}
+
+// writeFile writes b to the file name of fs
+func writeFile(fs FileSystem, name string, b []byte) error {
+	f, err := fs.Create(name)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Trash lists the oldest n entries of the trash, all of them for n <= 0.
+// Only the info files of the entries listed are read.
+func (t *TrashFS) Trash(n int) ([]TrashEntry, error) {
+	d, err := t.fs.Open(trashInfo)
+	if err != nil {
+		return nil, err
+	}
+	fis, err := d.Readdir(-1)
+	d.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	// IDs sort by the time they were made
+	ids := make([]string, len(fis))
+	for i, fi := range fis {
+		ids[i] = fi.Name()
+	}
+	sort.Strings(ids)
+	var entries []TrashEntry
+	for _, id := range ids {
+		if n > 0 && len(entries) == n {
+			break
+		}
+		e, err := t.TrashEntry(id)
+		if err != nil {
+			// removed meanwhile, or half of a Remove that failed
+			continue
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// TrashEntry returns the entry id, os.ErrNotExist if there is none
+func (t *TrashFS) TrashEntry(id string) (TrashEntry, error) {
+	if id == "" || id == "." || id == ".." || strings.ContainsAny(id, "/\\") {
+		return TrashEntry{}, hidden("trash", id, os.ErrNotExist)
+	}
+	f, err := t.fs.Open(path.Join(trashInfo, id))
+	if err != nil {
+		return TrashEntry{}, err
+	}
+	var info trashInfoFile
+	err = json.NewDecoder(io.LimitReader(f, 64<<10)).Decode(&info)
+	f.Close()
+	if err != nil {
+		return TrashEntry{}, err
+	}
+	fi, err := statName(t.fs, path.Join(trashFiles, id))
+	if err != nil {
+		return TrashEntry{}, err
+	}
+	return TrashEntry{ID: id, Path: info.Path, Deleted: info.Deleted, Size: fi.Size()}, nil
+}
+
+// Restore moves the trash entry id to name
+func (t *TrashFS) Restore(id, name string) error {
+	if isTrashPath(name) {
+		return hidden("restore", name, os.ErrPermission)
+	}
+	if _, err := t.TrashEntry(id); err != nil {
+		return err
+	}
+	if err := t.rn.Rename(path.Join(trashFiles, id), name); err != nil {
+		return err
+	}
+	t.fs.Remove(path.Join(trashInfo, id))
+	return nil
+}
+
+// Purge removes the trash entry id for good
+func (t *TrashFS) Purge(id string) error {
+	if _, err := t.TrashEntry(id); err != nil {
+		return err
+	}
+	if err := t.fs.Remove(path.Join(trashFiles, id)); err != nil {
+		return err
+	}
+	return t.fs.Remove(path.Join(trashInfo, id))
+}
+
+// Rename forwards to the inner Renamer
+func (t *TrashFS) Rename(oldname, newname string) error {
+	if isTrashPath(oldname) || isTrashPath(newname) {
+		return hidden("rename", oldname, os.ErrPermission)
+	}
+	return t.rn.Rename(oldname, newname)
+}
+
+// CreateTemp forwards to the inner TempFiler
+func (t *TrashFS) CreateTemp(dir, pattern string) (File, string, error) {
+	tf, ok := t.fs.(TempFiler)
+	if !ok {
+		return nil, "", ErrNotImplemented
+	}
+	if isTrashPath(dir) {
+		return nil, "", hidden("createtemp", dir, os.ErrPermission)
+	}
+	return tf.CreateTemp(dir, pattern)
+}
+
+// CopyFile forwards to the inner Copier
+func (t *TrashFS) CopyFile(src, dst string) error {
+	c, ok := t.fs.(Copier)
+	if !ok {
+		return ErrNotImplemented
+	}
+	if isTrashPath(src) || isTrashPath(dst) {
+		return hidden("copy", src, os.ErrPermission)
+	}
+	return c.CopyFile(src, dst)
+}
+
+// ETag forwards to the inner ETagger
+func (t *TrashFS) ETag(name string) (string, error) {
+	e, ok := t.fs.(ETagger)
+	if !ok {
+		return "", ErrNotImplemented
+	}
+	if isTrashPath(name) {
+		return "", hidden("etag", name, os.ErrNotExist)
+	}
+	return e.ETag(name)
+}
+
+// Chtimes forwards to the inner Chtimer
+func (t *TrashFS) Chtimes(name string, atime, mtime time.Time) error {
+	c, ok := t.fs.(Chtimer)
+	if !ok {
+		return ErrNotImplemented
+	}
+	if isTrashPath(name) {
+		return hidden("chtimes", name, os.ErrPermission)
+	}
+	return c.Chtimes(name, atime, mtime)
+}
+
+// Chmod forwards to the inner Chmoder
+func (t *TrashFS) Chmod(name string, mode os.FileMode) error {
+	c, ok := t.fs.(Chmoder)
+	if !ok {
+		return ErrNotImplemented
+	}
+	if isTrashPath(name) {
+		return hidden("chmod", name, os.ErrPermission)
+	}
+	return c.Chmod(name, mode)
+}
+
+// Checksum forwards to the inner ChecksumFS
+func (t *TrashFS) Checksum(name, algo string) (string, error) {
+	c, ok := t.fs.(ChecksumFS)
+	if !ok {
+		return "", ErrNotImplemented
+	}
+	if isTrashPath(name) {
+		return "", hidden("checksum", name, os.ErrNotExist)
+	}
+	return c.Checksum(name, algo)
+}
+
+// CachedChecksum forwards to the inner ChecksumCache, there is none for a
+// name in the trash
+func (t *TrashFS) CachedChecksum(name, algo string) (string, bool) {
+	if c, ok := t.fs.(ChecksumCache); ok && !isTrashPath(name) {
+		return c.CachedChecksum(name, algo)
+	}
+	return "", false
+}
+
+// CacheChecksum forwards to the inner ChecksumCache, ignoring a name in
+// the trash
+func (t *TrashFS) CacheChecksum(name, algo, sum string, size int64) {
+	if c, ok := t.fs.(ChecksumCache); ok && !isTrashPath(name) {
+		c.CacheChecksum(name, algo, sum, size)
+	}
+}
+
+// Owner forwards to the inner OwnerFS
+func (t *TrashFS) Owner(name string) (FileOwner, error) {
+	o, ok := t.fs.(OwnerFS)
+	if !ok {
+		return FileOwner{}, ErrNotImplemented
+	}
+	if isTrashPath(name) {
+		return FileOwner{}, hidden("owner", name, os.ErrNotExist)
+	}
+	return o.Owner(name)
+}
+
+// Watch forwards to the inner Watcher, leaving out the events of the trash
+func (t *TrashFS) Watch(prefix string) (<-chan Event, func(), error) {
+	w, ok := t.fs.(Watcher)
+	if !ok {
+		return nil, nil, ErrNotImplemented
+	}
+	in, stop, err := w.Watch(prefix)
+	if err != nil {
+		return nil, nil, err
+	}
+	out, done := make(chan Event), make(chan struct{})
+	go func() {
+		defer close(out)
+		// once stopped, events are dropped until the inner watch ends
+		for ev := range in {
+			if isTrashPath(ev.Path) {
+				continue
+			}
+			select {
+			case out <- ev:
+			case <-done:
+			}
+		}
+	}()
+	var once sync.Once
+	return out, func() { once.Do(func() { close(done); stop() }) }, nil
+}
+
+// Close closes the inner FileSystem if it is a FileSystemCloser
+func (t *TrashFS) Close() error {
+	if c, ok := t.fs.(FileSystemCloser); ok {
+		return c.Close()
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package webdav
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path"
+)
+
+// WalkFunc is called by Walk and Client.Walk for every file and
+// directory, see fs.WalkDirFunc. Client.Walk passes *RemoteFileInfo.
+type WalkFunc func(name string, fi os.FileInfo, err error) error
+
+// walkBatch is the number of directory entries Walk reads at a time
+const walkBatch = 256
+
+// Walk calls fn for root and everything below it in fsys, depth first, in
+// the order the FileSystem lists directories. Directories are read
+// walkBatch entries at a time with Readdir, and every batch is handed to fn
+// before the next is read, so huge directories don't have to fit in memory
+// at once. Recursive operations on a FileSystem should use it rather than
+// Readdir(0).
+//
+// As with Client.Walk, a directory that can't be read is passed to fn a
+// second time with the error, fs.SkipDir skips a directory or the rest of
+// the directory of a file, and fs.SkipAll ends the walk.
+func Walk(fsys FileSystem, root string, fn WalkFunc) error {
+	f, err := fsys.Open(root)
+	if err != nil {
+		return skipDir(fn(root, nil, err))
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return skipDir(fn(root, nil, err))
+	}
+	if err := fn(root, fi, nil); err != nil || !fi.IsDir() {
+		f.Close()
+		return skipDir(err)
+	}
+	return skipDir(walkDir(fsys, root, f, fn))
+}
+
+// walkDir walks the entries of the open directory f and closes it
+func walkDir(fsys FileSystem, dir string, f File, fn WalkFunc) error {
+	defer f.Close()
+
+	for {
+		fis, err := f.Readdir(walkBatch)
+		for _, fi := range fis {
+			name := path.Join(dir, fi.Name())
+			err := fn(name, fi, nil)
+			if err == fs.SkipDir {
+				if fi.IsDir() {
+					continue
+				}
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if fi.IsDir() {
+				if err := walkSub(fsys, name, fn); err != nil {
+					return err
+				}
+			}
+		}
+
+		if err == io.EOF || (err == nil && len(fis) == 0) {
+			return nil
+		}
+		if err != nil {
+			if err := fn(dir, nil, err); err != nil && err != fs.SkipDir {
+				return err
+			}
+			return nil
+		}
+	}
+}
+
+// walkSub opens the directory name and walks it
+func walkSub(fsys FileSystem, name string, fn WalkFunc) error {
+	f, err := fsys.Open(name)
+	if err != nil {
+		if err := fn(name, nil, err); err != nil && err != fs.SkipDir {
+			return err
+		}
+		return nil
+	}
+	return walkDir(fsys, name, f, fn)
+}
@@ -0,0 +1,136 @@
+package webdav
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"runtime"
+	"testing"
+)
+
+// batchFS records the largest Readdir count asked of its files
+type batchFS struct {
+	FileSystem
+	largest int
+	all     bool // Readdir(0) was called
+}
+
+type batchFile struct {
+	File
+	fsys *batchFS
+}
+
+func (b *batchFS) Open(name string) (File, error) {
+	f, err := b.FileSystem.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return batchFile{f, b}, nil
+}
+
+func (f batchFile) Readdir(count int) ([]os.FileInfo, error) {
+	if count <= 0 {
+		f.fsys.all = true
+	}
+	f.fsys.largest = max(f.fsys.largest, count)
+	return f.File.Readdir(count)
+}
+
+// hugeDir returns a MemFS with n empty files in /huge
+func hugeDir(t testing.TB, n int) *MemFS {
+	m := NewMemFS()
+	if err := m.Mkdir("/huge"); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < n; i++ {
+		f, err := m.Create(fmt.Sprintf("/huge/%06d", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+	}
+	return m
+}
+
+func heapAlloc() uint64 {
+	runtime.GC()
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	return ms.HeapAlloc
+}
+
+func TestWalkHugeDirectory(t *testing.T) {
+	if testing.Short() {
+		t.Skip("creates 200k files")
+	}
+	const n = 200000
+	fsys := &batchFS{FileSystem: hugeDir(t, n)}
+
+	// the FileInfos of a whole listing would take some 16 MB; MemFS itself
+	// keeps a sorted list of the names, 3.2 MB, while it is read
+	base := heapAlloc()
+	var seen int
+	var peak uint64
+	err := Walk(fsys, "/huge", func(name string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !fi.IsDir() {
+			seen++
+			if seen%20000 == 0 {
+				peak = max(peak, heapAlloc())
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if seen != n {
+		t.Errorf("walked %d files, want %d", seen, n)
+	}
+	if fsys.all || fsys.largest > walkBatch {
+		t.Errorf("directories read %d entries at a time, Readdir(0) %v", fsys.largest, fsys.all)
+	}
+	if peak > base && peak-base > 8<<20 {
+		t.Errorf("walking grew the heap by %d MB", (peak-base)>>20)
+	}
+}
+
+func TestWalkSkip(t *testing.T) {
+	m := NewMemFS()
+	for _, dir := range []string{"/a", "/a/skipped", "/b"} {
+		m.Mkdir(dir)
+	}
+	for _, name := range []string{"/a/f1", "/a/skipped/f", "/b/f1", "/b/f2", "/b/f3"} {
+		writeMem(t, m, name, nil)
+	}
+
+	var names []string
+	err := Walk(m, "/", func(name string, fi os.FileInfo, err error) error {
+		names = append(names, name)
+		switch name {
+		case "/a/skipped", "/b/f2":
+			return fs.SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "[/ /a /a/f1 /a/skipped /b /b/f1 /b/f2]"
+	if got := fmt.Sprint(names); got != want {
+		t.Errorf("Walk = %s, want %s", got, want)
+	}
+}
+
+// BenchmarkWalkHugeDirectory walks a directory of 200k files
+func BenchmarkWalkHugeDirectory(b *testing.B) {
+	m := hugeDir(b, 200000)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if err := Walk(m, "/huge", func(string, os.FileInfo, error) error { return nil }); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
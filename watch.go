@@ -0,0 +1,277 @@
+package webdav
+
+import (
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// Op is the kind of change reported by an Event
+type Op int
+
+// change operations
+const (
+	OpCreate Op = iota + 1
+	OpWrite
+	OpRemove
+	OpRename
+	OpResync // events below Path were lost, it has to be listed again
+)
+
+var opNames = map[Op]string{
+	OpCreate: "create",
+	OpWrite:  "write",
+	OpRemove: "remove",
+	OpRename: "rename",
+	OpResync: "resync",
+}
+
+func (op Op) String() string {
+	if s, ok := opNames[op]; ok {
+		return s
+	}
+	return "unknown"
+}
+
+// An Event reports a change to a file. Path is slash separated and rooted
+// at "/" of the FileSystem. A rename is reported as OpRename for the old
+// path followed by OpCreate for the new one.
+type Event struct {
+	Op   Op
+	Path string
+	Time time.Time
+}
+
+// A Watcher is a FileSystem that reports changes below prefix, both those
+// made through the server and those made out-of-band. Calling the returned
+// function stops the watch, the channel is closed once the pending events
+// have been received.
+type Watcher interface {
+	Watch(prefix string) (<-chan Event, func(), error)
+}
+
+// Watch reports changes below prefix. If Fs is a Watcher its events are
+// returned, otherwise only the changes made through this Server are seen.
+// Close stops the watches that are left.
+func (s *Server) Watch(prefix string) (<-chan Event, func(), error) {
+	select {
+	case <-s.done():
+		return nil, nil, errServerClosed
+	default:
+	}
+
+	var (
+		ch   <-chan Event
+		stop func()
+		err  error = ErrNotImplemented
+	)
+	if w, ok := s.Fs.(Watcher); ok {
+		ch, stop, err = w.Watch(prefix)
+	}
+	if err == ErrNotImplemented {
+		ch, stop, err = s.events.subscribe(prefix)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return ch, s.track(stop), nil
+}
+
+// track keeps the stop function of a watch for Close, the function it
+// returns stops the watch and forgets it
+func (s *Server) track(stop func()) func() {
+	s.watchMu.Lock()
+	defer s.watchMu.Unlock()
+	select {
+	case <-s.done():
+		stop()
+		return stop
+	default:
+	}
+
+	key := new(byte)
+	if s.watches == nil {
+		s.watches = make(map[*byte]func())
+	}
+	s.watches[key] = stop
+	return func() {
+		s.watchMu.Lock()
+		delete(s.watches, key)
+		s.watchMu.Unlock()
+		stop()
+	}
+}
+
+// stopWatches stops every watch still running, for Close
+func (s *Server) stopWatches() {
+	s.watchMu.Lock()
+	watches := s.watches
+	s.watches = nil
+	s.watchMu.Unlock()
+	for _, stop := range watches {
+		stop()
+	}
+}
+
+// publish records a mutation made by a request handler
+func (s *Server) publish(op Op, name string) {
+	s.events.publish(Event{Op: op, Path: path.Clean("/" + name), Time: time.Now()})
+}
+
+// inPrefix reports whether the cleaned path name is prefix or below it
+func inPrefix(name, prefix string) bool {
+	prefix = path.Clean("/" + prefix)
+	return prefix == "/" || name == prefix || strings.HasPrefix(name, prefix+"/")
+}
+
+// notifier fans events out to subscribers. A subscriber that does not keep
+// up loses events rather than stalling the request handlers, and is sent
+// an OpResync of its prefix in their place.
+type notifier struct {
+	mu     sync.Mutex
+	subs   map[chan Event]*subscription
+	closed bool
+}
+
+// subscription is what a notifier knows of a subscriber
+type subscription struct {
+	prefix string
+	// an OpResync is queued for events lost since, later ones are
+	// dropped until the subscriber has caught up
+	overflowed bool
+}
+
+// subscriberBuffer is the number of events a subscriber can fall behind
+// by; its channel has one more slot, kept for the OpResync
+const subscriberBuffer = 64
+
+func (n *notifier) subscribe(prefix string) (<-chan Event, func(), error) {
+	ch := make(chan Event, subscriberBuffer+1)
+
+	n.mu.Lock()
+	if n.closed {
+		n.mu.Unlock()
+		return nil, nil, errServerClosed
+	}
+	if n.subs == nil {
+		n.subs = make(map[chan Event]*subscription)
+	}
+	n.subs[ch] = &subscription{prefix: path.Clean("/" + prefix)}
+	n.mu.Unlock()
+
+	return ch, func() {
+		n.mu.Lock()
+		defer n.mu.Unlock()
+		if _, ok := n.subs[ch]; ok {
+			delete(n.subs, ch)
+			close(ch)
+		}
+	}, nil
+}
+
+// close ends every subscription, as if its stop function was called
+func (n *notifier) close() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.closed = true
+	for ch := range n.subs {
+		delete(n.subs, ch)
+		close(ch)
+	}
+}
+
+func (n *notifier) publish(ev Event) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	for ch, sub := range n.subs {
+		if !inPrefix(ev.Path, sub.prefix) {
+			continue
+		}
+		// only publish sends, under mu, so a channel with room below the
+		// reserved slot can take the event without blocking
+		if len(ch) < subscriberBuffer {
+			sub.overflowed = false
+			ch <- ev
+			continue
+		}
+		if !sub.overflowed {
+			glog.Infoln("DAV:", "watcher too slow, events lost below", sub.prefix)
+			sub.overflowed = true
+			ch <- Event{Op: OpResync, Path: sub.prefix, Time: ev.Time}
+		}
+	}
+}
+
+// Coalesce merges bursts of events for the same path: an event is held
+// back until its path has been quiet for window, then the latest one is
+// delivered (a create followed by writes stays a create, a resync stays a
+// resync). The returned channel is closed once in is closed and pending
+// events are flushed.
+func Coalesce(in <-chan Event, window time.Duration) <-chan Event {
+	return coalesce(in, window, nil)
+}
+
+// coalesce is Coalesce that gives up on delivering the events it holds
+// once stop is closed: those that don't fit in the buffer of the returned
+// channel are dropped, rather than waiting for a reader that has gone
+func coalesce(in <-chan Event, window time.Duration, stop <-chan struct{}) <-chan Event {
+	if window <= 0 {
+		return in
+	}
+	out := make(chan Event, cap(in))
+
+	go func() {
+		defer close(out)
+
+		pending := make(map[string]Event)
+		var order []string
+		ticker := time.NewTicker(window / 2)
+		defer ticker.Stop()
+
+		flush := func(all bool) {
+			keep := order[:0]
+			for _, p := range order {
+				ev := pending[p]
+				if all || time.Since(ev.Time) >= window {
+					select {
+					case out <- ev:
+					case <-stop:
+						select {
+						case out <- ev:
+						default:
+						}
+					}
+					delete(pending, p)
+				} else {
+					keep = append(keep, p)
+				}
+			}
+			order = keep
+		}
+
+		for {
+			select {
+			case ev, ok := <-in:
+				if !ok {
+					flush(true)
+					return
+				}
+				prev, seen := pending[ev.Path]
+				if !seen {
+					order = append(order, ev.Path)
+				} else if prev.Op == OpResync || prev.Op == OpCreate && ev.Op == OpWrite {
+					ev.Op = prev.Op
+				}
+				pending[ev.Path] = ev
+			case <-ticker.C:
+				flush(false)
+			}
+		}
+	}()
+
+	return out
+}
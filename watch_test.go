@@ -0,0 +1,143 @@
+package webdav
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// collect receives from ch until it is closed, failing the test if that
+// takes longer than timeout
+func collect(t testing.TB, ch <-chan Event, timeout time.Duration) []Event {
+	t.Helper()
+	var evs []Event
+	deadline := time.After(timeout)
+	for {
+		select {
+		case ev, ok := <-ch:
+			if !ok {
+				return evs
+			}
+			evs = append(evs, ev)
+		case <-deadline:
+			t.Fatalf("channel not closed after %v, received %v", timeout, evs)
+		}
+	}
+}
+
+func TestCoalesce(t *testing.T) {
+	in := make(chan Event, 16)
+	out := Coalesce(in, 50*time.Millisecond)
+	now := time.Now()
+	for _, ev := range []Event{
+		{Op: OpCreate, Path: "/a"},
+		{Op: OpWrite, Path: "/a"},
+		{Op: OpWrite, Path: "/a"},
+		{Op: OpWrite, Path: "/b"},
+		{Op: OpRemove, Path: "/b"},
+		{Op: OpResync, Path: "/"},
+		{Op: OpWrite, Path: "/"},
+	} {
+		ev.Time = now
+		in <- ev
+	}
+	close(in)
+
+	got := collect(t, out, time.Second)
+	want := []Event{{Op: OpCreate, Path: "/a"}, {Op: OpRemove, Path: "/b"}, {Op: OpResync, Path: "/"}}
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i].Op != want[i].Op || got[i].Path != want[i].Path {
+			t.Errorf("event %d is %v %s, want %v %s", i, got[i].Op, got[i].Path, want[i].Op, want[i].Path)
+		}
+	}
+}
+
+func TestCoalesceWaitsForQuiet(t *testing.T) {
+	in := make(chan Event, 1)
+	out := Coalesce(in, 100*time.Millisecond)
+	defer close(in)
+
+	in <- Event{Op: OpWrite, Path: "/a", Time: time.Now()}
+	select {
+	case ev := <-out:
+		t.Fatalf("%v delivered before the path was quiet", ev)
+	case <-time.After(20 * time.Millisecond):
+	}
+	select {
+	case <-out:
+	case <-time.After(time.Second):
+		t.Fatal("event not delivered after the window")
+	}
+}
+
+func TestCoalesceStopDropsUnread(t *testing.T) {
+	in := make(chan Event, 1)
+	stop := make(chan struct{})
+	out := coalesce(in, time.Millisecond, stop)
+
+	// more paths than out can buffer, and nobody reading
+	for i := 0; i < 10; i++ {
+		in <- Event{Op: OpCreate, Path: "/" + string(rune('a'+i))}
+	}
+	close(stop)
+	close(in)
+
+	time.Sleep(20 * time.Millisecond)
+	if got := collect(t, out, time.Second); len(got) > cap(out) {
+		t.Errorf("%d events delivered, out holds %d", len(got), cap(out))
+	}
+}
+
+// TestNotifierOverflow floods a subscriber that doesn't read: the events
+// that don't fit are replaced by one OpResync of its prefix, after which
+// it gets events again once it has caught up
+func TestNotifierOverflow(t *testing.T) {
+	var n notifier
+	slow, stopSlow, err := n.subscribe("/dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, stopOther, err := n.subscribe("/other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3*subscriberBuffer; i++ {
+		n.publish(Event{Op: OpWrite, Path: fmt.Sprintf("/dir/f%d", i), Time: time.Now()})
+	}
+	n.publish(Event{Op: OpCreate, Path: "/other/a", Time: time.Now()})
+
+	var evs []Event
+	for len(slow) > 0 {
+		evs = append(evs, <-slow)
+	}
+	if len(evs) != subscriberBuffer+1 {
+		t.Fatalf("%d events queued, want %d", len(evs), subscriberBuffer+1)
+	}
+	for i, ev := range evs[:subscriberBuffer] {
+		if ev.Op != OpWrite || ev.Path != fmt.Sprintf("/dir/f%d", i) {
+			t.Errorf("event %d is %v %s", i, ev.Op, ev.Path)
+		}
+	}
+	if last := evs[subscriberBuffer]; last.Op != OpResync || last.Path != "/dir" {
+		t.Errorf("last event %v %s, want a resync of /dir", last.Op, last.Path)
+	}
+
+	// caught up, nothing is lost any more
+	n.publish(Event{Op: OpRemove, Path: "/dir/f0", Time: time.Now()})
+	if ev := <-slow; ev.Op != OpRemove || ev.Path != "/dir/f0" {
+		t.Errorf("after catching up: %v %s", ev.Op, ev.Path)
+	}
+	// a subscriber that kept up lost nothing
+	if ev := <-other; ev.Op != OpCreate || len(other) != 0 {
+		t.Errorf("other subscriber: %v, %d more", ev, len(other))
+	}
+
+	stopSlow()
+	stopOther()
+	if got := collect(t, slow, time.Second); len(got) != 0 {
+		t.Errorf("events after stop: %v", got)
+	}
+}
@@ -0,0 +1,51 @@
+package webdav
+
+import (
+	"encoding/xml"
+	"errors"
+	"io"
+)
+
+// maxXMLDepth is the deepest nesting of elements accepted in XML bodies,
+// far more than any WebDAV body or property value needs
+const maxXMLDepth = 64
+
+// errXMLLimit is returned for XML nested deeper than maxXMLDepth or with
+// more tokens than the decoder allows
+var errXMLLimit = errors.New("webdav: XML body too deeply nested or too long")
+
+// limitedTokens is an xml.TokenReader that fails once its decoder is
+// nested too deeply or has read too many tokens
+type limitedTokens struct {
+	d      *xml.Decoder
+	depth  int
+	tokens int
+	max    int // zero for no limit
+}
+
+func (l *limitedTokens) Token() (xml.Token, error) {
+	tok, err := l.d.RawToken()
+	if err != nil {
+		return tok, err
+	}
+	l.tokens++
+	if l.max > 0 && l.tokens > l.max {
+		return nil, errXMLLimit
+	}
+	switch tok.(type) {
+	case xml.StartElement:
+		l.depth++
+		if l.depth > maxXMLDepth {
+			return nil, errXMLLimit
+		}
+	case xml.EndElement:
+		l.depth--
+	}
+	return tok, nil
+}
+
+// newXMLDecoder returns a decoder of r that refuses XML nested deeper than
+// maxXMLDepth or, unless maxTokens is zero, longer than maxTokens tokens
+func newXMLDecoder(r io.Reader, maxTokens int) *xml.Decoder {
+	return xml.NewTokenDecoder(&limitedTokens{d: xml.NewDecoder(r), max: maxTokens})
+}
@@ -0,0 +1,166 @@
+package webdav
+
+import (
+	"archive/zip"
+	"errors"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/golang/glog"
+)
+
+// limits of a zip download unless the Server sets its own
+const (
+	DefaultZipMaxEntries = 10000
+	DefaultZipMaxBytes   = 4 << 30
+)
+
+var errZipTooLarge = errors.New("zip archive over the limit")
+
+// stored are the extensions of files that are already compressed, they
+// are stored in a zip without compressing them again
+var stored = map[string]bool{
+	".zip": true, ".gz": true, ".tgz": true, ".bz2": true, ".xz": true, ".zst": true, ".7z": true, ".rar": true,
+	".jpg": true, ".jpeg": true, ".png": true, ".gif": true, ".webp": true, ".heic": true,
+	".mp3": true, ".m4a": true, ".ogg": true, ".flac": true, ".mp4": true, ".m4v": true, ".mov": true, ".mkv": true, ".webm": true,
+	".docx": true, ".xlsx": true, ".pptx": true, ".odt": true, ".ods": true, ".odp": true, ".epub": true, ".jar": true,
+}
+
+// wantsZip reports whether a GET of a collection asks for a zip archive
+func wantsZip(r *http.Request) bool {
+	if r.URL.Query().Get("accept") == "zip" {
+		return true
+	}
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		typ, _, _ := strings.Cut(part, ";")
+		if strings.EqualFold(strings.TrimSpace(typ), "application/zip") {
+			return true
+		}
+	}
+	return false
+}
+
+// zipHidden reports whether a name is left out of zip archives, dot files
+// like .git or .DS_Store and unfinished uploads
+func zipHidden(name string) bool {
+	return strings.HasPrefix(name, ".")
+}
+
+// serveZip answers GET and HEAD of the collection name with a zip archive
+// of everything below it, streamed as it is read with chunked encoding.
+// The tree is walked once up front so a collection over ZipMaxEntries or
+// ZipMaxBytes is refused with 413 before anything is sent. ?store=1 stores
+// every file uncompressed, already compressed formats always are.
+func (s *Server) serveZip(w http.ResponseWriter, r *http.Request, name string) {
+	maxEntries, maxBytes := s.ZipMaxEntries, s.ZipMaxBytes
+	if maxEntries <= 0 {
+		maxEntries = DefaultZipMaxEntries
+	}
+	if maxBytes <= 0 {
+		maxBytes = DefaultZipMaxBytes
+	}
+
+	type entry struct {
+		name string
+		rel  string
+		fi   os.FileInfo
+	}
+	var entries []entry
+	var size int64
+	err := Walk(s.Fs, name, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if p == name {
+			return nil
+		}
+		if zipHidden(fi.Name()) {
+			if fi.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if !fi.IsDir() {
+			size += fi.Size()
+		}
+		entries = append(entries, entry{p, strings.TrimPrefix(strings.TrimPrefix(p, name), "/"), fi})
+		if len(entries) > maxEntries || size > maxBytes {
+			return errZipTooLarge
+		}
+		return nil
+	})
+	if err == errZipTooLarge {
+		glog.Infoln("DAV:", "zip of", name, "over the limit of", maxEntries, "entries or", maxBytes, "bytes")
+		http.Error(w, "collection too large to download as zip", StatusRequestTooLarge)
+		return
+	}
+	if err != nil {
+		glog.Infoln("DAV:", "zip of", name, "error", err)
+		w.WriteHeader(StatusInternalServerError)
+		return
+	}
+
+	base := path.Base(path.Clean("/" + name))
+	if base == "/" {
+		base = "archive"
+	}
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": base + ".zip"}))
+	w.WriteHeader(StatusOK)
+	if r.Method == "HEAD" {
+		return
+	}
+
+	storeAll := r.URL.Query().Get("store") == "1"
+	zw := zip.NewWriter(w)
+	for _, e := range entries {
+		hdr, err := zip.FileInfoHeader(e.fi)
+		if err != nil {
+			s.abortZip(name, err)
+		}
+		hdr.Name = e.rel
+		if e.fi.IsDir() {
+			hdr.Name += "/"
+		} else if storeAll || stored[strings.ToLower(path.Ext(e.rel))] {
+			hdr.Method = zip.Store
+		} else {
+			hdr.Method = zip.Deflate
+		}
+
+		fw, err := zw.CreateHeader(hdr)
+		if err != nil {
+			s.abortZip(name, err)
+		}
+		if e.fi.IsDir() {
+			continue
+		}
+		if err := s.zipFile(fw, e.name); err != nil {
+			s.abortZip(name, err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		s.abortZip(name, err)
+	}
+}
+
+func (s *Server) zipFile(w io.Writer, name string) error {
+	f, err := s.Fs.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = s.copy(w, f)
+	return err
+}
+
+// abortZip breaks off a zip download that failed after the status was
+// sent, so the client sees an error rather than a truncated archive
+func (s *Server) abortZip(name string, err error) {
+	glog.Infoln("DAV:", "zip of", name, "aborted, error", err)
+	panic(http.ErrAbortHandler)
+}
@@ -0,0 +1,169 @@
+package webdav
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"mime"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readZip returns the entries of a zip archive by name
+func readZip(t *testing.T, body string) map[string]*zip.File {
+	t.Helper()
+	zr, err := zip.NewReader(strings.NewReader(body), int64(len(body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]*zip.File{}
+	for _, f := range zr.File {
+		files[f.Name] = f
+	}
+	return files
+}
+
+// TestZipDownload downloads a collection as a zip and checks its entries
+// are named below the collection, keep their modification times, and
+// leave out hidden files and unfinished uploads
+func TestZipDownload(t *testing.T) {
+	root := t.TempDir()
+	mtime := time.Date(2020, 5, 6, 7, 8, 9, 0, time.UTC)
+	for name, content := range map[string]string{
+		"docs/a dir/notes.txt":           "some notes, some notes, some notes",
+		"docs/a dir/sub/deep.txt":        "deep",
+		"docs/a dir/photo.png":           "not really a png",
+		"docs/a dir/.DS_Store":           "hidden",
+		"docs/a dir/.git/config":         "hidden directory",
+		"docs/a dir/" + TempPrefix + "1": "an upload in progress",
+		"docs/outside.txt":               "not in the collection",
+	} {
+		p := filepath.Join(root, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(p), 0o755)
+		if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		os.Chtimes(p, mtime, mtime)
+	}
+	ts := newTestServer(t, &Server{Fs: Dir(root), Listings: true, ZipDownloads: true})
+
+	resp, body := request(t, ts, "GET", "/docs/a%20dir/", "", "Accept", "text/html;q=0.5, application/zip")
+	wantStatus(t, resp, StatusOK)
+	if typ := resp.Header.Get("Content-Type"); typ != "application/zip" {
+		t.Errorf("Content-Type %q", typ)
+	}
+	disposition, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition"))
+	if err != nil || disposition != "attachment" || params["filename"] != "a dir.zip" {
+		t.Errorf("Content-Disposition %q", resp.Header.Get("Content-Disposition"))
+	}
+
+	files := readZip(t, body)
+	var names []string
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if got := strings.Join(names, ","); got != "notes.txt,photo.png,sub/,sub/deep.txt" {
+		t.Errorf("entries %s", got)
+	}
+	for name, f := range files {
+		if !f.Mode().IsDir() && !f.Modified.Equal(mtime) {
+			t.Errorf("%s modified %v, want %v", name, f.Modified, mtime)
+		}
+	}
+	if f := files["notes.txt"]; f != nil {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var b bytes.Buffer
+		b.ReadFrom(rc)
+		rc.Close()
+		if b.String() != "some notes, some notes, some notes" {
+			t.Errorf("notes.txt holds %q", b.String())
+		}
+	}
+
+	// the root is archive.zip
+	resp, _ = request(t, ts, "HEAD", "/?accept=zip", "")
+	wantStatus(t, resp, StatusOK)
+	if _, params, _ := mime.ParseMediaType(resp.Header.Get("Content-Disposition")); params["filename"] != "archive.zip" {
+		t.Errorf("root Content-Disposition %q", resp.Header.Get("Content-Disposition"))
+	}
+}
+
+// TestZipStore checks files are compressed unless they already are, or
+// ?store=1 asks for every file to be stored
+func TestZipStore(t *testing.T) {
+	m := NewMemFS()
+	m.Mkdir("/dir")
+	text := strings.Repeat("compressible ", 100)
+	writeMem(t, m, "/dir/a.txt", []byte(text))
+	writeMem(t, m, "/dir/b.PNG", []byte(text))
+	ts := newTestServer(t, &Server{Fs: m, Listings: true, ZipDownloads: true})
+
+	for query, want := range map[string]map[string]uint16{
+		"?accept=zip":         {"a.txt": zip.Deflate, "b.PNG": zip.Store},
+		"?accept=zip&store=1": {"a.txt": zip.Store, "b.PNG": zip.Store},
+	} {
+		resp, body := request(t, ts, "GET", "/dir"+query, "")
+		wantStatus(t, resp, StatusOK)
+		files := readZip(t, body)
+		for name, method := range want {
+			f := files[name]
+			if f == nil {
+				t.Errorf("%s: no %s", query, name)
+				continue
+			}
+			if f.Method != method {
+				t.Errorf("%s: %s method %d, want %d", query, name, f.Method, method)
+			}
+			if method == zip.Store && f.CompressedSize64 != uint64(len(text)) {
+				t.Errorf("%s: %s stored in %d bytes", query, name, f.CompressedSize64)
+			}
+		}
+	}
+}
+
+// TestZipLimits fills a collection up to the entry and byte ceilings of a
+// zip download and one past each
+func TestZipLimits(t *testing.T) {
+	for _, tc := range []struct {
+		name           string
+		files, size    int
+		entries, bytes int
+		status         int
+	}{
+		{"at the entry limit", 10, 1, 10, 100, StatusOK},
+		{"over the entry limit", 11, 1, 10, 100, StatusRequestTooLarge},
+		{"at the byte limit", 4, 25, 10, 100, StatusOK},
+		{"over the byte limit", 4, 26, 10, 100, StatusRequestTooLarge},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			m := NewMemFS()
+			m.Mkdir("/dir")
+			for i := 0; i < tc.files; i++ {
+				writeMem(t, m, fmt.Sprintf("/dir/f%02d", i), bytes.Repeat([]byte("x"), tc.size))
+			}
+			// hidden files count toward neither
+			writeMem(t, m, "/dir/.hidden", bytes.Repeat([]byte("x"), 1000))
+			ts := newTestServer(t, &Server{Fs: m, Listings: true, ZipDownloads: true,
+				ZipMaxEntries: tc.entries, ZipMaxBytes: int64(tc.bytes)})
+
+			for _, method := range []string{"HEAD", "GET"} {
+				resp, body := request(t, ts, method, "/dir?accept=zip", "")
+				wantStatus(t, resp, tc.status)
+				if method == "HEAD" || tc.status != StatusOK {
+					continue
+				}
+				if n := len(readZip(t, body)); n != tc.files {
+					t.Errorf("%d entries in the archive, want %d", n, tc.files)
+				}
+			}
+		})
+	}
+}